@@ -7,9 +7,15 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Config is the typed, validated configuration the server uses.
@@ -17,6 +23,22 @@ type Config struct {
 	// HTTP server bind address (host:port).
 	HTTPAddr string
 
+	// PEM certificate and key paths. When both are set the server speaks
+	// HTTPS (with HTTP/2) on HTTPAddr; when both are empty it serves
+	// plain HTTP, e.g. behind a reverse proxy.
+	TLSCert string
+	TLSKey  string
+
+	// Networks of the reverse proxies whose X-Forwarded-For / X-Real-IP
+	// headers are believed when identifying a client (visitor counts,
+	// rate limits). Requests from anywhere else are keyed by their
+	// socket address.
+	TrustedProxies []netip.Prefix
+
+	// Bind address of a separate listener for the Prometheus /metrics
+	// endpoint. Empty serves /metrics on HTTPAddr instead.
+	MetricsAddr string
+
 	// Path to the SQLite database file (runtime state).
 	DBPath string
 
@@ -29,9 +51,27 @@ type Config struct {
 	DiscordBotToken   string
 	DiscordChannelIDs []string
 
-	// libpcap chat-capture config.
+	// libpcap chat-capture config. The device comes from
+	// SNIFFER_INTERFACE (or the older CHAT_CAPTURE_DEVICE); empty means
+	// auto-select. A non-empty BPF replaces the default
+	// "tcp port <ChatCapturePort>" filter.
 	ChatCaptureDevice string
 	ChatCapturePort   string
+	ChatCaptureBPF    string
+
+	// Captured chat messages are buffered and written in one transaction
+	// once ChatBatchSize of them are waiting or every ChatFlushSeconds,
+	// whichever comes first. Both must be at least 1.
+	ChatBatchSize    int
+	ChatFlushSeconds int
+
+	// Page views are queued in a channel of PageViewChannelSize (views
+	// arriving while it is full are dropped) and written in one
+	// transaction once PageViewBatchSize are waiting or every
+	// PageViewFlushSeconds. All must be at least 1.
+	PageViewChannelSize  int
+	PageViewBatchSize    int
+	PageViewFlushSeconds int
 
 	// If true, refuse to start without ADMIN_PASSWORD set explicitly.
 	// Set RequireAdminPassword=true (via REQUIRE_ADMIN_PASSWORD=1) in
@@ -39,27 +79,292 @@ type Config struct {
 	// random password on every boot.
 	RequireAdminPassword bool
 
+	// If true, the admin user and password are printed to the log at
+	// startup. Off by default so shared logs never carry the plaintext
+	// credential; a generated password is written to data/pwd.txt instead.
+	LogAdminPassword bool
+
 	// If true, skip starting all scrape jobs and the chat packet capture
 	// loop. Intended for local development (set by `make run`) so a dev
 	// instance doesn't hammer upstream sources or require libpcap.
 	DisableScrapers bool
+
+	// If true, only the chat packet capture loop is skipped; the other
+	// scrape jobs still run. For hosts without libpcap, capture
+	// privileges or a usable interface. The chat pages keep serving
+	// whatever messages are already stored.
+	DisableChatSniffer bool
+
+	// Per-IP token bucket for /search, which runs five queries per hit.
+	// SearchRatePerMinute is the sustained rate and SearchRateBurst the
+	// bucket size. A rate of 0 disables the limit.
+	SearchRatePerMinute int
+	SearchRateBurst     int
+
+	// player_history rows older than this many days are downsampled to
+	// one point per hour (keeping each hour's peak) by a daily job.
+	// 0 disables the compaction.
+	PlayerHistoryRetentionDays int
+
+	// Listings the market scrape has not seen for this many hours are
+	// marked unavailable by a periodic job, so the summary and full list
+	// stop showing phantom stock when scrapes stop succeeding. 0 (the
+	// default) disables it.
+	StaleListingHours int
+
+	// Pages show a warning banner when the scrape backing them (market,
+	// characters, guilds, player count) last succeeded more than this
+	// many hours ago. 0 disables the banner.
+	StaleDataHours int
+
+	// The "Updated X ago" text on data pages is colored fresh while the
+	// scrape behind it is at most UpdatedFreshIntervals of that scrape's
+	// runs old, stale up to UpdatedStaleIntervals, and very stale past
+	// that.
+	UpdatedFreshIntervals int
+	UpdatedStaleIntervals int
+
+	// Database queries on the heaviest pages that take longer than this
+	// many milliseconds are logged with their name and duration. 0
+	// disables the logging.
+	SlowQueryMS int
+
+	// How many online item-ID lookups (rodatabase searches) may run at
+	// once. Further lookups wait in line; identical ones in flight are
+	// shared. Must be at least 1.
+	OnlineLookupConcurrency int
+
+	// Trading post items whose item ID could not be resolved when they
+	// were posted (usually a slow or failing rodatabase) are looked up
+	// again by a background job for this many hours after the post was
+	// created. 0 disables the retries.
+	TradeItemIDRetryHours int
+
+	// http(s) URL that price watches POST to when an item's cheapest
+	// listing reaches a visitor's target price. Empty disables the
+	// notifications; visitors can still save watches.
+	PriceWatchWebhookURL string
+
+	// Row cap for list queries that have no natural LIMIT (an item's
+	// drop history, a guild's members, a character's drops). Results
+	// past the cap are cut off and the page says so. Must be at least 1.
+	MaxResultRows int
+
+	// Pages are rendered into a buffer of up to this many KiB so a
+	// template error can still become a clean 500. Pages that outgrow it
+	// are streamed from then on. 0 streams every page.
+	RenderBufferKB int
+
+	// Vending tax as a percentage of the sale price. Stats pages can
+	// show net proceeds (price minus this fee) via ?net=true. 0 means
+	// sellers keep the full price.
+	VendFeePercent float64
+
+	// Thresholds for bumping a character's last_active. Experience is in
+	// percentage points per scrape; zeny in absolute zeny per scrape.
+	// Smaller changes are still written to the changelog but don't count
+	// as activity. A zero zeny threshold means any zeny change counts.
+	// There is no combined exp+zeny minimum: exp comes from the character
+	// scrape and zeny from the zeny scrape, so neither sees the other's
+	// change for the same interval, and the units don't add up.
+	ActivityMinExpDelta  float64
+	ActivityMinZenyDelta int
+
+	// Days of SOLD events used for an item's "fair price" (median sale
+	// price) on the history page. 0 disables the reference line.
+	FairPriceWindowDays int
+
+	// The item history chart only records a new point when the lowest or
+	// highest price moves by more than this percentage since the last
+	// recorded point. 0 records every change.
+	PriceHistoryTolerancePercent float64
+
+	// When > 1, market stats drop SOLD events priced more than this
+	// factor above or below the item's rolling median sale price, on
+	// top of the flat price cap. 0 disables the rejection.
+	StatsOutlierFactor float64
+
+	// If true, total-zeny aggregates (the characters page total, guild
+	// combined zeny, character stats) add characters.bank_zeny to the
+	// on-hand zeny. Bank balances are not scraped yet, so the column is
+	// 0 until a scraper fills it.
+	IncludeBankZeny bool
+
+	// fmt template for item icon URLs; its single %d is the item ID.
+	// Point it at a self-hosted mirror to avoid depending on the
+	// external image host.
+	ItemImageURL string
+
+	// Overrides for which category tab each internal_item_db type is
+	// listed under, keyed by DB type (e.g. "ShadowGear" -> "Shadow Gear"
+	// to split shadow gear out of Armor). Types not listed keep the
+	// built-in grouping.
+	ItemCategoryGroups map[string]string
+
+	// Per-mob overrides of the amount subtracted from stored MVP kill
+	// counts before display, keyed by mob ID. Mobs that started being
+	// tracked later have a different baseline than the default of 3.
+	MvpKillOffsets map[string]int
+
+	// Characters kept by the name and item sanitizers, written as the
+	// body of a regexp character class (e.g. `\p{L}0-9 `). Anything
+	// outside the class is stripped from Discord author names and from
+	// item names before the item-ID lookup.
+	NameAllowedChars string
+	ItemAllowedChars string
+
+	// Route that a bare "/" redirects to. Must be one of homePages; the
+	// default "/summary" serves the summary at "/" without redirecting.
+	HomePage string
+
+	// What /characters shows a first-time visitor (no query string): the
+	// class tiers in the class graph (from characterGraphGroups) and the
+	// visible table columns (from characterColumns).
+	CharacterGraphFilter []string
+	CharacterColumns     []string
+
+	// Pseudo-character names (chat and drop announcements are logged as
+	// "System") left out of drop stats, player chat channels, search
+	// results and character leaderboards.
+	ExcludedCharacterNames []string
+
+	// Feature flags (see featureFlagDefs in the server package) that
+	// start switched off. The admin panel can override either way at
+	// runtime; overrides persist in the feature_flags table.
+	DisabledFeatures []string
+
+	// If true, first-time visitors without a lang cookie get the page in
+	// the language their browser's Accept-Language prefers, when it is
+	// one of the loaded ones, instead of Portuguese. Choosing a language
+	// explicitly still sets the cookie, which always wins.
+	DetectBrowserLanguage bool
+
+	// IANA zone name (e.g. "America/Sao_Paulo") used when formatting
+	// timestamps for display. Empty means the server's local zone.
+	// Stored timestamps are unaffected.
+	DisplayTimezone string
 }
 
+// DefaultItemImageURL is the item icon source used when ITEM_IMAGE_URL
+// is unset.
+const DefaultItemImageURL = "https://static.divine-pride.net/images/items/item/%d.png"
+
+// Default sanitizer character classes. Names keep letters in any script
+// (including combining accents) and digits; item names additionally keep
+// the punctuation real item names use, such as "Baphomet's Horn" or
+// "Sword Mace: Lv.1".
+const (
+	DefaultNameAllowedChars = `\p{L}\p{M}0-9 `
+	DefaultItemAllowedChars = `\p{L}\p{M}\p{N}\s\[\]\+\-'.:,()&!?/`
+)
+
+// homePages are the public routes HOME_PAGE may point at: full pages
+// that render without query parameters. Keep in sync with the routes
+// registered in server.New.
+var homePages = []string{
+	"/summary", "/full-list", "/stores", "/activity", "/discord", "/chat",
+	"/players", "/characters", "/guilds", "/mvp-kills", "/woe",
+	"/stats/drops", "/stats/market", "/stats/characters", "/stats/wealth", "/stats/rebirths",
+	"/stats/guild-churn", "/xp-calculator", "/about",
+}
+
+// characterGraphGroups and characterColumns are the values
+// CHARACTER_GRAPH_FILTER and CHARACTER_COLUMNS may list. Keep in sync with
+// the graph filters and columns of the characters page.
+var (
+	characterGraphGroups = []string{"novice", "first", "second"}
+	characterColumns     = []string{
+		"rank", "base_level", "job_level", "experience", "zeny", "class",
+		"guild", "last_updated", "last_active", "velocity",
+	}
+)
+
+// Default first-visit view of the characters page.
+var (
+	DefaultCharacterGraphFilter = []string{"second"}
+	DefaultCharacterColumns     = []string{"base_level", "job_level", "experience", "class", "guild", "last_active"}
+)
+
+// DefaultTrustedProxies is used when TRUSTED_PROXIES is unset: a reverse
+// proxy on the same host.
+var DefaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+// DefaultExcludedCharacterNames is used when EXCLUDED_CHARACTER_NAMES is
+// unset.
+var DefaultExcludedCharacterNames = []string{"System"}
+
 // Load reads env vars, applies defaults, and validates the result. It
 // returns a typed Config or an error describing every problem found.
 func Load() (*Config, error) {
+	var problems []string
 	cfg := &Config{
 		HTTPAddr:             envOr("HTTP_ADDR", ":8080"),
 		DBPath:               envOr("DB_PATH", "./data/runtime/market_data.db"),
+		TLSCert:              strings.TrimSpace(os.Getenv("TLS_CERT")),
+		TLSKey:               strings.TrimSpace(os.Getenv("TLS_KEY")),
+		MetricsAddr:          strings.TrimSpace(os.Getenv("METRICS_ADDR")),
 		AdminUser:            envOr("ADMIN_USER", "admin"),
 		AdminPassword:        os.Getenv("ADMIN_PASSWORD"),
 		GeminiAPIKey:         os.Getenv("GEMINI_API_KEY"),
 		DiscordBotToken:      os.Getenv("DISCORD_BOT_TOKEN"),
-		ChatCaptureDevice:    os.Getenv("CHAT_CAPTURE_DEVICE"),
+		ChatCaptureDevice:    envOr("SNIFFER_INTERFACE", os.Getenv("CHAT_CAPTURE_DEVICE")),
 		ChatCapturePort:      os.Getenv("CHAT_CAPTURE_PORT"),
+		ChatCaptureBPF:       strings.TrimSpace(os.Getenv("SNIFFER_BPF")),
 		RequireAdminPassword: boolEnv("REQUIRE_ADMIN_PASSWORD"),
+		LogAdminPassword:     boolEnv("LOG_ADMIN_PASSWORD"),
 		DisableScrapers:      boolEnv("DISABLE_SCRAPERS"),
+		DisableChatSniffer:   boolEnv("DISABLE_CHAT_SNIFFER"),
+		IncludeBankZeny:      boolEnv("INCLUDE_BANK_ZENY"),
+
+		PlayerHistoryRetentionDays: intEnv("PLAYER_HISTORY_RETENTION_DAYS", 90, &problems),
+		StaleListingHours:          intEnv("STALE_LISTING_HOURS", 0, &problems),
+		StaleDataHours:             intEnv("STALE_DATA_HOURS", 2, &problems),
+		UpdatedFreshIntervals:      intEnv("UPDATED_FRESH_INTERVALS", 2, &problems),
+		UpdatedStaleIntervals:      intEnv("UPDATED_STALE_INTERVALS", 6, &problems),
+		SlowQueryMS:                intEnv("SLOW_QUERY_MS", 0, &problems),
+		OnlineLookupConcurrency:    intEnv("ONLINE_LOOKUP_CONCURRENCY", 2, &problems),
+		TradeItemIDRetryHours:      intEnv("TRADE_ITEM_ID_RETRY_HOURS", 24, &problems),
+		MaxResultRows:              intEnv("MAX_RESULT_ROWS", 5000, &problems),
+		RenderBufferKB:             intEnv("RENDER_BUFFER_KB", 1024, &problems),
+		ChatBatchSize:              intEnv("CHAT_BATCH_SIZE", 200, &problems),
+		ChatFlushSeconds:           intEnv("CHAT_FLUSH_SECONDS", 5, &problems),
+		PageViewChannelSize:        intEnv("PAGEVIEW_CHANNEL_SIZE", 1000, &problems),
+		PageViewBatchSize:          intEnv("PAGEVIEW_BATCH_SIZE", 100, &problems),
+		PageViewFlushSeconds:       intEnv("PAGEVIEW_FLUSH_INTERVAL", 10, &problems),
+		SearchRatePerMinute:        intEnv("SEARCH_RATE_PER_MIN", 10, &problems),
+		SearchRateBurst:            intEnv("SEARCH_RATE_BURST", 10, &problems),
+		VendFeePercent:             floatEnv("VEND_FEE_PERCENT", 0, &problems),
+		FairPriceWindowDays:        intEnv("FAIR_PRICE_WINDOW_DAYS", 30, &problems),
+		StatsOutlierFactor:         floatEnv("STATS_OUTLIER_FACTOR", 0, &problems),
+		ActivityMinExpDelta:        floatEnv("ACTIVITY_MIN_EXP_DELTA", 0.001, &problems),
+		ActivityMinZenyDelta:       intEnv("ACTIVITY_MIN_ZENY_DELTA", 0, &problems),
+		ItemImageURL:               envOr("ITEM_IMAGE_URL", DefaultItemImageURL),
+		NameAllowedChars:           envOr("NAME_ALLOWED_CHARS", DefaultNameAllowedChars),
+		ItemAllowedChars:           envOr("ITEM_ALLOWED_CHARS", DefaultItemAllowedChars),
+		HomePage:                   envOr("HOME_PAGE", "/summary"),
+		DisplayTimezone:            strings.TrimSpace(os.Getenv("DISPLAY_TIMEZONE")),
+		PriceWatchWebhookURL:       strings.TrimSpace(os.Getenv("PRICE_WATCH_WEBHOOK_URL")),
+		DetectBrowserLanguage:      boolEnv("DETECT_BROWSER_LANGUAGE"),
+	}
+
+	cfg.ItemCategoryGroups = mapEnv("ITEM_CATEGORY_GROUPS", &problems)
+	for mobID, v := range mapEnv("MVP_KILL_OFFSETS", &problems) {
+		n, err := strconv.Atoi(v)
+		if _, idErr := strconv.Atoi(mobID); idErr != nil || err != nil || n < 0 {
+			problems = append(problems, fmt.Sprintf("MVP_KILL_OFFSETS entries must look like mobID=offset with a non-negative offset, got %q", mobID+"="+v))
+			continue
+		}
+		if cfg.MvpKillOffsets == nil {
+			cfg.MvpKillOffsets = make(map[string]int)
+		}
+		cfg.MvpKillOffsets[mobID] = n
 	}
+	cfg.CharacterGraphFilter = listEnv("CHARACTER_GRAPH_FILTER", DefaultCharacterGraphFilter)
+	cfg.CharacterColumns = listEnv("CHARACTER_COLUMNS", DefaultCharacterColumns)
+	cfg.PriceHistoryTolerancePercent = floatEnv("PRICE_HISTORY_TOLERANCE_PERCENT", 0, &problems)
+	cfg.ExcludedCharacterNames = listEnv("EXCLUDED_CHARACTER_NAMES", DefaultExcludedCharacterNames)
+	cfg.DisabledFeatures = listEnv("DISABLED_FEATURES", nil)
+	cfg.TrustedProxies = prefixListEnv("TRUSTED_PROXIES", DefaultTrustedProxies, &problems)
 
 	if ids := os.Getenv("DISCORD_CHANNEL_IDS"); ids != "" {
 		for _, id := range strings.Split(ids, ",") {
@@ -69,13 +374,126 @@ func Load() (*Config, error) {
 		}
 	}
 
-	var problems []string
 	if cfg.RequireAdminPassword && cfg.AdminPassword == "" {
 		problems = append(problems, "REQUIRE_ADMIN_PASSWORD is set but ADMIN_PASSWORD is empty")
 	}
+	if cfg.PlayerHistoryRetentionDays < 0 {
+		problems = append(problems, "PLAYER_HISTORY_RETENTION_DAYS must not be negative")
+	}
+	if cfg.VendFeePercent < 0 || cfg.VendFeePercent >= 100 {
+		problems = append(problems, "VEND_FEE_PERCENT must be in [0, 100)")
+	}
+	if cfg.SearchRatePerMinute < 0 {
+		problems = append(problems, "SEARCH_RATE_PER_MIN must not be negative")
+	}
+	if cfg.SearchRateBurst < 1 {
+		problems = append(problems, "SEARCH_RATE_BURST must be at least 1")
+	}
+	if cfg.StaleListingHours < 0 {
+		problems = append(problems, "STALE_LISTING_HOURS must not be negative")
+	}
+	if cfg.StaleDataHours < 0 {
+		problems = append(problems, "STALE_DATA_HOURS must not be negative")
+	}
+	if cfg.UpdatedFreshIntervals < 1 {
+		problems = append(problems, "UPDATED_FRESH_INTERVALS must be at least 1")
+	}
+	if cfg.UpdatedStaleIntervals < cfg.UpdatedFreshIntervals {
+		problems = append(problems, "UPDATED_STALE_INTERVALS must not be less than UPDATED_FRESH_INTERVALS")
+	}
+	if cfg.SlowQueryMS < 0 {
+		problems = append(problems, "SLOW_QUERY_MS must not be negative")
+	}
+	if cfg.OnlineLookupConcurrency < 1 {
+		problems = append(problems, "ONLINE_LOOKUP_CONCURRENCY must be at least 1")
+	}
+	if cfg.TradeItemIDRetryHours < 0 {
+		problems = append(problems, "TRADE_ITEM_ID_RETRY_HOURS must not be negative")
+	}
+	if cfg.PriceWatchWebhookURL != "" {
+		if u, err := url.Parse(cfg.PriceWatchWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("PRICE_WATCH_WEBHOOK_URL %q must be an http(s) URL", cfg.PriceWatchWebhookURL))
+		}
+	}
+	if cfg.MaxResultRows < 1 {
+		problems = append(problems, "MAX_RESULT_ROWS must be at least 1")
+	}
+	if cfg.RenderBufferKB < 0 {
+		problems = append(problems, "RENDER_BUFFER_KB must not be negative")
+	}
+	if cfg.ChatBatchSize < 1 {
+		problems = append(problems, "CHAT_BATCH_SIZE must be at least 1")
+	}
+	if cfg.ChatFlushSeconds < 1 {
+		problems = append(problems, "CHAT_FLUSH_SECONDS must be at least 1")
+	}
+	if cfg.PageViewChannelSize < 1 {
+		problems = append(problems, "PAGEVIEW_CHANNEL_SIZE must be at least 1")
+	}
+	if cfg.PageViewBatchSize < 1 {
+		problems = append(problems, "PAGEVIEW_BATCH_SIZE must be at least 1")
+	}
+	if cfg.PageViewFlushSeconds < 1 {
+		problems = append(problems, "PAGEVIEW_FLUSH_INTERVAL must be at least 1")
+	}
+	if cfg.ActivityMinExpDelta < 0 {
+		problems = append(problems, "ACTIVITY_MIN_EXP_DELTA must not be negative")
+	}
+	if cfg.ActivityMinZenyDelta < 0 {
+		problems = append(problems, "ACTIVITY_MIN_ZENY_DELTA must not be negative")
+	}
+	if cfg.FairPriceWindowDays < 0 {
+		problems = append(problems, "FAIR_PRICE_WINDOW_DAYS must not be negative")
+	}
+	if cfg.PriceHistoryTolerancePercent < 0 || cfg.PriceHistoryTolerancePercent >= 100 {
+		problems = append(problems, "PRICE_HISTORY_TOLERANCE_PERCENT must be in [0, 100)")
+	}
+	if !slices.Contains(homePages, cfg.HomePage) {
+		problems = append(problems, fmt.Sprintf("HOME_PAGE %q is not a known page; use one of %s", cfg.HomePage, strings.Join(homePages, ", ")))
+	}
+	if cfg.StatsOutlierFactor != 0 && cfg.StatsOutlierFactor <= 1 {
+		problems = append(problems, "STATS_OUTLIER_FACTOR must be 0 (disabled) or greater than 1")
+	}
+	if strings.Count(cfg.ItemImageURL, "%d") != 1 || strings.Count(cfg.ItemImageURL, "%") != 1 {
+		problems = append(problems, fmt.Sprintf("ITEM_IMAGE_URL %q must contain exactly one %%d and no other %% verbs", cfg.ItemImageURL))
+	}
+	for _, group := range cfg.CharacterGraphFilter {
+		if !slices.Contains(characterGraphGroups, group) {
+			problems = append(problems, fmt.Sprintf("CHARACTER_GRAPH_FILTER %q is not a class tier; use %s", group, strings.Join(characterGraphGroups, ", ")))
+		}
+	}
+	for _, col := range cfg.CharacterColumns {
+		if !slices.Contains(characterColumns, col) {
+			problems = append(problems, fmt.Sprintf("CHARACTER_COLUMNS %q is not a column; use %s", col, strings.Join(characterColumns, ", ")))
+		}
+	}
+	for key, chars := range map[string]string{"NAME_ALLOWED_CHARS": cfg.NameAllowedChars, "ITEM_ALLOWED_CHARS": cfg.ItemAllowedChars} {
+		if _, err := regexp.Compile("[^" + chars + "]+"); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q is not a valid character class: %v", key, chars, err))
+		}
+	}
+	if cfg.DisplayTimezone != "" {
+		if _, err := time.LoadLocation(cfg.DisplayTimezone); err != nil {
+			problems = append(problems, fmt.Sprintf("DISPLAY_TIMEZONE %q is not a valid timezone: %v", cfg.DisplayTimezone, err))
+		}
+	}
 	if cfg.HTTPAddr == "" {
 		problems = append(problems, "HTTP_ADDR is empty")
 	}
+	if cfg.MetricsAddr != "" && cfg.MetricsAddr == cfg.HTTPAddr {
+		problems = append(problems, "METRICS_ADDR must differ from HTTP_ADDR; leave it empty to serve /metrics on HTTP_ADDR")
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		problems = append(problems, "TLS_CERT and TLS_KEY must be set together")
+	}
+	for _, f := range []struct{ key, path string }{{"TLS_CERT", cfg.TLSCert}, {"TLS_KEY", cfg.TLSKey}} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q is not readable: %v", f.key, f.path, err))
+		}
+	}
 	if cfg.DBPath == "" {
 		problems = append(problems, "DB_PATH is empty")
 	} else {
@@ -108,6 +526,90 @@ func envOr(key, fallback string) string {
 	return fallback
 }
 
+// intEnv parses key as an integer, returning fallback when it is unset.
+// Unparseable values are reported through problems.
+func intEnv(key string, fallback int, problems *[]string) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("%s must be an integer, got %q", key, v))
+		return fallback
+	}
+	return n
+}
+
+// floatEnv is intEnv for decimal values.
+func floatEnv(key string, fallback float64, problems *[]string) float64 {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("%s must be a number, got %q", key, v))
+		return fallback
+	}
+	return f
+}
+
+// mapEnv parses key as comma-separated "key=value" pairs. Entries with
+// an empty key or value are reported through problems.
+func mapEnv(key string, problems *[]string) map[string]string {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		k, val, _ := strings.Cut(pair, "=")
+		k, val = strings.TrimSpace(k), strings.TrimSpace(val)
+		if k == "" || val == "" {
+			*problems = append(*problems, fmt.Sprintf("%s entries must look like key=value, got %q", key, strings.TrimSpace(pair)))
+			continue
+		}
+		m[k] = val
+	}
+	return m
+}
+
+// listEnv parses key as a comma-separated list, dropping empty entries.
+// It returns fallback when the variable is unset or lists nothing.
+func listEnv(key string, fallback []string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	if len(list) == 0 {
+		return fallback
+	}
+	return list
+}
+
+// prefixListEnv parses key as a comma-separated list of CIDRs, where a
+// bare address stands for itself alone. Unparseable entries are reported
+// through problems.
+func prefixListEnv(key string, fallback []string, problems *[]string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, v := range listEnv(key, fallback) {
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(v)
+			if addrErr != nil {
+				*problems = append(*problems, fmt.Sprintf("%s entry %q is not a CIDR or IP address", key, v))
+				continue
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes
+}
+
 func boolEnv(key string) bool {
 	v := strings.ToLower(strings.TrimSpace(os.Getenv(key)))
 	return v == "1" || v == "true" || v == "yes"
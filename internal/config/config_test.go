@@ -1,6 +1,10 @@
 package config
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -10,7 +14,22 @@ var envKeys = []string{
 	"HTTP_ADDR", "DB_PATH", "ADMIN_USER", "ADMIN_PASSWORD",
 	"GEMINI_API_KEY", "DISCORD_BOT_TOKEN", "DISCORD_CHANNEL_IDS",
 	"CHAT_CAPTURE_DEVICE", "CHAT_CAPTURE_PORT", "REQUIRE_ADMIN_PASSWORD",
-	"DISABLE_SCRAPERS",
+	"DISABLE_SCRAPERS", "PLAYER_HISTORY_RETENTION_DAYS", "VEND_FEE_PERCENT",
+	"DISPLAY_TIMEZONE", "FAIR_PRICE_WINDOW_DAYS", "DISABLE_CHAT_SNIFFER",
+	"SNIFFER_INTERFACE", "SNIFFER_BPF", "ACTIVITY_MIN_EXP_DELTA",
+	"ACTIVITY_MIN_ZENY_DELTA", "STALE_LISTING_HOURS", "STALE_DATA_HOURS",
+	"ITEM_IMAGE_URL", "STATS_OUTLIER_FACTOR", "HOME_PAGE",
+	"SEARCH_RATE_PER_MIN", "SEARCH_RATE_BURST", "SLOW_QUERY_MS",
+	"ITEM_CATEGORY_GROUPS", "ONLINE_LOOKUP_CONCURRENCY", "LOG_ADMIN_PASSWORD",
+	"TLS_CERT", "TLS_KEY", "INCLUDE_BANK_ZENY", "NAME_ALLOWED_CHARS",
+	"ITEM_ALLOWED_CHARS", "MAX_RESULT_ROWS", "RENDER_BUFFER_KB",
+	"CHARACTER_GRAPH_FILTER", "CHARACTER_COLUMNS", "CHAT_BATCH_SIZE",
+	"CHAT_FLUSH_SECONDS", "EXCLUDED_CHARACTER_NAMES",
+	"PRICE_HISTORY_TOLERANCE_PERCENT", "DISABLED_FEATURES",
+	"UPDATED_FRESH_INTERVALS", "UPDATED_STALE_INTERVALS", "DETECT_BROWSER_LANGUAGE",
+	"TRADE_ITEM_ID_RETRY_HOURS", "PAGEVIEW_CHANNEL_SIZE", "PAGEVIEW_BATCH_SIZE",
+	"PAGEVIEW_FLUSH_INTERVAL", "METRICS_ADDR", "TRUSTED_PROXIES",
+	"PRICE_WATCH_WEBHOOK_URL", "MVP_KILL_OFFSETS",
 }
 
 func clearEnv(t *testing.T) {
@@ -69,6 +88,57 @@ func TestLoadRequireAdminPasswordValidation(t *testing.T) {
 	}
 }
 
+func TestLoadLogAdminPassword(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.LogAdminPassword {
+		t.Error("LogAdminPassword should default to false")
+	}
+
+	t.Setenv("LOG_ADMIN_PASSWORD", "1")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if !cfg.LogAdminPassword {
+		t.Error("LOG_ADMIN_PASSWORD=1 should enable LogAdminPassword")
+	}
+}
+
+func TestLoadTLS(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "cert.pem")
+	key := filepath.Join(dir, "key.pem")
+	for _, p := range []string{cert, key} {
+		if err := os.WriteFile(p, []byte("pem"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("TLS_CERT", cert)
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail when TLS_CERT is set without TLS_KEY")
+	}
+
+	t.Setenv("TLS_KEY", key)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.TLSCert != cert || cfg.TLSKey != key {
+		t.Errorf("TLS files = %q, %q, want %q, %q", cfg.TLSCert, cfg.TLSKey, cert, key)
+	}
+
+	t.Setenv("TLS_KEY", filepath.Join(dir, "missing.pem"))
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail for a missing TLS_KEY file")
+	}
+}
+
 func TestLoadDBDirectoryValidation(t *testing.T) {
 	clearEnv(t)
 	// Under Linux, creating directories under '/' requires root.
@@ -80,3 +150,779 @@ func TestLoadDBDirectoryValidation(t *testing.T) {
 		t.Fatal("Load() should fail when database parent directory is unwritable")
 	}
 }
+
+func TestLoadPlayerHistoryRetentionDays(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.PlayerHistoryRetentionDays != 90 {
+		t.Errorf("PlayerHistoryRetentionDays default = %d, want 90", cfg.PlayerHistoryRetentionDays)
+	}
+
+	t.Setenv("PLAYER_HISTORY_RETENTION_DAYS", "0")
+	if cfg, err = Load(); err != nil || cfg.PlayerHistoryRetentionDays != 0 {
+		t.Errorf("PLAYER_HISTORY_RETENTION_DAYS=0: got %v, %v", cfg, err)
+	}
+
+	for _, bad := range []string{"abc", "-1"} {
+		t.Setenv("PLAYER_HISTORY_RETENTION_DAYS", bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("PLAYER_HISTORY_RETENTION_DAYS=%q should fail", bad)
+		}
+	}
+}
+
+func TestLoadVendFeePercent(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.VendFeePercent != 0 {
+		t.Errorf("VendFeePercent default = %v, want 0", cfg.VendFeePercent)
+	}
+
+	t.Setenv("VEND_FEE_PERCENT", "2.5")
+	if cfg, err = Load(); err != nil || cfg.VendFeePercent != 2.5 {
+		t.Errorf("VEND_FEE_PERCENT=2.5: got %v, %v", cfg, err)
+	}
+
+	for _, bad := range []string{"abc", "-1", "100"} {
+		t.Setenv("VEND_FEE_PERCENT", bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("VEND_FEE_PERCENT=%q should fail", bad)
+		}
+	}
+}
+
+func TestLoadFairPriceWindowDays(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.FairPriceWindowDays != 30 {
+		t.Errorf("FairPriceWindowDays default = %d, want 30", cfg.FairPriceWindowDays)
+	}
+
+	t.Setenv("FAIR_PRICE_WINDOW_DAYS", "0")
+	if cfg, err = Load(); err != nil || cfg.FairPriceWindowDays != 0 {
+		t.Errorf("FAIR_PRICE_WINDOW_DAYS=0: got %v, %v", cfg, err)
+	}
+
+	t.Setenv("FAIR_PRICE_WINDOW_DAYS", "-1")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail for a negative FAIR_PRICE_WINDOW_DAYS")
+	}
+}
+
+func TestLoadStaleListingHours(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.StaleListingHours != 0 {
+		t.Errorf("StaleListingHours default = %d, want 0", cfg.StaleListingHours)
+	}
+
+	t.Setenv("STALE_LISTING_HOURS", "6")
+	if cfg, err := Load(); err != nil || cfg.StaleListingHours != 6 {
+		t.Errorf("override: got %d, %v", cfg.StaleListingHours, err)
+	}
+
+	t.Setenv("STALE_LISTING_HOURS", "-3")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail for a negative STALE_LISTING_HOURS")
+	}
+}
+
+func TestLoadActivityThresholds(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.ActivityMinExpDelta != 0.001 || cfg.ActivityMinZenyDelta != 0 {
+		t.Errorf("defaults = %v / %d, want 0.001 / 0", cfg.ActivityMinExpDelta, cfg.ActivityMinZenyDelta)
+	}
+
+	t.Setenv("ACTIVITY_MIN_EXP_DELTA", "0.05")
+	t.Setenv("ACTIVITY_MIN_ZENY_DELTA", "10000")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.ActivityMinExpDelta != 0.05 || cfg.ActivityMinZenyDelta != 10000 {
+		t.Errorf("got %v / %d, want 0.05 / 10000", cfg.ActivityMinExpDelta, cfg.ActivityMinZenyDelta)
+	}
+
+	for key, bad := range map[string]string{"ACTIVITY_MIN_EXP_DELTA": "-0.1", "ACTIVITY_MIN_ZENY_DELTA": "-1"} {
+		clearEnv(t)
+		t.Setenv(key, bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("%s=%s should fail", key, bad)
+		}
+	}
+}
+
+func TestLoadSnifferInterface(t *testing.T) {
+	clearEnv(t)
+
+	t.Setenv("CHAT_CAPTURE_DEVICE", "eth0")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.ChatCaptureDevice != "eth0" {
+		t.Errorf("ChatCaptureDevice = %q, want eth0 from CHAT_CAPTURE_DEVICE", cfg.ChatCaptureDevice)
+	}
+
+	t.Setenv("SNIFFER_INTERFACE", "ens5")
+	t.Setenv("SNIFFER_BPF", " tcp port 6121 and host 10.0.0.2 ")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.ChatCaptureDevice != "ens5" {
+		t.Errorf("ChatCaptureDevice = %q, want SNIFFER_INTERFACE to win", cfg.ChatCaptureDevice)
+	}
+	if cfg.ChatCaptureBPF != "tcp port 6121 and host 10.0.0.2" {
+		t.Errorf("ChatCaptureBPF = %q", cfg.ChatCaptureBPF)
+	}
+}
+
+func TestLoadSearchRateLimit(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.SearchRatePerMinute != 10 || cfg.SearchRateBurst != 10 {
+		t.Errorf("defaults = %d/min burst %d, want 10/min burst 10", cfg.SearchRatePerMinute, cfg.SearchRateBurst)
+	}
+
+	t.Setenv("SEARCH_RATE_PER_MIN", "0")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.SearchRatePerMinute != 0 {
+		t.Errorf("SearchRatePerMinute = %d, want 0 (disabled)", cfg.SearchRatePerMinute)
+	}
+
+	for key, bad := range map[string]string{"SEARCH_RATE_PER_MIN": "-1", "SEARCH_RATE_BURST": "0"} {
+		clearEnv(t)
+		t.Setenv(key, bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("%s=%s should fail", key, bad)
+		}
+	}
+}
+
+func TestLoadItemCategoryGroups(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.ItemCategoryGroups != nil {
+		t.Errorf("ItemCategoryGroups default = %v, want nil", cfg.ItemCategoryGroups)
+	}
+
+	t.Setenv("ITEM_CATEGORY_GROUPS", "ShadowGear=Shadow Gear, PetEquip = Pet Equipment")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if len(cfg.ItemCategoryGroups) != 2 || cfg.ItemCategoryGroups["ShadowGear"] != "Shadow Gear" || cfg.ItemCategoryGroups["PetEquip"] != "Pet Equipment" {
+		t.Errorf("ItemCategoryGroups = %v", cfg.ItemCategoryGroups)
+	}
+
+	for _, bad := range []string{"ShadowGear", "=Armor", "ShadowGear="} {
+		t.Setenv("ITEM_CATEGORY_GROUPS", bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("ITEM_CATEGORY_GROUPS=%q should fail", bad)
+		}
+	}
+}
+
+func TestLoadStaleDataHours(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.StaleDataHours != 2 {
+		t.Errorf("StaleDataHours default = %d, want 2", cfg.StaleDataHours)
+	}
+
+	t.Setenv("STALE_DATA_HOURS", "0")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.StaleDataHours != 0 {
+		t.Errorf("StaleDataHours = %d, want 0 (disabled)", cfg.StaleDataHours)
+	}
+
+	t.Setenv("STALE_DATA_HOURS", "-1")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail for a negative STALE_DATA_HOURS")
+	}
+}
+
+func TestLoadUpdatedIntervals(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.UpdatedFreshIntervals != 2 || cfg.UpdatedStaleIntervals != 6 {
+		t.Errorf("Updated intervals default = %d/%d, want 2/6", cfg.UpdatedFreshIntervals, cfg.UpdatedStaleIntervals)
+	}
+
+	t.Setenv("UPDATED_FRESH_INTERVALS", "3")
+	t.Setenv("UPDATED_STALE_INTERVALS", "3")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.UpdatedFreshIntervals != 3 || cfg.UpdatedStaleIntervals != 3 {
+		t.Errorf("Updated intervals = %d/%d, want 3/3", cfg.UpdatedFreshIntervals, cfg.UpdatedStaleIntervals)
+	}
+
+	t.Setenv("UPDATED_STALE_INTERVALS", "2")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail when UPDATED_STALE_INTERVALS is below UPDATED_FRESH_INTERVALS")
+	}
+
+	t.Setenv("UPDATED_FRESH_INTERVALS", "0")
+	t.Setenv("UPDATED_STALE_INTERVALS", "")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail for UPDATED_FRESH_INTERVALS=0")
+	}
+}
+
+func TestLoadSlowQueryMS(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.SlowQueryMS != 0 {
+		t.Errorf("SlowQueryMS default = %d, want 0 (disabled)", cfg.SlowQueryMS)
+	}
+
+	t.Setenv("SLOW_QUERY_MS", "200")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.SlowQueryMS != 200 {
+		t.Errorf("SlowQueryMS = %d, want 200", cfg.SlowQueryMS)
+	}
+
+	t.Setenv("SLOW_QUERY_MS", "-1")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail for a negative SLOW_QUERY_MS")
+	}
+}
+
+func TestLoadOnlineLookupConcurrency(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.OnlineLookupConcurrency != 2 {
+		t.Errorf("OnlineLookupConcurrency default = %d, want 2", cfg.OnlineLookupConcurrency)
+	}
+
+	t.Setenv("ONLINE_LOOKUP_CONCURRENCY", "5")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.OnlineLookupConcurrency != 5 {
+		t.Errorf("OnlineLookupConcurrency = %d, want 5", cfg.OnlineLookupConcurrency)
+	}
+
+	t.Setenv("ONLINE_LOOKUP_CONCURRENCY", "0")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail for ONLINE_LOOKUP_CONCURRENCY=0")
+	}
+}
+
+func TestLoadStatsOutlierFactor(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.StatsOutlierFactor != 0 {
+		t.Errorf("StatsOutlierFactor default = %v, want 0", cfg.StatsOutlierFactor)
+	}
+
+	t.Setenv("STATS_OUTLIER_FACTOR", "5")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.StatsOutlierFactor != 5 {
+		t.Errorf("StatsOutlierFactor = %v, want 5", cfg.StatsOutlierFactor)
+	}
+
+	for _, bad := range []string{"1", "0.5", "-2"} {
+		t.Setenv("STATS_OUTLIER_FACTOR", bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("STATS_OUTLIER_FACTOR=%s should fail", bad)
+		}
+	}
+}
+
+func TestLoadHomePage(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.HomePage != "/summary" {
+		t.Errorf("HomePage default = %q, want /summary", cfg.HomePage)
+	}
+
+	t.Setenv("HOME_PAGE", "/activity")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.HomePage != "/activity" {
+		t.Errorf("HomePage = %q, want /activity", cfg.HomePage)
+	}
+
+	for _, bad := range []string{"/", "/admin", "activity", "https://example.com/"} {
+		t.Setenv("HOME_PAGE", bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("HOME_PAGE=%q should fail", bad)
+		}
+	}
+}
+
+func TestLoadItemImageURL(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.ItemImageURL != DefaultItemImageURL {
+		t.Errorf("ItemImageURL default = %q, want %q", cfg.ItemImageURL, DefaultItemImageURL)
+	}
+
+	t.Setenv("ITEM_IMAGE_URL", "https://cdn.example.com/items/%d.png")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.ItemImageURL != "https://cdn.example.com/items/%d.png" {
+		t.Errorf("ItemImageURL = %q", cfg.ItemImageURL)
+	}
+
+	for _, bad := range []string{"https://cdn.example.com/items/", "/items/%s.png", "/items/%d/%d.png", "/items/%d.png?w=50%"} {
+		t.Setenv("ITEM_IMAGE_URL", bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("ITEM_IMAGE_URL=%q should fail", bad)
+		}
+	}
+}
+
+func TestLoadDisplayTimezone(t *testing.T) {
+	clearEnv(t)
+
+	t.Setenv("DISPLAY_TIMEZONE", "America/Sao_Paulo")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.DisplayTimezone != "America/Sao_Paulo" {
+		t.Errorf("DisplayTimezone = %q, want America/Sao_Paulo", cfg.DisplayTimezone)
+	}
+
+	t.Setenv("DISPLAY_TIMEZONE", "Mars/Olympus_Mons")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail for an unknown DISPLAY_TIMEZONE")
+	}
+}
+
+func TestLoadIncludeBankZeny(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.IncludeBankZeny {
+		t.Error("IncludeBankZeny should default to false")
+	}
+
+	t.Setenv("INCLUDE_BANK_ZENY", "true")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if !cfg.IncludeBankZeny {
+		t.Error("INCLUDE_BANK_ZENY=true should enable IncludeBankZeny")
+	}
+}
+
+func TestLoadDetectBrowserLanguage(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.DetectBrowserLanguage {
+		t.Error("DetectBrowserLanguage should default to false")
+	}
+
+	t.Setenv("DETECT_BROWSER_LANGUAGE", "1")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if !cfg.DetectBrowserLanguage {
+		t.Error("DETECT_BROWSER_LANGUAGE=1 should enable DetectBrowserLanguage")
+	}
+}
+
+func TestLoadAllowedChars(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.NameAllowedChars != DefaultNameAllowedChars || cfg.ItemAllowedChars != DefaultItemAllowedChars {
+		t.Errorf("defaults not applied: name %q, item %q", cfg.NameAllowedChars, cfg.ItemAllowedChars)
+	}
+
+	t.Setenv("ITEM_ALLOWED_CHARS", `a-z\`)
+	if _, err := Load(); err == nil {
+		t.Error("an unterminated character class should be rejected")
+	}
+}
+
+func TestLoadMaxResultRows(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.MaxResultRows != 5000 {
+		t.Errorf("MaxResultRows default = %d, want 5000", cfg.MaxResultRows)
+	}
+
+	t.Setenv("MAX_RESULT_ROWS", "0")
+	if _, err := Load(); err == nil {
+		t.Error("MAX_RESULT_ROWS=0 should be rejected")
+	}
+}
+
+func TestLoadRenderBufferKB(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.RenderBufferKB != 1024 {
+		t.Errorf("RenderBufferKB default = %d, want 1024", cfg.RenderBufferKB)
+	}
+
+	t.Setenv("RENDER_BUFFER_KB", "0")
+	if cfg, err := Load(); err != nil || cfg.RenderBufferKB != 0 {
+		t.Errorf("RENDER_BUFFER_KB=0: got %d, %v; want streaming allowed", cfg.RenderBufferKB, err)
+	}
+
+	t.Setenv("RENDER_BUFFER_KB", "-1")
+	if _, err := Load(); err == nil {
+		t.Error("RENDER_BUFFER_KB=-1 should be rejected")
+	}
+}
+
+func TestLoadCharacterDefaults(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if !slices.Equal(cfg.CharacterGraphFilter, []string{"second"}) {
+		t.Errorf("CharacterGraphFilter default = %v, want [second]", cfg.CharacterGraphFilter)
+	}
+	if !slices.Equal(cfg.CharacterColumns, DefaultCharacterColumns) {
+		t.Errorf("CharacterColumns default = %v", cfg.CharacterColumns)
+	}
+
+	t.Setenv("CHARACTER_GRAPH_FILTER", "first, second")
+	t.Setenv("CHARACTER_COLUMNS", "rank,zeny,")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if !slices.Equal(cfg.CharacterGraphFilter, []string{"first", "second"}) || !slices.Equal(cfg.CharacterColumns, []string{"rank", "zeny"}) {
+		t.Errorf("got graph %v, columns %v", cfg.CharacterGraphFilter, cfg.CharacterColumns)
+	}
+
+	t.Setenv("CHARACTER_GRAPH_FILTER", "third")
+	if _, err := Load(); err == nil {
+		t.Error("an unknown class tier should be rejected")
+	}
+	t.Setenv("CHARACTER_GRAPH_FILTER", "")
+	t.Setenv("CHARACTER_COLUMNS", "rank,mana")
+	if _, err := Load(); err == nil {
+		t.Error("an unknown column should be rejected")
+	}
+}
+
+func TestLoadChatBatching(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.ChatBatchSize != 200 || cfg.ChatFlushSeconds != 5 {
+		t.Errorf("chat batching defaults = %d msgs / %ds, want 200 / 5s", cfg.ChatBatchSize, cfg.ChatFlushSeconds)
+	}
+
+	t.Setenv("CHAT_BATCH_SIZE", "50")
+	t.Setenv("CHAT_FLUSH_SECONDS", "2")
+	if cfg, err := Load(); err != nil || cfg.ChatBatchSize != 50 || cfg.ChatFlushSeconds != 2 {
+		t.Errorf("override: got %d / %d, %v; want 50 / 2", cfg.ChatBatchSize, cfg.ChatFlushSeconds, err)
+	}
+
+	for _, key := range []string{"CHAT_BATCH_SIZE", "CHAT_FLUSH_SECONDS"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, "0")
+			if _, err := Load(); err == nil {
+				t.Errorf("%s=0 should be rejected", key)
+			}
+		})
+	}
+}
+
+func TestLoadExcludedCharacterNames(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if !slices.Equal(cfg.ExcludedCharacterNames, []string{"System"}) {
+		t.Errorf("ExcludedCharacterNames default = %v, want [System]", cfg.ExcludedCharacterNames)
+	}
+
+	t.Setenv("EXCLUDED_CHARACTER_NAMES", " System , GM Bot ,")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if !slices.Equal(cfg.ExcludedCharacterNames, []string{"System", "GM Bot"}) {
+		t.Errorf("ExcludedCharacterNames = %v, want [System GM Bot]", cfg.ExcludedCharacterNames)
+	}
+}
+
+func TestLoadPriceHistoryTolerance(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.PriceHistoryTolerancePercent != 0 {
+		t.Errorf("PriceHistoryTolerancePercent default = %v, want 0", cfg.PriceHistoryTolerancePercent)
+	}
+
+	t.Setenv("PRICE_HISTORY_TOLERANCE_PERCENT", "2.5")
+	if cfg, err := Load(); err != nil || cfg.PriceHistoryTolerancePercent != 2.5 {
+		t.Errorf("PRICE_HISTORY_TOLERANCE_PERCENT=2.5: got %v, %v", cfg.PriceHistoryTolerancePercent, err)
+	}
+
+	for _, v := range []string{"-1", "100"} {
+		t.Setenv("PRICE_HISTORY_TOLERANCE_PERCENT", v)
+		if _, err := Load(); err == nil {
+			t.Errorf("PRICE_HISTORY_TOLERANCE_PERCENT=%s should be rejected", v)
+		}
+	}
+}
+
+func TestLoadDisabledFeatures(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if len(cfg.DisabledFeatures) != 0 {
+		t.Errorf("DisabledFeatures default = %v, want none", cfg.DisabledFeatures)
+	}
+
+	t.Setenv("DISABLED_FEATURES", "guild-churn, items-all-json")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if !slices.Equal(cfg.DisabledFeatures, []string{"guild-churn", "items-all-json"}) {
+		t.Errorf("DisabledFeatures = %v, want [guild-churn items-all-json]", cfg.DisabledFeatures)
+	}
+}
+
+func TestLoadTradeItemIDRetryHours(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.TradeItemIDRetryHours != 24 {
+		t.Errorf("TradeItemIDRetryHours default = %d, want 24", cfg.TradeItemIDRetryHours)
+	}
+
+	t.Setenv("TRADE_ITEM_ID_RETRY_HOURS", "0")
+	if cfg, err = Load(); err != nil || cfg.TradeItemIDRetryHours != 0 {
+		t.Errorf("TRADE_ITEM_ID_RETRY_HOURS=0: got %d, %v", cfg.TradeItemIDRetryHours, err)
+	}
+
+	t.Setenv("TRADE_ITEM_ID_RETRY_HOURS", "-1")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail for a negative TRADE_ITEM_ID_RETRY_HOURS")
+	}
+}
+
+func TestLoadPageViewBatching(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.PageViewChannelSize != 1000 || cfg.PageViewBatchSize != 100 || cfg.PageViewFlushSeconds != 10 {
+		t.Errorf("page view defaults = %d / %d / %ds, want 1000 / 100 / 10s", cfg.PageViewChannelSize, cfg.PageViewBatchSize, cfg.PageViewFlushSeconds)
+	}
+
+	t.Setenv("PAGEVIEW_CHANNEL_SIZE", "5000")
+	t.Setenv("PAGEVIEW_BATCH_SIZE", "250")
+	t.Setenv("PAGEVIEW_FLUSH_INTERVAL", "3")
+	if cfg, err := Load(); err != nil || cfg.PageViewChannelSize != 5000 || cfg.PageViewBatchSize != 250 || cfg.PageViewFlushSeconds != 3 {
+		t.Errorf("override: got %d / %d / %d, %v; want 5000 / 250 / 3", cfg.PageViewChannelSize, cfg.PageViewBatchSize, cfg.PageViewFlushSeconds, err)
+	}
+
+	for _, key := range []string{"PAGEVIEW_CHANNEL_SIZE", "PAGEVIEW_BATCH_SIZE", "PAGEVIEW_FLUSH_INTERVAL"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, "0")
+			if _, err := Load(); err == nil {
+				t.Errorf("%s=0 should be rejected", key)
+			}
+		})
+	}
+}
+
+func TestLoadMetricsAddr(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.MetricsAddr != "" {
+		t.Errorf("MetricsAddr default = %q, want empty", cfg.MetricsAddr)
+	}
+
+	t.Setenv("METRICS_ADDR", "127.0.0.1:9090")
+	if cfg, err := Load(); err != nil || cfg.MetricsAddr != "127.0.0.1:9090" {
+		t.Errorf("override: got %q, %v; want 127.0.0.1:9090", cfg.MetricsAddr, err)
+	}
+
+	t.Setenv("METRICS_ADDR", ":8080")
+	if _, err := Load(); err == nil {
+		t.Error("METRICS_ADDR equal to HTTP_ADDR should be rejected")
+	}
+}
+
+func TestLoadTrustedProxies(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if got := fmt.Sprint(cfg.TrustedProxies); got != "[127.0.0.0/8 ::1/128]" {
+		t.Errorf("TrustedProxies default = %s, want loopback", got)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.1.2.3/8, 192.0.2.7 ,2001:db8::/32")
+	if cfg, err := Load(); err != nil || fmt.Sprint(cfg.TrustedProxies) != "[10.0.0.0/8 192.0.2.7/32 2001:db8::/32]" {
+		t.Errorf("override: got %v, %v", cfg.TrustedProxies, err)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,proxy.local")
+	if _, err := Load(); err == nil {
+		t.Error("a hostname in TRUSTED_PROXIES should be rejected")
+	}
+}
+
+func TestLoadPriceWatchWebhookURL(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.PriceWatchWebhookURL != "" {
+		t.Errorf("PriceWatchWebhookURL default = %q, want empty", cfg.PriceWatchWebhookURL)
+	}
+
+	t.Setenv("PRICE_WATCH_WEBHOOK_URL", "https://discord.com/api/webhooks/1/abc")
+	if cfg, err := Load(); err != nil || cfg.PriceWatchWebhookURL != "https://discord.com/api/webhooks/1/abc" {
+		t.Errorf("override: got %q, %v", cfg.PriceWatchWebhookURL, err)
+	}
+
+	for _, bad := range []string{"discord.com/hook", "ftp://example.com/hook", "https://"} {
+		t.Setenv("PRICE_WATCH_WEBHOOK_URL", bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("PRICE_WATCH_WEBHOOK_URL=%q should be rejected", bad)
+		}
+	}
+}
+
+func TestLoadMvpKillOffsets(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.MvpKillOffsets != nil {
+		t.Errorf("MvpKillOffsets default = %v, want nil", cfg.MvpKillOffsets)
+	}
+
+	t.Setenv("MVP_KILL_OFFSETS", "1511=0, 1373 = 5")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if len(cfg.MvpKillOffsets) != 2 || cfg.MvpKillOffsets["1511"] != 0 || cfg.MvpKillOffsets["1373"] != 5 {
+		t.Errorf("MvpKillOffsets = %v", cfg.MvpKillOffsets)
+	}
+
+	for _, bad := range []string{"1511", "1511=-1", "1511=two", "Baphomet=2"} {
+		t.Setenv("MVP_KILL_OFFSETS", bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("MVP_KILL_OFFSETS=%q should fail", bad)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package httpx
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"math"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
@@ -70,3 +73,57 @@ func GetSortClause(r *http.Request, allowedSorts map[string]string, defaultSortB
 
 	return fmt.Sprintf("ORDER BY %s %s", orderByColumn, order), sortBy, order
 }
+
+// WriteJSON encodes v as the JSON response body with the given status code.
+// Encoding errors after the header has been written can't be reported to
+// the client, so they're returned for the caller to log.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// WantsJSON reports whether the request asked for a JSON response via
+// ?format=json.
+func WantsJSON(r *http.Request) bool {
+	return strings.EqualFold(r.URL.Query().Get("format"), "json")
+}
+
+// PrefersJSON reports whether an error response to r should be JSON
+// rather than an HTML page: the request asked for ?format=json, hit a
+// .json route, or sent an Accept header naming JSON but not HTML.
+func PrefersJSON(r *http.Request) bool {
+	if WantsJSON(r) || strings.HasSuffix(r.URL.Path, ".json") {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// WriteCSV writes header and rows as a CSV attachment named filename.
+// As with WriteJSON, write errors are returned for the caller to log.
+func WriteCSV(w http.ResponseWriter, filename string, header []string, rows [][]string) error {
+	cw, err := StartCSV(w, filename, header)
+	if err != nil {
+		return err
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// StartCSV begins a CSV attachment named filename and writes its header
+// row, for callers that stream rows instead of building them all first.
+// The caller writes the rows, then calls Flush and checks Error.
+func StartCSV(w http.ResponseWriter, filename string, header []string) (*csv.Writer, error) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
@@ -85,3 +85,66 @@ func TestGetSortClause(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteJSONAndWantsJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := WriteJSON(rec, 404, map[string]string{"error": "nope"}); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	if rec.Code != 404 {
+		t.Errorf("status=%d want 404", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type=%q", ct)
+	}
+	if body := rec.Body.String(); body != "{\"error\":\"nope\"}\n" {
+		t.Errorf("body=%q", body)
+	}
+
+	for query, want := range map[string]bool{"": false, "format=json": true, "format=JSON": true, "format=html": false} {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		if got := WantsJSON(r); got != want {
+			t.Errorf("WantsJSON(%q)=%v want %v", query, got, want)
+		}
+	}
+}
+
+func TestPrefersJSON(t *testing.T) {
+	tests := []struct {
+		target, accept string
+		want           bool
+	}{
+		{"/character?name=x", "text/html,application/xhtml+xml,*/*;q=0.8", false},
+		{"/character?name=x", "", false},
+		{"/character?name=x&format=json", "", true},
+		{"/summary.json", "", true},
+		{"/nope", "application/json", true},
+		{"/nope", "text/html, application/json", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := PrefersJSON(r); got != tt.want {
+			t.Errorf("PrefersJSON(%s, Accept %q) = %v, want %v", tt.target, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rows := [][]string{{"Foo", "99"}, {"Bar, Jr", "1"}}
+	if err := WriteCSV(rec, "roster.csv", []string{"name", "level"}, rows); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type=%q", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != "attachment; filename=roster.csv" {
+		t.Errorf("Content-Disposition=%q", cd)
+	}
+	if body := rec.Body.String(); body != "name,level\nFoo,99\n\"Bar, Jr\",1\n" {
+		t.Errorf("body=%q", body)
+	}
+}
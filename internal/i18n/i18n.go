@@ -2,21 +2,36 @@ package i18n
 
 import (
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// DetectBrowserLanguage makes Lang fall back to the Accept-Language header
+// when the visitor has no lang cookie yet. Set once at startup from
+// DETECT_BROWSER_LANGUAGE, before requests are served.
+var DetectBrowserLanguage bool
+
 var (
 	translationsMap = map[string]map[string]string{
 		"en": {
 			"market_summary":         "Market Summary",
 			"search_by_item_name":    "Search by item name or ID...",
 			"show_only_available":    "Show only available",
+			"show_last_prices":       "Show last known prices",
+			"search_mode_auto":       "ID or name",
+			"search_mode_id":         "Item ID only",
+			"search_mode_name":       "Name only",
+			"last_price_hint":        "Not listed now; last price seen before it was delisted",
 			"search":                 "Search",
 			"all_items":              "All Items",
 			"showing_unique_items":   "Showing <strong>%d</strong> unique items.",
 			"item_name":              "Item Name",
 			"item_id":                "Item ID",
 			"available":              "Available",
+			"sold_7d":                "Sold (7d)",
+			"sold_7d_hint":           "Sales in the last 7 days",
 			"lowest_price":           "Lowest Price",
 			"highest_price":          "Highest Price",
 			"updated_never":          "Updated: never",
@@ -24,6 +39,26 @@ var (
 			"nav_summary":            "Summary",
 			"nav_full_list":          "Full List",
 			"nav_activity":           "Activity",
+			"nav_stores":             "Stores",
+			"sort_ignored":           "Sorting by \"%s\" isn't available on this page; showing results sorted by \"%s\".",
+			"stale_data":             "%s data is over %d hours old; the scraper may be behind.",
+			"stale_src_market":       "Market",
+			"stale_src_players":      "Player count",
+			"stale_src_chars":        "Character",
+			"stale_src_guilds":       "Guild",
+			"not_found_title":        "Page not found",
+			"not_found_hint":         "The link may be mistyped, or the character, guild or item may have been renamed.",
+			"error_title":            "Something went wrong",
+			"error_page_not_found":   "Page not found",
+			"error_char_not_found":   "Character not found",
+			"error_guild_not_found":  "Guild not found",
+			"error_watches_load":     "Could not load your price watches",
+			"back_home":              "Back to the home page",
+			"stores_title":           "Store Directory",
+			"search_by_store_seller": "Search by store or seller name",
+			"no_stores_found":        "No stores found matching your criteria.",
+			"showing_page_stores":    "Showing page %d of %d (%d total stores)",
+			"items_listed_now":       "Items Now",
 			"nav_discord":            "Discord",
 			"nav_chat":               "Chat",
 			"nav_misc":               "Misc.",
@@ -38,7 +73,6 @@ var (
 			"nav_woe_guild_by_class": "Guilds by Class",
 			// --- NEW for activity.html ---
 			"recent_market_activity": "Recent Market Activity",
-			"show_only_sold":         "Show only sold items",
 			"filter":                 "Filter",
 			"clear_filters":          "[Clear Filters]",
 			"was_added":              "was added for sale.",
@@ -48,6 +82,15 @@ var (
 			"new_historical_low":     "New historical low",
 			"for":                    "for",
 			"no_market_activity":     "No market activity has been recorded yet.",
+			"activity_view_events":   "Individual events",
+			"activity_view_by_item":  "Grouped by item",
+			"activity_added":         "Added",
+			"activity_sold":          "Sold",
+			"activity_delisted":      "Delisted",
+			"activity_removed":       "Removed",
+			"activity_vendor_left":   "Removed (vendor offline)",
+			"activity_new_low":       "New low",
+			"activity_total":         "Total",
 			"page_of":                "Page %d of %d",
 			"previous":               "Previous",
 			"next":                   "Next",
@@ -95,6 +138,7 @@ var (
 			"guild_history":    "Guild History",
 			"no_guild_history": "No guild history recorded.",
 			"guild_leader":     "Guild Leader",
+			"rows_truncated":   "Only the first %d rows are shown.",
 
 			// --- NEW for character_changelog.html ---
 			"char_changelog_title": "Character Changelog",
@@ -119,10 +163,12 @@ var (
 			"second_class":        "Second Class",
 			"total_zeny_filtered": "Total Zeny (Filtered)",
 			"sum_across_chars":    "Sum across %d characters (without bank).",
+			"sum_with_bank":       "Sum across %d characters (including bank).",
 			"name":                "Name",
 			"base_lvl":            "Base Lvl",
 			"job_lvl":             "Job Lvl",
 			"exp_perc":            "Exp %",
+			"exp_per_day":         "Exp %/Day (7d)",
 			"class":               "Class",
 			"guild":               "Guild",
 			"last_updated":        "Last Updated",
@@ -152,8 +198,14 @@ var (
 			// --- NEW for chat.html ---
 			"public_chat_log":        "Public Chat Log",
 			"chat_listener_activity": "Chat Listener Activity (Last 24h)",
+			"chat_sniffer_disabled":  "Live chat capture is disabled on this server. Showing previously recorded messages only.",
 			"all":                    "All",
 			"search_by_message_char": "Search by message or character...",
+			"search_mode_contains":   "Contains",
+			"search_mode_phrase":     "Exact phrase",
+			"search_mode_regex":      "Regex",
+			"chat_regex_invalid":     "Invalid regular expression: %s",
+			"chat_regex_truncated":   "Regex search stopped early; only the most recent messages were searched.",
 			"channel":                "Channel",
 			"message":                "Message",
 			"no_chat_messages":       "No chat messages found.",
@@ -178,6 +230,7 @@ var (
 			"no_chart_data":      "Not enough data to display a chart.",
 			"guild_activity_log": "Guild Activity Log",
 			"js_num_of_members":  "Number of Members",
+			"member_history":     "Member History",
 
 			// --- NEW for history.html ---
 			"price_history_for":     "Price History:",
@@ -197,11 +250,29 @@ var (
 			"listing_not_available": "This specific listing is no longer available",
 			"total_listings":        "(%d total listings)",
 			"js_lowest_price":       "Lowest Price",
+			"fair_price":            "Fair Price",
+			"fair_price_median_of":  "Median of",
+			"fair_price_sales_in":   "sales in the last",
+			"fair_price_days":       "days",
+			"granularity_all":       "Every scrape",
+			"granularity_day":       "Daily",
+			"granularity_week":      "Weekly",
+			"granularity_month":     "Monthly",
+			"price_wars":            "Price Wars",
+			"price_war_undercuts":   "%d undercuts by %d sellers",
+			"market_vs_discord":     "Market vs Discord",
+			"compare_market":        "In-game",
+			"compare_discord":       "Discord",
+			"compare_no_offer":      "No offers",
+			"compare_same_price":    "Same price on both",
+			"discord_cheaper_by":    "Discord is %sz cheaper",
+			"market_cheaper_by":     "The market is %sz cheaper",
 			"js_highest_price":      "Highest Price",
 
 			// --- NEW for mvp_kills.html ---
 			"mvp_kills_title":   "MVP Kills",
 			"showing_chars_mvp": "Showing <strong>%d</strong> characters with MVP kills.",
+			"hide_zero_kills":   "Hide characters and MVPs with no kills",
 			"character_name":    "Character Name",
 			"total_kills":       "Total Kills",
 
@@ -241,6 +312,7 @@ var (
 			"negotiable":       "Negotiable",
 			"no_trading_posts": "No trading posts found{{if .SearchQuery}} matching your search{{end}}.",
 			"lightbox_title":   "Source / Original Message",
+			"item_offers":      "All Offers",
 
 			// --- NEW for woe_rankings.html ---
 			"woe_rankings_title": "WoE Rankings",
@@ -256,6 +328,14 @@ var (
 			"kd_ratio":           "K/D Ratio",
 			"total_damage":       "Total Damage",
 			"total_healing":      "Total Healing",
+			"woe_matchup":        "Matchup",
+			"woe_matchup_title":  "Guild Matchup",
+			"woe_matchup_prompt": "Pick two different guilds to compare their WoE results event by event.",
+			"woe_matchup_none":   "Neither guild has WoE results yet.",
+			"woe_matchup_stats":  "%d events together · %d / %d events each · wins by points: %d - %d",
+			"woe_absent":         "Did not take part",
+			"compare":            "Compare",
+			"deaths":             "Deaths",
 
 			// --- NEW for xp_calculator.html ---
 			"xp_calc_title":    "XP Calculator",
@@ -279,6 +359,8 @@ var (
 			"store_details":     "Store Details",
 			"showing_last_seen": "Showing the <strong>%d</strong> items last seen in this store. Faded items are no longer available.",
 			"last_seen":         "Last Seen",
+			"other_stores":      "Other Stores by This Seller",
+			"navi_list":         "Navi list (text)",
 
 			"item_details": "Item Details",
 			"weight":       "Weight",
@@ -290,6 +372,7 @@ var (
 			"search_placeholder":       "Search for characters, guilds, items, chat...",
 			"search_results_for":       "Search results for: <strong>%s</strong>",
 			"no_results_found":         "No results found.",
+			"search_too_short":         "Enter at least %d characters to search.",
 			"characters_found":         "Characters",
 			"guilds_found":             "Guilds",
 			"chat_messages_found":      "Chat Messages",
@@ -320,6 +403,10 @@ var (
 			"nav_market_stats":      "Market",
 			"total_items_sold":      "Total Items Sold",
 			"total_zeny_transacted": "Total Zeny Transacted",
+			"net_after_fee":         "Net after fee",
+			"outliers_rejected":     "%d sales excluded as price outliers (more than %vx off the item's rolling median).",
+			"show_net_zeny":         "Show net (after vend fee)",
+			"show_gross_zeny":       "Show gross",
 			"sales_over_time":       "Sales Over Time",
 			"top_sold_items":        "Top Sold Items (by units)",
 			"top_sellers":           "Top Sellers (by units)",
@@ -332,6 +419,11 @@ var (
 			"interval_7d":           "7d",
 			"interval_30d":          "30d",
 			"interval_all":          "All Time",
+			"stats_range_from":      "From",
+			"stats_range_to":        "To",
+			"stats_max_price":       "Max price",
+			"stats_price_cap":       "Sales priced at %sz or more are left out.",
+			"stats_no_price_cap":    "No price cap: every sale is counted.",
 			// --- END NEW ---
 
 			"category_all":            "All Items",
@@ -346,6 +438,7 @@ var (
 			"category_armor":          "Armor",
 			"category_cash_shop_item": "Cash Shop",
 			"category_taming_item":    "Taming",
+			"category_shadow_gear":    "Shadow",
 
 			"nav_character_stats": "Character",
 			"total_characters":    "Total Characters",
@@ -356,6 +449,49 @@ var (
 			"top_richest_chars":   "Top Richest Characters",
 			"top_exp_chars":       "Top Characters by Exp",
 
+			"nav_wealth_stats":    "Wealth",
+			"top_richest_guilds":  "Top Richest Guilds",
+			"exclude_capped_zeny": "Exclude capped zeny (banks)",
+			"show_top":            "Show top",
+			"export_json":         "Export JSON",
+
+			"nav_rebirth_stats": "Class Changes",
+			"class_from":        "From",
+			"class_to":          "To",
+			"top_transitions":   "Most Common Changes",
+			"no_class_changes":  "No class changes recorded yet.",
+
+			"nav_guild_churn":    "Guild Changes",
+			"guild_change":       "Change",
+			"guild_from":         "From Guild",
+			"guild_to":           "To Guild",
+			"guild_change_join":  "Joined",
+			"guild_change_leave": "Left",
+			"guild_change_move":  "Moved",
+			"no_guild_changes":   "No guild changes in this period.",
+
+			"nav_price_spread":   "Price Spread",
+			"price_spread":       "Spread",
+			"price_spread_pct":   "Spread %",
+			"price_spread_intro": "Items whose cheapest and most expensive current listings are furthest apart. A wide spread often means a mispriced listing.",
+			"no_price_spreads":   "No items currently have more than one listing.",
+
+			"nav_price_watch":          "Price Watch",
+			"price_watch_intro":        "Get notified when the cheapest listing of an item drops to your target price. Your list is tied to this browser and network.",
+			"price_watch_disabled":     "Notifications are not set up on this server: watches are saved but will not fire.",
+			"price_watch_add":          "Watch",
+			"price_watch_target":       "Target Price",
+			"price_watch_below":        "At or below target",
+			"price_watch_waiting":      "Waiting",
+			"price_watch_remove":       "Remove",
+			"price_watch_item":         "Watch price",
+			"price_watch_limit":        "You can watch up to %d items.",
+			"price_watch_added":        "Watch saved.",
+			"price_watch_removed":      "Watch removed.",
+			"price_watch_invalid":      "Enter an item ID and a target price above zero.",
+			"price_watch_unknown_item": "No item has that ID.",
+			"no_price_watches":         "You are not watching any items yet.",
+
 			"nav_toggle_theme":  "Toggle Theme",
 			"nav_theme":         "Theme",
 			"nav_settings":      "Settings",
@@ -372,12 +508,19 @@ var (
 			"market_summary":         "Resumo do Mercado",
 			"search_by_item_name":    "Buscar por nome ou ID do item...",
 			"show_only_available":    "Mostrar apenas disponíveis",
+			"show_last_prices":       "Mostrar últimos preços conhecidos",
+			"search_mode_auto":       "ID ou nome",
+			"search_mode_id":         "Apenas ID do item",
+			"search_mode_name":       "Apenas nome",
+			"last_price_hint":        "Sem anúncios agora; último preço visto antes de sair do mercado",
 			"search":                 "Buscar",
 			"all_items":              "Todos os Itens",
 			"showing_unique_items":   "Mostrando <strong>%d</strong> itens únicos.",
 			"item_name":              "Nome do Item",
 			"item_id":                "ID do Item",
 			"available":              "Disponíveis",
+			"sold_7d":                "Vendidos (7d)",
+			"sold_7d_hint":           "Vendas nos últimos 7 dias",
 			"lowest_price":           "Menor Preço",
 			"highest_price":          "Maior Preço",
 			"updated_never":          "Atualizado: nunca",
@@ -385,6 +528,26 @@ var (
 			"nav_summary":            "Resumo",
 			"nav_full_list":          "Lista Completa",
 			"nav_activity":           "Atividade",
+			"nav_stores":             "Lojas",
+			"sort_ignored":           "Ordenar por \"%s\" não está disponível nesta página; exibindo resultados ordenados por \"%s\".",
+			"stale_data":             "Os dados de %s têm mais de %d horas; a coleta pode estar atrasada.",
+			"stale_src_market":       "mercado",
+			"stale_src_players":      "jogadores online",
+			"stale_src_chars":        "personagens",
+			"stale_src_guilds":       "guilds",
+			"not_found_title":        "Página não encontrada",
+			"not_found_hint":         "O link pode estar errado, ou o personagem, guild ou item pode ter mudado de nome.",
+			"error_title":            "Algo deu errado",
+			"error_page_not_found":   "Página não encontrada",
+			"error_char_not_found":   "Personagem não encontrado",
+			"error_guild_not_found":  "Guild não encontrada",
+			"error_watches_load":     "Não foi possível carregar seus alertas de preço",
+			"back_home":              "Voltar ao início",
+			"stores_title":           "Diretório de Lojas",
+			"search_by_store_seller": "Buscar por loja ou vendedor",
+			"no_stores_found":        "Nenhuma loja encontrada com seus critérios.",
+			"showing_page_stores":    "Mostrando página %d de %d (%d lojas no total)",
+			"items_listed_now":       "Itens Agora",
 			"nav_discord":            "Discord",
 			"nav_chat":               "Chat",
 			"nav_misc":               "Outros",
@@ -399,7 +562,6 @@ var (
 			"nav_woe_guild_by_class": "Guilds por Classe",
 			// --- NEW for activity.html ---
 			"recent_market_activity": "Atividade Recente do Mercado",
-			"show_only_sold":         "Mostrar apenas vendidos",
 			"filter":                 "Filtrar",
 			"clear_filters":          "[Limpar Filtros]",
 			"was_added":              "foi adicionado à venda.",
@@ -409,6 +571,15 @@ var (
 			"new_historical_low":     "Novo recorde de preço baixo",
 			"for":                    "para",
 			"no_market_activity":     "Nenhuma atividade de mercado foi registrada ainda.",
+			"activity_view_events":   "Eventos individuais",
+			"activity_view_by_item":  "Agrupado por item",
+			"activity_added":         "Adicionados",
+			"activity_sold":          "Vendidos",
+			"activity_delisted":      "Retirados",
+			"activity_removed":       "Removidos",
+			"activity_vendor_left":   "Removidos (vendedor offline)",
+			"activity_new_low":       "Novo mínimo",
+			"activity_total":         "Total",
 			"page_of":                "Página %d de %d",
 			"previous":               "Anterior",
 			"next":                   "Próxima",
@@ -456,6 +627,7 @@ var (
 			"guild_history":    "Histórico de Guild",
 			"no_guild_history": "Nenhum histórico de guild registrado.",
 			"guild_leader":     "Líder da Guild",
+			"rows_truncated":   "Apenas as primeiras %d linhas são exibidas.",
 
 			// --- NEW for character_changelog.html ---
 			"char_changelog_title": "Histórico de Personagens",
@@ -480,10 +652,12 @@ var (
 			"second_class":        "Classe 2",
 			"total_zeny_filtered": "Zeny Total (Filtrado)",
 			"sum_across_chars":    "Soma entre %d personagens (sem banco).",
+			"sum_with_bank":       "Soma entre %d personagens (com banco).",
 			"name":                "Nome",
 			"base_lvl":            "Nível Base",
 			"job_lvl":             "Nível Classe",
 			"exp_perc":            "Exp %",
+			"exp_per_day":         "Exp %/Dia (7d)",
 			"class":               "Classe",
 			"guild":               "Guild",
 			"last_updated":        "Última Atualização",
@@ -513,8 +687,14 @@ var (
 			// --- NEW for chat.html ---
 			"public_chat_log":        "Log de Chat Público",
 			"chat_listener_activity": "Atividade do Chat (Últimas 24h)",
+			"chat_sniffer_disabled":  "A captura do chat ao vivo está desativada neste servidor. Exibindo apenas mensagens já registradas.",
 			"all":                    "Todos",
 			"search_by_message_char": "Buscar por mensagem ou personagem...",
+			"search_mode_contains":   "Contém",
+			"search_mode_phrase":     "Frase exata",
+			"search_mode_regex":      "Regex",
+			"chat_regex_invalid":     "Expressão regular inválida: %s",
+			"chat_regex_truncated":   "A busca por regex parou antes do fim; apenas as mensagens mais recentes foram pesquisadas.",
 			"channel":                "Canal",
 			"message":                "Mensagem",
 			"no_chat_messages":       "Nenhuma mensagem de chat encontrada.",
@@ -540,6 +720,7 @@ var (
 			"no_chart_data":      "Sem dados suficientes para exibir um gráfico.",
 			"guild_activity_log": "Histórico de Atividade da Guild",
 			"js_num_of_members":  "Número de Membros",
+			"member_history":     "Histórico de Membros",
 
 			// --- NEW for history.html ---
 			"price_history_for":     "Histórico de Preço:",
@@ -559,11 +740,29 @@ var (
 			"listing_not_available": "Este anúncio específico não está mais disponível",
 			"total_listings":        "(%d anúncios no total)",
 			"js_lowest_price":       "Menor Preço",
+			"fair_price":            "Preço Justo",
+			"fair_price_median_of":  "Mediana de",
+			"fair_price_sales_in":   "vendas nos últimos",
+			"fair_price_days":       "dias",
+			"granularity_all":       "Cada coleta",
+			"granularity_day":       "Diário",
+			"granularity_week":      "Semanal",
+			"granularity_month":     "Mensal",
+			"price_wars":            "Guerras de Preço",
+			"price_war_undercuts":   "%d reduções por %d vendedores",
+			"market_vs_discord":     "Mercado vs Discord",
+			"compare_market":        "No jogo",
+			"compare_discord":       "Discord",
+			"compare_no_offer":      "Sem ofertas",
+			"compare_same_price":    "Mesmo preço nos dois",
+			"discord_cheaper_by":    "O Discord está %sz mais barato",
+			"market_cheaper_by":     "O mercado está %sz mais barato",
 			"js_highest_price":      "Maior Preço",
 
 			// --- NEW for mvp_kills.html ---
 			"mvp_kills_title":   "MVPs Mortos",
 			"showing_chars_mvp": "Mostrando <strong>%d</strong> personagens com mortes de MVP.",
+			"hide_zero_kills":   "Ocultar personagens e MVPs sem mortes",
 			"character_name":    "Nome do Personagem",
 			"total_kills":       "Total de Abates",
 
@@ -603,6 +802,7 @@ var (
 			"negotiable":       "Negociável",
 			"no_trading_posts": "Nenhum post encontrado{{if .SearchQuery}} com a sua busca{{end}}.",
 			"lightbox_title":   "Fonte / Mensagem Original",
+			"item_offers":      "Todas as Ofertas",
 
 			// --- NEW for woe_rankings.html ---
 			"woe_rankings_title": "Rankings WoE",
@@ -618,6 +818,13 @@ var (
 			"kd_ratio":           "K/D",
 			"total_damage":       "Dano Total",
 			"total_healing":      "Cura Total",
+			"woe_matchup":        "Confronto",
+			"woe_matchup_title":  "Confronto de Guilds",
+			"woe_matchup_prompt": "Escolha duas guilds diferentes para comparar os resultados de WoE evento a evento.",
+			"woe_matchup_none":   "Nenhuma das guilds tem resultados de WoE ainda.",
+			"woe_matchup_stats":  "%d eventos juntas · %d / %d eventos cada · vitórias por pontos: %d - %d",
+			"woe_absent":         "Não participou",
+			"compare":            "Comparar",
 			"deaths":             "Mortes",
 
 			// --- NEW for xp_calculator.html ---
@@ -642,6 +849,8 @@ var (
 			"store_details":     "Detalhes da Loja",
 			"showing_last_seen": "Mostrando os <strong>%d</strong> itens vistos por último nesta loja. Itens esmaecidos não estão mais disponíveis.",
 			"last_seen":         "Visto por Último",
+			"other_stores":      "Outras Lojas deste Vendedor",
+			"navi_list":         "Lista de navi (texto)",
 
 			"item_details": "Detalhes do Item",
 			"weight":       "Peso",
@@ -654,6 +863,7 @@ var (
 			"search_placeholder":       "Buscar personagens, guilds, itens, chat...",
 			"search_results_for":       "Resultados da busca por: <strong>%s</strong>",
 			"no_results_found":         "Nenhum resultado encontrado.",
+			"search_too_short":         "Digite pelo menos %d caracteres para buscar.",
 			"characters_found":         "Personagens",
 			"guilds_found":             "Guilds",
 			"chat_messages_found":      "Mensagens de Chat",
@@ -684,6 +894,10 @@ var (
 			"nav_market_stats":      "Mercado",
 			"total_items_sold":      "Total de Itens Vendidos",
 			"total_zeny_transacted": "Total de Zeny Transacionado",
+			"net_after_fee":         "Líquido após taxa",
+			"outliers_rejected":     "%d vendas excluídas como preços fora da curva (mais de %vx da mediana móvel do item).",
+			"show_net_zeny":         "Mostrar líquido (após taxa)",
+			"show_gross_zeny":       "Mostrar bruto",
 			"sales_over_time":       "Vendas ao Longo do Tempo",
 			"top_sold_items":        "Itens Mais Vendidos (por unid.)",
 			"top_sellers":           "Melhores Vendedores (por unid.)",
@@ -696,6 +910,11 @@ var (
 			"interval_7d":           "7d",
 			"interval_30d":          "30d",
 			"interval_all":          "Total",
+			"stats_range_from":      "De",
+			"stats_range_to":        "Até",
+			"stats_max_price":       "Preço máximo",
+			"stats_price_cap":       "Vendas a partir de %sz não são contadas.",
+			"stats_no_price_cap":    "Sem limite de preço: todas as vendas são contadas.",
 			// --- END NEW ---
 
 			"category_all":            "Todos os Itens",
@@ -710,6 +929,7 @@ var (
 			"category_armor":          "Equip.",
 			"category_cash_shop_item": "Loja ROPs",
 			"category_taming_item":    "Doma",
+			"category_shadow_gear":    "Sombrio",
 
 			"nav_character_stats": "Personagens",
 			"total_characters":    "Total de Personagens",
@@ -720,6 +940,49 @@ var (
 			"top_richest_chars":   "Top Personagens Ricos",
 			"top_exp_chars":       "Top Personagens por Exp",
 
+			"nav_wealth_stats":    "Riqueza",
+			"top_richest_guilds":  "Top Guilds Ricas",
+			"exclude_capped_zeny": "Excluir zeny no limite (bancos)",
+			"show_top":            "Mostrar top",
+			"export_json":         "Exportar JSON",
+
+			"nav_rebirth_stats": "Mudanças de Classe",
+			"class_from":        "De",
+			"class_to":          "Para",
+			"top_transitions":   "Mudanças Mais Comuns",
+			"no_class_changes":  "Nenhuma mudança de classe registrada ainda.",
+
+			"nav_guild_churn":    "Mudanças de Guilda",
+			"guild_change":       "Mudança",
+			"guild_from":         "Guilda Anterior",
+			"guild_to":           "Nova Guilda",
+			"guild_change_join":  "Entrou",
+			"guild_change_leave": "Saiu",
+			"guild_change_move":  "Trocou",
+			"no_guild_changes":   "Nenhuma mudança de guilda neste período.",
+
+			"nav_price_spread":   "Diferença de Preço",
+			"price_spread":       "Diferença",
+			"price_spread_pct":   "Diferença %",
+			"price_spread_intro": "Itens cujas ofertas atuais mais barata e mais cara estão mais distantes. Uma diferença grande costuma indicar uma oferta com preço errado.",
+			"no_price_spreads":   "Nenhum item tem mais de uma oferta no momento.",
+
+			"nav_price_watch":          "Alerta de Preço",
+			"price_watch_intro":        "Seja avisado quando a oferta mais barata de um item chegar ao seu preço alvo. Sua lista fica vinculada a este navegador e rede.",
+			"price_watch_disabled":     "As notificações não estão configuradas neste servidor: os alertas são salvos, mas não disparam.",
+			"price_watch_add":          "Vigiar",
+			"price_watch_target":       "Preço Alvo",
+			"price_watch_below":        "No alvo ou abaixo",
+			"price_watch_waiting":      "Aguardando",
+			"price_watch_remove":       "Remover",
+			"price_watch_item":         "Vigiar preço",
+			"price_watch_limit":        "Você pode vigiar até %d itens.",
+			"price_watch_added":        "Alerta salvo.",
+			"price_watch_removed":      "Alerta removido.",
+			"price_watch_invalid":      "Informe um ID de item e um preço alvo maior que zero.",
+			"price_watch_unknown_item": "Nenhum item tem esse ID.",
+			"no_price_watches":         "Você ainda não está vigiando nenhum item.",
+
 			"nav_toggle_theme":  "Alternar Tema",
 			"nav_theme":         "Tema",
 			"nav_settings":      "Configurações",
@@ -735,6 +998,36 @@ var (
 	}
 )
 
+// MissingKeys returns, per language, the sorted keys that another
+// language defines but it lacks. Languages missing nothing are omitted.
+func MissingKeys() map[string][]string {
+	return missingKeys(translationsMap)
+}
+
+func missingKeys(maps map[string]map[string]string) map[string][]string {
+	all := make(map[string]bool)
+	for _, trans := range maps {
+		for key := range trans {
+			all[key] = true
+		}
+	}
+	missing := make(map[string][]string)
+	for lang, trans := range maps {
+		for key := range all {
+			if _, ok := trans[key]; !ok {
+				missing[lang] = append(missing[lang], key)
+			}
+		}
+		sort.Strings(missing[lang])
+	}
+	for lang, keys := range missing {
+		if len(keys) == 0 {
+			delete(missing, lang)
+		}
+	}
+	return missing
+}
+
 // Translations returns the translation map for the given language.
 func Translations(lang string) map[string]string {
 	if trans, ok := translationsMap[lang]; ok {
@@ -744,10 +1037,16 @@ func Translations(lang string) map[string]string {
 	return translationsMap["en"]
 }
 
-// Lang reads the language preference from the cookie.
+// Lang reads the language preference from the cookie. Without one it
+// tries Accept-Language when DetectBrowserLanguage is set.
 func Lang(r *http.Request) string {
 	cookie, err := r.Cookie("lang")
 	if err != nil {
+		if DetectBrowserLanguage {
+			if lang := acceptedLanguage(r.Header.Get("Accept-Language")); lang != "" {
+				return lang
+			}
+		}
 		// No cookie, default to Portuguese
 		return "pt"
 	}
@@ -758,6 +1057,40 @@ func Lang(r *http.Request) string {
 	return "pt"
 }
 
+// acceptedLanguage returns the loaded language the Accept-Language header
+// ranks highest, matching on the primary subtag ("pt-BR" picks "pt"), or
+// "" if none of them is loaded.
+func acceptedLanguage(header string) string {
+	type rankedLang struct {
+		tag string
+		q   float64
+	}
+	var langs []rankedLang
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		langs = append(langs, rankedLang{primary, q})
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	for _, l := range langs {
+		if _, ok := translationsMap[l.tag]; ok {
+			return l.tag
+		}
+	}
+	return ""
+}
+
 // SetLangHandler sets the language cookie and redirects back.
 func SetLangHandler(w http.ResponseWriter, r *http.Request) {
 	lang := r.URL.Query().Get("lang")
@@ -0,0 +1,76 @@
+package i18n
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAcceptedLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"en-US,en;q=0.9", "en"},
+		{"pt-BR,pt;q=0.9,en;q=0.8", "pt"},
+		{"de-DE,de;q=0.9,en;q=0.5", "en"},
+		{"fr;q=0.4, EN-gb;q=0.7, pt;q=0.6", "en"},
+		{"en;q=0, pt;q=0.1", "pt"},
+		{"*", ""},
+		{"ja,zh;q=0.8", ""},
+		{"en;q=abc, pt", "pt"},
+	}
+	for _, tt := range tests {
+		if got := acceptedLanguage(tt.header); got != tt.want {
+			t.Errorf("acceptedLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestLangDetection(t *testing.T) {
+	saved := DetectBrowserLanguage
+	defer func() { DetectBrowserLanguage = saved }()
+
+	req := func(cookie string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", "en-US,en;q=0.9")
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "lang", Value: cookie})
+		}
+		return r
+	}
+
+	DetectBrowserLanguage = false
+	if got := Lang(req("")); got != "pt" {
+		t.Errorf("detection off: Lang = %q, want pt", got)
+	}
+
+	DetectBrowserLanguage = true
+	if got := Lang(req("")); got != "en" {
+		t.Errorf("detection on, no cookie: Lang = %q, want en", got)
+	}
+	if got := Lang(req("pt")); got != "pt" {
+		t.Errorf("detection on, lang=pt cookie: Lang = %q, want the cookie to win", got)
+	}
+}
+
+func TestMissingKeys(t *testing.T) {
+	got := missingKeys(map[string]map[string]string{
+		"en": {"a": "A", "b": "B", "c": "C"},
+		"pt": {"a": "A", "d": "D"},
+		"es": {"a": "A", "b": "B", "c": "C", "d": "D"},
+	})
+	want := map[string][]string{
+		"en": {"d"},
+		"pt": {"b", "c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("missingKeys = %v, want %v", got, want)
+	}
+
+	if missing := MissingKeys(); len(missing) > 0 {
+		t.Errorf("translations out of sync: %v", missing)
+	}
+}
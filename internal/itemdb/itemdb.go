@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"io/fs"
 	"log"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -83,14 +84,16 @@ func store(db *sql.DB, items []Entry) error {
 	stmt, err := tx.Prepare(`
 		INSERT OR IGNORE INTO internal_item_db (
 			item_id, aegis_name, name, type, buy, sell, weight, slots,
-			jobs, locations, script, equip_script, unequip_script, name_pt
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			jobs, locations, script, equip_script, unequip_script, name_pt,
+			last_modified
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
+	now := time.Now().Format(time.RFC3339)
 	var success int
 	for _, item := range items {
 		var namePT sql.NullString
@@ -111,7 +114,7 @@ func store(db *sql.DB, items []Entry) error {
 			toNullInt64(item.Weight), toNullInt64(item.Slots),
 			string(jobsJSON), string(locsJSON),
 			item.Script, item.EquipScript, item.UnEquipScript,
-			namePT,
+			namePT, now,
 		)
 		if err != nil {
 			log.Printf("[W] [ItemDB] Failed to insert item %d (%s): %v", item.ID, item.Name, err)
@@ -0,0 +1,64 @@
+// Package metrics holds the Prometheus collectors served on /metrics.
+// They are registered on the default registry, so promhttp.Handler also
+// exports the standard Go runtime and process collectors.
+package metrics
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// PageViewsProcessed counts page views written to the database.
+	PageViewsProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "yufa_page_views_processed_total",
+		Help: "Page views written to the database.",
+	})
+	// PageViewsDropped counts page views discarded because the queue
+	// to the batch writer was full.
+	PageViewsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "yufa_page_views_dropped_total",
+		Help: "Page views dropped because the page-view queue was full.",
+	})
+	// Scrapes counts scraper runs by scraper (see ScraperLabel) and
+	// result ("success" or "failure").
+	Scrapes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "yufa_scrapes_total",
+		Help: "Scraper runs by scraper and result.",
+	}, []string{"scraper", "result"})
+)
+
+// RegisterPageViewQueue exports queueLen as the current length of the
+// page-view queue. Call it once, after the queue exists.
+func RegisterPageViewQueue(queueLen func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "yufa_page_view_queue_length",
+		Help: "Page views waiting for the batch writer.",
+	}, func() float64 { return float64(queueLen()) })
+}
+
+// ScraperLabel turns a job name such as "Player Count" or "Player-Count"
+// into the scraper label value "player_count", so scheduled and
+// admin-triggered runs of a scraper share one series.
+func ScraperLabel(name string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, name), "_")
+}
+
+// ObserveScrape records one run of the scraper called name.
+func ObserveScrape(name string, ok bool) {
+	result := "success"
+	if !ok {
+		result = "failure"
+	}
+	Scrapes.WithLabelValues(ScraperLabel(name), result).Inc()
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestScraperLabel(t *testing.T) {
+	cases := map[string]string{
+		"Market":            "market",
+		"Player Count":      "player_count",
+		"Player-Count":      "player_count",
+		"WoE-Char-Rankings": "woe_char_rankings",
+		" MVP Kill ":        "mvp_kill",
+	}
+	for in, want := range cases {
+		if got := ScraperLabel(in); got != want {
+			t.Errorf("ScraperLabel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestObserveScrape(t *testing.T) {
+	ObserveScrape("Player Count", true)
+	ObserveScrape("Player-Count", true)
+	ObserveScrape("Player Count", false)
+
+	if got := testutil.ToFloat64(Scrapes.WithLabelValues("player_count", "success")); got != 2 {
+		t.Errorf("successes = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(Scrapes.WithLabelValues("player_count", "failure")); got != 1 {
+		t.Errorf("failures = %v, want 1", got)
+	}
+}
@@ -0,0 +1,156 @@
+// Package middleware: per-IP token-bucket rate limiting.
+//
+// RateLimiter keeps one bucket per client IP. Each bucket holds up to
+// burst tokens and refills at perMinute tokens per minute; a request
+// spends one token and is answered 429 when none are left. Buckets that
+// have been idle long enough to refill completely are dropped, so the
+// map only holds clients seen recently.
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a per-IP token bucket. The zero value is not usable;
+// create one with NewRateLimiter.
+type RateLimiter struct {
+	perSecond float64
+	burst     float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing perMinute requests per minute
+// per IP with bursts of up to burst requests. A burst below 1 is raised
+// to 1.
+func NewRateLimiter(perMinute float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		perSecond: perMinute / 60,
+		burst:     float64(max(burst, 1)),
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// Allow spends one token from key's bucket at now and reports whether
+// one was available.
+func (l *RateLimiter) Allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) >= time.Minute {
+		l.prune(now)
+		l.lastPrune = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune drops buckets that would be full again by now. Caller holds mu.
+func (l *RateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.perSecond >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// retryAfter is the whole number of seconds until one token refills.
+func (l *RateLimiter) retryAfter() int {
+	return max(1, int(1/l.perSecond+0.999))
+}
+
+// Limit wraps h so each client IP is held to the limiter's rate.
+// Rejected requests get 429 Too Many Requests with a Retry-After header.
+func (l *RateLimiter) Limit(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.Allow(ClientIP(r), time.Now()) {
+			w.Header().Set("Retry-After", strconv.Itoa(l.retryAfter()))
+			http.Error(w, "Too many requests, please slow down.", http.StatusTooManyRequests)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// trustedProxies are the networks whose X-Forwarded-For and X-Real-IP
+// headers ClientIP believes. Set once at startup by SetTrustedProxies.
+var trustedProxies []netip.Prefix
+
+// SetTrustedProxies replaces the networks ClientIP trusts to report the
+// client address. With none, forwarding headers are ignored. Call it
+// before serving requests.
+func SetTrustedProxies(prefixes []netip.Prefix) {
+	trustedProxies = prefixes
+}
+
+func isTrustedProxy(ip netip.Addr) bool {
+	for _, p := range trustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the requesting client's IP. Forwarding headers are
+// only honored when the connection comes from a trusted proxy (see
+// SetTrustedProxies); anyone else could forge them. X-Forwarded-For is
+// then read from right to left, skipping trusted hops, so the result is
+// the address the outermost trusted proxy saw. X-Real-IP is the fallback
+// when there is no X-Forwarded-For.
+func ClientIP(r *http.Request) string {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		host = h
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !isTrustedProxy(remote.Unmap()) {
+		return host
+	}
+
+	if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+		hops := strings.Split(strings.Join(fwd, ","), ",")
+		client := remote.Unmap()
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// A malformed hop can't be traced past; keep the last
+				// address a trusted proxy vouched for.
+				break
+			}
+			client = ip.Unmap()
+			if !isTrustedProxy(client) {
+				break
+			}
+		}
+		return client.String()
+	}
+	if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return ip.Unmap().String()
+	}
+	return host
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := NewRateLimiter(60, 3) // one token per second, bursts of 3
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow("1.2.3.4", now) {
+			t.Fatalf("request %d within the burst was rejected", i+1)
+		}
+	}
+	if l.Allow("1.2.3.4", now) {
+		t.Error("request past the burst should be rejected")
+	}
+	if !l.Allow("5.6.7.8", now) {
+		t.Error("another IP should have its own bucket")
+	}
+	if !l.Allow("1.2.3.4", now.Add(time.Second)) {
+		t.Error("one token should refill after a second")
+	}
+	if l.Allow("1.2.3.4", now.Add(time.Second)) {
+		t.Error("only one token should have refilled")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	SetTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+
+	r := httptest.NewRequest("GET", "/search", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	if got := ClientIP(r); got != "10.0.0.1" {
+		t.Errorf("RemoteAddr: got %q", got)
+	}
+	r.Header.Set("X-Real-IP", "203.0.113.9")
+	if got := ClientIP(r); got != "203.0.113.9" {
+		t.Errorf("X-Real-IP: got %q", got)
+	}
+	r.Header.Set("X-Forwarded-For", " 198.51.100.7 , 10.0.0.1")
+	if got := ClientIP(r); got != "198.51.100.7" {
+		t.Errorf("X-Forwarded-For: got %q", got)
+	}
+}
+
+func TestClientIPUntrustedProxy(t *testing.T) {
+	SetTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+
+	cases := []struct {
+		name, remote, fwd, want string
+	}{
+		{"untrusted peer ignores header", "203.0.113.50:1234", "198.51.100.7", "203.0.113.50"},
+		{"spoofed leftmost entry skipped", "10.0.0.1:80", "1.2.3.4, 198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		{"all hops trusted", "10.0.0.1:80", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"malformed hop stops the walk", "10.0.0.1:80", "198.51.100.7, junk, 10.0.0.2", "10.0.0.2"},
+		{"ipv4-mapped peer", "[::ffff:10.0.0.1]:80", "198.51.100.7", "198.51.100.7"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = c.remote
+			r.Header.Set("X-Forwarded-For", c.fwd)
+			r.Header.Set("X-Real-IP", "192.0.2.1")
+			if got := ClientIP(r); got != c.want {
+				t.Errorf("ClientIP() = %q, want %q", got, c.want)
+			}
+		})
+	}
+
+	SetTrustedProxies(nil)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:80"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	if got := ClientIP(r); got != "10.0.0.1" {
+		t.Errorf("no trusted proxies: got %q, want the socket IP", got)
+	}
+}
@@ -0,0 +1,44 @@
+// Package middleware: per-request correlation IDs.
+//
+// RequestID tags every request with a short random ID, stores it in the
+// request context and echoes it in the X-Request-ID response header, so
+// a user reporting "the history page errored" can hand over the ID and
+// the matching log lines can be grepped out of the shared output.
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+type requestIDKey struct{}
+
+// RequestID wraps h so each request carries an ID retrievable with
+// RequestIDFrom. The ID is set on the response header before h runs so
+// it is present even when h writes an error.
+func RequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newRequestID()
+		w.Header().Set("X-Request-ID", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFrom returns the ID stored by RequestID, or "" when ctx did
+// not pass through the middleware.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns 8 random hex characters: short enough to read
+// over chat, and unique enough to tell concurrent requests apart.
+func newRequestID() string {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "00000000"
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/denislee/yufa-mt/internal/httpx"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -85,8 +87,8 @@ func getDashboardStats(stats *AdminDashboardData) error {
 	g.Go(runQuery("SELECT COUNT(*) FROM player_history", &newStats.PlayerHistoryEntries))
 	g.Go(runQuery("SELECT COUNT(*) FROM market_events", &newStats.MarketEvents))
 	g.Go(runQuery("SELECT COUNT(*) FROM character_changelog", &newStats.ChangelogEntries))
-	g.Go(runQuery("SELECT COUNT(*) FROM visitors", &newStats.TotalVisitors))
-	g.Go(runQuery("SELECT COUNT(*) FROM visitors WHERE date(last_visit) = date('now', 'localtime')", &newStats.VisitorsToday))
+	g.Go(runQuery("SELECT COUNT(*) FROM visitors WHERE is_bot = 0", &newStats.TotalVisitors))
+	g.Go(runQuery("SELECT COUNT(*) FROM visitors WHERE is_bot = 0 AND date(last_visit) = date('now', 'localtime')", &newStats.VisitorsToday))
 
 	if err := g.Wait(); err != nil {
 		return fmt.Errorf("could not query for one or more dashboard stats: %w", err)
@@ -103,11 +105,13 @@ func getDashboardStats(stats *AdminDashboardData) error {
 	return nil
 }
 
-// getDashboardPageVisitCounts populates the page view summary.
+// getDashboardPageVisitCounts populates the page view summary. Bot
+// traffic is left out; see /admin/views/referrers for the split.
 func getDashboardPageVisitCounts(stats *AdminDashboardData) error {
 	rows, err := srv.db.Query(`
 		SELECT page_path, COUNT(page_path) as Cnt
 		FROM page_views
+		WHERE is_bot = 0
 		GROUP BY page_path
 		ORDER BY Cnt DESC
 		LIMIT 25
@@ -152,6 +156,7 @@ func getDashboardPageViews(r *http.Request, stats *AdminDashboardData) {
 	err := srv.db.QueryRow(`
 		SELECT page_path, COUNT(page_path) as Cnt
 		FROM page_views
+		WHERE is_bot = 0
 		GROUP BY page_path
 		ORDER BY Cnt DESC
 		LIMIT 1
@@ -195,7 +200,7 @@ func getDashboardPageViews(r *http.Request, stats *AdminDashboardData) {
 		var timestampStr string
 		if err := viewRows.Scan(&entry.Path, &timestampStr, &entry.VisitorHash); err == nil {
 			parsedTime, _ := time.Parse(time.RFC3339, timestampStr)
-			entry.Timestamp = parsedTime.Format("15:04:05")
+			entry.Timestamp = displayTime(parsedTime).Format("15:04:05")
 			stats.RecentPageViews = append(stats.RecentPageViews, entry)
 		}
 	}
@@ -269,7 +274,8 @@ func getDashboardTradingPosts(r *http.Request, stats *AdminDashboardData) {
 	stats.RecentTradingPosts = posts
 }
 
-// performRMSCacheSearch performs the FTS search on the local RMS cache.
+// performRMSCacheSearch performs the FTS prefix search on the local item
+// DB (see ftsPrefixQuery).
 func performRMSCacheSearch(r *http.Request, stats *AdminDashboardData) {
 	rmsQuery := r.URL.Query().Get("rms_query")
 	stats.RMSCacheSearchQuery = rmsQuery
@@ -277,13 +283,17 @@ func performRMSCacheSearch(r *http.Request, stats *AdminDashboardData) {
 		return
 	}
 
-	likeQuery := "%" + strings.ReplaceAll(rmsQuery, " ", "%") + "%"
+	match := ftsPrefixQuery(rmsQuery)
+	if match == "" {
+		return
+	}
 	searchRows, err := srv.db.Query(`
-		SELECT item_id, name, name_pt 
-		FROM internal_item_db 
-		WHERE name LIKE ? OR name_pt LIKE ?
-		ORDER BY item_id 
-		LIMIT 50`, likeQuery, likeQuery)
+		SELECT db.item_id, db.name, db.name_pt
+		FROM internal_item_db_fts fts
+		JOIN internal_item_db db ON db.item_id = fts.rowid
+		WHERE internal_item_db_fts MATCH ?
+		ORDER BY db.item_id
+		LIMIT 50`, match)
 	if err != nil {
 		log.Printf("[W] [Admin/Stats] Admin Internal DB query error: %v", err)
 		return
@@ -337,7 +347,7 @@ func getAdminDashboardData(r *http.Request) (AdminDashboardData, error) {
 
 	var (
 		g                                                                  errgroup.Group
-		statsR, guildsR, pageViewsR, tpR, rmsCacheR, rmsLiveR, visitsR, chatR AdminDashboardData
+		statsR, guildsR, pageViewsR, tpR, rmsCacheR, rmsLiveR, visitsR, chatR, watchesR AdminDashboardData
 	)
 
 	// Task 1: Main Stats (Critical)
@@ -369,6 +379,16 @@ func getAdminDashboardData(r *http.Request) (AdminDashboardData, error) {
 		return nil
 	})
 
+	// Task 4b: Trade Watches (Not Critical)
+	g.Go(func() error {
+		watches, err := fetchTradeWatches()
+		if err != nil {
+			log.Printf("[W] [Admin] Could not load trade watches: %v", err)
+		}
+		watchesR.TradeWatches = watches
+		return nil
+	})
+
 	// Task 5: RMS Cache Search (Not Critical)
 	g.Go(func() error {
 		performRMSCacheSearch(r, &rmsCacheR)
@@ -429,6 +449,9 @@ func getAdminDashboardData(r *http.Request) (AdminDashboardData, error) {
 	stats.TradingPostHasNextPage = tpR.TradingPostHasNextPage
 	stats.TradingPostNextPage = tpR.TradingPostNextPage
 	stats.RecentTradingPosts = tpR.RecentTradingPosts
+	stats.TradeWatches = watchesR.TradeWatches
+	stats.FeatureFlags = listFeatureFlags()
+	stats.ScrapeDriftCounts, stats.ScrapeDriftAlerts = scrapeDriftSummary()
 	stats.RMSCacheSearchQuery = rmsCacheR.RMSCacheSearchQuery
 	stats.RMSCacheSearchResults = rmsCacheR.RMSCacheSearchResults
 	stats.RMSLiveSearchQuery = rmsLiveR.RMSLiveSearchQuery
@@ -533,7 +556,7 @@ func adminHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := tmpl.Execute(w, stats); err != nil {
-		log.Printf("[E] [HTTP] Could not execute admin.html template: %v", err)
+		logRequestf(r, "[E] [HTTP] Could not execute admin.html template: %v", err)
 	}
 }
 
@@ -578,18 +601,18 @@ func adminParseTradeHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := tmpl.Execute(w, stats); err != nil {
-		log.Printf("[E] [HTTP] Could not execute admin.html template: %v", err)
+		logRequestf(r, "[E] [HTTP] Could not execute admin.html template: %v", err)
 	}
 }
 
-func adminTriggerScrapeHandler(scraperFunc func(), name string) http.HandlerFunc {
+func adminTriggerScrapeHandler(scraperFunc func() error, name string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Redirect(w, r, "/admin", http.StatusSeeOther)
 			return
 		}
 		log.Printf("[I] [Admin] Admin triggered '%s' scrape manually.", name)
-		go scraperFunc()
+		go runScrape(name, scraperFunc)
 		msg := fmt.Sprintf("%s scrape started.", name)
 		http.Redirect(w, r, adminRedirectURL(r, msg), http.StatusSeeOther)
 	}
@@ -720,6 +743,100 @@ func adminClearMvpKillsHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, adminRedirectURL(r, msg), http.StatusSeeOther)
 }
 
+// adminReferrersHandler serves /admin/views/referrers: the top external
+// referrers of human visits, and the bot-vs-human and browser-family
+// split, over the last ?days= days (default 30).
+func adminReferrersHandler(w http.ResponseWriter, r *http.Request) {
+	const defaultDays, maxDays, topReferrers = 30, 365, 50
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil || days < 1 {
+		days = defaultDays
+	} else if days > maxDays {
+		days = maxDays
+	}
+	since := time.Now().AddDate(0, 0, -days).Format(time.RFC3339)
+
+	data := AdminReferrersPageData{
+		Days:         days,
+		TopReferrers: []ReferrerCount{},
+		UAFamilies:   []UAFamilyCount{},
+	}
+
+	err = srv.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(is_bot = 0), 0),
+			COALESCE(SUM(is_bot = 1), 0),
+			COUNT(DISTINCT CASE WHEN is_bot = 0 THEN visitor_hash END),
+			COUNT(DISTINCT CASE WHEN is_bot = 1 THEN visitor_hash END),
+			COALESCE(SUM(is_bot = 0 AND referrer = ''), 0)
+		FROM page_views WHERE view_timestamp >= ?`, since).Scan(
+		&data.HumanViews, &data.BotViews, &data.HumanVisitors, &data.BotVisitors, &data.DirectViews)
+	if err != nil {
+		logRequestf(r, "[E] [Admin/Referrers] Could not count page views: %v", err)
+		http.Error(w, "Could not load referrer report", http.StatusInternalServerError)
+		return
+	}
+
+	refRows, err := srv.db.Query(`
+		SELECT referrer, COUNT(*) AS views, COUNT(DISTINCT visitor_hash)
+		FROM page_views
+		WHERE view_timestamp >= ? AND is_bot = 0 AND referrer != ''
+		GROUP BY referrer
+		ORDER BY views DESC
+		LIMIT ?`, since, topReferrers)
+	if err != nil {
+		logRequestf(r, "[E] [Admin/Referrers] Could not query referrers: %v", err)
+		http.Error(w, "Could not load referrer report", http.StatusInternalServerError)
+		return
+	}
+	defer refRows.Close()
+	for refRows.Next() {
+		var rc ReferrerCount
+		if err := refRows.Scan(&rc.Referrer, &rc.Views, &rc.Visitors); err != nil {
+			log.Printf("[W] [Admin/Referrers] Failed to scan referrer row: %v", err)
+			continue
+		}
+		data.TopReferrers = append(data.TopReferrers, rc)
+	}
+
+	// Rows written before the family was recorded have an empty one.
+	famRows, err := srv.db.Query(`
+		SELECT COALESCE(NULLIF(ua_family, ''), 'Unknown'), COUNT(*) AS views
+		FROM page_views
+		WHERE view_timestamp >= ?
+		GROUP BY 1
+		ORDER BY views DESC`, since)
+	if err != nil {
+		logRequestf(r, "[W] [Admin/Referrers] Could not query UA families: %v", err)
+	} else {
+		defer famRows.Close()
+		for famRows.Next() {
+			var fc UAFamilyCount
+			if err := famRows.Scan(&fc.Family, &fc.Views); err == nil {
+				data.UAFamilies = append(data.UAFamilies, fc)
+			}
+		}
+	}
+
+	if httpx.WantsJSON(r) {
+		if err := httpx.WriteJSON(w, http.StatusOK, data); err != nil {
+			logRequestf(r, "[W] [Admin/Referrers] Could not encode report: %v", err)
+		}
+		return
+	}
+
+	tmpl, ok := templateCache["admin_referrers.html"]
+	if !ok {
+		http.Error(w, "Could not load referrers template", http.StatusInternalServerError)
+		log.Println("[E] [HTTP] admin_referrers.html template missing from cache")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, data); err != nil {
+		logRequestf(r, "[E] [HTTP] Could not execute admin_referrers.html: %v", err)
+	}
+}
+
 func adminDeleteVisitorViewsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Redirect(w, r, "/admin", http.StatusSeeOther)
@@ -780,6 +897,26 @@ func adminDeleteVisitorViewsHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, adminRedirectURL(r, msg), http.StatusSeeOther)
 }
 
+// adminVacuumHandler runs VACUUM and ANALYZE and reports how much the
+// database shrank. It blocks until both finish; see vacuumDatabase.
+func adminVacuumHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	log.Println("[I] [Admin] Manual database vacuum triggered.")
+	before, after, err := vacuumDatabase()
+	if err != nil {
+		logRequestf(r, "[E] [Admin] Database vacuum failed: %v", err)
+		http.Redirect(w, r, adminRedirectURL(r, fmt.Sprintf("Vacuum failed: %v", err)), http.StatusSeeOther)
+		return
+	}
+
+	msg := fmt.Sprintf("Vacuum complete. Database size: %.1f MB -> %.1f MB.", float64(before)/(1<<20), float64(after)/(1<<20))
+	http.Redirect(w, r, adminRedirectURL(r, msg), http.StatusSeeOther)
+}
+
 // --- NEW: Handler to cleanup redundant guild history ---
 func adminCleanupGuildHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -878,6 +1015,109 @@ func adminCleanupGuildHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, adminRedirectURL(r, msg), http.StatusSeeOther)
 }
 
+// guildChangelogMatch returns a WHERE condition matching the changelog
+// entries processGuildData wrote about guildName: joins, leaves, moves
+// from or to it, and leadership changes. The name is matched exactly,
+// quotes included, so resetting "Foo" leaves "FooBar" alone.
+func guildChangelogMatch(guildName string) (string, []interface{}) {
+	quoted := escapeLike("'" + guildName + "'")
+	return `(event_kind IN (?, ?, ?, ?) OR event_kind IS NULL) AND (
+			activity_description IN (?, ?)
+			OR activity_description LIKE ? ESCAPE '\'
+			OR activity_description LIKE ? ESCAPE '\'
+			OR activity_description LIKE ? ESCAPE '\')`,
+		[]interface{}{
+			changelogKindGuildJoin, changelogKindGuildLeave, changelogKindGuildMove, changelogKindGuildMaster,
+			"Joined guild '" + guildName + "'.",
+			"Left guild '" + guildName + "'.",
+			"Moved from guild " + quoted + " to %",
+			"Moved from guild % to " + quoted + ".",
+			"Guild " + quoted + " leadership changed from %",
+		}
+}
+
+// GuildResetCounts is how many rows resetGuildHistory deleted per table.
+type GuildResetCounts struct {
+	Changelog     int64
+	MemberHistory int64
+	Guild         int64
+}
+
+// resetGuildHistory deletes one guild's changelog entries and member
+// count history in a single transaction. With snapshot it also deletes
+// the guild's row in guilds; the next guild scrape recreates it. Member
+// characters keep their guild_name, so that scrape logs no joins.
+func resetGuildHistory(guildName string, snapshot bool) (GuildResetCounts, error) {
+	var counts GuildResetCounts
+	if strings.TrimSpace(guildName) == "" {
+		return counts, errors.New("guild name is required")
+	}
+
+	tx, err := srv.db.Begin()
+	if err != nil {
+		return counts, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	type deleteStep struct {
+		table, query string
+		args         []interface{}
+		count        *int64
+	}
+	where, params := guildChangelogMatch(guildName)
+	steps := []deleteStep{
+		{"character_changelog", "DELETE FROM character_changelog WHERE " + where, params, &counts.Changelog},
+		{"guild_member_history", "DELETE FROM guild_member_history WHERE guild_name = ?", []interface{}{guildName}, &counts.MemberHistory},
+	}
+	if snapshot {
+		steps = append(steps, deleteStep{"guilds", "DELETE FROM guilds WHERE name = ?", []interface{}{guildName}, &counts.Guild})
+	}
+	for _, step := range steps {
+		res, err := tx.Exec(step.query, step.args...)
+		if err != nil {
+			return GuildResetCounts{}, fmt.Errorf("could not delete from %s: %w", step.table, err)
+		}
+		*step.count, _ = res.RowsAffected()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return GuildResetCounts{}, fmt.Errorf("could not commit: %w", err)
+	}
+	return counts, nil
+}
+
+// adminResetGuildHandler is the targeted counterpart of
+// adminCleanupGuildHistoryHandler: it wipes the history of the one guild
+// named in the form, for when only that guild's data is corrupted.
+func adminResetGuildHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	guildName := strings.TrimSpace(r.FormValue("name"))
+	if guildName == "" {
+		http.Redirect(w, r, adminRedirectURL(r, "Error: Guild name cannot be empty."), http.StatusSeeOther)
+		return
+	}
+	snapshot := r.FormValue("snapshot") == "true"
+
+	counts, err := resetGuildHistory(guildName, snapshot)
+	if err != nil {
+		log.Printf("[E] [Admin] Failed to reset guild '%s': %v", guildName, err)
+		http.Redirect(w, r, adminRedirectURL(r, "Database error while resetting the guild."), http.StatusSeeOther)
+		return
+	}
+
+	msg := fmt.Sprintf("Reset guild '%s': deleted %d changelog entries and %d member history points", guildName, counts.Changelog, counts.MemberHistory)
+	if snapshot {
+		msg += fmt.Sprintf(", and %d guild row", counts.Guild)
+	}
+	msg += "."
+	log.Printf("[I] [Admin] %s", msg)
+	http.Redirect(w, r, adminRedirectURL(r, msg), http.StatusSeeOther)
+}
+
 // 1. New Helper: Fetch Chat Messages for Admin
 func getAdminChatMessages(r *http.Request, stats *AdminDashboardData) {
 	const messagesPerPage = 50
@@ -939,7 +1179,7 @@ func getAdminChatMessages(r *http.Request, stats *AdminDashboardData) {
 		var ts string
 		if err := rows.Scan(&msg.ID, &ts, &msg.Channel, &msg.CharacterName, &msg.Message); err == nil {
 			if t, parseErr := time.Parse(time.RFC3339, ts); parseErr == nil {
-				msg.Timestamp = t.Format("2006-01-02 15:04:05")
+				msg.Timestamp = displayTime(t).Format("2006-01-02 15:04:05")
 			} else {
 				msg.Timestamp = ts
 			}
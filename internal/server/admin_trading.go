@@ -2,12 +2,14 @@ package server
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func adminDeleteTradingPostHandler(w http.ResponseWriter, r *http.Request) {
@@ -48,6 +50,8 @@ func adminDeleteTradingPostHandler(w http.ResponseWriter, r *http.Request) {
 
 // reparseTradingPostItems handles the database transaction for updating items.
 func reparseTradingPostItems(postID int, itemsToUpdate []GeminiTradeItem) (int, error) {
+	itemIDs := resolveTradeItemIDs(itemsToUpdate, "[Admin/Reparse]")
+
 	tx, err := srv.db.Begin()
 	if err != nil {
 		return 0, fmt.Errorf("failed to start database transaction: %w", err)
@@ -77,16 +81,12 @@ func reparseTradingPostItems(postID int, itemsToUpdate []GeminiTradeItem) (int,
 	defer stmt.Close()
 
 	// 3. Insert new items
-	for _, item := range itemsToUpdate {
+	for i, item := range itemsToUpdate {
 		itemName := sanitizeString(item.Name, itemSanitizer)
 		if strings.TrimSpace(itemName) == "" {
 			continue
 		}
-
-		itemID, findErr := findItemIDByName(itemName, true, item.Slots)
-		if findErr != nil {
-			log.Printf("[W] [Admin/Reparse] Error finding item ID for '%s' during re-parse: %v. Proceeding without ID.", itemName, findErr)
-		}
+		itemID := itemIDs[i]
 
 		paymentMethods := "zeny"
 		if item.PaymentMethods == "rmt" || item.PaymentMethods == "both" {
@@ -250,7 +250,7 @@ func adminShowEditTradingPostPage(w http.ResponseWriter, r *http.Request, postID
 		LastScrapeTime: GetLastScrapeTime(),
 	}
 	if err := tmpl.Execute(w, data); err != nil {
-		log.Printf("[E] [HTTP] Could not execute admin_edit_post.html: %v", err)
+		logRequestf(r, "[E] [HTTP] Could not execute admin_edit_post.html: %v", err)
 	}
 }
 
@@ -582,3 +582,334 @@ func backfillDropLogsToChangelog() (int64, error) {
 	return newEntriesCount, nil
 }
 
+
+// adminBackfillMarketEventsHandler rebuilds missing market_events from the
+// items history. It only ever adds events, so it is safe to run again.
+func adminBackfillMarketEventsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("[I] [Admin] Manual backfill of market events triggered.")
+
+	count, err := backfillMarketEvents()
+	if err != nil {
+		log.Printf("[E] [Admin] Market event backfill failed: %v", err)
+		http.Redirect(w, r, adminRedirectURL(r, "Market event backfill failed."), http.StatusSeeOther)
+		return
+	}
+
+	msg := fmt.Sprintf("Market event backfill complete. %d new events added.", count)
+	log.Printf("[I] [Admin] %s", msg)
+	http.Redirect(w, r, adminRedirectURL(r, msg), http.StatusSeeOther)
+}
+
+// marketSnapshot is every items row of one name written by one scrape.
+// The scraper rewrites a name's full listing set whenever it changes, so
+// consecutive snapshots of a name diff the same way the scraper did.
+type marketSnapshot struct {
+	Timestamp string
+	Items     []Item
+}
+
+// derivedMarketEvent is a market_events row rebuilt from the items history.
+// The live scraper logged it at some scrape in (After, Timestamp]: the
+// items history only shows that a listing changed between two snapshots
+// of its name, not at which scrape in between.
+type derivedMarketEvent struct {
+	After     string
+	Timestamp string
+	EventType string
+	ItemName  string
+	ItemID    int
+	Details   string
+}
+
+// marketEventDetails is the details JSON the scraper stores for a listing.
+func marketEventDetails(item Item) string {
+	details, _ := json.Marshal(map[string]interface{}{
+		"price":      item.Price,
+		"quantity":   item.Quantity,
+		"seller":     item.SellerName,
+		"store_name": item.StoreName,
+	})
+	return string(details)
+}
+
+// deriveMarketEvents replays a name's snapshots (oldest first) and returns
+// the ADDED and removal events the scraper would have logged. The first
+// snapshot is an ADDED event; each listing missing from the next snapshot
+// is SOLD when its seller wrote rows at that scrape (activeSellers, keyed
+// by timestamp) and REMOVED otherwise, and each listing that is new in it
+// (a seller or price that appears or reappears) is ADDED. The removal of
+// a name's last snapshot isn't recorded in items, so it is never derived.
+func deriveMarketEvents(name string, snapshots []marketSnapshot, activeSellers map[string]map[string]bool) []derivedMarketEvent {
+	if len(snapshots) == 0 || len(snapshots[0].Items) == 0 {
+		return nil
+	}
+
+	first := snapshots[0]
+	events := []derivedMarketEvent{{
+		Timestamp: first.Timestamp,
+		EventType: "ADDED",
+		ItemName:  name,
+		ItemID:    first.Items[0].ItemID,
+		Details:   marketEventDetails(first.Items[0]),
+	}}
+
+	for i := 1; i < len(snapshots); i++ {
+		prev, next := snapshots[i-1], snapshots[i]
+		prevSet := make(map[comparableItem]bool, len(prev.Items))
+		for _, item := range prev.Items {
+			prevSet[toComparable(item)] = true
+		}
+		nextSet := make(map[comparableItem]bool, len(next.Items))
+		for _, item := range next.Items {
+			nextSet[toComparable(item)] = true
+		}
+		for _, item := range prev.Items {
+			if nextSet[toComparable(item)] {
+				continue
+			}
+			eventType := "REMOVED"
+			if activeSellers[next.Timestamp][item.SellerName] {
+				eventType = "SOLD"
+			}
+			events = append(events, derivedMarketEvent{
+				After:     prev.Timestamp,
+				Timestamp: next.Timestamp,
+				EventType: eventType,
+				ItemName:  name,
+				ItemID:    item.ItemID,
+				Details:   marketEventDetails(item),
+			})
+		}
+		for _, item := range next.Items {
+			if prevSet[toComparable(item)] {
+				continue
+			}
+			events = append(events, derivedMarketEvent{
+				After:     prev.Timestamp,
+				Timestamp: next.Timestamp,
+				EventType: "ADDED",
+				ItemName:  name,
+				ItemID:    item.ItemID,
+				Details:   marketEventDetails(item),
+			})
+		}
+	}
+	return events
+}
+
+// backfillMarketEvents diffs every item's consecutive snapshots and inserts
+// the events that market_events is missing. An event already counts as
+// present when one with the same name and details was logged anywhere in
+// its (After, Timestamp] window, since the live scraper may have seen the
+// change at any scrape in between; removals match any removal type, since
+// the scraper knew better than the backfill whether the seller was still
+// online.
+func backfillMarketEvents() (int64, error) {
+	log.Println("[I] [Backfill] Starting market event backfill process...")
+
+	marketMutex.Lock()
+	defer marketMutex.Unlock()
+
+	tx, err := srv.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Sellers that wrote any rows at a scrape were online for it.
+	activeSellers := make(map[string]map[string]bool)
+	rows, err := tx.Query("SELECT DISTINCT date_and_time_retrieved, seller_name FROM items")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query scrape sellers: %w", err)
+	}
+	for rows.Next() {
+		var ts, seller string
+		if err := rows.Scan(&ts, &seller); err != nil {
+			continue
+		}
+		if activeSellers[ts] == nil {
+			activeSellers[ts] = make(map[string]bool)
+		}
+		activeSellers[ts][seller] = true
+	}
+	rows.Close()
+
+	var names []string
+	rows, err = tx.Query("SELECT DISTINCT name_of_the_item FROM items")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query item names: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+
+	stmtCount, err := tx.Prepare(`
+		SELECT COUNT(*) FROM market_events
+		WHERE event_timestamp > ? AND event_timestamp <= ? AND item_name = ? AND details = ?
+		  AND (event_type = ? OR (? != 'ADDED' AND event_type IN ('SOLD', 'REMOVED', 'REMOVED_SINGLE')))`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare count statement: %w", err)
+	}
+	defer stmtCount.Close()
+
+	stmtInsert, err := tx.Prepare(`INSERT INTO market_events (event_timestamp, event_type, item_name, item_id, details) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert statement: %w", err)
+	}
+	defer stmtInsert.Close()
+
+	var created int64
+	for _, name := range names {
+		snapshots, err := loadMarketSnapshots(tx, name)
+		if err != nil {
+			log.Printf("[W] [Backfill] Failed to load snapshots for '%s': %v", name, err)
+			continue
+		}
+
+		// Identical listings produce identical events, so compare counts
+		// rather than mere existence.
+		wanted := make(map[derivedMarketEvent]int)
+		var order []derivedMarketEvent
+		for _, ev := range deriveMarketEvents(name, snapshots, activeSellers) {
+			if wanted[ev] == 0 {
+				order = append(order, ev)
+			}
+			wanted[ev]++
+		}
+
+		for _, ev := range order {
+			var existing int
+			if err := stmtCount.QueryRow(ev.After, ev.Timestamp, ev.ItemName, ev.Details, ev.EventType, ev.EventType).Scan(&existing); err != nil {
+				return created, fmt.Errorf("failed to check events for '%s': %w", name, err)
+			}
+			for n := existing; n < wanted[ev]; n++ {
+				if _, err := stmtInsert.Exec(ev.Timestamp, ev.EventType, ev.ItemName, ev.ItemID, ev.Details); err != nil {
+					return created, fmt.Errorf("failed to insert %s event for '%s': %w", ev.EventType, name, err)
+				}
+				created++
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("[I] [Backfill] Market event backfill complete. %d item names scanned, %d events added.", len(names), created)
+	return created, nil
+}
+
+// loadMarketSnapshots returns a name's items rows grouped by retrieval
+// time, oldest first.
+func loadMarketSnapshots(tx *sql.Tx, name string) ([]marketSnapshot, error) {
+	rows, err := tx.Query(`
+		SELECT date_and_time_retrieved, name_of_the_item, COALESCE(item_id, 0), quantity, price,
+		       store_name, seller_name, map_name, map_coordinates
+		FROM items
+		WHERE name_of_the_item = ?
+		ORDER BY date_and_time_retrieved ASC, id ASC`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []marketSnapshot
+	for rows.Next() {
+		var ts string
+		var item Item
+		if err := rows.Scan(&ts, &item.Name, &item.ItemID, &item.Quantity, &item.Price,
+			&item.StoreName, &item.SellerName, &item.MapName, &item.MapCoordinates); err != nil {
+			return nil, err
+		}
+		if n := len(snapshots); n == 0 || snapshots[n-1].Timestamp != ts {
+			snapshots = append(snapshots, marketSnapshot{Timestamp: ts})
+		}
+		snapshots[len(snapshots)-1].Items = append(snapshots[len(snapshots)-1].Items, item)
+	}
+	return snapshots, rows.Err()
+}
+
+// adminAddTradeWatchHandler saves a new trade watch. A watch needs an
+// item ID or a name pattern (or both) and an http(s) webhook URL.
+func adminAddTradeWatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Redirect(w, r, adminRedirectURL(r, "Error parsing form."), http.StatusSeeOther)
+		return
+	}
+
+	namePattern := strings.TrimSpace(r.FormValue("name_pattern"))
+	webhookURL := strings.TrimSpace(r.FormValue("webhook_url"))
+	postType := r.FormValue("post_type")
+
+	var itemID sql.NullInt64
+	if idStr := strings.TrimSpace(r.FormValue("item_id")); idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Redirect(w, r, adminRedirectURL(r, "Error: Invalid item ID."), http.StatusSeeOther)
+			return
+		}
+		itemID = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	if !itemID.Valid && namePattern == "" {
+		http.Redirect(w, r, adminRedirectURL(r, "Error: An item ID or name pattern is required."), http.StatusSeeOther)
+		return
+	}
+	if u, err := url.Parse(webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Redirect(w, r, adminRedirectURL(r, "Error: Webhook URL must be an http(s) URL."), http.StatusSeeOther)
+		return
+	}
+	if postType != "buying" && postType != "selling" {
+		postType = ""
+	}
+
+	var msg string
+	_, err := srv.db.Exec(`INSERT INTO trade_watches (item_id, name_pattern, post_type, webhook_url, created_at) VALUES (?, ?, ?, ?, ?)`,
+		itemID, sql.NullString{String: namePattern, Valid: namePattern != ""}, sql.NullString{String: postType, Valid: postType != ""},
+		webhookURL, time.Now().Format(time.RFC3339))
+	if err != nil {
+		log.Printf("[E] [Admin] Failed to add trade watch: %v", err)
+		msg = "Database error while saving trade watch."
+	} else {
+		log.Printf("[I] [Admin] Admin added trade watch (item_id=%v, pattern='%s', type='%s').", itemID.Int64, namePattern, postType)
+		msg = "Trade watch added."
+	}
+
+	http.Redirect(w, r, adminRedirectURL(r, msg), http.StatusSeeOther)
+}
+
+func adminDeleteTradeWatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Redirect(w, r, adminRedirectURL(r, "Error parsing form."), http.StatusSeeOther)
+		return
+	}
+
+	watchID := r.FormValue("watch_id")
+	var msg string
+
+	result, err := srv.db.Exec("DELETE FROM trade_watches WHERE id = ?", watchID)
+	if err != nil {
+		log.Printf("[E] [Admin] Failed to delete trade watch %s: %v", watchID, err)
+		msg = "Database error while deleting trade watch."
+	} else if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		msg = "Trade watch not found."
+	} else {
+		log.Printf("[I] [Admin] Admin deleted trade watch %s.", watchID)
+		msg = "Trade watch deleted."
+	}
+
+	http.Redirect(w, r, adminRedirectURL(r, msg), http.StatusSeeOther)
+}
@@ -59,22 +59,22 @@ func emblemFilename(url string) string {
 // keys out the magenta background to transparent, and stores the result
 // under emblemDir() as PNG. The DB column emblem_local_path is updated
 // to point at the served path (/emblems/<hash>.png).
-func processGuildEmblems() {
+func processGuildEmblems() error {
 	dir := emblemDir()
 	if dir == "" {
 		log.Println("[W] [Emblem] Skipping emblem processing: emblem directory not configured.")
-		return
+		return nil
 	}
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		log.Printf("[E] [Emblem] Failed to create emblem dir %s: %v", dir, err)
-		return
+		return err
 	}
 
 	rows, err := srv.db.Query(`SELECT name, COALESCE(emblem_url, ''), COALESCE(emblem_local_path, '')
 		FROM guilds WHERE is_active = 1`)
 	if err != nil {
 		log.Printf("[E] [Emblem] Failed to query guilds: %v", err)
-		return
+		return err
 	}
 	type job struct{ name, url, local string }
 	var jobs []job
@@ -118,6 +118,7 @@ func processGuildEmblems() {
 		processed++
 	}
 	log.Printf("[I] [Emblem] Emblem processing complete: %d processed, %d skipped, %d failed.", processed, skipped, failed)
+	return nil
 }
 
 // downloadAndKeyEmblem fetches a URL, keys magenta to transparent, and
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// featureFlagDefs lists the routes that can be switched off at runtime,
+// in the order the admin panel shows them. A flag name is what goes in
+// DISABLED_FEATURES and the feature_flags table.
+var featureFlagDefs = []struct {
+	Name  string
+	Route string
+}{
+	{"guild-churn", "/stats/guild-churn"},
+	{"rebirth-stats", "/stats/rebirths"},
+	{"wealth-stats", "/stats/wealth"},
+	{"seller-volume", "/seller/volume"},
+	{"woe-matchup", "/woe/matchup"},
+	{"woe-by-class-json", "/woe/by-class.json"},
+	{"items-all-json", "/items/all.json"},
+	{"guild-roster", "/guild/roster"},
+	{"activity-calendar", "/character/activity-calendar"},
+	{"price-watch", "/watch"},
+}
+
+// FeatureFlag is one row of the admin panel's feature list. Overridden
+// means the state was set from the panel rather than DISABLED_FEATURES.
+type FeatureFlag struct {
+	Name       string
+	Route      string
+	Enabled    bool
+	Overridden bool
+}
+
+var (
+	featureFlagMutex     sync.RWMutex
+	featureFlagOverrides = make(map[string]bool)
+)
+
+// featureEnabled reports whether the named feature is on. An admin
+// override wins; otherwise the feature is on unless DISABLED_FEATURES
+// lists it.
+func featureEnabled(name string) bool {
+	featureFlagMutex.RLock()
+	enabled, ok := featureFlagOverrides[name]
+	featureFlagMutex.RUnlock()
+	if ok {
+		return enabled
+	}
+	return appConfig == nil || !slices.Contains(appConfig.DisabledFeatures, name)
+}
+
+// featureGate serves h only while the named feature is enabled and
+// answers 404 otherwise, so a disabled page looks like it never existed.
+func featureGate(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !featureEnabled(name) {
+			notFoundHandler(w, r)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// isFeatureFlag reports whether name is one of featureFlagDefs.
+func isFeatureFlag(name string) bool {
+	for _, def := range featureFlagDefs {
+		if def.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadFeatureFlags reads the admin overrides saved in feature_flags into
+// memory. It runs once at startup; toggles keep the map in sync after.
+func loadFeatureFlags() error {
+	if appConfig != nil {
+		for _, name := range appConfig.DisabledFeatures {
+			if !isFeatureFlag(name) {
+				log.Printf("[W] [Features] DISABLED_FEATURES names unknown feature '%s'.", name)
+			}
+		}
+	}
+
+	rows, err := srv.db.Query("SELECT name, enabled FROM feature_flags")
+	if err != nil {
+		return fmt.Errorf("could not load feature flags: %w", err)
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return fmt.Errorf("could not scan feature flag: %w", err)
+		}
+		overrides[name] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not load feature flags: %w", err)
+	}
+
+	featureFlagMutex.Lock()
+	featureFlagOverrides = overrides
+	featureFlagMutex.Unlock()
+	return nil
+}
+
+// listFeatureFlags returns the current state of every known feature.
+func listFeatureFlags() []FeatureFlag {
+	featureFlagMutex.RLock()
+	defer featureFlagMutex.RUnlock()
+
+	flags := make([]FeatureFlag, 0, len(featureFlagDefs))
+	for _, def := range featureFlagDefs {
+		flag := FeatureFlag{Name: def.Name, Route: def.Route}
+		if enabled, ok := featureFlagOverrides[def.Name]; ok {
+			flag.Enabled, flag.Overridden = enabled, true
+		} else {
+			flag.Enabled = appConfig == nil || !slices.Contains(appConfig.DisabledFeatures, def.Name)
+		}
+		flags = append(flags, flag)
+	}
+	return flags
+}
+
+// adminToggleFeatureHandler sets a feature's override. state is "on",
+// "off", or "default" to drop the override and fall back to
+// DISABLED_FEATURES.
+func adminToggleFeatureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	name := r.FormValue("name")
+	state := r.FormValue("state")
+	if !isFeatureFlag(name) {
+		http.Redirect(w, r, adminRedirectURL(r, "Error: Unknown feature."), http.StatusSeeOther)
+		return
+	}
+
+	var err error
+	switch state {
+	case "on", "off":
+		_, err = srv.db.Exec(`INSERT INTO feature_flags (name, enabled, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT(name) DO UPDATE SET enabled = excluded.enabled, updated_at = excluded.updated_at`,
+			name, state == "on", time.Now().Format(time.RFC3339))
+	case "default":
+		_, err = srv.db.Exec("DELETE FROM feature_flags WHERE name = ?", name)
+	default:
+		http.Redirect(w, r, adminRedirectURL(r, "Error: Invalid feature state."), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Printf("[E] [Admin] Failed to set feature '%s' to %s: %v", name, state, err)
+		http.Redirect(w, r, adminRedirectURL(r, "Database error while updating the feature flag."), http.StatusSeeOther)
+		return
+	}
+
+	featureFlagMutex.Lock()
+	if state == "default" {
+		delete(featureFlagOverrides, name)
+	} else {
+		featureFlagOverrides[name] = state == "on"
+	}
+	featureFlagMutex.Unlock()
+
+	log.Printf("[I] [Admin] Feature '%s' set to %s.", name, state)
+	http.Redirect(w, r, adminRedirectURL(r, fmt.Sprintf("Feature '%s' set to %s.", name, state)), http.StatusSeeOther)
+}
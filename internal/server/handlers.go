@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
@@ -9,15 +10,20 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"html/template"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/agnivade/levenshtein"
+	"github.com/denislee/yufa-mt/internal/config"
 	"github.com/denislee/yufa-mt/internal/httpx"
 	"github.com/denislee/yufa-mt/internal/i18n"
 	"github.com/denislee/yufa-mt/web"
@@ -28,6 +34,20 @@ type BasePageData struct {
 	Lang       string
 	T          map[string]string
 	RequestURL string
+
+	// Set when the request's sort_by was rejected by the page's allowed
+	// sorts; EffectiveSort is the column actually used.
+	IgnoredSort   string
+	EffectiveSort string
+
+	// Set when the scrape backing the page is older than STALE_DATA_HOURS.
+	// StaleSource is the i18n key naming that data.
+	StaleSource string
+	StaleHours  int
+
+	// How old that scrape is, for coloring the "Updated X ago" text:
+	// "fresh", "stale", "very-stale", or "" when unknown.
+	Freshness string
 }
 
 // Add these package-level variables to handlers.go
@@ -56,10 +76,14 @@ var (
 		"toggleOrder":      toggleOrder,
 		"parseDropMessage": parseDropMessage,
 		"formatZeny":       formatZeny,
+		"formatNumber":     formatNumber,
+		"netZeny":          netZeny,
 		"formatRMT":        formatRMT,
 		"getKillCount":     getKillCount,
 		"formatAvgLevel":   formatAvgLevel,
 		"getClassImageURL": getClassImageURL,
+		"itemImage":        itemImageURL,
+		"itemImageOrigin":  itemImageOrigin,
 		"TmplHTML":         tmplHTML,
 		"renderTmpl":       renderTmpl,
 		"TmplURL":          tmplURL,
@@ -68,6 +92,7 @@ var (
 		"trimPrefix":       strings.TrimPrefix,
 		"default":          defaultFunc,
 		"asset":            assetURL,
+		"featureEnabled":   featureEnabled,
 	}
 
 	// classImages maps class names to their icon URLs.
@@ -120,21 +145,118 @@ var definedEvents = []EventDefinition{
 }
 
 var (
-	nameSanitizer    = regexp.MustCompile(`[^a-zA-Z0-9 ]+`)
-	itemSanitizer    = regexp.MustCompile(`[^\p{L}0-9\s\[\]\+\-]+`)
+	nameSanitizer    = allowedCharsSanitizer(config.DefaultNameAllowedChars)
+	itemSanitizer    = allowedCharsSanitizer(config.DefaultItemAllowedChars)
 	reCardRemover    = regexp.MustCompile(`(?i)\s*\b(card|carta)\b\s*`)
+	reMarketNameDeco = regexp.MustCompile(`\s*(\[[^\]]*\]|\+\d+)`)
 	reSlotRemover    = regexp.MustCompile(`\s*\[\d+\]\s*`)
+	reMarketSlots    = regexp.MustCompile(`^[^\[+]*\[(\d+)\]`)
 	dropMessageRegex = regexp.MustCompile(`'(.+)'\s+(got|stole)\s+(.+)`)
+	classChangeRegex = regexp.MustCompile(`^Changed class from '(.*)' to '(.*)'\.$`)
+	guildJoinRegex   = regexp.MustCompile(`^Joined guild '(.*)'\.$`)
+	guildLeaveRegex  = regexp.MustCompile(`^Left guild '(.*)'\.$`)
+	guildMoveRegex   = regexp.MustCompile(`^Moved from guild '(.*)' to '(.*)'\.$`)
 	reItemFromDrop   = regexp.MustCompile(`(?:(?:\d+\s*x\s*)?'(.+?)'|.+\'s\s+(.+?)|(.+?))\s*(?:\(chance:.*)?$`)
 	aliasSanitizer   = regexp.MustCompile(`[^a-zA-Z0-9_]`)
 	reRefineRemover  = regexp.MustCompile(`\s*\+\d+\s*`)
 )
 
+// MvpKillCountOffset is subtracted from the stored kill count of MVPs
+// without an MVP_KILL_OFFSETS entry.
 const MvpKillCountOffset = 3
 
+// statsPriceCap is the price at or above which SOLD events are left out
+// of market statistics: such prices are almost always typos or
+// placeholder listings that would swamp the totals. The market stats
+// page can override it with ?max_price=.
+const statsPriceCap = 50_000_000
+
+// soldPriceSQL reads a market_events row's sale price as an INTEGER.
+var soldPriceSQL = marketPriceSQL("json_extract(details, '$.price')")
+
+// soldSinceSQL selects SOLD events since a bound start time.
+var soldSinceSQL = "event_type = 'SOLD' AND event_timestamp >= ?"
+
+// soldSinceWithinCapSQL is soldSinceSQL skipping prices at or above a
+// bound cap.
+var soldSinceWithinCapSQL = soldSinceSQL + " AND " + soldPriceSQL + " < ?"
+
+// maxResultRows is the cap applied to list queries without a natural
+// LIMIT (MAX_RESULT_ROWS).
+func maxResultRows() int {
+	if appConfig == nil || appConfig.MaxResultRows < 1 {
+		return 5000
+	}
+	return appConfig.MaxResultRows
+}
+
+// characterDefaultView returns the columns and graph class tiers the
+// characters page shows on a first visit (CHARACTER_COLUMNS and
+// CHARACTER_GRAPH_FILTER).
+func characterDefaultView() (cols, graphFilter []string) {
+	if appConfig == nil {
+		return config.DefaultCharacterColumns, config.DefaultCharacterGraphFilter
+	}
+	return appConfig.CharacterColumns, appConfig.CharacterGraphFilter
+}
+
+// mvpKillCountOffset returns the offset of mobID's stored kill counts:
+// its MVP_KILL_OFFSETS entry, or MvpKillCountOffset.
+func mvpKillCountOffset(mobID string) int {
+	if appConfig != nil {
+		if offset, ok := appConfig.MvpKillOffsets[mobID]; ok {
+			return offset
+		}
+	}
+	return MvpKillCountOffset
+}
+
+// mvpDisplayKills applies mobID's offset to a stored kill count.
+// Kills are offset in the DB to protect against stale data.
+func mvpDisplayKills(mobID string, stored int) int {
+	offset := mvpKillCountOffset(mobID)
+	if stored < offset {
+		return 0
+	}
+	return stored - offset
+}
+
 // Legacy pagination structures extracted to internal/httpx
 
-func buildItemSearchClause(searchQuery, tableAlias string) (string, []interface{}, error) {
+// itemSearchMode validates ?search_mode=. "id" matches the query as an
+// item ID, "name" always searches names (for numeric-looking names), and
+// anything else is "auto": an all-digit query is an ID, the rest names.
+func itemSearchMode(v string) string {
+	switch v {
+	case "id", "name":
+		return v
+	}
+	return "auto"
+}
+
+// searchByItemID reports whether a search for query in the given mode
+// should match item IDs rather than names.
+func searchByItemID(query, mode string) bool {
+	switch mode {
+	case "id":
+		return true
+	case "name":
+		return false
+	}
+	_, err := strconv.Atoi(query)
+	return err == nil
+}
+
+// searchModeParam is the search_mode query-string suffix for page links;
+// empty for the default "auto".
+func searchModeParam(mode string) template.URL {
+	if mode == "auto" || mode == "" {
+		return ""
+	}
+	return template.URL("&search_mode=" + url.QueryEscape(mode))
+}
+
+func buildItemSearchClause(searchQuery, searchMode, tableAlias string) (string, []interface{}, error) {
 	if searchQuery == "" {
 		return "", nil, nil
 	}
@@ -144,7 +266,7 @@ func buildItemSearchClause(searchQuery, tableAlias string) (string, []interface{
 		alias += "."
 	}
 
-	if _, err := strconv.Atoi(searchQuery); err == nil {
+	if searchByItemID(searchQuery, searchMode) {
 		return fmt.Sprintf("%sitem_id = ?", alias), []interface{}{searchQuery}, nil
 	}
 
@@ -173,18 +295,106 @@ type TemplateData struct {
 	Data interface{}  // Page-specific data (e.g., SummaryPageData)
 }
 
+// renderBufferBytes is how much of a page renderTemplate holds back
+// before it starts streaming (RENDER_BUFFER_KB).
+func renderBufferBytes() int {
+	if appConfig == nil {
+		return 1024 * 1024
+	}
+	return appConfig.RenderBufferKB * 1024
+}
+
+// pageWriter buffers a rendered page until it outgrows limit, then sends
+// what it holds and passes later writes straight through. While nothing
+// has been sent, a template error can still be answered with a clean 500.
+type pageWriter struct {
+	w         http.ResponseWriter
+	buf       bytes.Buffer
+	limit     int
+	streaming bool
+}
+
+func newPageWriter(w http.ResponseWriter, limit int) *pageWriter {
+	return &pageWriter{w: w, limit: limit, streaming: limit <= 0}
+}
+
+func (p *pageWriter) Write(b []byte) (int, error) {
+	if p.streaming {
+		return p.w.Write(b)
+	}
+	if p.buf.Len()+len(b) <= p.limit {
+		return p.buf.Write(b)
+	}
+	p.streaming = true
+	if _, err := p.w.Write(p.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	p.buf.Reset()
+	return p.w.Write(b)
+}
+
+// flush sends a page that fit in the buffer.
+func (p *pageWriter) flush() error {
+	if p.streaming || p.buf.Len() == 0 {
+		return nil
+	}
+	_, err := p.w.Write(p.buf.Bytes())
+	p.buf.Reset()
+	return err
+}
+
+// fail logs a render error and, if the page is still buffered, drops it
+// in favour of a 500. A page that is already streaming can only be cut
+// short.
+func (p *pageWriter) fail(r *http.Request, format string, args ...interface{}) {
+	logRequestf(r, format, args...)
+	if !p.streaming {
+		p.buf.Reset()
+		http.Error(p.w, "Could not render page", http.StatusInternalServerError)
+	}
+}
+
 // renderTemplate executes a pre-parsed template with a consistent data
 // structure. Renders the full layout (which composes title/head_extra/
 // shell/content blocks); page templates may define any subset.
 //
+// Output goes through a pageWriter, so a template error on a page smaller
+// than RENDER_BUFFER_KB gives a 500 rather than half a page.
+//
 // For htmx-boosted navigation (HX-Request: true) the response is reduced
 // to just the page-specific title + head_extra + content, which the body
 // htmx attrs swap into #main. The navbar and shell are not re-rendered,
 // shrinking the response by ~10x on a typical page.
 func renderTemplate(w http.ResponseWriter, r *http.Request, tmplFile string, data interface{}) {
+	renderPage(w, r, tmplFile, data, nil, renderBufferBytes())
+}
+
+// renderSortedTemplate is renderTemplate for pages sorted by ?sort_by=.
+// sortBy is the column the handler actually sorted on (as returned by
+// httpx.GetSortClause), so the page can say when the requested one was
+// not allowed.
+func renderSortedTemplate(w http.ResponseWriter, r *http.Request, tmplFile string, data interface{}, sortBy string) {
+	renderPage(w, r, tmplFile, data, []sortParam{{"sort_by", sortBy}}, renderBufferBytes())
+}
+
+// sortParam pairs a sort query parameter with the column the handler
+// actually sorted on.
+type sortParam struct {
+	param, sortBy string
+}
+
+// renderMultiSortTemplate is renderSortedTemplate for pages with several
+// sorted tables, each taking its column from its own query parameter.
+func renderMultiSortTemplate(w http.ResponseWriter, r *http.Request, tmplFile string, data interface{}, sorts ...sortParam) {
+	renderPage(w, r, tmplFile, data, sorts, renderBufferBytes())
+}
+
+// renderPage does the work of renderSortedTemplate, buffering up to
+// bufLimit bytes of the page in a pageWriter.
+func renderPage(w http.ResponseWriter, r *http.Request, tmplFile string, data interface{}, sorts []sortParam, bufLimit int) {
 	tmpl, ok := templateCache[tmplFile]
 	if !ok {
-		log.Printf("[E] [HTTP] Could not find template '%s' in cache!", tmplFile)
+		logRequestf(r, "[E] [HTTP] Could not find template '%s' in cache!", tmplFile)
 		http.Error(w, "Could not load template", http.StatusInternalServerError)
 		return
 	}
@@ -195,43 +405,231 @@ func renderTemplate(w http.ResponseWriter, r *http.Request, tmplFile string, dat
 		T:          i18n.Translations(lang),
 		RequestURL: r.URL.RequestURI(),
 	}
+	for _, s := range sorts {
+		if requested := r.FormValue(s.param); requested != "" && s.sortBy != "" && s.sortBy != requested {
+			pageCtx.IgnoredSort = requested
+			pageCtx.EffectiveSort = s.sortBy
+			break
+		}
+	}
+	now := time.Now()
+	if source, hours, stale := staleDataFor(tmplFile, now); stale {
+		pageCtx.StaleSource = source
+		pageCtx.StaleHours = hours
+	}
+	pageCtx.Freshness = updatedFreshness(tmplFile, now)
 	fullData := TemplateData{Page: pageCtx, Data: data}
+	pw := newPageWriter(w, bufLimit)
 
 	if r.Header.Get("HX-Request") == "true" {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		// Emit <title> first so the browser-side htmx:beforeSwap handler
 		// in app.js can parse it out and update document.title. Stray
 		// title inside #main is harmless (browsers don't render it).
-		if _, err := w.Write([]byte("<title>")); err != nil {
+		if _, err := pw.Write([]byte("<title>")); err != nil {
 			return
 		}
-		if err := tmpl.ExecuteTemplate(w, "title", fullData); err != nil {
-			log.Printf("[E] [HTTP] partial title '%s': %v", tmplFile, err)
+		if err := tmpl.ExecuteTemplate(pw, "title", fullData); err != nil {
+			pw.fail(r, "[E] [HTTP] partial title '%s': %v", tmplFile, err)
 			return
 		}
-		if _, err := w.Write([]byte("</title>")); err != nil {
+		if _, err := pw.Write([]byte("</title>")); err != nil {
 			return
 		}
 		// head_extra is defined as a block in layout.html, so Lookup
 		// always succeeds (empty default when the page didn't override).
-		if err := tmpl.ExecuteTemplate(w, "head_extra", fullData); err != nil {
-			log.Printf("[E] [HTTP] partial head_extra '%s': %v", tmplFile, err)
+		for _, block := range []string{"head_extra", "stale_notice", "sort_notice", "content"} {
+			if err := tmpl.ExecuteTemplate(pw, block, fullData); err != nil {
+				pw.fail(r, "[E] [HTTP] partial %s '%s': %v", block, tmplFile, err)
+				return
+			}
 		}
-		if err := tmpl.ExecuteTemplate(w, "content", fullData); err != nil {
-			log.Printf("[E] [HTTP] partial content '%s': %v", tmplFile, err)
+		pw.flush()
+		return
+	}
+
+	if err := tmpl.ExecuteTemplate(pw, "layout.html", fullData); err != nil {
+		pw.fail(r, "[E] [HTTP] Could not execute template '%s': %v", tmplFile, err)
+		return
+	}
+	pw.flush()
+}
+
+// bufferedResponse holds a whole response in memory so the caller can
+// check how it went before anything reaches the client.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// renderError answers with status and the message under msgKey in the
+// visitor's language: {"error": msg} for API clients (see
+// httpx.PrefersJSON), otherwise error.html inside the usual layout so the
+// visitor keeps the navbar and language. The page is rendered in full
+// before the status goes out, so a template error still gets a plain
+// http.Error with the same status and message.
+func renderError(w http.ResponseWriter, r *http.Request, status int, msgKey string) {
+	msg := i18n.Translations(i18n.Lang(r))[msgKey]
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	if httpx.PrefersJSON(r) {
+		if err := httpx.WriteJSON(w, status, map[string]string{"error": msg}); err != nil {
+			logRequestf(r, "[W] [HTTP] Failed to write %d error JSON: %v", status, err)
 		}
 		return
 	}
+	page := &bufferedResponse{header: http.Header{}}
+	renderPage(page, r, "error.html", ErrorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    msg,
+		PageTitle:  http.StatusText(status),
+	}, nil, math.MaxInt)
+	if page.status != 0 && page.status != http.StatusOK {
+		// renderPage already logged why.
+		http.Error(w, msg, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write(page.body.Bytes()); err != nil {
+		logRequestf(r, "[W] [HTTP] Failed to write %d error page: %v", status, err)
+	}
+}
+
+// notFoundHandler is the catch-all for paths no route matches.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	renderError(w, r, http.StatusNotFound, "error_page_not_found")
+}
+
+// pageDataSource is the scrape a page's data comes from: the i18n key
+// naming it, the GetLast*Time that reports its freshness, and how often
+// it runs.
+type pageDataSource struct {
+	key      string
+	last     func() string
+	interval time.Duration
+}
+
+// pageDataSources maps page templates to the scrape that backs them.
+// Pages not listed (chat, WoE, about, ...) never show the stale banner.
+var pageDataSources = map[string]pageDataSource{
+	"index.html":               {"stale_src_market", GetLastScrapeTime, marketScrapeInterval},
+	"full_list.html":           {"stale_src_market", GetLastScrapeTime, marketScrapeInterval},
+	"activity.html":            {"stale_src_market", GetLastScrapeTime, marketScrapeInterval},
+	"history.html":             {"stale_src_market", GetLastScrapeTime, marketScrapeInterval},
+	"stores.html":              {"stale_src_market", GetLastScrapeTime, marketScrapeInterval},
+	"store_detail.html":        {"stale_src_market", GetLastScrapeTime, marketScrapeInterval},
+	"market_stats.html":        {"stale_src_market", GetLastScrapeTime, marketScrapeInterval},
+	"price_spread.html":        {"stale_src_market", GetLastScrapeTime, marketScrapeInterval},
+	"price_watch.html":         {"stale_src_market", GetLastScrapeTime, marketScrapeInterval},
+	"players.html":             {"stale_src_players", GetLastPlayerCountTime, playerCountScrapeInterval},
+	"characters.html":          {"stale_src_chars", GetLastCharacterScrapeTime, characterScrapeInterval},
+	"character_detail.html":    {"stale_src_chars", GetLastCharacterScrapeTime, characterScrapeInterval},
+	"character_changelog.html": {"stale_src_chars", GetLastCharacterScrapeTime, characterScrapeInterval},
+	"character_stats.html":     {"stale_src_chars", GetLastCharacterScrapeTime, characterScrapeInterval},
+	"wealth_stats.html":        {"stale_src_chars", GetLastCharacterScrapeTime, characterScrapeInterval},
+	"rebirth_stats.html":       {"stale_src_chars", GetLastCharacterScrapeTime, characterScrapeInterval},
+	"guild_churn.html":         {"stale_src_guilds", GetLastGuildScrapeTime, guildScrapeInterval},
+	"mvp_kills.html":           {"stale_src_chars", GetLastCharacterScrapeTime, characterScrapeInterval},
+	"guilds.html":              {"stale_src_guilds", GetLastGuildScrapeTime, guildScrapeInterval},
+	"guild_detail.html":        {"stale_src_guilds", GetLastGuildScrapeTime, guildScrapeInterval},
+}
+
+// staleDataHours is STALE_DATA_HOURS; 0 disables the banner.
+func staleDataHours() int {
+	if appConfig == nil {
+		return 0
+	}
+	return appConfig.StaleDataHours
+}
+
+// staleDataFor reports whether the scrape backing tmplFile last ran more
+// than STALE_DATA_HOURS before now, returning the source's i18n key and
+// the threshold for the banner. A source that never ran isn't flagged:
+// a fresh install has nothing to warn about yet.
+func staleDataFor(tmplFile string, now time.Time) (string, int, bool) {
+	hours := staleDataHours()
+	source, ok := pageDataSources[tmplFile]
+	if hours <= 0 || !ok {
+		return "", 0, false
+	}
+	last, ok := source.lastRun()
+	if !ok || now.Sub(last) <= time.Duration(hours)*time.Hour {
+		return "", 0, false
+	}
+	return source.key, hours, true
+}
+
+// lastRun parses the source's GetLast*Time. It reports false when the
+// scrape never ran.
+func (s pageDataSource) lastRun() (time.Time, bool) {
+	last, err := time.ParseInLocation("2006-01-02 15:04:05", s.last(), displayLocation)
+	return last, err == nil
+}
 
-	if err := tmpl.ExecuteTemplate(w, "layout.html", fullData); err != nil {
-		log.Printf("[E] [HTTP] Could not execute template '%s': %v", tmplFile, err)
+// updatedFreshness classifies the age of the scrape backing tmplFile in
+// multiples of that scrape's interval: "fresh" up to
+// UPDATED_FRESH_INTERVALS, "stale" up to UPDATED_STALE_INTERVALS, and
+// "very-stale" beyond. Counting in intervals lets one setting fit both
+// the 3-minute market scrape and the 6-hour character scrape. Pages
+// without a source, or whose scrape never ran, get "".
+func updatedFreshness(tmplFile string, now time.Time) string {
+	source, ok := pageDataSources[tmplFile]
+	if !ok {
+		return ""
 	}
+	last, ok := source.lastRun()
+	if !ok {
+		return ""
+	}
+	fresh, stale := 2, 6
+	if appConfig != nil {
+		fresh, stale = appConfig.UpdatedFreshIntervals, appConfig.UpdatedStaleIntervals
+	}
+	switch age := now.Sub(last); {
+	case age <= time.Duration(fresh)*source.interval:
+		return "fresh"
+	case age <= time.Duration(stale)*source.interval:
+		return "stale"
+	}
+	return "very-stale"
 }
 
 func sanitizeString(input string, sanitizer *regexp.Regexp) string {
 	return sanitizer.ReplaceAllString(input, "")
 }
 
+// allowedCharsSanitizer builds a sanitizer stripping every character
+// outside the given character-class body.
+func allowedCharsSanitizer(chars string) *regexp.Regexp {
+	return regexp.MustCompile("[^" + chars + "]+")
+}
+
+// configureSanitizers swaps in the character sets from the config. Only
+// called from Run, before any request or scraper can use the sanitizers;
+// config.Load has already checked the classes compile.
+func configureSanitizers(cfg *config.Config) {
+	if cfg.NameAllowedChars != "" {
+		nameSanitizer = allowedCharsSanitizer(cfg.NameAllowedChars)
+	}
+	if cfg.ItemAllowedChars != "" {
+		itemSanitizer = allowedCharsSanitizer(cfg.ItemAllowedChars)
+	}
+}
+
 // getCombinedItemIDs searches the in-memory item cache for matching item IDs.
 // Avoids a full-table scan on internal_item_db (LIKE '%q%' can't use any index)
 // by iterating the cache that's already loaded for findItemIDInCache.
@@ -242,10 +640,19 @@ func getCombinedItemIDs(searchQuery string) ([]int, error) {
 		return nil, nil
 	}
 
+	// Items renamed by the server are still found by their old names.
+	renamedIDs, err := renamedItemIDs(q)
+	if err != nil {
+		log.Printf("[W] [ItemID] %v", err)
+	}
+
 	itemCacheMu.RLock()
 	defer itemCacheMu.RUnlock()
 
 	idMap := make(map[int]struct{})
+	for _, id := range renamedIDs {
+		idMap[id] = struct{}{}
+	}
 	for _, item := range itemFuzzyCache {
 		if strings.Contains(strings.ToLower(item.name), q) ||
 			(item.namePT != "" && strings.Contains(strings.ToLower(item.namePT), q)) {
@@ -264,6 +671,51 @@ func getCombinedItemIDs(searchQuery string) ([]int, error) {
 	return idList, nil
 }
 
+// defaultItemCategories maps internal_item_db.type to the category tab
+// it is listed under. ITEM_CATEGORY_GROUPS overrides individual entries;
+// types in neither get a tab of their own.
+var defaultItemCategories = map[string]string{
+	"Healing":    "Healing Item",
+	"Usable":     "Usable Item",
+	"Etc":        "Miscellaneous",
+	"Ammo":       "Ammunition",
+	"Card":       "Card",
+	"PetEgg":     "Monster Egg",
+	"PetArmor":   "Pet Armor",
+	"PetEquip":   "Pet Armor",
+	"Weapon":     "Weapon",
+	"Armor":      "Armor",
+	"ShadowGear": "Armor",
+	"Cash":       "Cash Shop Item",
+}
+
+// itemCategories is defaultItemCategories with ITEM_CATEGORY_GROUPS
+// applied on top.
+func itemCategories() map[string]string {
+	if appConfig == nil || len(appConfig.ItemCategoryGroups) == 0 {
+		return defaultItemCategories
+	}
+	merged := make(map[string]string, len(defaultItemCategories)+len(appConfig.ItemCategoryGroups))
+	for dbType, tab := range defaultItemCategories {
+		merged[dbType] = tab
+	}
+	for dbType, tab := range appConfig.ItemCategoryGroups {
+		merged[dbType] = tab
+	}
+	return merged
+}
+
+// itemCategory returns the tab a DB item type is listed under. The
+// lookup ignores case; unmapped types are their own tab.
+func itemCategory(dbType string) string {
+	for t, tab := range itemCategories() {
+		if strings.EqualFold(t, dbType) {
+			return tab
+		}
+	}
+	return dbType
+}
+
 func getItemTypeTabs(showAll bool) []ItemTypeTab {
 	var availabilityClause string
 	if !showAll {
@@ -308,35 +760,7 @@ func getItemTypeTabs(showAll bool) []ItemTypeTab {
 			log.Printf("[W] [HTTP] Failed to scan item type: %v", err)
 			continue
 		}
-		var mappedType string
-		switch strings.ToLower(itemType) {
-		case "healing":
-			mappedType = "Healing Item"
-		case "usable":
-			mappedType = "Usable Item"
-		case "etc":
-			mappedType = "Miscellaneous"
-		case "ammo":
-			mappedType = "Ammunition"
-		case "card":
-			mappedType = "Card"
-		case "petegg":
-			mappedType = "Monster Egg"
-		case "petarmor":
-			mappedType = "Pet Armor"
-		case "petequip":
-			mappedType = "Pet Armor"
-		case "weapon":
-			mappedType = "Weapon"
-		case "armor":
-			mappedType = "Armor"
-		case "shadowgear":
-			mappedType = "Armor" // Grouping shadow gear with armor
-		case "cash":
-			mappedType = "Cash Shop Item"
-		default:
-			mappedType = itemType // Fallback
-		}
+		mappedType := itemCategory(itemType)
 		if idx, ok := indexByFullName[mappedType]; ok {
 			itemTypes[idx].Count += rawCounts[itemType]
 		} else {
@@ -349,18 +773,20 @@ func getItemTypeTabs(showAll bool) []ItemTypeTab {
 	return itemTypes
 }
 
+// generateEventIntervals lists the events overlapping [viewStart, viewEnd].
+// Event times are in viewStart's zone (the server's), while activeDates
+// holds the display-zone dates with data, so each event is kept when its
+// start or end falls on one of them.
 func generateEventIntervals(viewStart, viewEnd time.Time, events []EventDefinition, activeDates map[string]struct{}) []map[string]interface{} {
 	var intervals []map[string]interface{}
 	loc := viewStart.Location()
 	currentDay := time.Date(viewStart.Year(), viewStart.Month(), viewStart.Day(), 0, 0, 0, 0, loc)
+	hasData := func(t time.Time) bool {
+		_, ok := activeDates[displayTime(t).Format("2006-01-02")]
+		return ok
+	}
 
 	for currentDay.Before(viewEnd) {
-		dateStr := currentDay.Format("2006-01-02")
-		if _, exists := activeDates[dateStr]; !exists {
-			currentDay = currentDay.Add(24 * time.Hour)
-			continue
-		}
-
 		for _, event := range events {
 			isEventDay := false
 			for _, dayOfWeek := range event.Days {
@@ -381,11 +807,11 @@ func generateEventIntervals(viewStart, viewEnd time.Time, events []EventDefiniti
 					continue
 				}
 
-				if eventStart.Before(viewEnd) && eventEnd.After(viewStart) {
+				if eventStart.Before(viewEnd) && eventEnd.After(viewStart) && (hasData(eventStart) || hasData(eventEnd)) {
 					intervals = append(intervals, map[string]interface{}{
 						"name":  event.Name,
-						"start": eventStart.Format("2006-01-02 15:04"),
-						"end":   eventEnd.Format("2006-01-02 15:04"),
+						"start": displayTime(eventStart).Format("2006-01-02 15:04"),
+						"end":   displayTime(eventEnd).Format("2006-01-02 15:04"),
 					})
 				}
 			}
@@ -437,6 +863,9 @@ func mapItemTypeToTabData(typeName string) ItemTypeTab {
 	case "Cash Shop Item":
 		tab.ShortName = "category_cash_shop_item"
 		tab.IconItemID = 200441
+	case "Shadow Gear":
+		tab.ShortName = "category_shadow_gear"
+		tab.IconItemID = 24012
 	default:
 		// Fallback, just use the name as-is (won't be translated)
 		tab.ShortName = typeName
@@ -473,15 +902,24 @@ func init() {
 		"character_changelog.html",
 		"guild_detail.html",
 		"store_detail.html",
+		"stores.html",
+		"woe_matchup.html",
 		"trading_post.html",
 		"woe_rankings.html",
 		"chat.html",
 		"xp_calculator.html",
 		"about.html",
+		"error.html",
+		"item_all.html",
 		"search.html",
 		"drop_stats.html",
 		"market_stats.html",
 		"character_stats.html",
+		"wealth_stats.html",
+		"rebirth_stats.html",
+		"guild_churn.html",
+		"price_spread.html",
+		"price_watch.html",
 	}
 
 	for _, tmplName := range templates {
@@ -501,7 +939,7 @@ func init() {
 	}
 
 	// Admin templates are standalone (no navbar/pagination partials), but still need head.html.
-	for _, tmplName := range []string{"admin.html", "admin_edit_post.html"} {
+	for _, tmplName := range []string{"admin.html", "admin_edit_post.html", "admin_referrers.html"} {
 		tmpl, err := template.New(tmplName).Funcs(templateFuncs).ParseFS(web.Templates, "templates/"+tmplName, "templates/head.html")
 		if err != nil {
 			log.Fatalf("[F] [HTTP] Could not parse template '%s': %v", tmplName, err)
@@ -510,19 +948,74 @@ func init() {
 	}
 
 	log.Println("[I] [HTTP] All templates parsed and cached successfully.")
+
+	warnMissingTranslations()
 }
 
+// warnMissingTranslations logs the translation keys each language lacks
+// but another defines; templates render those as blank strings.
+func warnMissingTranslations() {
+	missing := i18n.MissingKeys()
+	langs := make([]string, 0, len(missing))
+	for lang := range missing {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		keys := missing[lang]
+		log.Printf("[W] [HTTP/i18n] Language '%s' is missing %d translation key(s): %s", lang, len(keys), strings.Join(keys, ", "))
+	}
+}
+
+func homePage() string {
+	if appConfig == nil || appConfig.HomePage == "" {
+		return "/summary"
+	}
+	return appConfig.HomePage
+}
+
+// rootHandler sends a bare "/" to the configured HOME_PAGE. Requests with
+// a query string (old summary search links) and the default home page
+// are served the summary directly.
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	// "/" is the mux's catch-all; anything else that lands here is a
+	// mistyped or stale URL.
+	if r.URL.Path != "/" {
+		notFoundHandler(w, r)
+		return
+	}
+	if home := homePage(); r.URL.RawQuery == "" && home != "/summary" {
+		http.Redirect(w, r, home, http.StatusFound)
+		return
+	}
+	summaryHandler(w, r)
+}
+
+// currentPriceRangeColumns aggregates an item's rows in items (alias i)
+// into its current lowest and highest price and available listing count.
+// The summary and /stats/spread share it.
+var currentPriceRangeColumns = `
+				MIN(CASE WHEN i.is_available = 1 THEN ` + marketPriceSQL("i.price") + ` ELSE NULL END) as lowest_price,
+				MAX(CASE WHEN i.is_available = 1 THEN ` + marketPriceSQL("i.price") + ` ELSE NULL END) as highest_price,
+				SUM(CASE WHEN i.is_available = 1 THEN 1 ELSE 0 END) as listing_count`
+
 func summaryHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
 	}
 	searchQuery := r.FormValue("query")
+	searchMode := itemSearchMode(r.FormValue("search_mode"))
 	selectedType := r.FormValue("type")
+	includeHistorical := r.FormValue("include_historical") == "true"
+	asJSON := r.URL.Path == "/summary.json"
+	asAPI := r.URL.Path == "/api/summary"
 
-	// Determine if we should show all items or only available ones
+	// Determine if we should show all items or only available ones. The
+	// JSON twin only ever lists items that are on sale right now; the API
+	// reads the same parameters as the page.
 	formSubmitted := len(r.Form) > 0
-	showAll := formSubmitted && r.FormValue("only_available") != "true"
+	showAll := !asJSON && formSubmitted && r.FormValue("only_available") != "true"
 
 	var innerWhereConditions []string
 	var innerParams []interface{}
@@ -530,7 +1023,7 @@ func summaryHandler(w http.ResponseWriter, r *http.Request) {
 	var outerParams []interface{}
 
 	// 1. Item search (name/ID) filters the 'items' table (inner query)
-	if searchClause, searchParams, err := buildItemSearchClause(searchQuery, "i"); err != nil {
+	if searchClause, searchParams, err := buildItemSearchClause(searchQuery, searchMode, "i"); err != nil {
 		http.Error(w, "Failed to build item search query", http.StatusInternalServerError)
 		return
 	} else if searchClause != "" {
@@ -540,9 +1033,9 @@ func summaryHandler(w http.ResponseWriter, r *http.Request) {
 
 	// 2. Type filter checks 'internal_item_db' (outer query)
 	if selectedType != "" {
-		dbType := mapItemTypeToDBType(selectedType) // Use the new helper
-		outerWhereConditions = append(outerWhereConditions, "local_db.type = ?")
-		outerParams = append(outerParams, dbType)
+		typeClause, typeParams := itemTypeFilter("local_db.type", selectedType)
+		outerWhereConditions = append(outerWhereConditions, typeClause)
+		outerParams = append(outerParams, typeParams...)
 	}
 
 	// 3. Availability filter (outer query)
@@ -563,14 +1056,11 @@ func summaryHandler(w http.ResponseWriter, r *http.Request) {
 	// --- Main Query & Count Query ---
 	// This query structure uses a subquery (aliased 't') to get item stats
 	// and then joins with the item DB to filter by type.
-	const queryTemplate = `
+	queryTemplate := `
 		FROM (
 			SELECT
 				i.name_of_the_item,
-				MAX(i.item_id) as item_id, 
-				MIN(CASE WHEN i.is_available = 1 THEN CAST(REPLACE(i.price, ',', '') AS INTEGER) ELSE NULL END) as lowest_price,
-				MAX(CASE WHEN i.is_available = 1 THEN CAST(REPLACE(i.price, ',', '') AS INTEGER) ELSE NULL END) as highest_price,
-				SUM(CASE WHEN i.is_available = 1 THEN 1 ELSE 0 END) as listing_count
+				MAX(i.item_id) as item_id, ` + currentPriceRangeColumns + `
 			FROM items i
 			%s -- innerWhereClause
 			GROUP BY i.name_of_the_item
@@ -584,7 +1074,7 @@ func summaryHandler(w http.ResponseWriter, r *http.Request) {
 	countParams := append(innerParams, outerParams...) // Combine params
 	totalUniqueItems, err := queryCount(countQuery, countParams...)
 	if err != nil {
-		log.Printf("[E] [HTTP] Summary count query error: %v", err)
+		logRequestf(r, "[E] [HTTP] Summary count query error: %v", err)
 		// Don't return, just show 0 items
 	}
 
@@ -613,9 +1103,10 @@ func summaryHandler(w http.ResponseWriter, r *http.Request) {
 	)
 
 	mainParams := append(innerParams, outerParams...) // Combine params
+	queryStart := time.Now()
 	rows, err := srv.db.Query(selectQuery, mainParams...)
 	if err != nil {
-		log.Printf("[E] [HTTP] Summary query error: %v, Query: %s, Params: %v", err, selectQuery, mainParams)
+		logRequestf(r, "[E] [HTTP] Summary query error: %v, Query: %s, Params: %v", err, selectQuery, mainParams)
 		http.Error(w, "Database query for summary failed", http.StatusInternalServerError)
 		return
 	}
@@ -630,6 +1121,60 @@ func summaryHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		items = append(items, item)
 	}
+	logSlowQuery("summary", queryStart)
+
+	if asJSON {
+		resp := SummaryJSON{Type: selectedType, TotalItems: totalUniqueItems, Items: make([]SummaryJSONItem, 0, len(items))}
+		for _, item := range items {
+			resp.Items = append(resp.Items, SummaryJSONItem{
+				ItemID:       item.ItemID,
+				Name:         item.Name,
+				NamePT:       item.NamePT.String,
+				LowestPrice:  item.LowestPrice.Int64,
+				HighestPrice: item.HighestPrice.Int64,
+				ListingCount: item.ListingCount,
+			})
+		}
+		if err := httpx.WriteJSON(w, http.StatusOK, resp); err != nil {
+			logRequestf(r, "[W] [HTTP] Failed to write summary JSON: %v", err)
+		}
+		return
+	}
+
+	if includeHistorical && showAll {
+		if err := fillHistoricalPrices(items); err != nil {
+			logRequestf(r, "[E] [HTTP] Could not load last known prices: %v", err)
+		}
+	}
+	if err := fillRecentSales(items); err != nil {
+		logRequestf(r, "[E] [HTTP] Could not load recent sales counts: %v", err)
+	}
+
+	if asAPI {
+		resp := SummaryAPIResponse{
+			TotalUniqueItems: totalUniqueItems,
+			LastScrapeTime:   GetLastScrapeTime(),
+			SortBy:           sortBy,
+			Order:            order,
+			Items:            make([]SummaryJSONItem, 0, len(items)),
+		}
+		for _, item := range items {
+			resp.Items = append(resp.Items, SummaryJSONItem{
+				ItemID:       item.ItemID,
+				Name:         item.Name,
+				NamePT:       item.NamePT.String,
+				LowestPrice:  item.LowestPrice.Int64,
+				HighestPrice: item.HighestPrice.Int64,
+				ListingCount: item.ListingCount,
+				IsHistorical: item.IsHistorical,
+				RecentSales:  item.RecentSales,
+			})
+		}
+		if err := httpx.WriteJSON(w, http.StatusOK, resp); err != nil {
+			logRequestf(r, "[W] [HTTP] Failed to write summary API JSON: %v", err)
+		}
+		return
+	}
 
 	var totalVisitors int
 	if err := srv.db.QueryRow("SELECT COUNT(*) FROM visitors").Scan(&totalVisitors); err != nil {
@@ -643,20 +1188,119 @@ func summaryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := SummaryPageData{
-		Items:            items,
-		SearchQuery:      searchQuery,
-		SortBy:           sortBy,
-		Order:            order,
-		ShowAll:          showAll,
-		LastScrapeTime:   GetLastScrapeTime(),
-		ItemTypes:        itemTypeTabs,
-		ItemTypesTotal:   itemTypesTotal,
-		SelectedType:     selectedType,
-		TotalVisitors:    totalVisitors,
-		TotalUniqueItems: totalUniqueItems,
-		PageTitle:        "Summary",
+		Items:             items,
+		SearchQuery:       searchQuery,
+		SearchMode:        searchMode,
+		SearchModeParam:   searchModeParam(searchMode),
+		SortBy:            sortBy,
+		Order:             order,
+		ShowAll:           showAll,
+		IncludeHistorical: includeHistorical,
+		LastScrapeTime:    GetLastScrapeTime(),
+		ItemTypes:         itemTypeTabs,
+		ItemTypesTotal:    itemTypesTotal,
+		SelectedType:      selectedType,
+		TotalVisitors:     totalVisitors,
+		TotalUniqueItems:  totalUniqueItems,
+		PageTitle:         "Summary",
+	}
+	renderSortedTemplate(w, r, "index.html", data, data.SortBy)
+}
+
+// fillHistoricalPrices gives items with no current listing the lowest and
+// highest price from the last scrape they were seen in, flagged with
+// IsHistorical. Sorting is unaffected: it ran on live prices only.
+func fillHistoricalPrices(items []ItemSummary) error {
+	needed := make(map[string]int)
+	var names []string
+	for i, item := range items {
+		if item.ListingCount == 0 {
+			needed[item.Name] = i
+			names = append(names, item.Name)
+		}
+	}
+	if len(needed) == 0 {
+		return nil
+	}
+
+	// Only the displayed names: the window would otherwise partition the
+	// whole sold-out history on every page load.
+	nameClause, params := nameInClause("name_of_the_item", names)
+	rows, err := srv.db.Query(`
+		SELECT name_of_the_item, MIN(price), MAX(price) FROM (
+			SELECT name_of_the_item, date_and_time_retrieved,
+				`+marketPriceSQL("price")+` AS price,
+				MAX(date_and_time_retrieved) OVER (PARTITION BY name_of_the_item) AS last_seen
+			FROM items
+			WHERE is_available = 0 AND `+nameClause+`
+		)
+		WHERE date_and_time_retrieved = last_seen
+		GROUP BY name_of_the_item`, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var low, high int64
+		if err := rows.Scan(&name, &low, &high); err != nil {
+			return err
+		}
+		if i, ok := needed[name]; ok {
+			items[i].LowestPrice = sql.NullInt64{Int64: low, Valid: true}
+			items[i].HighestPrice = sql.NullInt64{Int64: high, Valid: true}
+			items[i].IsHistorical = true
+		}
+	}
+	return rows.Err()
+}
+
+// summarySalesWindow is how far back the summary's per-item sales count
+// reaches.
+const summarySalesWindow = 7 * 24 * time.Hour
+
+// fillRecentSales sets RecentSales on items from a single aggregate over
+// the SOLD events of their item IDs. Items without an ID keep 0.
+func fillRecentSales(items []ItemSummary) error {
+	byID := make(map[int64][]int)
+	ids := make([]int64, 0, len(items))
+	for i, item := range items {
+		if item.ItemID <= 0 {
+			continue
+		}
+		id := int64(item.ItemID)
+		if _, ok := byID[id]; !ok {
+			ids = append(ids, id)
+		}
+		byID[id] = append(byID[id], i)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	since := time.Now().Add(-summarySalesWindow).Format(time.RFC3339)
+	rows, err := srv.db.Query(`
+		SELECT item_id, COUNT(*)
+		FROM market_events
+		WHERE event_type = 'SOLD' AND event_timestamp >= ? AND item_id IN (`+joinInt64s(ids)+`)
+		GROUP BY item_id`, since)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return err
+		}
+		for _, i := range byID[id] {
+			items[i].RecentSales = count
+		}
 	}
-	renderTemplate(w, r, "index.html", data)
+	return rows.Err()
 }
 
 func fullListHandler(w http.ResponseWriter, r *http.Request) {
@@ -665,16 +1309,21 @@ func fullListHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	searchQuery := r.FormValue("query")
+	searchMode := itemSearchMode(r.FormValue("search_mode"))
 	storeNameQuery := r.FormValue("store_name")
 	selectedCols := r.Form["cols"]
 	selectedType := r.FormValue("type")
+	format := r.FormValue("format")
+	if format != "" && format != "csv" {
+		http.Error(w, "format must be csv", http.StatusBadRequest)
+		return
+	}
 
-	formSubmitted := len(r.Form) > 0
+	// The format parameter alone doesn't count as a submitted form, so
+	// ?format=csv exports what the bare page shows.
+	formSubmitted := len(r.Form) > 0 && !(len(r.Form) == 1 && format != "")
 	showAll := formSubmitted && r.FormValue("only_available") != "true"
 
-	// Fetch all store names for the dropdown
-	allStoreNames := getAllStoreNames()
-
 	// --- Column Visibility Logic ---
 	allCols := []Column{
 		{ID: "item_id", DisplayName: "Item ID"}, {ID: "quantity", DisplayName: "Quantity"},
@@ -701,7 +1350,7 @@ func fullListHandler(w http.ResponseWriter, r *http.Request) {
 	// --- Query Building Logic ---
 	allowedSorts := map[string]string{
 		"name": "i.name_of_the_item", "item_id": "i.item_id", "quantity": "i.quantity",
-		"price": "CAST(REPLACE(i.price, ',', '') AS INTEGER)", "store": "i.store_name", "seller": "i.seller_name",
+		"price": marketPriceSQL("i.price"), "store": "i.store_name", "seller": "i.seller_name",
 		"retrieved": "i.date_and_time_retrieved", "store_name": "i.store_name", "map_name": "i.map_name",
 		"availability": "i.is_available",
 	}
@@ -711,7 +1360,7 @@ func fullListHandler(w http.ResponseWriter, r *http.Request) {
 	var queryParams []interface{}
 
 	// Add item search
-	if searchClause, searchParams, err := buildItemSearchClause(searchQuery, "i"); err != nil {
+	if searchClause, searchParams, err := buildItemSearchClause(searchQuery, searchMode, "i"); err != nil {
 		http.Error(w, "Failed to build item search query", http.StatusInternalServerError)
 		return
 	} else if searchClause != "" {
@@ -727,9 +1376,9 @@ func fullListHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Add item type filter
 	if selectedType != "" {
-		dbType := mapItemTypeToDBType(selectedType) // Use the new helper
-		whereConditions = append(whereConditions, "local_db.type = ?")
-		queryParams = append(queryParams, dbType)
+		typeClause, typeParams := itemTypeFilter("local_db.type", selectedType)
+		whereConditions = append(whereConditions, typeClause)
+		queryParams = append(queryParams, typeParams...)
 	}
 
 	// Add availability filter
@@ -755,12 +1404,22 @@ func fullListHandler(w http.ResponseWriter, r *http.Request) {
 
 	rows, err := srv.db.Query(query, queryParams...)
 	if err != nil {
-		log.Printf("[E] [HTTP] Database query error: %v", err)
+		logRequestf(r, "[E] [HTTP] Database query error: %v", err)
 		http.Error(w, "Database query failed", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
+	if format == "csv" {
+		if err := streamFullListCSV(w, rows); err != nil {
+			logRequestf(r, "[W] [HTTP] Failed to write full list CSV: %v", err)
+		}
+		return
+	}
+
+	// Fetch all store names for the dropdown
+	allStoreNames := getAllStoreNames()
+
 	var items []Item
 	for rows.Next() {
 		var item Item
@@ -772,7 +1431,7 @@ func fullListHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		// Format timestamp nicely
 		if parsedTime, err := time.Parse(time.RFC3339, retrievedTime); err == nil {
-			item.Timestamp = parsedTime.Format("2006-01-02 15:04")
+			item.Timestamp = displayTime(parsedTime).Format("2006-01-02 15:04")
 		} else {
 			item.Timestamp = retrievedTime
 		}
@@ -786,23 +1445,66 @@ func fullListHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := PageData{
-		Items:          items,
-		SearchQuery:    searchQuery,
-		StoreNameQuery: storeNameQuery,
-		AllStoreNames:  allStoreNames,
-		SortBy:         sortBy,
-		Order:          order,
-		ShowAll:        showAll,
-		LastScrapeTime: GetLastScrapeTime(),
-		VisibleColumns: visibleColumns,
-		AllColumns:     allCols,
-		ColumnParams:   template.URL(columnParams.Encode()),
-		ItemTypes:      itemTypeTabs,
-		ItemTypesTotal: itemTypesTotal,
-		SelectedType:   selectedType,
-		PageTitle:      "Full List",
+		Items:           items,
+		SearchQuery:     searchQuery,
+		SearchMode:      searchMode,
+		SearchModeParam: searchModeParam(searchMode),
+		StoreNameQuery:  storeNameQuery,
+		AllStoreNames:   allStoreNames,
+		SortBy:          sortBy,
+		Order:           order,
+		ShowAll:         showAll,
+		LastScrapeTime:  GetLastScrapeTime(),
+		VisibleColumns:  visibleColumns,
+		AllColumns:      allCols,
+		ColumnParams:    template.URL(columnParams.Encode()),
+		ItemTypes:       itemTypeTabs,
+		ItemTypesTotal:  itemTypesTotal,
+		SelectedType:    selectedType,
+		PageTitle:       "Full List",
+	}
+	renderSortedTemplate(w, r, "full_list.html", data, data.SortBy)
+}
+
+// streamFullListCSV writes the full list query's rows as full-list.csv
+// while reading them, so large exports are never held in memory. Prices
+// are written as integers and timestamps as stored (RFC3339).
+func streamFullListCSV(w http.ResponseWriter, rows *sql.Rows) error {
+	cw, err := httpx.StartCSV(w, "full-list.csv", []string{
+		"item_id", "name_of_the_item", "name_pt", "quantity", "price", "store_name",
+		"seller_name", "map_name", "map_coordinates", "is_available", "date_and_time_retrieved",
+	})
+	if err != nil {
+		return err
 	}
-	renderTemplate(w, r, "full_list.html", data)
+	for rows.Next() {
+		var item Item
+		var retrievedTime string
+		if err := rows.Scan(&item.ID, &item.Name, &item.NamePT, &item.ItemID, &item.Quantity, &item.Price, &item.StoreName, &item.SellerName, &retrievedTime, &item.MapName, &item.MapCoordinates, &item.IsAvailable); err != nil {
+			log.Printf("[W] [HTTP] Failed to scan full list CSV row: %v", err)
+			continue
+		}
+		if err := cw.Write([]string{
+			strconv.Itoa(item.ItemID),
+			item.Name,
+			item.NamePT.String,
+			strconv.Itoa(item.Quantity),
+			strconv.FormatInt(parseMarketPrice(item.Price), 10),
+			item.StoreName,
+			item.SellerName,
+			item.MapName,
+			item.MapCoordinates,
+			strconv.FormatBool(item.IsAvailable),
+			retrievedTime,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return rows.Err()
 }
 
 // getAllStoreNames is a small helper to abstract the store name query
@@ -826,56 +1528,231 @@ func getAllStoreNames() []string {
 	return allStoreNames
 }
 
-func activityHandler(w http.ResponseWriter, r *http.Request) {
+// storesHandler serves /stores, a paginated directory of every store seen
+// in the items table, one row per (store_name, seller_name).
+func storesHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
 	}
-	searchQuery := r.FormValue("query")
-	soldOnly := r.FormValue("sold_only") == "true"
-	const eventsPerPage = 50
+	searchQuery := strings.TrimSpace(r.FormValue("query"))
+	const storesPerPage = 50
 
-	var whereConditions []string
+	var whereClause string
 	var params []interface{}
-
-	// Add item search
-	if searchClause, searchParams, err := buildItemSearchClause(searchQuery, "me"); err != nil {
-		http.Error(w, "Failed to build item search query", http.StatusInternalServerError)
-		return
-	} else if searchClause != "" {
-		whereConditions = append(whereConditions, searchClause)
-		params = append(params, searchParams...)
-	}
-
-	// Add "sold only" filter
-	if soldOnly {
-		whereConditions = append(whereConditions, "event_type = ?")
-		params = append(params, "SOLD")
+	if searchQuery != "" {
+		whereClause = "WHERE store_name LIKE ? OR seller_name LIKE ?"
+		like := "%" + searchQuery + "%"
+		params = append(params, like, like)
 	}
 
-	whereClause := ""
-	if len(whereConditions) > 0 {
-		whereClause = "WHERE " + strings.Join(whereConditions, " AND ")
+	allowedSorts := map[string]string{
+		"store": "store_name", "seller": "seller_name",
+		"items": "item_count", "last_seen": "last_seen",
 	}
+	orderByClause, sortBy, order := httpx.GetSortClause(r, allowedSorts, "last_seen", "DESC")
 
-	// Base query joins with item DB to get Portuguese names
-	const baseQuery = `
-		FROM market_events me
-		LEFT JOIN internal_item_db local_db ON me.item_id = local_db.item_id
-	`
-
-	// 1. Get total count
-	totalEvents, err := queryCount(fmt.Sprintf("SELECT COUNT(*) %s %s", baseQuery, whereClause), params...)
+	totalStores, err := queryCount(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 FROM items %s GROUP BY store_name, seller_name)", whereClause), params...)
 	if err != nil {
-		log.Printf("[E] [HTTP] Could not count market events: %v", err)
-		http.Error(w, "Could not count market events", http.StatusInternalServerError)
+		logRequestf(r, "[E] [HTTP/Stores] Could not count stores: %v", err)
+		http.Error(w, "Could not count stores", http.StatusInternalServerError)
 		return
 	}
+	pagination := httpx.NewPaginationData(r, totalStores, storesPerPage)
 
-	// 2. Create pagination *after* getting the total
-	pagination := httpx.NewPaginationData(r, totalEvents, eventsPerPage)
-
-	// 3. Get paginated data
+	filterValues := url.Values{}
+	if searchQuery != "" {
+		filterValues.Set("query", searchQuery)
+	}
+	filterValues.Set("sort_by", sortBy)
+	filterValues.Set("order", order)
+	filterString := "&" + filterValues.Encode()
+
+	// SQLite takes bare columns (map_name, map_coordinates) from the row
+	// that supplied MAX(), so the map shown is where the store was last seen.
+	query := fmt.Sprintf(`
+		SELECT store_name, seller_name, map_name, map_coordinates,
+			SUM(is_available) AS item_count,
+			MAX(date_and_time_retrieved) AS last_seen
+		FROM items %s
+		GROUP BY store_name, seller_name
+		%s, store_name ASC
+		LIMIT ? OFFSET ?`, whereClause, orderByClause)
+
+	rows, err := srv.db.Query(query, append(params, pagination.ItemsPerPage, pagination.Offset)...)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/Stores] Could not query stores: %v", err)
+		http.Error(w, "Could not query stores", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var stores []StoreSummary
+	for rows.Next() {
+		var s StoreSummary
+		var lastSeen string
+		if err := rows.Scan(&s.StoreName, &s.SellerName, &s.MapName, &s.MapCoordinates, &s.ItemCount, &lastSeen); err != nil {
+			log.Printf("[W] [HTTP/Stores] Failed to scan store row: %v", err)
+			continue
+		}
+		if t, pErr := time.Parse(time.RFC3339, lastSeen); pErr == nil {
+			s.LastSeen = displayTime(t).Format("2006-01-02 15:04")
+		} else {
+			s.LastSeen = lastSeen
+		}
+		stores = append(stores, s)
+	}
+
+	data := StoresPageData{
+		Stores:         stores,
+		LastScrapeTime: GetLastScrapeTime(),
+		SearchQuery:    searchQuery,
+		SortBy:         sortBy,
+		Order:          order,
+		Pagination:     pagination,
+		TotalStores:    totalStores,
+		PageTitle:      "Stores",
+		Filter:         template.URL(filterString),
+	}
+	renderSortedTemplate(w, r, "stores.html", data, data.SortBy)
+}
+
+// activityEventTypes are the market_events types the activity page can
+// filter on, in the order its filter lists them.
+var activityEventTypes = []ActivityEventTypeOption{
+	{Type: "ADDED", LabelKey: "activity_added"},
+	{Type: "SOLD", LabelKey: "activity_sold"},
+	{Type: "REMOVED", LabelKey: "activity_removed"},
+	{Type: "REMOVED_SINGLE", LabelKey: "activity_vendor_left"},
+	{Type: "NEW_LOW", LabelKey: "activity_new_low"},
+}
+
+// activityEventTypeFilter returns the event types selected by the
+// repeatable event_type parameter, in activityEventTypes order and
+// without duplicates. sold_only=true, the page's older filter, still
+// selects SOLD. None selected means every type.
+func activityEventTypeFilter(r *http.Request) ([]string, error) {
+	selected := make(map[string]bool)
+	for _, t := range r.Form["event_type"] {
+		t = strings.ToUpper(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		selected[t] = true
+	}
+	for t := range selected {
+		if !slices.ContainsFunc(activityEventTypes, func(et ActivityEventTypeOption) bool { return et.Type == t }) {
+			return nil, fmt.Errorf("unknown event_type %q", t)
+		}
+	}
+	if r.FormValue("sold_only") == "true" {
+		selected["SOLD"] = true
+	}
+	var types []string
+	for _, et := range activityEventTypes {
+		if selected[et.Type] {
+			types = append(types, et.Type)
+		}
+	}
+	return types, nil
+}
+
+// activityEventTypeOptions lists every filterable event type for the
+// page's checkboxes, marking the selected ones.
+func activityEventTypeOptions(selected []string) []ActivityEventTypeOption {
+	options := slices.Clone(activityEventTypes)
+	for i := range options {
+		options[i].Selected = slices.Contains(selected, options[i].Type)
+	}
+	return options
+}
+
+func activityHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	searchQuery := r.FormValue("query")
+	searchMode := itemSearchMode(r.FormValue("search_mode"))
+	eventTypes, err := activityEventTypeFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groupByItem := r.FormValue("group") == "item"
+	const eventsPerPage = 50
+
+	var whereConditions []string
+	var params []interface{}
+
+	// Add item search
+	if searchClause, searchParams, err := buildItemSearchClause(searchQuery, searchMode, "me"); err != nil {
+		http.Error(w, "Failed to build item search query", http.StatusInternalServerError)
+		return
+	} else if searchClause != "" {
+		whereConditions = append(whereConditions, searchClause)
+		params = append(params, searchParams...)
+	}
+
+	if len(eventTypes) > 0 {
+		whereConditions = append(whereConditions, "me.event_type IN (?"+strings.Repeat(", ?", len(eventTypes)-1)+")")
+		for _, t := range eventTypes {
+			params = append(params, t)
+		}
+	}
+
+	whereClause := ""
+	if len(whereConditions) > 0 {
+		whereClause = "WHERE " + strings.Join(whereConditions, " AND ")
+	}
+
+	// Base query joins with item DB to get Portuguese names
+	const baseQuery = `
+		FROM market_events me
+		LEFT JOIN internal_item_db local_db ON me.item_id = local_db.item_id
+	`
+
+	if groupByItem {
+		// The grouped summary is bounded by the market stats interval.
+		selectedInterval, startTime := getMarketStatsInterval(r)
+		whereConditions = append(whereConditions, "me.event_timestamp >= ?")
+		params = append(params, startTime)
+		whereClause = "WHERE " + strings.Join(whereConditions, " AND ")
+
+		groups, pagination, err := fetchActivityItemGroups(r, baseQuery, whereClause, params, eventsPerPage)
+		if err != nil {
+			logRequestf(r, "[E] [HTTP/Activity] %v", err)
+			http.Error(w, "Could not query for market activity", http.StatusInternalServerError)
+			return
+		}
+
+		data := ActivityPageData{
+			ItemGroups:       groups,
+			LastScrapeTime:   GetLastScrapeTime(),
+			SearchQuery:      searchQuery,
+			EventTypes:       eventTypes,
+			EventTypeOptions: activityEventTypeOptions(eventTypes),
+			GroupByItem:      true,
+			SelectedInterval: selectedInterval,
+			Pagination:       pagination,
+			PageTitle:        "Activity",
+		}
+		renderTemplate(w, r, "activity.html", data)
+		return
+	}
+
+	// 1. Get total count
+	totalEvents, err := queryCount(fmt.Sprintf("SELECT COUNT(*) %s %s", baseQuery, whereClause), params...)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP] Could not count market events: %v", err)
+		http.Error(w, "Could not count market events", http.StatusInternalServerError)
+		return
+	}
+
+	// 2. Create pagination *after* getting the total
+	pagination := httpx.NewPaginationData(r, totalEvents, eventsPerPage)
+
+	// 3. Get paginated data
 	query := fmt.Sprintf(`
         SELECT me.event_timestamp, me.event_type, me.item_name, local_db.name_pt, me.item_id, me.details
         %s %s
@@ -886,7 +1763,7 @@ func activityHandler(w http.ResponseWriter, r *http.Request) {
 
 	eventRows, err := srv.db.Query(query, queryArgs...)
 	if err != nil {
-		log.Printf("[E] [HTTP] Could not query for market events: %v", err)
+		logRequestf(r, "[E] [HTTP] Could not query for market events: %v", err)
 		http.Error(w, "Could not query for market events", http.StatusInternalServerError)
 		return
 	}
@@ -902,7 +1779,7 @@ func activityHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		// Format timestamp
 		if parsedTime, err := time.Parse(time.RFC3339, timestampStr); err == nil {
-			event.Timestamp = parsedTime.Format("2006-01-02 15:04")
+			event.Timestamp = displayTime(parsedTime).Format("2006-01-02 15:04")
 		} else {
 			event.Timestamp = timestampStr
 		}
@@ -914,16 +1791,56 @@ func activityHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := ActivityPageData{
-		MarketEvents:   marketEvents,
-		LastScrapeTime: GetLastScrapeTime(),
-		SearchQuery:    searchQuery,
-		SoldOnly:       soldOnly,
-		Pagination:     pagination,
-		PageTitle:      "Activity",
+		MarketEvents:     marketEvents,
+		LastScrapeTime:   GetLastScrapeTime(),
+		SearchQuery:      searchQuery,
+		EventTypes:       eventTypes,
+		EventTypeOptions: activityEventTypeOptions(eventTypes),
+		Pagination:       pagination,
+		PageTitle:        "Activity",
 	}
 	renderTemplate(w, r, "activity.html", data)
 }
 
+// fetchActivityItemGroups aggregates market events per item for the
+// activity page's ?group=item mode, busiest items first. whereClause and
+// params are the activity filters, already including the time window.
+func fetchActivityItemGroups(r *http.Request, baseQuery, whereClause string, params []interface{}, perPage int) ([]MarketActivityGroup, httpx.PaginationData, error) {
+	totalGroups, err := queryCount(fmt.Sprintf("SELECT COUNT(DISTINCT me.item_name) %s %s", baseQuery, whereClause), params...)
+	if err != nil {
+		return nil, httpx.PaginationData{}, fmt.Errorf("could not count activity groups: %w", err)
+	}
+	pagination := httpx.NewPaginationData(r, totalGroups, perPage)
+
+	query := fmt.Sprintf(`
+		SELECT me.item_name, MAX(local_db.name_pt), COALESCE(MAX(me.item_id), 0),
+			SUM(CASE WHEN me.event_type = 'ADDED' THEN 1 ELSE 0 END) AS added,
+			SUM(CASE WHEN me.event_type = 'SOLD' THEN 1 ELSE 0 END) AS sold,
+			SUM(CASE WHEN me.event_type IN ('REMOVED', 'REMOVED_SINGLE') THEN 1 ELSE 0 END) AS delisted
+		%s %s
+		GROUP BY me.item_name
+		ORDER BY (added + sold + delisted) DESC, me.item_name ASC
+		LIMIT ? OFFSET ?`, baseQuery, whereClause)
+
+	rows, err := srv.db.Query(query, append(params, perPage, pagination.Offset)...)
+	if err != nil {
+		return nil, pagination, fmt.Errorf("could not query activity groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []MarketActivityGroup
+	for rows.Next() {
+		var g MarketActivityGroup
+		if err := rows.Scan(&g.ItemName, &g.NamePT, &g.ItemID, &g.Added, &g.Sold, &g.Delisted); err != nil {
+			log.Printf("[W] [HTTP/Activity] Failed to scan activity group row: %v", err)
+			continue
+		}
+		g.Total = g.Added + g.Sold + g.Delisted
+		groups = append(groups, g)
+	}
+	return groups, pagination, nil
+}
+
 // fetchCurrentListingExtremes retrieves both the lowest and highest available listings in a single query.
 // This replaces fetchCurrentLowestListing and fetchCurrentHighestListing.
 func fetchCurrentListingExtremes(itemName string) (*ItemListing, *ItemListing, error) {
@@ -932,7 +1849,7 @@ func fetchCurrentListingExtremes(itemName string) (*ItemListing, *ItemListing, e
 	query := `
 		SELECT * FROM (
 			SELECT 
-				CAST(REPLACE(REPLACE(price, ',', ''), 'z', '') AS INTEGER) as price_int,
+				` + marketPriceSQL("price") + ` as price_int,
 				quantity, store_name, seller_name, map_name, map_coordinates, date_and_time_retrieved,
 				'min' as type
 			FROM items 
@@ -942,7 +1859,7 @@ func fetchCurrentListingExtremes(itemName string) (*ItemListing, *ItemListing, e
 		UNION ALL
 		SELECT * FROM (
 			SELECT 
-				CAST(REPLACE(REPLACE(price, ',', ''), 'z', '') AS INTEGER) as price_int,
+				` + marketPriceSQL("price") + ` as price_int,
 				quantity, store_name, seller_name, map_name, map_coordinates, date_and_time_retrieved,
 				'max' as type
 			FROM items 
@@ -973,7 +1890,7 @@ func fetchCurrentListingExtremes(itemName string) (*ItemListing, *ItemListing, e
 
 		// Format timestamp
 		if parsedTime, pErr := time.Parse(time.RFC3339, timestampStr); pErr == nil {
-			l.Timestamp = parsedTime.Format("2006-01-02 15:04")
+			l.Timestamp = displayTime(parsedTime).Format("2006-01-02 15:04")
 		} else {
 			l.Timestamp = timestampStr
 		}
@@ -1003,19 +1920,43 @@ func itemHistoryHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Item name is required", http.StatusBadRequest)
 		return
 	}
+	showNet := r.FormValue("net") == "true"
+	granularity := priceHistoryGranularity(r.FormValue("granularity"))
+	var cursor *listingCursor
+	if token := r.FormValue("cursor"); token != "" {
+		c, err := decodeListingCursor(token)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		cursor = &c
+	}
 	log.Printf("[D] [HTTP/History] Handling request for item: '%s'", itemName)
 
 	// Step 1: Get Item ID (Sequential, as itemID is needed for some lookups)
 	itemID, itemNamePT := getItemIDAndNamePT(itemName)
+	if itemID == 0 {
+		// Never listed on the market (e.g. only offered on Discord): fall
+		// back to the local item DB so the details and offers still show.
+		itemID = int(resolveItemID(itemName))
+	}
 	log.Printf("[D] [HTTP/History] Step 1: Found ItemID: %d, NamePT: '%s'", itemID, itemNamePT.String)
 
+	// Historical rows may still carry a name the server has since renamed.
+	itemNames := itemNameAliases(itemName)
+
 	// --- Concurrent Data Fetching ---
 	var g errgroup.Group
 	var rmsItemDetails *RMSItem
 	var finalPriceHistory []PricePointDetails
 	var overallLowest, overallHighest sql.NullInt64
 	var dropHistory []PlayerDropInfo
+	var dropHistoryTruncated bool
 	var totalListings int
+	var fairPrice int64
+	var fairPriceSales int
+
+	var discordLowest *FlatTradingPostItem
 
 	// Variables for the optimized combined query
 	var currentLowest *ItemListing
@@ -1034,7 +1975,7 @@ func itemHistoryHandler(w http.ResponseWriter, r *http.Request) {
 		var err error
 		currentLowest, currentHighest, err = fetchCurrentListingExtremes(itemName)
 		if err != nil {
-			log.Printf("[E] [HTTP/History] Step 3: %v", err)
+			logRequestf(r, "[E] [HTTP/History] Step 3: %v", err)
 			return err // This is a critical query
 		}
 		if currentLowest != nil || currentHighest != nil {
@@ -1046,9 +1987,9 @@ func itemHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	// Task 4: Get price history for the graph
 	g.Go(func() error {
 		var err error
-		finalPriceHistory, err = fetchPriceHistory(itemName)
+		finalPriceHistory, err = fetchPriceHistory(itemNames)
 		if err != nil {
-			log.Printf("[E] [HTTP/History] Step 4: %v", err)
+			logRequestf(r, "[E] [HTTP/History] Step 4: %v", err)
 			return err // This is a critical query
 		}
 		log.Printf("[D] [HTTP/History] Step 4: Found %d unique price points for history graph.", len(finalPriceHistory))
@@ -1057,7 +1998,7 @@ func itemHistoryHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Task 5: Get all-time min/max prices
 	g.Go(func() error {
-		overallLowest, overallHighest = getOverallPriceRange(itemName)
+		overallLowest, overallHighest = getOverallPriceRange(itemNames)
 		log.Printf("[D] [HTTP/History] Step 5: Found Overall Lowest: %d z, Overall Highest: %d z", overallLowest.Int64, overallHighest.Int64)
 		return nil // Not critical
 	})
@@ -1065,20 +2006,42 @@ func itemHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	// Task 5b: Get item drop history
 	g.Go(func() error {
 		var err error
-		dropHistory, err = fetchItemDropHistory(itemName)
+		dropHistory, dropHistoryTruncated, err = fetchItemDropHistory(itemName)
 		if err != nil {
-			log.Printf("[E] [HTTP/History] Step 5b: %v", err)
+			logRequestf(r, "[E] [HTTP/History] Step 5b: %v", err)
 		}
 		log.Printf("[D] [HTTP/History] Step 5b: Found %d drop records for this item.", len(dropHistory))
 		return nil // Not critical
 	})
 
+	// Task 5c: Get the fair price (median of recent sales)
+	g.Go(func() error {
+		var err error
+		fairPrice, fairPriceSales, err = fetchFairPrice(itemNames)
+		if err != nil {
+			logRequestf(r, "[E] [HTTP/History] Step 5c: %v", err)
+		}
+		log.Printf("[D] [HTTP/History] Step 5c: Fair price %d z from %d recent sales.", fairPrice, fairPriceSales)
+		return nil // Not critical
+	})
+
+	// Task 5d: Get the cheapest Discord zeny offer to compare with the market
+	g.Go(func() error {
+		var err error
+		discordLowest, err = fetchCheapestDiscordOffer(itemID)
+		if err != nil {
+			logRequestf(r, "[E] [HTTP/History] Step 5d: %v", err)
+		}
+		log.Printf("[D] [HTTP/History] Step 5d: Found Discord offer: %t.", discordLowest != nil)
+		return nil // Not critical
+	})
+
 	// Task 6: Get total listings count for pagination
 	g.Go(func() error {
 		var err error
-		totalListings, err = countAllListings(itemName)
+		totalListings, err = countAllListings(itemNames)
 		if err != nil {
-			log.Printf("[E] [HTTP/History] Step 6a: %v", err)
+			logRequestf(r, "[E] [HTTP/History] Step 6a: %v", err)
 			return err // This is a critical query
 		}
 		log.Printf("[D] [HTTP/History] Step 6a: Found %d total historical listings.", totalListings)
@@ -1095,9 +2058,16 @@ func itemHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	// Step 7: Create pagination and fetch the current page of listings
 	const listingsPerPage = 50
 	pagination := httpx.NewPaginationData(r, totalListings, listingsPerPage)
-	allListings, err := fetchAllListings(itemName, pagination) // This is the last query
+	var allListings []Item
+	var nextCursor string
+	var err error
+	if cursor != nil {
+		allListings, nextCursor, err = fetchListingsAfter(itemNames, cursor, listingsPerPage)
+	} else {
+		allListings, nextCursor, err = fetchAllListings(itemNames, pagination) // This is the last query
+	}
 	if err != nil {
-		log.Printf("[E] [HTTP/History] Step 6b: %v", err)
+		logRequestf(r, "[E] [HTTP/History] Step 6b: %v", err)
 		http.Error(w, "Database query for all listings failed", http.StatusInternalServerError)
 		return
 	}
@@ -1106,7 +2076,15 @@ func itemHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	// Step 8: Prepare data for template
 	currentLowestJSON, _ := json.Marshal(currentLowest)
 	currentHighestJSON, _ := json.Marshal(currentHighest)
-	priceHistoryJSON, _ := json.Marshal(finalPriceHistory)
+	priceHistoryJSON, _ := json.Marshal(aggregatePriceHistory(finalPriceHistory, granularity))
+
+	filter := "&name=" + url.QueryEscape(itemName)
+	if showNet {
+		filter += "&net=true"
+	}
+	if granularity != "" {
+		filter += "&granularity=" + granularity
+	}
 
 	data := HistoryPageData{
 		ItemName:           itemName,
@@ -1123,9 +2101,19 @@ func itemHistoryHandler(w http.ResponseWriter, r *http.Request) {
 		LastScrapeTime:     GetLastScrapeTime(),
 		TotalListings:      totalListings,
 		Pagination:         pagination,
+		CursorPaging:       cursor != nil,
+		NextCursor:         nextCursor,
 		PageTitle:          itemName,
-		Filter:             template.URL("&name=" + url.QueryEscape(itemName)),
+		Filter:             template.URL(filter),
 		DropHistory:        dropHistory,
+		DropsTruncated:     dropHistoryTruncated,
+		Net:                showNet,
+		FairPrice:          fairPrice,
+		FairPriceSales:     fairPriceSales,
+		FairPriceDays:      fairPriceWindowDays(),
+		Granularity:        granularity,
+		PriceWars:          detectPriceWars(finalPriceHistory),
+		MarketVsDiscord:    compareMarketDiscord(currentLowest, discordLowest),
 	}
 
 	log.Printf("[D] [HTTP/History] Rendering template for '%s' with all data.", itemName)
@@ -1140,7 +2128,7 @@ func playerCountHandler(w http.ResponseWriter, r *http.Request) {
 	// 2. Fetch and process history data
 	playerHistory, activeDates, err := fetchPlayerHistory(interval)
 	if err != nil {
-		log.Printf("[E] [HTTP/Player] %v", err)
+		logRequestf(r, "[E] [HTTP/Player] %v", err)
 		http.Error(w, "Could not query for player history", http.StatusInternalServerError)
 		return
 	}
@@ -1189,6 +2177,16 @@ func characterHandler(w http.ResponseWriter, r *http.Request) {
 	selectedCols := r.Form["cols"]
 	graphFilter := r.Form["graph_filter"]
 
+	switch format := strings.ToLower(r.FormValue("format")); format {
+	case "":
+	case "csv", "json":
+		characterExportHandler(w, r, format, searchName, selectedClass, selectedGuild)
+		return
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
 	isInitialLoad := len(r.Form) == 0
 	const playersPerPage = 50
 
@@ -1205,18 +2203,15 @@ func characterHandler(w http.ResponseWriter, r *http.Request) {
 		{ID: "rank", DisplayName: "Rank"}, {ID: "base_level", DisplayName: "Base Lvl"}, {ID: "job_level", DisplayName: "Job Lvl"},
 		{ID: "experience", DisplayName: "Exp %"}, {ID: "zeny", DisplayName: "Zeny"}, {ID: "class", DisplayName: "Class"},
 		{ID: "guild", DisplayName: "Guild"}, {ID: "last_updated", DisplayName: "Last Updated"}, {ID: "last_active", DisplayName: "Last Active"},
+		{ID: "velocity", DisplayName: "Exp %/Day"},
 	}
-	visibleColumns := make(map[string]bool)
 	if isInitialLoad {
-		// Set default visible columns
-		visibleColumns["base_level"], visibleColumns["job_level"], visibleColumns["experience"] = true, true, true
-		visibleColumns["class"], visibleColumns["guild"], visibleColumns["last_active"] = true, true, true
-		selectedCols = []string{"base_level", "job_level", "experience", "class", "guild", "last_active"} // Set defaults for filter
-		graphFilter = []string{"second"}                                                                  // Set default graph filter
-	} else {
-		for _, col := range selectedCols {
-			visibleColumns[col] = true
-		}
+		// First visit: CHARACTER_COLUMNS and CHARACTER_GRAPH_FILTER
+		selectedCols, graphFilter = characterDefaultView()
+	}
+	visibleColumns := make(map[string]bool)
+	for _, col := range selectedCols {
+		visibleColumns[col] = true
 	}
 
 	// 3. Get data for filters and graphs
@@ -1229,16 +2224,12 @@ func characterHandler(w http.ResponseWriter, r *http.Request) {
 
 	// 5. Get pagination and sort order
 	pagination := httpx.NewPaginationData(r, totalPlayers, playersPerPage)
-	allowedSorts := map[string]string{
-		"rank": "rank", "name": "name", "base_level": "base_level", "job_level": "job_level", "experience": "experience",
-		"zeny": "zeny", "class": "class", "guild": "guild_name", "last_updated": "last_updated", "last_active": "last_active",
-	}
-	orderByClause, sortBy, order := httpx.GetSortClause(r, allowedSorts, "rank", "ASC")
+	orderByClause, sortBy, order := httpx.GetSortClause(r, characterSorts, "rank", "ASC")
 
 	// 6. Fetch the paginated character data
 	players, err := fetchCharacters(whereClause, params, orderByClause, pagination, guildMasters, specialPlayers)
 	if err != nil {
-		log.Printf("[E] [HTTP/Char] %v", err)
+		logRequestf(r, "[E] [HTTP/Char] %v", err)
 		http.Error(w, "Could not query for player characters", http.StatusInternalServerError)
 		return
 	}
@@ -1262,12 +2253,13 @@ func characterHandler(w http.ResponseWriter, r *http.Request) {
 		Pagination:            pagination,
 		TotalPlayers:          totalPlayers,
 		TotalZeny:             totalZeny,
+		IncludesBank:          appConfig != nil && appConfig.IncludeBankZeny,
 		ClassDistributionJSON: classDistJSON,
 		GraphFilter:           graphFilterMap,
 		HasChartData:          hasChartData,
 		PageTitle:             "Characters",
 	}
-	renderTemplate(w, r, "characters.html", data)
+	renderSortedTemplate(w, r, "characters.html", data, data.SortBy)
 }
 
 func guildHandler(w http.ResponseWriter, r *http.Request) {
@@ -1327,19 +2319,15 @@ func guildHandler(w http.ResponseWriter, r *http.Request) {
 			COALESCE(cs.total_zeny, 0) as total_zeny,
 			COALESCE(cs.avg_base_level, 0) as avg_base_level
 		FROM guilds g
-		LEFT JOIN (
-			SELECT guild_name, COUNT(*) as member_count, SUM(zeny) as total_zeny, AVG(base_level) as avg_base_level
-			FROM characters
-			WHERE guild_name IS NOT NULL AND guild_name != ''
-			GROUP BY guild_name
-		) cs ON g.name = cs.guild_name
-		%s %s LIMIT ? OFFSET ?`, whereClause, orderByClause)
+		LEFT JOIN (%s) cs ON g.name = cs.guild_name
+		%s %s LIMIT ? OFFSET ?`, guildMemberStatsSQL(""), whereClause, orderByClause)
 
 	finalParams := append(params, pagination.ItemsPerPage, pagination.Offset)
 
+	queryStart := time.Now()
 	rows, err := srv.db.Query(query, finalParams...)
 	if err != nil {
-		log.Printf("[E] [HTTP/Guild] Could not query for guilds: %v", err)
+		logRequestf(r, "[E] [HTTP/Guild] Could not query for guilds: %v", err)
 		http.Error(w, "Could not query for guilds", http.StatusInternalServerError)
 		return
 	}
@@ -1354,6 +2342,7 @@ func guildHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		guilds = append(guilds, g)
 	}
+	logSlowQuery("guilds", queryStart)
 
 	// 7. Render Template
 	data := GuildPageData{
@@ -1367,10 +2356,32 @@ func guildHandler(w http.ResponseWriter, r *http.Request) {
 		PageTitle:           "Guilds",
 		Filter:              template.URL(filterString),
 	}
-	renderTemplate(w, r, "guilds.html", data)
+	renderSortedTemplate(w, r, "guilds.html", data, data.SortBy)
+}
+
+// totalZenySQL is the per-character zeny expression summed by every
+// total-zeny aggregate. Bank zeny only counts with INCLUDE_BANK_ZENY.
+func totalZenySQL() string {
+	if appConfig != nil && appConfig.IncludeBankZeny {
+		return "(zeny + bank_zeny)"
+	}
+	return "zeny"
+}
+
+// guildMemberStatsSQL aggregates member count, zeny and average level per
+// guild from the characters table. memberCondition is an optional extra
+// "AND ..." condition on the character rows.
+func guildMemberStatsSQL(memberCondition string) string {
+	return fmt.Sprintf(`
+			SELECT guild_name, COUNT(*) as member_count, SUM(%s) as total_zeny, AVG(base_level) as avg_base_level
+			FROM characters
+			WHERE guild_name IS NOT NULL AND guild_name != ''%s
+			GROUP BY guild_name`, totalZenySQL(), memberCondition)
 }
 
 func mvpKillsHandler(w http.ResponseWriter, r *http.Request) {
+	nonzeroOnly := r.URL.Query().Get("nonzero_only") == "true"
+
 	// 1. Build table headers
 	headers := []MvpHeader{{MobID: "total", MobName: "Total Kills"}}
 	for _, mobID := range mvpMobIDs {
@@ -1383,18 +2394,22 @@ func mvpKillsHandler(w http.ResponseWriter, r *http.Request) {
 	for _, mobID := range mvpMobIDs {
 		colName := fmt.Sprintf("mvp_%s", mobID)
 		allowedSorts[mobID] = colName
-		sumParts = append(sumParts, colName)
+		sumParts = append(sumParts, fmt.Sprintf("MAX(%s - %d, 0)", colName, mvpKillCountOffset(mobID)))
 	}
-	// Add a "total" sort option that sums all kill columns
+	// Add a "total" sort option that sums the displayed kill counts
 	allowedSorts["total"] = fmt.Sprintf("(%s)", strings.Join(sumParts, " + "))
 
 	orderByClause, sortBy, order := httpx.GetSortClause(r, allowedSorts, "total", "DESC")
 
 	// 3. Fetch data
-	query := fmt.Sprintf("SELECT * FROM character_mvp_kills %s", orderByClause)
-	rows, err := srv.db.Query(query)
+	where, params := excludedNamesCondition("character_name")
+	if where != "" {
+		where = "WHERE " + where
+	}
+	query := fmt.Sprintf("SELECT * FROM character_mvp_kills %s %s", where, orderByClause)
+	rows, err := srv.db.Query(query, params...)
 	if err != nil {
-		log.Printf("[E] [HTTP/MVP] Could not query for MVP kills: %v", err)
+		logRequestf(r, "[E] [HTTP/MVP] Could not query for MVP kills: %v", err)
 		http.Error(w, "Could not query MVP kills", http.StatusInternalServerError)
 		return
 	}
@@ -1424,20 +2439,36 @@ func mvpKillsHandler(w http.ResponseWriter, r *http.Request) {
 				player.CharacterName = val.(string)
 			} else if strings.HasPrefix(colName, "mvp_") {
 				mobID := strings.TrimPrefix(colName, "mvp_")
-				// Kills are offset in the DB to protect against stale data
-				displayKillCount := int(val.(int64)) - MvpKillCountOffset
-				if displayKillCount < 0 {
-					displayKillCount = 0
-				}
+				displayKillCount := mvpDisplayKills(mobID, int(val.(int64)))
 				player.Kills[mobID] = displayKillCount
 				totalKills += displayKillCount
 			}
 		}
 		player.TotalKills = totalKills
+		if nonzeroOnly && totalKills == 0 {
+			continue
+		}
 		players = append(players, player)
 	}
 
-	// 5. Render template
+	// 5. With nonzero_only, also drop MVP columns nobody has displayed kills for.
+	// This has to happen after the offset subtraction above.
+	var filter string
+	if nonzeroOnly {
+		filter = "&nonzero_only=true"
+		visible := []MvpHeader{headers[0]} // always keep "total"
+		for _, h := range headers[1:] {
+			for _, p := range players {
+				if p.Kills[h.MobID] > 0 {
+					visible = append(visible, h)
+					break
+				}
+			}
+		}
+		headers = visible
+	}
+
+	// 6. Render template
 	data := MvpKillPageData{
 		Players:        players,
 		Headers:        headers,
@@ -1445,8 +2476,10 @@ func mvpKillsHandler(w http.ResponseWriter, r *http.Request) {
 		Order:          order,
 		LastScrapeTime: GetLastScrapeTime(),
 		PageTitle:      "MVP Kills",
+		NonzeroOnly:    nonzeroOnly,
+		Filter:         template.URL(filter),
 	}
-	renderTemplate(w, r, "mvp_kills.html", data)
+	renderSortedTemplate(w, r, "mvp_kills.html", data, data.SortBy)
 }
 
 func characterDetailHandler(w http.ResponseWriter, r *http.Request) {
@@ -1456,14 +2489,18 @@ func characterDetailHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Character name is required", http.StatusBadRequest)
 		return
 	}
+	if format := r.URL.Query().Get("format"); format != "" && !httpx.WantsJSON(r) {
+		http.Error(w, "format must be json", http.StatusBadRequest)
+		return
+	}
 
 	// 1. Fetch core character data (unchanged)
 	p, err := fetchCharacterData(charName)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			http.Error(w, "Character not found", http.StatusNotFound)
+			renderError(w, r, http.StatusNotFound, "error_char_not_found")
 		} else {
-			log.Printf("[E] [HTTP/Char] %v", err)
+			logRequestf(r, "[E] [HTTP/Char] %v", err)
 			http.Error(w, "Database query for character failed", http.StatusInternalServerError)
 		}
 		return
@@ -1489,7 +2526,7 @@ func characterDetailHandler(w http.ResponseWriter, r *http.Request) {
 
 	totalChangelogEntries, err := countCharacterChangelog(p.Name, changelogQuery)
 	if err != nil {
-		log.Printf("[E] [HTTP/Char] %v", err)
+		logRequestf(r, "[E] [HTTP/Char] %v", err)
 		http.Error(w, "Could not query for character changelog count", http.StatusInternalServerError)
 		return
 	}
@@ -1498,7 +2535,7 @@ func characterDetailHandler(w http.ResponseWriter, r *http.Request) {
 
 	activityHistory, err := fetchCharacterChangelog(p.Name, changelogQuery, pagination)
 	if err != nil {
-		log.Printf("[E] [HTTP/Char] %v", err)
+		logRequestf(r, "[E] [HTTP/Char] %v", err)
 		http.Error(w, "Could not query for character changelog", http.StatusInternalServerError)
 		return
 	}
@@ -1511,6 +2548,25 @@ func characterDetailHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// --- END OPTIMIZATION ---
 
+	// 5. ?format=json returns the same profile as one JSON object.
+	if httpx.WantsJSON(r) {
+		resp := CharacterProfile{
+			Character:           CharacterProfileInfo{PlayerCharacter: p, GuildName: p.GuildName.String},
+			Guild:               guild,
+			MvpKills:            characterMvpKills(p.Name),
+			GuildHistory:        nonNilChangelog(guildHistory),
+			DropHistory:         nonNilChangelog(dropHistory),
+			Changelog:           nonNilChangelog(activityHistory),
+			ChangelogTotal:      totalChangelogEntries,
+			ChangelogPagination: pagination,
+			LastScrapeTime:      GetLastScrapeTime(),
+		}
+		if err := httpx.WriteJSON(w, http.StatusOK, resp); err != nil {
+			logRequestf(r, "[W] [HTTP/Char] Failed to write profile JSON for '%s': %v", p.Name, err)
+		}
+		return
+	}
+
 	// 6. Build Filter URL for changelog pagination (unchanged)
 	filterValues := url.Values{}
 	filterValues.Set("name", p.Name)
@@ -1542,6 +2598,15 @@ func characterDetailHandler(w http.ResponseWriter, r *http.Request) {
 	renderTemplate(w, r, "character_detail.html", data)
 }
 
+// nonNilChangelog returns entries, or an empty slice for nil so JSON
+// gets [] rather than null.
+func nonNilChangelog(entries []CharacterChangelog) []CharacterChangelog {
+	if entries == nil {
+		return []CharacterChangelog{}
+	}
+	return entries
+}
+
 func characterChangelogHandler(w http.ResponseWriter, r *http.Request) {
 	const entriesPerPage = 100
 	var totalEntries int
@@ -1549,7 +2614,7 @@ func characterChangelogHandler(w http.ResponseWriter, r *http.Request) {
 	// 1. Get total count for pagination
 	err := srv.db.QueryRow("SELECT COUNT(*) FROM character_changelog").Scan(&totalEntries)
 	if err != nil {
-		log.Printf("[E] [HTTP/Changelog] Could not count changelog entries: %v", err)
+		logRequestf(r, "[E] [HTTP/Changelog] Could not count changelog entries: %v", err)
 		http.Error(w, "Could not count changelog entries", http.StatusInternalServerError)
 		return
 	}
@@ -1564,7 +2629,7 @@ func characterChangelogHandler(w http.ResponseWriter, r *http.Request) {
 
 	rows, err := srv.db.Query(query, pagination.ItemsPerPage, pagination.Offset)
 	if err != nil {
-		log.Printf("[E] [HTTP/Changelog] Could not query for character changelog: %v", err)
+		logRequestf(r, "[E] [HTTP/Changelog] Could not query for character changelog: %v", err)
 		http.Error(w, "Could not query for character changelog", http.StatusInternalServerError)
 		return
 	}
@@ -1579,7 +2644,7 @@ func characterChangelogHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		if parsedTime, err := time.Parse(time.RFC3339, timestampStr); err == nil {
-			entry.ChangeTime = parsedTime.Format("2006-01-02 15:04:05")
+			entry.ChangeTime = displayTime(parsedTime).Format("2006-01-02 15:04:05")
 		} else {
 			entry.ChangeTime = timestampStr
 		}
@@ -1609,9 +2674,9 @@ func guildDetailHandler(w http.ResponseWriter, r *http.Request) {
 	g, err := fetchGuildDetails(guildName)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			http.Error(w, "Guild not found", http.StatusNotFound)
+			renderError(w, r, http.StatusNotFound, "error_guild_not_found")
 		} else {
-			log.Printf("[E] [HTTP/Guild] %v", err)
+			logRequestf(r, "[E] [HTTP/Guild] %v", err)
 			http.Error(w, "Could not query for guild details", http.StatusInternalServerError)
 		}
 		return
@@ -1624,19 +2689,25 @@ func guildDetailHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	orderByClause, sortBy, order := httpx.GetSortClause(r, allowedSorts, "base_level", "DESC")
 
-	members, classDistribution, err := fetchGuildMembersAndStats(g.Name, g.Master, orderByClause)
+	members, classDistribution, membersTruncated, err := fetchGuildMembersAndStats(g.Name, g.Master, orderByClause)
 	if err != nil {
-		log.Printf("[E] [HTTP/Guild] %v", err)
+		logRequestf(r, "[E] [HTTP/Guild] %v", err)
 		http.Error(w, "Could not query for guild members", http.StatusInternalServerError)
 		return
 	}
 	classDistJSON, _ := json.Marshal(classDistribution)
 
+	memberHistory, err := fetchGuildMemberHistory(g.Name)
+	if err != nil {
+		logRequestf(r, "[W] [HTTP/Guild] %v", err) // Not critical, the chart is hidden
+	}
+	memberHistoryJSON, _ := json.Marshal(memberHistory)
+
 	// 3. Fetch paginated guild changelog
 	const entriesPerPage = 25
 	changelogEntries, pagination, err := fetchGuildChangelog(g.Name, r, entriesPerPage)
 	if err != nil {
-		log.Printf("[E] [HTTP/Guild] %v", err)
+		logRequestf(r, "[E] [HTTP/Guild] %v", err)
 		http.Error(w, "Could not query for guild changelog", http.StatusInternalServerError)
 		return
 	}
@@ -1657,94 +2728,337 @@ func guildDetailHandler(w http.ResponseWriter, r *http.Request) {
 	data := GuildDetailPageData{
 		Guild:                 g,
 		Members:               members,
+		MembersTruncated:      membersTruncated,
 		LastScrapeTime:        GetLastScrapeTime(),
 		SortBy:                sortBy,
 		Order:                 order,
 		ClassDistributionJSON: template.JS(classDistJSON),
 		HasChartData:          len(classDistribution) > 1,
+		MemberHistoryJSON:     template.JS(memberHistoryJSON),
+		HasMemberHistory:      len(memberHistory) > 1,
 		ChangelogEntries:      changelogEntries,
 		ChangelogPagination:   pagination,
 		PageTitle:             g.Name,
 		Filter:                template.URL(filterString),
 	}
-	renderTemplate(w, r, "guild_detail.html", data)
+	renderSortedTemplate(w, r, "guild_detail.html", data, data.SortBy)
 }
 
-func storeDetailHandler(w http.ResponseWriter, r *http.Request) {
-	storeName := r.URL.Query().Get("name")
-	sellerNameQuery := r.URL.Query().Get("seller")
-	if storeName == "" {
-		http.Error(w, "Store name is required", http.StatusBadRequest)
-		return
-	}
+// guildMemberHistoryMaxPoints caps the guild member-count chart; longer
+// histories are averaged into evenly sized time buckets.
+const guildMemberHistoryMaxPoints = 365
 
-	// 1. Get Sort Order
-	allowedSorts := map[string]string{
-		"name": "name_of_the_item", "item_id": "item_id", "quantity": "quantity",
-		"price": "CAST(REPLACE(price, ',', '') AS INTEGER)",
+// fetchGuildMemberHistory returns a guild's member count over time,
+// oldest first, downsampled to at most guildMemberHistoryMaxPoints.
+func fetchGuildMemberHistory(guildName string) ([]GuildMemberPoint, error) {
+	var total int
+	var first, last sql.NullString
+	err := srv.db.QueryRow(`
+		SELECT COUNT(*), MIN(timestamp), MAX(timestamp)
+		FROM guild_member_history WHERE guild_name = ?`, guildName).Scan(&total, &first, &last)
+	if err != nil {
+		return nil, fmt.Errorf("could not count member history for guild '%s': %w", guildName, err)
+	}
+	if total == 0 {
+		return []GuildMemberPoint{}, nil
 	}
-	orderByClause, sortBy, order := httpx.GetSortClause(r, allowedSorts, "price", "DESC")
 
-	// 2. Find the store's "signature" (seller, map, coords)
-	var sellerName, mapName, mapCoords, mostRecentTimestampStr string
-	var signatureQueryArgs []interface{}
-	signatureQuery := `SELECT seller_name, map_name, map_coordinates, date_and_time_retrieved FROM items WHERE store_name = ?`
-	signatureQueryArgs = append(signatureQueryArgs, storeName)
-	if sellerNameQuery != "" {
-		signatureQuery += " AND seller_name = ?"
-		signatureQueryArgs = append(signatureQueryArgs, sellerNameQuery)
+	query := `SELECT timestamp, member_count FROM guild_member_history WHERE guild_name = ? ORDER BY timestamp ASC`
+	if total > guildMemberHistoryMaxPoints {
+		start, _ := time.Parse(time.RFC3339, first.String)
+		end, _ := time.Parse(time.RFC3339, last.String)
+		bucketSeconds := max(int(end.Sub(start).Seconds())/(guildMemberHistoryMaxPoints-1)+1, 60)
+		query = fmt.Sprintf(`
+			SELECT MIN(timestamp), CAST(ROUND(AVG(member_count)) AS INTEGER)
+			FROM guild_member_history WHERE guild_name = ?
+			GROUP BY CAST(unixepoch(timestamp) / %d AS INTEGER) ORDER BY 1 ASC`, bucketSeconds)
 	}
-	signatureQuery += ` ORDER BY date_and_time_retrieved DESC, id DESC LIMIT 1`
 
-	err := srv.db.QueryRow(signatureQuery, signatureQueryArgs...).Scan(&sellerName, &mapName, &mapCoords, &mostRecentTimestampStr)
+	rows, err := srv.db.Query(query, guildName)
+	if err != nil {
+		return nil, fmt.Errorf("could not query member history for guild '%s': %w", guildName, err)
+	}
+	defer rows.Close()
 
-	// 3. Fetch Items
-	var items []Item
-	if err == nil {
-		// Store was found, now fetch its items
-		query := fmt.Sprintf(`
-			WITH RankedItems AS (
-				SELECT i.*, local_db.name_pt, ROW_NUMBER() OVER(PARTITION BY i.name_of_the_item ORDER BY i.id DESC) as rn
-				FROM items i
-				LEFT JOIN internal_item_db local_db ON i.item_id = local_db.item_id
-				WHERE i.store_name = ? AND i.seller_name = ? AND i.map_name = ? AND i.map_coordinates = ?
-			)
-			SELECT id, name_of_the_item, name_pt, item_id, quantity, price, store_name, seller_name, date_and_time_retrieved, map_name, map_coordinates, is_available
-			FROM RankedItems WHERE rn = 1 %s`, orderByClause)
-
-		rows, queryErr := srv.db.Query(query, storeName, sellerName, mapName, mapCoords)
-		if queryErr != nil {
-			http.Error(w, "Could not query for store items", http.StatusInternalServerError)
-			return
+	points := []GuildMemberPoint{}
+	for rows.Next() {
+		var p GuildMemberPoint
+		var timestampStr string
+		if err := rows.Scan(&timestampStr, &p.Members); err != nil {
+			log.Printf("[W] [HTTP/Guild] Failed to scan member history row: %v", err)
+			continue
 		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var item Item
-			var retrievedTime string
-			if err := rows.Scan(&item.ID, &item.Name, &item.NamePT, &item.ItemID, &item.Quantity, &item.Price, &item.StoreName, &item.SellerName, &retrievedTime, &item.MapName, &item.MapCoordinates, &item.IsAvailable); err == nil {
-				if t, err := time.Parse(time.RFC3339, retrievedTime); err == nil {
-					item.Timestamp = t.Format("2006-01-02 15:04")
-				}
-				items = append(items, item)
-			}
+		p.Timestamp = timestampStr
+		if t, err := time.Parse(time.RFC3339, timestampStr); err == nil {
+			p.Timestamp = displayTime(t).Format("2006-01-02 15:04")
 		}
-	} else if err != sql.ErrNoRows {
-		http.Error(w, "Database error finding store", http.StatusInternalServerError)
-		return
+		points = append(points, p)
 	}
+	return points, rows.Err()
+}
 
-	// 4. Build Filter URL
-	filterValues := url.Values{}
-	filterValues.Set("name", storeName)
-	filterValues.Set("seller", sellerName)
+// guildRosterHandler exports a guild's member list as JSON (default) or
+// CSV (?format=csv). It accepts the same sort_by/order params as the
+// guild detail page.
+func guildRosterHandler(w http.ResponseWriter, r *http.Request) {
+	guildName := r.URL.Query().Get("name")
+	if guildName == "" {
+		http.Error(w, "Guild name is required", http.StatusBadRequest)
+		return
+	}
 
-	filterString := ""
-	if encodedFilter := filterValues.Encode(); encodedFilter != "" {
-		filterString = "&" + encodedFilter
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
 	}
 
-	// 5. Render Template
+	g, err := fetchGuildDetails(guildName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Guild not found", http.StatusNotFound)
+		} else {
+			logRequestf(r, "[E] [HTTP/Guild] %v", err)
+			http.Error(w, "Could not query for guild details", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	allowedSorts := map[string]string{
+		"rank": "rank", "name": "name", "base_level": "base_level", "job_level": "job_level",
+		"experience": "experience", "zeny": "zeny", "class": "class", "last_active": "last_active",
+	}
+	orderByClause, _, _ := httpx.GetSortClause(r, allowedSorts, "base_level", "DESC")
+
+	members, _, truncated, err := fetchGuildMembersAndStats(g.Name, g.Master, orderByClause)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/Guild] %v", err)
+		http.Error(w, "Could not query for guild members", http.StatusInternalServerError)
+		return
+	}
+
+	roster := GuildRoster{
+		Guild:     g.Name,
+		Master:    g.Master,
+		Members:   make([]GuildRosterMember, 0, len(members)),
+		Truncated: truncated,
+	}
+	for _, m := range members {
+		roster.Members = append(roster.Members, GuildRosterMember{
+			Name:       m.Name,
+			BaseLevel:  m.BaseLevel,
+			JobLevel:   m.JobLevel,
+			Class:      m.Class,
+			Zeny:       m.Zeny,
+			LastActive: m.LastActive,
+			IsLeader:   m.IsGuildLeader,
+		})
+	}
+
+	if format == "csv" {
+		rows := make([][]string, 0, len(roster.Members))
+		for _, m := range roster.Members {
+			rows = append(rows, []string{
+				m.Name,
+				strconv.Itoa(m.BaseLevel),
+				strconv.Itoa(m.JobLevel),
+				m.Class,
+				strconv.FormatInt(m.Zeny, 10),
+				m.LastActive,
+				strconv.FormatBool(m.IsLeader),
+			})
+		}
+		header := []string{"name", "base_level", "job_level", "class", "zeny", "last_active", "is_leader"}
+		if err := httpx.WriteCSV(w, g.Name+"-roster.csv", header, rows); err != nil {
+			log.Printf("[W] [HTTP/Guild] Failed to write roster CSV for '%s': %v", g.Name, err)
+		}
+		return
+	}
+
+	if err := httpx.WriteJSON(w, http.StatusOK, roster); err != nil {
+		log.Printf("[W] [HTTP/Guild] Failed to write roster JSON for '%s': %v", g.Name, err)
+	}
+}
+
+// findStoreSignature returns the seller, map, coordinates and last-seen
+// time of the most recent listing for storeName (optionally narrowed to
+// sellerName). It returns sql.ErrNoRows for an unknown store.
+func findStoreSignature(storeName, sellerName string) (seller, mapName, mapCoords, lastSeen string, err error) {
+	query := `SELECT seller_name, map_name, map_coordinates, date_and_time_retrieved FROM items WHERE store_name = ?`
+	args := []interface{}{storeName}
+	if sellerName != "" {
+		query += " AND seller_name = ?"
+		args = append(args, sellerName)
+	}
+	query += ` ORDER BY date_and_time_retrieved DESC, id DESC LIMIT 1`
+	err = srv.db.QueryRow(query, args...).Scan(&seller, &mapName, &mapCoords, &lastSeen)
+	return seller, mapName, mapCoords, lastSeen, err
+}
+
+// storeItemsQuery selects the latest row of each item a store has listed,
+// taking store name, seller, map and coordinates as parameters.
+func storeItemsQuery(orderByClause string) string {
+	return fmt.Sprintf(`
+		WITH RankedItems AS (
+			SELECT i.*, local_db.name_pt, ROW_NUMBER() OVER(PARTITION BY i.name_of_the_item ORDER BY i.id DESC) as rn
+			FROM items i
+			LEFT JOIN internal_item_db local_db ON i.item_id = local_db.item_id
+			WHERE i.store_name = ? AND i.seller_name = ? AND i.map_name = ? AND i.map_coordinates = ?
+		)
+		SELECT id, name_of_the_item, name_pt, item_id, quantity, price, store_name, seller_name, date_and_time_retrieved, map_name, map_coordinates, is_available
+		FROM RankedItems WHERE rn = 1 %s`, orderByClause)
+}
+
+// naviCommand formats the in-game /navi command for a map and "(x,y)"
+// coordinates, matching the copy buttons on the store and list pages.
+func naviCommand(mapName, coords string) string {
+	c := strings.NewReplacer("(", "", ")", "", ",", "/").Replace(strings.TrimSpace(coords))
+	return fmt.Sprintf("/navi %s %s", strings.ToLower(strings.TrimSpace(mapName)), c)
+}
+
+// storeNaviHandler serves /store/navi?name=...[&seller=...] as plain
+// text: one /navi line per item the store currently has for sale, so a
+// buyer can copy a whole store into their route at once.
+func storeNaviHandler(w http.ResponseWriter, r *http.Request) {
+	storeName := r.URL.Query().Get("name")
+	if storeName == "" {
+		http.Error(w, "Store name is required", http.StatusBadRequest)
+		return
+	}
+
+	sellerName, mapName, mapCoords, _, err := findStoreSignature(storeName, r.URL.Query().Get("seller"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Store not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logRequestf(r, "[E] [HTTP/Store] Could not find store '%s' for navi export: %v", storeName, err)
+		http.Error(w, "Database error finding store", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := srv.db.Query(storeItemsQuery("ORDER BY name_of_the_item ASC"), storeName, sellerName, mapName, mapCoords)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/Store] Could not query items of '%s' for navi export: %v", storeName, err)
+		http.Error(w, "Could not query for store items", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	navi := naviCommand(mapName, mapCoords)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "# %s (%s) - %s\n", storeName, sellerName, navi)
+	for rows.Next() {
+		var item Item
+		var retrievedTime string
+		if err := rows.Scan(&item.ID, &item.Name, &item.NamePT, &item.ItemID, &item.Quantity, &item.Price, &item.StoreName, &item.SellerName, &retrievedTime, &item.MapName, &item.MapCoordinates, &item.IsAvailable); err != nil {
+			logRequestf(r, "[W] [HTTP/Store] Failed to scan store item for navi export: %v", err)
+			continue
+		}
+		if !item.IsAvailable {
+			continue
+		}
+		fmt.Fprintf(w, "%s # %s x%d @ %s\n", navi, item.Name, item.Quantity, item.Price)
+	}
+}
+
+func storeDetailHandler(w http.ResponseWriter, r *http.Request) {
+	storeName := r.URL.Query().Get("name")
+	sellerNameQuery := r.URL.Query().Get("seller")
+	if storeName == "" {
+		http.Error(w, "Store name is required", http.StatusBadRequest)
+		return
+	}
+
+	// 1. Get Sort Order
+	allowedSorts := map[string]string{
+		"name": "name_of_the_item", "item_id": "item_id", "quantity": "quantity",
+		"price": marketPriceSQL("price"),
+	}
+	orderByClause, sortBy, order := httpx.GetSortClause(r, allowedSorts, "price", "DESC")
+
+	// 2. Find the store's "signature" (seller, map, coords)
+	sellerName, mapName, mapCoords, mostRecentTimestampStr, err := findStoreSignature(storeName, sellerNameQuery)
+
+	if err == sql.ErrNoRows && httpx.WantsJSON(r) {
+		httpx.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "store not found"})
+		return
+	}
+
+	// 3. Fetch Items
+	var items []Item
+	var inventory []StoreInventoryItem
+	if err == nil {
+		// Store was found, now fetch its items
+		rows, queryErr := srv.db.Query(storeItemsQuery(orderByClause), storeName, sellerName, mapName, mapCoords)
+		if queryErr != nil {
+			http.Error(w, "Could not query for store items", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var item Item
+			var retrievedTime string
+			if err := rows.Scan(&item.ID, &item.Name, &item.NamePT, &item.ItemID, &item.Quantity, &item.Price, &item.StoreName, &item.SellerName, &retrievedTime, &item.MapName, &item.MapCoordinates, &item.IsAvailable); err == nil {
+				if t, err := time.Parse(time.RFC3339, retrievedTime); err == nil {
+					item.Timestamp = displayTime(t).Format("2006-01-02 15:04")
+				}
+				items = append(items, item)
+				inventory = append(inventory, StoreInventoryItem{
+					ItemID:      item.ItemID,
+					Name:        item.Name,
+					NamePT:      item.NamePT.String,
+					Quantity:    item.Quantity,
+					Price:       parseMarketPrice(item.Price),
+					LastSeen:    retrievedTime,
+					IsAvailable: item.IsAvailable,
+				})
+			}
+		}
+	} else if err != sql.ErrNoRows {
+		http.Error(w, "Database error finding store", http.StatusInternalServerError)
+		return
+	}
+
+	if httpx.WantsJSON(r) {
+		if inventory == nil {
+			inventory = []StoreInventoryItem{}
+		}
+		resp := StoreInventory{
+			StoreName:      storeName,
+			SellerName:     sellerName,
+			MapName:        strings.ToLower(mapName),
+			MapCoordinates: mapCoords,
+			LastSeen:       mostRecentTimestampStr,
+			Items:          inventory,
+		}
+		if err := httpx.WriteJSON(w, http.StatusOK, resp); err != nil {
+			logRequestf(r, "[E] [HTTP/Store] Could not encode store inventory JSON: %v", err)
+		}
+		return
+	}
+
+	var relatedStores []StoreSummary
+	if sellerName != "" {
+		if relatedStores, err = fetchRelatedStores(storeName, sellerName, mapName, mapCoords); err != nil {
+			logRequestf(r, "[W] [HTTP/Store] Could not load other stores of '%s': %v", sellerName, err)
+		}
+	}
+
+	// 4. Build Filter URL
+	filterValues := url.Values{}
+	filterValues.Set("name", storeName)
+	filterValues.Set("seller", sellerName)
+
+	filterString := ""
+	if encodedFilter := filterValues.Encode(); encodedFilter != "" {
+		filterString = "&" + encodedFilter
+	}
+
+	// 5. Render Template
 	data := StoreDetailPageData{
 		StoreName:      storeName,
 		SellerName:     sellerName,
@@ -1752,12 +3066,49 @@ func storeDetailHandler(w http.ResponseWriter, r *http.Request) {
 		MapCoordinates: mapCoords,
 		Items:          items,
 		LastScrapeTime: GetLastScrapeTime(),
+		RelatedStores:  relatedStores,
 		SortBy:         sortBy,
 		Order:          order,
 		PageTitle:      storeName,
 		Filter:         template.URL(filterString), // <-- ADDED
 	}
-	renderTemplate(w, r, "store_detail.html", data)
+	renderSortedTemplate(w, r, "store_detail.html", data, data.SortBy)
+}
+
+// fetchRelatedStores lists the other stores sellerName currently has
+// open. A store is identified by the same (store, seller, map, coords)
+// signature storeDetailHandler uses, so a seller running two carts with
+// the same title on different maps gets both listed; the store being
+// viewed is left out.
+func fetchRelatedStores(storeName, sellerName, mapName, mapCoords string) ([]StoreSummary, error) {
+	rows, err := srv.db.Query(`
+		SELECT store_name, map_name, map_coordinates, COUNT(*), MAX(date_and_time_retrieved)
+		FROM items
+		WHERE seller_name = ? AND is_available = 1
+			AND NOT (store_name = ? AND map_name = ? AND map_coordinates = ?)
+		GROUP BY store_name, map_name, map_coordinates
+		ORDER BY store_name ASC`, sellerName, storeName, mapName, mapCoords)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stores []StoreSummary
+	for rows.Next() {
+		s := StoreSummary{SellerName: sellerName}
+		var lastSeen string
+		if err := rows.Scan(&s.StoreName, &s.MapName, &s.MapCoordinates, &s.ItemCount, &lastSeen); err != nil {
+			return nil, err
+		}
+		s.MapName = strings.ToLower(s.MapName)
+		if t, pErr := time.Parse(time.RFC3339, lastSeen); pErr == nil {
+			s.LastSeen = displayTime(t).Format("2006-01-02 15:04")
+		} else {
+			s.LastSeen = lastSeen
+		}
+		stores = append(stores, s)
+	}
+	return stores, rows.Err()
 }
 
 func generateSecretToken(length int) (string, error) {
@@ -1768,8 +3119,86 @@ func generateSecretToken(length int) (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// flatTradingPostItemsQuery selects trading post items joined with their
+// post and PT name; callers append WHERE / ORDER BY / LIMIT clauses.
+const flatTradingPostItemsQuery = `
+		SELECT
+			p.id, p.title, p.post_type, p.character_name, p.contact_info, p.created_at, p.notes,
+			i.item_name, local_db.name_pt, i.item_id, i.quantity, i.price_zeny, i.price_rmt, 
+			i.payment_methods, i.refinement, i.card1, i.card2, i.card3, i.card4
+		FROM trading_post_items i
+		JOIN trading_posts p ON i.post_id = p.id
+		LEFT JOIN internal_item_db local_db ON i.item_id = local_db.item_id
+	`
+
+// queryFlatTradingPostItems runs flatTradingPostItemsQuery followed by
+// tail (WHERE / ORDER BY / LIMIT) and scans the rows.
+func queryFlatTradingPostItems(tail string, params ...interface{}) ([]FlatTradingPostItem, error) {
+	rows, err := srv.db.Query(flatTradingPostItemsQuery+tail, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []FlatTradingPostItem
+	for rows.Next() {
+		var item FlatTradingPostItem
+		err := rows.Scan(
+			&item.PostID, &item.Title, &item.PostType, &item.CharacterName, &item.ContactInfo, &item.CreatedAt, &item.Notes,
+			&item.ItemName, &item.NamePT, &item.ItemID, &item.Quantity, &item.PriceZeny, &item.PriceRMT,
+			&item.PaymentMethods,
+			&item.Refinement, &item.Card1, &item.Card2, &item.Card3, &item.Card4,
+		)
+		if err != nil {
+			log.Printf("[W] [HTTP/Trade] Failed to scan flat trading post item: %v", err)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// tradeCurrencyCondition returns the WHERE condition for the trading post
+// currency filter. "both" posts match either currency.
+func tradeCurrencyCondition(currency string) string {
+	switch currency {
+	case "zeny":
+		return "(i.price_zeny > 0 OR i.payment_methods IN ('zeny', 'both'))"
+	case "rmt":
+		return "(i.price_rmt > 0 OR i.payment_methods IN ('rmt', 'both'))"
+	}
+	return ""
+}
+
+// tradePriceSortExpr returns the price sort expression for a currency
+// filter. It sorts on the filtered currency's column, with unpriced posts
+// (e.g. a "both" post that only gave an RMT price) last. Unfiltered lists
+// sort by zeny.
+func tradePriceSortExpr(currency string) string {
+	column := "i.price_zeny"
+	if currency == "rmt" {
+		column = "i.price_rmt"
+	}
+	return fmt.Sprintf("CASE WHEN %s > 0 THEN %s ELSE 9223372036854775807 END", column, column)
+}
+
+// applyTradeCurrencyView hides the price of the currency that isn't being
+// filtered on, so a "both" post listed under the zeny filter shows its
+// zeny price (or "negotiable") rather than its RMT one.
+func applyTradeCurrencyView(items []FlatTradingPostItem, currency string) {
+	for i := range items {
+		switch currency {
+		case "zeny":
+			items[i].PriceRMT = 0
+		case "rmt":
+			items[i].PriceZeny = 0
+		}
+	}
+}
+
 func tradingPostListHandler(w http.ResponseWriter, r *http.Request) {
 	searchQuery := r.URL.Query().Get("query")
+	searchMode := itemSearchMode(r.URL.Query().Get("search_mode"))
 	filterType := r.URL.Query().Get("filter_type")
 	if filterType == "" {
 		filterType = "all"
@@ -1780,20 +3209,11 @@ func tradingPostListHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var queryParams []interface{}
-	baseQuery := `
-		SELECT
-			p.id, p.title, p.post_type, p.character_name, p.contact_info, p.created_at, p.notes,
-			i.item_name, local_db.name_pt, i.item_id, i.quantity, i.price_zeny, i.price_rmt, 
-			i.payment_methods, i.refinement, i.card1, i.card2, i.card3, i.card4
-		FROM trading_post_items i
-		JOIN trading_posts p ON i.post_id = p.id
-		LEFT JOIN internal_item_db local_db ON i.item_id = local_db.item_id
-	`
 	var whereConditions []string
 
 	// 1. Build WHERE clause
 	if searchQuery != "" {
-		if _, err := strconv.Atoi(searchQuery); err == nil {
+		if searchByItemID(searchQuery, searchMode) {
 			// Search by Item ID
 			whereConditions = append(whereConditions, "i.item_id = ?")
 			queryParams = append(queryParams, searchQuery)
@@ -1825,11 +3245,8 @@ func tradingPostListHandler(w http.ResponseWriter, r *http.Request) {
 		queryParams = append(queryParams, "buying")
 	}
 
-	switch filterCurrency {
-	case "zeny":
-		whereConditions = append(whereConditions, "(i.price_zeny > 0 OR i.payment_methods IN ('zeny', 'both'))")
-	case "rmt":
-		whereConditions = append(whereConditions, "(i.price_rmt > 0 OR i.payment_methods IN ('rmt', 'both'))")
+	if cond := tradeCurrencyCondition(filterCurrency); cond != "" {
+		whereConditions = append(whereConditions, cond)
 	}
 
 	whereClause := ""
@@ -1845,11 +3262,7 @@ func tradingPostListHandler(w http.ResponseWriter, r *http.Request) {
 		"posted":    "p.created_at",
 	}
 	// Dynamic price sort based on currency filter
-	if filterCurrency == "rmt" {
-		allowedSorts["price"] = "CASE WHEN i.price_rmt = 0 THEN 9223372036854775807 ELSE i.price_rmt END"
-	} else {
-		allowedSorts["price"] = "CASE WHEN i.price_zeny = 0 THEN 9223372036854775807 ELSE i.price_zeny END"
-	}
+	allowedSorts["price"] = tradePriceSortExpr(filterCurrency)
 	orderByClause, sortBy, order := httpx.GetSortClause(r, allowedSorts, "posted", "DESC")
 
 	// 3. Build Filter URL
@@ -1863,6 +3276,9 @@ func tradingPostListHandler(w http.ResponseWriter, r *http.Request) {
 	if filterCurrency != "all" {
 		filterValues.Set("filter_currency", filterCurrency)
 	}
+	if searchMode != "auto" {
+		filterValues.Set("search_mode", searchMode)
+	}
 	filterValues.Set("sort_by", sortBy)
 	filterValues.Set("order", order)
 
@@ -1872,44 +3288,297 @@ func tradingPostListHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 4. Execute Query
-	finalQuery := baseQuery + whereClause + " " + orderByClause
-	rows, err := srv.db.Query(finalQuery, queryParams...)
+	items, err := queryFlatTradingPostItems(whereClause+" "+orderByClause, queryParams...)
 	if err != nil {
-		log.Printf("[E] [HTTP/Trade] Trading Post flat list query error: %v", err)
+		logRequestf(r, "[E] [HTTP/Trade] Trading Post flat list query error: %v", err)
 		http.Error(w, "Database query failed", http.StatusInternalServerError)
 		return
 	}
+	applyTradeCurrencyView(items, filterCurrency)
+
+	// 5. Render Template
+	data := TradingPostPageData{
+		Items:           items,
+		LastScrapeTime:  GetLastScrapeTime(),
+		SearchQuery:     searchQuery,
+		SearchMode:      searchMode,
+		SearchModeParam: searchModeParam(searchMode),
+		FilterType:      filterType,
+		FilterCurrency:  filterCurrency,
+		SortBy:          sortBy,
+		Order:           order,
+		PageTitle:       "Discord",
+		Filter:          template.URL(filterString), // <-- ADDED
+	}
+	renderSortedTemplate(w, r, "trading_post.html", data, data.SortBy)
+}
+
+// itemOffersTradeLimit caps how many trading post entries /item/all shows.
+const itemOffersTradeLimit = 100
+
+// resolveItemID returns the item ID for a market name or a numeric ID,
+// trying market rows first and then the local item DB. 0 means unknown.
+func resolveItemID(name string) int64 {
+	if id, err := strconv.ParseInt(name, 10, 64); err == nil {
+		return id
+	}
+	if id, _ := getItemIDAndNamePT(name); id > 0 {
+		return int64(id)
+	}
+	clean := strings.TrimSpace(reRefineRemover.ReplaceAllString(reSlotRemover.ReplaceAllString(name, " "), ""))
+	if id, found := findItemIDInCache(clean, 0); found {
+		return id.Int64
+	}
+	return 0
+}
+
+// fetchCurrentListings returns the available market listings of an item,
+// cheapest first. Listings are matched by itemID when known, otherwise by
+// exact market name.
+func fetchCurrentListings(itemID int64, itemName string) ([]Item, error) {
+	match, param := "i.name_of_the_item = ?", interface{}(itemName)
+	if itemID > 0 {
+		match, param = "i.item_id = ?", itemID
+	}
+	rows, err := srv.db.Query(`
+		SELECT i.id, i.name_of_the_item, local_db.name_pt, i.item_id, i.quantity, i.price,
+		       i.store_name, i.seller_name, i.date_and_time_retrieved, i.map_name,
+		       i.map_coordinates, i.is_available
+		FROM items i
+		LEFT JOIN internal_item_db local_db ON i.item_id = local_db.item_id
+		WHERE i.is_available = 1 AND `+match+`
+		ORDER BY `+marketPriceSQL("i.price")+` ASC`, param)
+	if err != nil {
+		return nil, fmt.Errorf("could not query current listings: %w", err)
+	}
 	defer rows.Close()
 
-	var items []FlatTradingPostItem
+	var listings []Item
 	for rows.Next() {
-		var item FlatTradingPostItem
-		err := rows.Scan(
-			&item.PostID, &item.Title, &item.PostType, &item.CharacterName, &item.ContactInfo, &item.CreatedAt, &item.Notes,
-			&item.ItemName, &item.NamePT, &item.ItemID, &item.Quantity, &item.PriceZeny, &item.PriceRMT,
-			&item.PaymentMethods,
-			&item.Refinement, &item.Card1, &item.Card2, &item.Card3, &item.Card4,
-		)
-		if err != nil {
-			log.Printf("[W] [HTTP/Trade] Failed to scan flat trading post item: %v", err)
-			continue
+		var item Item
+		var retrievedTime string
+		if err := rows.Scan(&item.ID, &item.Name, &item.NamePT, &item.ItemID, &item.Quantity, &item.Price, &item.StoreName, &item.SellerName, &retrievedTime, &item.MapName, &item.MapCoordinates, &item.IsAvailable); err != nil {
+			return nil, fmt.Errorf("could not scan current listing: %w", err)
 		}
-		items = append(items, item)
+		if t, err := time.Parse(time.RFC3339, retrievedTime); err == nil {
+			item.Timestamp = displayTime(t).Format("2006-01-02 15:04")
+		}
+		listings = append(listings, item)
 	}
+	return listings, rows.Err()
+}
 
-	// 5. Render Template
-	data := TradingPostPageData{
-		Items:          items,
+// itemOffersHandler serves /item/all?name=...: the item's live market
+// listings and its Discord trading post entries on one page. The item ID
+// is resolved once and used for both lookups; trade entries that were
+// never matched to an ID fall back to a name match.
+func itemOffersHandler(w http.ResponseWriter, r *http.Request) {
+	itemName := strings.TrimSpace(r.URL.Query().Get("name"))
+	if itemName == "" {
+		http.Error(w, "Item name is required", http.StatusBadRequest)
+		return
+	}
+
+	itemID := resolveItemID(itemName)
+
+	var g errgroup.Group
+	var listings []Item
+	var trades []FlatTradingPostItem
+	g.Go(func() error {
+		var err error
+		listings, err = fetchCurrentListings(itemID, itemName)
+		return err
+	})
+	g.Go(func() error {
+		pattern := "%" + escapeLike(itemName) + "%"
+		var err error
+		if itemID > 0 {
+			trades, err = queryFlatTradingPostItems(`
+				WHERE i.item_id = ? OR (i.item_id IS NULL AND i.item_name LIKE ? ESCAPE '\')
+				ORDER BY p.created_at DESC LIMIT ?`, itemID, pattern, itemOffersTradeLimit)
+		} else {
+			trades, err = queryFlatTradingPostItems(`
+				WHERE i.item_name LIKE ? ESCAPE '\'
+				ORDER BY p.created_at DESC LIMIT ?`, pattern, itemOffersTradeLimit)
+		}
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		logRequestf(r, "[E] [HTTP/Offers] Could not load offers for '%s': %v", itemName, err)
+		http.Error(w, "Database query failed", http.StatusInternalServerError)
+		return
+	}
+
+	data := ItemOffersPageData{
+		ItemName:       itemName,
+		ItemID:         itemID,
+		Listings:       listings,
+		Trades:         trades,
 		LastScrapeTime: GetLastScrapeTime(),
-		SearchQuery:    searchQuery,
-		FilterType:     filterType,
-		FilterCurrency: filterCurrency,
-		SortBy:         sortBy,
-		Order:          order,
-		PageTitle:      "Discord",
-		Filter:         template.URL(filterString), // <-- ADDED
+		PageTitle:      itemName,
+	}
+	if len(listings) > 0 && listings[0].NamePT.Valid {
+		data.NamePT = listings[0].NamePT.String
+	} else if len(trades) > 0 && trades[0].NamePT.Valid {
+		data.NamePT = trades[0].NamePT.String
+	}
+	renderTemplate(w, r, "item_all.html", data)
+}
+
+// itemLocationsHandler serves /item/locations?name=...: every distinct
+// map and coordinates where the item is currently vended, with the
+// listing count and cheapest price at each spot, cheapest spot first.
+func itemLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	itemName := strings.TrimSpace(r.URL.Query().Get("name"))
+	if itemName == "" {
+		http.Error(w, "Item name is required", http.StatusBadRequest)
+		return
+	}
+
+	itemID := resolveItemID(itemName)
+	match, param := "name_of_the_item = ?", interface{}(itemName)
+	if itemID > 0 {
+		match, param = "item_id = ?", itemID
+	}
+	rows, err := srv.db.Query(`
+		SELECT map_name, map_coordinates, COUNT(*),
+		       MIN(`+marketPriceSQL("price")+`) AS cheapest
+		FROM items
+		WHERE is_available = 1 AND `+match+`
+		GROUP BY map_name, map_coordinates
+		ORDER BY cheapest ASC, map_name ASC, map_coordinates ASC`, param)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/ItemLocations] Could not query locations for '%s': %v", itemName, err)
+		http.Error(w, "Could not query item locations", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	resp := ItemLocations{ItemName: itemName, ItemID: itemID, Locations: []ItemLocation{}}
+	for rows.Next() {
+		var loc ItemLocation
+		if err := rows.Scan(&loc.MapName, &loc.MapCoordinates, &loc.Listings, &loc.CheapestPrice); err != nil {
+			log.Printf("[W] [HTTP/ItemLocations] Failed to scan location row: %v", err)
+			continue
+		}
+		resp.Locations = append(resp.Locations, loc)
+	}
+
+	if err := httpx.WriteJSON(w, http.StatusOK, resp); err != nil {
+		log.Printf("[W] [HTTP/ItemLocations] Failed to write locations JSON for '%s': %v", itemName, err)
+	}
+}
+
+// marketBaseName strips the slot count, refine and card brackets from a
+// market listing name ("Boots [1] +4 [Poring]" -> "Boots").
+func marketBaseName(name string) string {
+	return strings.TrimSpace(reMarketNameDeco.ReplaceAllString(name, ""))
+}
+
+// marketSlots returns the slot count of a market listing name, the
+// "[N]" right after the base name ("Boots [1] +4 [Poring]" -> 1), or 0
+// for an unslotted item.
+func marketSlots(name string) int {
+	m := reMarketSlots.FindStringSubmatch(name)
+	if m == nil {
+		return 0
+	}
+	slots, _ := strconv.Atoi(m[1])
+	return slots
+}
+
+// cardOfferKey groups offers by base item: the item ID when known,
+// otherwise the lowercased base name.
+func cardOfferKey(o CardOffer) string {
+	if o.ItemID > 0 {
+		return strconv.FormatInt(o.ItemID, 10)
+	}
+	return strings.ToLower(o.BaseName)
+}
+
+// cheapestCardOffers keeps the cheapest offer per base item and returns
+// them cheapest first.
+func cheapestCardOffers(offers []CardOffer) []CardOffer {
+	best := make(map[string]int)
+	result := []CardOffer{}
+	for _, o := range offers {
+		key := cardOfferKey(o)
+		if i, ok := best[key]; ok {
+			if o.Price < result[i].Price {
+				result[i] = o
+			}
+			continue
+		}
+		best[key] = len(result)
+		result = append(result, o)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Price != result[j].Price {
+			return result[i].Price < result[j].Price
+		}
+		return result[i].BaseName < result[j].BaseName
+	})
+	return result
+}
+
+// cardSearchHandler serves /search/by-card?card=...: the cheapest offer of
+// every base item slotted with the card. Market listings carry cards as
+// "[Name]" suffixes on the listing name (see buildMarketItemName); Discord
+// selling posts carry them in card1..card4.
+func cardSearchHandler(w http.ResponseWriter, r *http.Request) {
+	card := cleanCardName(strings.TrimSpace(r.URL.Query().Get("card")))
+	if len(card) < 2 {
+		http.Error(w, "card must be at least 2 characters", http.StatusBadRequest)
+		return
+	}
+
+	var offers []CardOffer
+	rows, err := srv.db.Query(`
+		SELECT name_of_the_item, COALESCE(item_id, 0),
+		       `+marketPriceSQL("price")+`,
+		       seller_name, store_name, map_name, map_coordinates
+		FROM items
+		WHERE is_available = 1 AND name_of_the_item LIKE '%[' || ? || ']%'`, card)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/CardSearch] Could not query market listings for card '%s': %v", card, err)
+		http.Error(w, "Could not search listings", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		o := CardOffer{Source: "market"}
+		if err := rows.Scan(&o.Listing, &o.ItemID, &o.Price, &o.Seller, &o.StoreName, &o.MapName, &o.MapCoordinates); err != nil {
+			log.Printf("[W] [HTTP/CardSearch] Failed to scan market row: %v", err)
+			continue
+		}
+		o.BaseName = marketBaseName(o.Listing)
+		offers = append(offers, o)
+	}
+	rows.Close()
+
+	pattern := "%" + card + "%"
+	trades, err := queryFlatTradingPostItems(`
+		WHERE p.post_type = 'selling' AND i.price_zeny > 0
+		  AND (i.card1 LIKE ? OR i.card2 LIKE ? OR i.card3 LIKE ? OR i.card4 LIKE ?)`,
+		pattern, pattern, pattern, pattern)
+	if err != nil {
+		logRequestf(r, "[W] [HTTP/CardSearch] Could not query Discord offers for card '%s': %v", card, err) // Not critical
+	}
+	for _, t := range trades {
+		offers = append(offers, CardOffer{
+			ItemID:   t.ItemID.Int64,
+			BaseName: marketBaseName(t.ItemName),
+			Listing:  t.ItemName,
+			Source:   "discord",
+			Price:    t.PriceZeny,
+			Seller:   t.CharacterName,
+			PostID:   t.PostID,
+		})
+	}
+
+	resp := CardSearchResult{Card: card, Offers: cheapestCardOffers(offers)}
+	if err := httpx.WriteJSON(w, http.StatusOK, resp); err != nil {
+		log.Printf("[W] [HTTP/CardSearch] Failed to write results for '%s': %v", card, err)
 	}
-	renderTemplate(w, r, "trading_post.html", data)
 }
 
 // ensureItemCache lazily loads the in-memory item cache from internal_item_db.
@@ -1958,7 +3627,11 @@ func ensureItemCache() {
 }
 
 // updateItemInCache dynamically updates or adds an item translation in the in-memory cache.
+// A different, non-empty previous PT name is recorded as a rename.
 func updateItemInCache(itemID int64, namePT string) {
+	var previousPT string
+	defer func() { recordItemRename(itemID, "pt", previousPT, namePT) }()
+
 	itemCacheMu.Lock()
 	defer itemCacheMu.Unlock()
 	if !itemCacheLoaded {
@@ -1967,6 +3640,7 @@ func updateItemInCache(itemID int64, namePT string) {
 
 	for i, item := range itemFuzzyCache {
 		if item.id == itemID {
+			previousPT = item.namePT
 			itemFuzzyCache[i].namePT = namePT
 
 			// Register new PT exact key
@@ -1981,6 +3655,11 @@ func updateItemInCache(itemID int64, namePT string) {
 // findItemIDInCache attempts to find an item ID using an in-memory cache of the local item DB.
 // This replaces the expensive SQL "LIKE %...%" query. Safe for concurrent execution.
 func findItemIDInCache(cleanItemName string, slots int) (sql.NullInt64, bool) {
+	return matchItemInCache(cleanItemName, slots, nil)
+}
+
+// matchItemInCache is findItemIDInCache with an optional decision trace.
+func matchItemInCache(cleanItemName string, slots int, trace *ItemMatchReport) (sql.NullInt64, bool) {
 	ensureItemCache()
 
 	itemCacheMu.RLock()
@@ -1993,8 +3672,10 @@ func findItemIDInCache(cleanItemName string, slots int) (sql.NullInt64, bool) {
 	exactKey := fmt.Sprintf("%s_%d", lowerCleanItemName, slots)
 	if id, ok := itemExactCache[exactKey]; ok {
 		log.Printf("[D] [ItemID] Found in-memory exact match for '%s': ID %d", cleanItemName, id)
+		trace.step("local-exact", "Exact match on key '%s': ID %d.", exactKey, id)
 		return sql.NullInt64{Int64: id, Valid: true}, true
 	}
+	trace.step("local-exact", "No exact match on key '%s'.", exactKey)
 
 	// 3. Slow Path: Fuzzy Search (Iterate Memory instead of SQL Table Scan)
 	// This replicates the original "LIKE %query%" logic but in RAM.
@@ -2035,9 +3716,15 @@ func findItemIDInCache(cleanItemName string, slots int) (sql.NullInt64, bool) {
 	// 4. Disambiguation Logic (Levenshtein)
 	// (This logic remains largely identical to the original, operating on the memory slice)
 
+	trace.step("local-substring", "%d item(s) contain '%s' (capped at 50).", len(potentialMatches), cleanItemName)
+
 	if len(potentialMatches) == 1 {
 		itemID := potentialMatches[0].id
 		log.Printf("[D] [ItemID] Found unique memory substring match for '%s': ID %d", cleanItemName, itemID)
+		if trace != nil {
+			trace.LocalCandidates = append(trace.LocalCandidates, ItemMatchCandidate{ID: itemID, Name: potentialMatches[0].name, NamePT: potentialMatches[0].namePT})
+		}
+		trace.step("local-substring", "Unique substring match: ID %d.", itemID)
 		return sql.NullInt64{Int64: itemID, Valid: true}, true
 	}
 
@@ -2054,6 +3741,10 @@ func findItemIDInCache(cleanItemName string, slots int) (sql.NullInt64, bool) {
 			// Check perfect match case-insensitive first within candidates
 			lowerNameEN := strings.ToLower(match.name)
 			if lowerNameEN == lowerCleanItemName || strings.ToLower(match.namePT) == lowerCleanItemName {
+				if trace != nil {
+					trace.LocalCandidates = append(trace.LocalCandidates, ItemMatchCandidate{ID: match.id, Name: match.name, NamePT: match.namePT})
+				}
+				trace.step("local-levenshtein", "Case-insensitive exact name among candidates: ID %d.", match.id)
 				return sql.NullInt64{Int64: match.id, Valid: true}, true
 			}
 
@@ -2070,6 +3761,10 @@ func findItemIDInCache(cleanItemName string, slots int) (sql.NullInt64, bool) {
 				}
 			}
 
+			if trace != nil {
+				trace.LocalCandidates = append(trace.LocalCandidates, ItemMatchCandidate{ID: match.id, Name: match.name, NamePT: match.namePT, Distance: currentMinDist})
+			}
+
 			if currentMinDist < minDistance {
 				minDistance = currentMinDist
 				bestMatchID = match.id
@@ -2080,14 +3775,19 @@ func findItemIDInCache(cleanItemName string, slots int) (sql.NullInt64, bool) {
 
 	if bestMatchID != -1 && minDistance <= maxLevenshteinDistance {
 		log.Printf("[D] [ItemID] Accepting proximity match for '%s' -> '%s' (ID %d). Dist: %d", cleanItemName, bestMatchName, bestMatchID, minDistance)
+		trace.step("local-levenshtein", "Closest candidate '%s' (ID %d) at distance %d is within %d; accepted.", bestMatchName, bestMatchID, minDistance, maxLevenshteinDistance)
 		return sql.NullInt64{Int64: bestMatchID, Valid: true}, true
 	}
+	if bestMatchID != -1 {
+		trace.step("local-levenshtein", "Closest candidate '%s' (ID %d) at distance %d exceeds %d; rejected.", bestMatchName, bestMatchID, minDistance, maxLevenshteinDistance)
+	}
 
 	// Fallback for Cards if generic search failed but keyword exists
 	if strings.Contains(lowerCleanItemName, "card") || strings.Contains(lowerCleanItemName, "carta") {
 		if len(potentialMatches) > 0 {
 			itemID := potentialMatches[0].id
 			log.Printf("[D] [ItemID] Using first result (ID %d) due to 'card' keyword fallback.", itemID)
+			trace.step("local-card-fallback", "Name mentions a card; taking the first candidate, ID %d.", itemID)
 			return sql.NullInt64{Int64: itemID, Valid: true}, true
 		}
 	}
@@ -2097,19 +3797,29 @@ func findItemIDInCache(cleanItemName string, slots int) (sql.NullInt64, bool) {
 
 // findItemIDOnline performs a web scrape to find an item ID.
 func findItemIDOnline(cleanItemName string, slots int) (sql.NullInt64, bool) {
+	return matchItemOnline(cleanItemName, slots, nil)
+}
+
+// matchItemOnline is findItemIDOnline with an optional decision trace.
+func matchItemOnline(cleanItemName string, slots int, trace *ItemMatchReport) (sql.NullInt64, bool) {
 	log.Printf("[D] [ItemID] No local FTS match for '%s'. Initiating online search...", cleanItemName)
 
 	rdbResults, rodbErr := scrapeRODatabaseSearch(cleanItemName, slots)
 	if rodbErr != nil {
 		log.Printf("[W] [ItemID] RDB Search failed for '%s': %v", cleanItemName, rodbErr)
+		trace.step("online", "rodatabase search failed: %v", rodbErr)
 	}
 
 	combinedIDs := make(map[int]string)
 	for _, res := range rdbResults {
 		if _, ok := combinedIDs[res.ID]; !ok {
 			combinedIDs[res.ID] = res.Name
+			if trace != nil {
+				trace.OnlineResults = append(trace.OnlineResults, ItemMatchCandidate{ID: int64(res.ID), Name: res.Name})
+			}
 		}
 	}
+	trace.step("online", "rodatabase returned %d distinct item(s) for '%s'.", len(combinedIDs), cleanItemName)
 
 	if len(combinedIDs) == 1 {
 		var foundID int
@@ -2119,6 +3829,7 @@ func findItemIDOnline(cleanItemName string, slots int) (sql.NullInt64, bool) {
 			_ = name // foundName = name
 		}
 		log.Printf("[D] [ItemID] Found unique ONLINE match for '%s': ID %d", cleanItemName, foundID)
+		trace.step("online", "Unique online match: ID %d.", foundID)
 		// --- MODIFICATION: Removed background caching ---
 		// go scrapeAndCacheItemIfNotExists(foundID, foundName) // This function no longer exists
 		// --- END MODIFICATION ---
@@ -2133,6 +3844,7 @@ func findItemIDOnline(cleanItemName string, slots int) (sql.NullInt64, bool) {
 
 			if name == cleanItemName || (nameWithoutSlots != "" && nameWithoutSlots == cleanItemName) {
 				log.Printf("[D] [ItemID] Found perfect match (exact or slot-stripped) '%s' (ID %d) within ONLINE results.", cleanItemName, id)
+				trace.step("online", "Online result '%s' matches exactly: ID %d.", name, id)
 				// --- MODIFICATION: Removed background caching ---
 				// go scrapeAndCacheItemIfNotExists(id, name) // This function no longer exists
 				// --- END MODIFICATION ---
@@ -2151,6 +3863,7 @@ func findItemIDOnline(cleanItemName string, slots int) (sql.NullInt64, bool) {
 				break    // Get the first one
 			}
 			log.Printf("[D] [ItemID] Found %d ONLINE matches for '%s'. Using first result (ID %d) due to 'card'/'carta' keyword.", len(combinedIDs), cleanItemName, foundID)
+			trace.step("online-card-fallback", "Name mentions a card; taking online result ID %d of %d.", foundID, len(combinedIDs))
 			// --- MODIFICATION: Removed background caching ---
 			// go scrapeAndCacheItemIfNotExists(foundID, foundName) // This function no longer exists
 			// --- END MODIFICATION ---
@@ -2158,6 +3871,7 @@ func findItemIDOnline(cleanItemName string, slots int) (sql.NullInt64, bool) {
 		}
 
 		log.Printf("[D] [ItemID] Found %d ambiguous ONLINE matches for '%s'. Not selecting.", len(combinedIDs), cleanItemName)
+		trace.step("online", "%d online results and none match exactly; ambiguous.", len(combinedIDs))
 	}
 
 	// No online matches or ambiguous matches
@@ -2185,7 +3899,7 @@ func woeRankingsHandler(w http.ResponseWriter, r *http.Request) {
 	var allSeasons []WoeSeasonInfo
 	seasonRows, err := srv.db.Query("SELECT season_id, start_date, end_date FROM woe_seasons ORDER BY start_date DESC")
 	if err != nil {
-		log.Printf("[E] [HTTP/WoE] Could not query for WoE seasons: %v", err)
+		logRequestf(r, "[E] [HTTP/WoE] Could not query for WoE seasons: %v", err)
 		http.Error(w, "Could not query WoE seasons", http.StatusInternalServerError)
 		return
 	}
@@ -2199,7 +3913,7 @@ func woeRankingsHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		if t, err := time.Parse(time.RFC3339, startDate); err == nil {
-			s.StartDate = t.Format("2006-01-02")
+			s.StartDate = displayTime(t).Format("2006-01-02")
 		}
 		allSeasons = append(allSeasons, s)
 	}
@@ -2219,7 +3933,7 @@ func woeRankingsHandler(w http.ResponseWriter, r *http.Request) {
 	var eventsForSeason []WoeEventInfo
 	eventRows, err := srv.db.Query("SELECT event_id, event_date, is_season_summary FROM woe_events WHERE season_id = ? ORDER BY event_date DESC", selectedSeasonID)
 	if err != nil {
-		log.Printf("[E] [HTTP/WoE] Could not query for WoE events: %v", err)
+		logRequestf(r, "[E] [HTTP/WoE] Could not query for WoE events: %v", err)
 		http.Error(w, "Could not query WoE events", http.StatusInternalServerError)
 		return
 	}
@@ -2234,7 +3948,7 @@ func woeRankingsHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		if t, err := time.Parse(time.RFC3339, eventDate); err == nil {
-			e.EventDate = t.Format("2006-01-02 15:04")
+			e.EventDate = displayTime(t).Format("2006-01-02 15:04")
 		}
 		eventsForSeason = append(eventsForSeason, e)
 	}
@@ -2312,104 +4026,50 @@ func woeRankingsHandler(w http.ResponseWriter, r *http.Request) {
 			SELECT
 				guild_name, guild_id, COUNT(*) AS member_count,
 				SUM(kill_count) AS total_kills, SUM(death_count) AS total_deaths,
-				SUM(damage_done) AS total_damage, SUM(healing_done) AS total_healing,
-				SUM(emperium_kill) AS total_emp_kills, SUM(points) AS total_points,
-				CASE
-					WHEN SUM(death_count) = 0 THEN SUM(kill_count)
-					ELSE CAST(SUM(kill_count) AS REAL) / SUM(death_count)
-				END AS kd_ratio
-			FROM woe_event_rankings
-			%s -- whereClause
-			GROUP BY guild_name, guild_id
-			%s -- orderByClause
-		`, whereClause, orderByClause)
-
-		rows, err := srv.db.Query(query, queryParams...)
-		if err != nil {
-			log.Printf("[E] [HTTP/WoE] Could not query for WoE guild rankings: %v", err)
-			http.Error(w, "Could not query WoE guild rankings", http.StatusInternalServerError)
-			return
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var g WoeGuildRank
-			if err := rows.Scan(
-				&g.GuildName, &g.GuildID, &g.MemberCount, &g.TotalKills, &g.TotalDeaths,
-				&g.TotalDamage, &g.TotalHealing, &g.TotalEmpKills, &g.TotalPoints, &g.KillDeathRatio,
-			); err != nil {
-				log.Printf("[W] [HTTP/WoE] Failed to scan WoE guild row: %v", err)
-				continue
-			}
-			guilds = append(guilds, g)
-		}
-
-	} else if activeTab == "guilds_by_class" {
-		// --- GUILD BY CLASS RANKING LOGIC ---
-		allowedSorts = map[string]string{
-			"guild": "guild_name", "class": "class", "members": "member_count", "kills": "total_kills",
-			"deaths": "total_deaths", "kd": "kd_ratio", "damage": "total_damage",
-			"healing": "total_healing", "emperium": "total_emp_kills", "points": "total_points",
-		}
-		orderByClause, sortBy, order = httpx.GetSortClause(r, allowedSorts, "guild", "ASC")
-		if sortBy == "guild" {
-			orderByClause = fmt.Sprintf("ORDER BY guild_name %s, total_kills DESC", order)
-		}
-		filterValues.Set("sort_by", sortBy)
-		filterValues.Set("order", order)
-
-		whereConditions = append(whereConditions, "guild_name IS NOT NULL AND guild_name != ''")
-
-		if searchQuery != "" {
-			whereConditions = append(whereConditions, "guild_name LIKE ?")
-			queryParams = append(queryParams, "%"+searchQuery+"%")
-		}
-		if selectedClass != "" {
-			whereConditions = append(whereConditions, "class = ?")
-			queryParams = append(queryParams, selectedClass)
-		}
-		whereClause = "WHERE " + strings.Join(whereConditions, " AND ")
-
-		query := fmt.Sprintf(`
-			SELECT
-				guild_name, class,
-				COUNT(character_name) AS member_count,
-				SUM(kill_count) AS total_kills,
-				SUM(death_count) AS total_deaths,
-				SUM(damage_done) AS total_damage,
-				SUM(healing_done) AS total_healing,
-				SUM(emperium_kill) AS total_emp_kills,
-				SUM(points) AS total_points,
+				SUM(damage_done) AS total_damage, SUM(healing_done) AS total_healing,
+				SUM(emperium_kill) AS total_emp_kills, SUM(points) AS total_points,
 				CASE
 					WHEN SUM(death_count) = 0 THEN SUM(kill_count)
 					ELSE CAST(SUM(kill_count) AS REAL) / SUM(death_count)
 				END AS kd_ratio
 			FROM woe_event_rankings
 			%s -- whereClause
-			GROUP BY guild_name, class
+			GROUP BY guild_name, guild_id
 			%s -- orderByClause
 		`, whereClause, orderByClause)
 
 		rows, err := srv.db.Query(query, queryParams...)
 		if err != nil {
-			log.Printf("[E] [HTTP/WoE] Could not query for WoE guild-by-class rankings: %v", err)
-			http.Error(w, "Could not query WoE guild-by-class rankings", http.StatusInternalServerError)
+			logRequestf(r, "[E] [HTTP/WoE] Could not query for WoE guild rankings: %v", err)
+			http.Error(w, "Could not query WoE guild rankings", http.StatusInternalServerError)
 			return
 		}
 		defer rows.Close()
 
-		guildsByClassMap = make(map[string][]WoeGuildClassRank)
 		for rows.Next() {
-			var guildName string
-			var g WoeGuildClassRank
+			var g WoeGuildRank
 			if err := rows.Scan(
-				&guildName, &g.Class, &g.MemberCount, &g.TotalKills, &g.TotalDeaths,
+				&g.GuildName, &g.GuildID, &g.MemberCount, &g.TotalKills, &g.TotalDeaths,
 				&g.TotalDamage, &g.TotalHealing, &g.TotalEmpKills, &g.TotalPoints, &g.KillDeathRatio,
 			); err != nil {
-				log.Printf("[W] [HTTP/WoE] Failed to scan WoE guild-by-class row: %v", err)
+				log.Printf("[W] [HTTP/WoE] Failed to scan WoE guild row: %v", err)
 				continue
 			}
-			guildsByClassMap[guildName] = append(guildsByClassMap[guildName], g)
+			guilds = append(guilds, g)
+		}
+
+	} else if activeTab == "guilds_by_class" {
+		// --- GUILD BY CLASS RANKING LOGIC ---
+		orderByClause, sortBy, order = woeGuildClassSort(r)
+		filterValues.Set("sort_by", sortBy)
+		filterValues.Set("order", order)
+
+		var err error
+		guildsByClassMap, err = fetchWoeGuildClassRanks(selectedEventID, searchQuery, selectedClass, orderByClause)
+		if err != nil {
+			logRequestf(r, "[E] [HTTP/WoE] %v", err)
+			http.Error(w, "Could not query WoE guild-by-class rankings", http.StatusInternalServerError)
+			return
 		}
 
 	} else {
@@ -2443,7 +4103,7 @@ func woeRankingsHandler(w http.ResponseWriter, r *http.Request) {
 
 		rows, err := srv.db.Query(query, queryParams...)
 		if err != nil {
-			log.Printf("[E] [HTTP/WoE] Could not query for WoE character rankings: %v", err)
+			logRequestf(r, "[E] [HTTP/WoE] Could not query for WoE character rankings: %v", err)
 			http.Error(w, "Could not query WoE rankings", http.StatusInternalServerError)
 			return
 		}
@@ -2492,7 +4152,239 @@ func woeRankingsHandler(w http.ResponseWriter, r *http.Request) {
 		AllClasses:    allClasses,
 		SelectedClass: selectedClass,
 	}
-	renderTemplate(w, r, "woe_rankings.html", data)
+	renderSortedTemplate(w, r, "woe_rankings.html", data, data.SortBy)
+}
+
+// woeGuildClassSort returns the ORDER BY for the guild-by-class ranking.
+// Sorting by guild keeps each guild's classes ordered by kills.
+func woeGuildClassSort(r *http.Request) (orderByClause, sortBy, order string) {
+	allowedSorts := map[string]string{
+		"guild": "guild_name", "class": "class", "members": "member_count", "kills": "total_kills",
+		"deaths": "total_deaths", "kd": "kd_ratio", "damage": "total_damage",
+		"healing": "total_healing", "emperium": "total_emp_kills", "points": "total_points",
+	}
+	orderByClause, sortBy, order = httpx.GetSortClause(r, allowedSorts, "guild", "ASC")
+	if sortBy == "guild" {
+		orderByClause = fmt.Sprintf("ORDER BY guild_name %s, total_kills DESC", order)
+	}
+	return orderByClause, sortBy, order
+}
+
+// fetchWoeGuildClassRanks aggregates one WoE event's rankings per guild
+// and class, keyed by guild name. searchQuery filters guild names
+// (substring) and class, when set, keeps a single class.
+func fetchWoeGuildClassRanks(eventID int, searchQuery, class, orderByClause string) (map[string][]WoeGuildClassRank, error) {
+	whereConditions := []string{"event_id = ?", "guild_name IS NOT NULL AND guild_name != ''"}
+	queryParams := []interface{}{eventID}
+	if searchQuery != "" {
+		whereConditions = append(whereConditions, "guild_name LIKE ?")
+		queryParams = append(queryParams, "%"+searchQuery+"%")
+	}
+	if class != "" {
+		whereConditions = append(whereConditions, "class = ?")
+		queryParams = append(queryParams, class)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			guild_name, class,
+			COUNT(character_name) AS member_count,
+			SUM(kill_count) AS total_kills,
+			SUM(death_count) AS total_deaths,
+			SUM(damage_done) AS total_damage,
+			SUM(healing_done) AS total_healing,
+			SUM(emperium_kill) AS total_emp_kills,
+			SUM(points) AS total_points,
+			CASE
+				WHEN SUM(death_count) = 0 THEN SUM(kill_count)
+				ELSE CAST(SUM(kill_count) AS REAL) / SUM(death_count)
+			END AS kd_ratio
+		FROM woe_event_rankings
+		WHERE %s
+		GROUP BY guild_name, class
+		%s`, strings.Join(whereConditions, " AND "), orderByClause)
+
+	rows, err := srv.db.Query(query, queryParams...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query for WoE guild-by-class rankings: %w", err)
+	}
+	defer rows.Close()
+
+	guildsByClass := make(map[string][]WoeGuildClassRank)
+	for rows.Next() {
+		var guildName string
+		var g WoeGuildClassRank
+		if err := rows.Scan(
+			&guildName, &g.Class, &g.MemberCount, &g.TotalKills, &g.TotalDeaths,
+			&g.TotalDamage, &g.TotalHealing, &g.TotalEmpKills, &g.TotalPoints, &g.KillDeathRatio,
+		); err != nil {
+			log.Printf("[W] [HTTP/WoE] Failed to scan WoE guild-by-class row: %v", err)
+			continue
+		}
+		guildsByClass[guildName] = append(guildsByClass[guildName], g)
+	}
+	return guildsByClass, rows.Err()
+}
+
+// woeByClassJSONHandler serves /woe/by-class.json, the JSON twin of the
+// guilds_by_class tab of /woe. It takes the same season_id, event_id,
+// query (guild name), class_filter, sort_by and order params; without
+// an event_id it uses the latest event of the season, or of the latest
+// season when season_id is missing too.
+func woeByClassJSONHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	seasonID, _ := strconv.Atoi(q.Get("season_id"))
+	eventID, _ := strconv.Atoi(q.Get("event_id"))
+
+	eventQuery := "SELECT event_id, season_id, event_date FROM woe_events"
+	var conditions []string
+	var params []interface{}
+	if eventID > 0 {
+		conditions = append(conditions, "event_id = ?")
+		params = append(params, eventID)
+	}
+	if seasonID > 0 {
+		conditions = append(conditions, "season_id = ?")
+		params = append(params, seasonID)
+	}
+	if len(conditions) > 0 {
+		eventQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	eventQuery += " ORDER BY event_date DESC LIMIT 1"
+
+	var resp WoeGuildClassExport
+	var eventDate string
+	err := srv.db.QueryRow(eventQuery, params...).Scan(&resp.EventID, &resp.SeasonID, &eventDate)
+	if err == sql.ErrNoRows {
+		http.Error(w, "WoE event not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logRequestf(r, "[E] [HTTP/WoE] Could not resolve WoE event: %v", err)
+		http.Error(w, "Could not query WoE events", http.StatusInternalServerError)
+		return
+	}
+	resp.EventDate = eventDate
+	if t, err := time.Parse(time.RFC3339, eventDate); err == nil {
+		resp.EventDate = displayTime(t).Format("2006-01-02 15:04")
+	}
+	resp.GuildQuery = q.Get("query")
+	resp.Class = q.Get("class_filter")
+
+	orderByClause, _, _ := woeGuildClassSort(r)
+	resp.Guilds, err = fetchWoeGuildClassRanks(resp.EventID, resp.GuildQuery, resp.Class, orderByClause)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/WoE] %v", err)
+		http.Error(w, "Could not query WoE guild-by-class rankings", http.StatusInternalServerError)
+		return
+	}
+
+	if err := httpx.WriteJSON(w, http.StatusOK, resp); err != nil {
+		log.Printf("[W] [HTTP/WoE] Failed to write by-class JSON: %v", err)
+	}
+}
+
+// woeMatchupHandler serves /woe/matchup?a=GuildA&b=GuildB: both guilds'
+// per-event totals side by side, newest event first. Season summary rows
+// are skipped since they repeat the per-event numbers.
+func woeMatchupHandler(w http.ResponseWriter, r *http.Request) {
+	data := WoeMatchupPageData{
+		PageTitle: "WoE Rankings",
+		GuildA:    strings.TrimSpace(r.URL.Query().Get("a")),
+		GuildB:    strings.TrimSpace(r.URL.Query().Get("b")),
+	}
+
+	guildRows, err := srv.db.Query(`
+		SELECT DISTINCT guild_name FROM woe_event_rankings
+		WHERE guild_name IS NOT NULL AND guild_name != ''
+		ORDER BY guild_name COLLATE NOCASE`)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/WoE] Could not query WoE guild names: %v", err)
+	} else {
+		defer guildRows.Close()
+		for guildRows.Next() {
+			var name string
+			if err := guildRows.Scan(&name); err == nil {
+				data.AllGuilds = append(data.AllGuilds, name)
+			}
+		}
+	}
+
+	if data.GuildA == "" || data.GuildB == "" || strings.EqualFold(data.GuildA, data.GuildB) {
+		renderTemplate(w, r, "woe_matchup.html", data)
+		return
+	}
+
+	rows, err := srv.db.Query(`
+		SELECT
+			e.event_id, e.season_id, e.event_date, r.guild_name, COUNT(*),
+			SUM(r.kill_count), SUM(r.death_count), SUM(r.damage_done), SUM(r.points)
+		FROM woe_event_rankings r
+		JOIN woe_events e ON e.event_id = r.event_id
+		WHERE e.is_season_summary = 0
+		  AND (r.guild_name = ? COLLATE NOCASE OR r.guild_name = ? COLLATE NOCASE)
+		GROUP BY e.event_id, r.guild_name COLLATE NOCASE
+		ORDER BY e.event_date DESC, e.event_id DESC`, data.GuildA, data.GuildB)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/WoE] Could not query matchup '%s' vs '%s': %v", data.GuildA, data.GuildB, err)
+		http.Error(w, "Could not query WoE matchup", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var eventID, seasonID int
+		var eventDate, guildName string
+		var side WoeMatchupSide
+		if err := rows.Scan(&eventID, &seasonID, &eventDate, &guildName, &side.Members, &side.Kills, &side.Deaths, &side.Damage, &side.Points); err != nil {
+			log.Printf("[W] [HTTP/WoE] Failed to scan matchup row: %v", err)
+			continue
+		}
+		if n := len(data.Events); n == 0 || data.Events[n-1].EventID != eventID {
+			if t, err := time.Parse(time.RFC3339, eventDate); err == nil {
+				eventDate = displayTime(t).Format("2006-01-02 15:04")
+			}
+			data.Events = append(data.Events, WoeMatchupEvent{EventID: eventID, SeasonID: seasonID, EventDate: eventDate})
+		}
+		ev := &data.Events[len(data.Events)-1]
+		if strings.EqualFold(guildName, data.GuildA) {
+			ev.A = &side
+		} else {
+			ev.B = &side
+		}
+	}
+
+	for i := range data.Events {
+		ev := &data.Events[i]
+		if ev.A != nil {
+			data.EventsA++
+			addWoeMatchupSide(&data.TotalA, ev.A)
+		}
+		if ev.B != nil {
+			data.EventsB++
+			addWoeMatchupSide(&data.TotalB, ev.B)
+		}
+		if ev.A != nil && ev.B != nil {
+			data.EventsBoth++
+			switch {
+			case ev.A.Points > ev.B.Points:
+				ev.Leader = "a"
+				data.WinsA++
+			case ev.B.Points > ev.A.Points:
+				ev.Leader = "b"
+				data.WinsB++
+			}
+		}
+	}
+
+	renderTemplate(w, r, "woe_matchup.html", data)
+}
+
+func addWoeMatchupSide(total, s *WoeMatchupSide) {
+	total.Members += s.Members
+	total.Kills += s.Kills
+	total.Deaths += s.Deaths
+	total.Damage += s.Damage
+	total.Points += s.Points
 }
 
 type ChatActivityPoint struct {
@@ -2500,12 +4392,38 @@ type ChatActivityPoint struct {
 	Value     int    `json:"v"`
 }
 
+// Chat activity graph window: ?activity_hours= sets how far back the
+// graph reaches and ?activity_bucket= the bucket size in minutes. Windows
+// whose bucket count would exceed chatActivityMaxPoints get wider buckets.
+const (
+	chatActivityDefaultHours  = 24
+	chatActivityDefaultBucket = 3
+	chatActivityMaxHours      = 30 * 24
+	chatActivityMaxBucket     = 24 * 60
+	chatActivityMaxPoints     = 2000
+)
+
+// chatActivityWindow reads and clamps the activity graph query params,
+// returning the window in hours and the bucket size in minutes.
+func chatActivityWindow(r *http.Request) (hours, bucketMinutes int) {
+	hours, bucketMinutes = chatActivityDefaultHours, chatActivityDefaultBucket
+	if v, err := strconv.Atoi(r.URL.Query().Get("activity_hours")); err == nil && v > 0 {
+		hours = min(v, chatActivityMaxHours)
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("activity_bucket")); err == nil && v > 0 {
+		bucketMinutes = min(v, chatActivityMaxBucket)
+	}
+	if minBucket := (hours*60 + chatActivityMaxPoints - 1) / chatActivityMaxPoints; bucketMinutes < minBucket {
+		bucketMinutes = minBucket
+	}
+	return hours, bucketMinutes
+}
+
 // getChatActivityGraphData queries the DB for activity heartbeats in the
-// last 24 hours and formats them for a Chart.js graph.
-func getChatActivityGraphData() template.JS {
+// last `hours` and returns them as JSON buckets of `bucketMinutes` each.
+func getChatActivityGraphData(hours, bucketMinutes int) template.JS {
 	now := time.Now()
-	// Start from exactly 24 hours ago, truncated to the minute
-	viewStart := now.Add(-24 * time.Hour).Truncate(time.Minute)
+	viewStart := now.Add(-time.Duration(hours) * time.Hour).Truncate(time.Minute)
 
 	// 1. Get all heartbeats from the DB in the time range
 	rows, err := srv.db.Query("SELECT timestamp FROM chat_activity_log WHERE timestamp >= ?", viewStart.Format(time.RFC3339))
@@ -2515,45 +4433,19 @@ func getChatActivityGraphData() template.JS {
 	}
 	defer rows.Close()
 
-	// Store found heartbeats in a map for fast lookup
-	activeMinutes := make(map[string]bool)
+	var heartbeats []time.Time
 	for rows.Next() {
 		var ts string
-		if err := rows.Scan(&ts); err == nil {
-			activeMinutes[ts] = true
+		if err := rows.Scan(&ts); err != nil {
+			continue
 		}
-	}
-
-	// 2. Build the full 1440-point (24 * 60) dataset
-	var graphData []ChatActivityPoint
-	currentMinute := viewStart
-
-	// Loop from 24 hours ago up to the current minute, in 3-minute steps
-	for currentMinute.Before(now) {
-		timestampStr := currentMinute.Format(time.RFC3339)
-
-		// Check the next two minutes as well to cover the 3-minute window
-		minute2 := currentMinute.Add(1 * time.Minute)
-		minute2Str := minute2.Format(time.RFC3339)
-		minute3 := currentMinute.Add(2 * time.Minute)
-		minute3Str := minute3.Format(time.RFC3339)
-
-		value := 0
-		// Check if *any* minute in the 3-minute window has activity
-		if activeMinutes[timestampStr] || activeMinutes[minute2Str] || activeMinutes[minute3Str] {
-			value = 1 // Active
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			heartbeats = append(heartbeats, t)
 		}
-
-		graphData = append(graphData, ChatActivityPoint{
-			Timestamp: timestampStr, // The timestamp for the *start* of the 3-minute bucket
-			Value:     value,
-		})
-
-		// Increment by 3 minutes for the next bucket
-		currentMinute = currentMinute.Add(3 * time.Minute)
 	}
 
-	// 3. Marshal to JSON
+	// 2. Bucket them and marshal to JSON
+	graphData := bucketChatActivity(heartbeats, viewStart, now, time.Duration(bucketMinutes)*time.Minute)
 	jsonData, err := json.Marshal(graphData)
 	if err != nil {
 		log.Printf("[E] [HTTP/Chat] Could not marshal activity graph data: %v", err)
@@ -2563,17 +4455,86 @@ func getChatActivityGraphData() template.JS {
 	return template.JS(jsonData)
 }
 
+// bucketChatActivity splits [start, end) into buckets of the given size,
+// each stamped with its start time. A bucket is active (1) when any
+// heartbeat falls inside it.
+func bucketChatActivity(heartbeats []time.Time, start, end time.Time, bucket time.Duration) []ChatActivityPoint {
+	var points []ChatActivityPoint
+	for t := start; t.Before(end); t = t.Add(bucket) {
+		points = append(points, ChatActivityPoint{Timestamp: t.Format(time.RFC3339)})
+	}
+	for _, hb := range heartbeats {
+		if hb.Before(start) {
+			continue
+		}
+		if idx := int(hb.Sub(start) / bucket); idx < len(points) {
+			points[idx].Value = 1
+		}
+	}
+	return points
+}
+
+// Limits for ?mode=regex chat searches, which filter in Go rather than
+// SQL: patterns longer than chatRegexMaxLen are refused, and at most
+// chatRegexScanLimit of the newest messages are scanned, stopping early
+// once chatRegexTimeBudget has elapsed.
+const (
+	chatRegexMaxLen     = 256
+	chatRegexScanLimit  = 50000
+	chatRegexTimeBudget = 2 * time.Second
+)
+
+// searchChatRegex returns the messages matching whereClause whose text or
+// author matches re, newest first. truncated reports that the scan limit
+// or time budget cut the search short.
+func searchChatRegex(whereClause string, params []interface{}, re *regexp.Regexp) (messages []ChatMessage, truncated bool, err error) {
+	query := fmt.Sprintf(`
+		SELECT timestamp, channel, character_name, message
+		FROM chat
+		%s
+		ORDER BY timestamp DESC
+		LIMIT ?`, whereClause)
+	rows, err := srv.db.Query(query, append(params, chatRegexScanLimit)...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	deadline := time.Now().Add(chatRegexTimeBudget)
+	scanned := 0
+	for rows.Next() {
+		scanned++
+		if scanned%1000 == 0 && time.Now().After(deadline) {
+			return messages, true, nil
+		}
+		var msg ChatMessage
+		if err := rows.Scan(&msg.Timestamp, &msg.Channel, &msg.CharacterName, &msg.Message); err != nil {
+			log.Printf("[W] [HTTP/Chat] Failed to scan chat message row: %v", err)
+			continue
+		}
+		if re.MatchString(msg.Message) || re.MatchString(msg.CharacterName) {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, scanned >= chatRegexScanLimit, rows.Err()
+}
+
 // This handler is now much simpler and only handles chat logs.
 func chatHandler(w http.ResponseWriter, r *http.Request) {
 	const messagesPerPage = 100
 	activeChannel := r.URL.Query().Get("channel")
 	searchQuery := r.URL.Query().Get("query")
+	searchMode := r.URL.Query().Get("mode")
 	if activeChannel == "" {
 		activeChannel = "all" // Default to "all"
 	}
+	if searchMode != "phrase" && searchMode != "regex" {
+		searchMode = ""
+	}
 
 	// 1. Get all unique channels for tabs
 	allChannels := getAllChatChannels() // Reverted to include "Drop"
+	activityHours, activityBucket := chatActivityWindow(r)
 
 	// Initialize Page Data
 	data := ChatPageData{
@@ -2582,7 +4543,10 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 		AllChannels:       allChannels,
 		ActiveChannel:     activeChannel,
 		SearchQuery:       searchQuery,
-		ActivityGraphJSON: getChatActivityGraphData(),
+		SearchMode:        searchMode,
+		ActivityGraphJSON: getChatActivityGraphData(activityHours, activityBucket),
+		ActivityHours:     activityHours,
+		SnifferDisabled:   !chatSnifferEnabled(),
 	}
 
 	// 2. Build Filter URL (for pagination)
@@ -2593,6 +4557,9 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 	if searchQuery != "" {
 		queryFilter.Set("query", searchQuery)
 	}
+	if searchMode != "" {
+		queryFilter.Set("mode", searchMode)
+	}
 	var filterString string
 	if encodedFilter := queryFilter.Encode(); encodedFilter != "" {
 		filterString = "&" + encodedFilter
@@ -2615,12 +4582,38 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 		params = append(params, activeChannel)
 	}
 
-	if searchQuery != "" {
-		whereConditions = append(whereConditions, "(message LIKE ? OR character_name LIKE ?)")
-		likeQuery := "%" + searchQuery + "%"
+	// Regex searches are applied after the query, in searchChatRegex.
+	var searchRegex *regexp.Regexp
+	switch {
+	case searchQuery == "":
+	case searchMode == "regex":
+		var err error
+		if len(searchQuery) > chatRegexMaxLen {
+			data.SearchError = fmt.Sprintf("pattern is longer than %d characters", chatRegexMaxLen)
+		} else if searchRegex, err = regexp.Compile(searchQuery); err != nil {
+			data.SearchError = err.Error()
+		}
+	case searchMode == "phrase":
+		// Case-sensitive and verbatim; surrounding quotes are optional.
+		if phrase := strings.Trim(searchQuery, `"`); phrase != "" {
+			whereConditions = append(whereConditions, "instr(message, ?) > 0")
+			params = append(params, phrase)
+		}
+	default:
+		whereConditions = append(whereConditions, `(message LIKE ? ESCAPE '\' OR character_name LIKE ? ESCAPE '\')`)
+		likeQuery := "%" + escapeLike(searchQuery) + "%"
 		params = append(params, likeQuery, likeQuery)
 	}
 
+	// Pseudo-characters are hidden from player channels; the system
+	// channels are written only by them.
+	if !slices.Contains(chatSystemChannels, activeChannel) {
+		if exclude, excludeArgs := excludedNamesCondition("character_name"); exclude != "" {
+			whereConditions = append(whereConditions, exclude)
+			params = append(params, excludeArgs...)
+		}
+	}
+
 	// Filter out noisy system messages (only applies if "Drop" channel is selected)
 	if activeChannel == "Drop" {
 		whereConditions = append(whereConditions, "NOT (channel = 'Drop' AND character_name = 'System' AND (message LIKE '%Os Campos de Batalha%' OR message LIKE '%Utilizem os efeitos%'))")
@@ -2628,10 +4621,35 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 
 	whereClause := "WHERE " + strings.Join(whereConditions, " AND ")
 
+	if data.SearchError != "" {
+		data.Pagination = httpx.NewPaginationData(r, 0, messagesPerPage)
+		renderTemplate(w, r, "chat.html", data)
+		return
+	}
+	if searchRegex != nil {
+		matches, truncated, err := searchChatRegex(whereClause, params, searchRegex)
+		if err != nil {
+			logRequestf(r, "[E] [HTTP/Chat] Regex chat search failed: %v", err)
+			http.Error(w, "Could not search chat messages", http.StatusInternalServerError)
+			return
+		}
+		data.SearchTruncated = truncated
+		data.Pagination = httpx.NewPaginationData(r, len(matches), messagesPerPage)
+		end := min(data.Pagination.Offset+messagesPerPage, len(matches))
+		for _, msg := range matches[min(data.Pagination.Offset, end):end] {
+			if parsedTime, err := time.Parse(time.RFC3339, msg.Timestamp); err == nil {
+				msg.Timestamp = displayTime(parsedTime).Format("2006-01-02 15:04:05")
+			}
+			data.Messages = append(data.Messages, msg)
+		}
+		renderTemplate(w, r, "chat.html", data)
+		return
+	}
+
 	// 4. Get total count
 	totalMessages, err := queryCount(fmt.Sprintf("SELECT COUNT(*) FROM chat %s", whereClause), params...)
 	if err != nil {
-		log.Printf("[E] [HTTP/Chat] Could not count chat messages: %v", err)
+		logRequestf(r, "[E] [HTTP/Chat] Could not count chat messages: %v", err)
 		http.Error(w, "Could not count chat messages", http.StatusInternalServerError)
 		return
 	}
@@ -2650,7 +4668,7 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 	queryArgs := append(params, data.Pagination.ItemsPerPage, data.Pagination.Offset)
 	rows, err := srv.db.Query(query, queryArgs...)
 	if err != nil {
-		log.Printf("[E] [HTTP/Chat] Could not query for chat messages: %v", err)
+		logRequestf(r, "[E] [HTTP/Chat] Could not query for chat messages: %v", err)
 		http.Error(w, "Could not query for chat messages", http.StatusInternalServerError)
 		return
 	}
@@ -2665,7 +4683,7 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		if parsedTime, err := time.Parse(time.RFC3339, timestampStr); err == nil {
-			msg.Timestamp = parsedTime.Format("2006-01-02 15:04:05")
+			msg.Timestamp = displayTime(parsedTime).Format("2006-01-02 15:04:05")
 		} else {
 			msg.Timestamp = timestampStr
 		}
@@ -2704,31 +4722,98 @@ func getMarketStatsInterval(r *http.Request) (string, string) {
 	return intervalStr, startTime
 }
 
+// getMarketStatsRange reads the optional ?start= and ?end= bounds of the
+// market stats page, each RFC3339 or YYYY-MM-DD. Dates are days in
+// displayLocation, and an end date includes its whole day. ok is false,
+// and the page keeps its ?interval=, unless both parse and start comes
+// before end.
+func getMarketStatsRange(r *http.Request) (start, end time.Time, ok bool) {
+	start, startOK := parseStatsRangeTime(r.URL.Query().Get("start"), false)
+	end, endOK := parseStatsRangeTime(r.URL.Query().Get("end"), true)
+	if !startOK || !endOK || !start.Before(end) {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// parseStatsRangeTime parses one getMarketStatsRange bound. endOfDay moves
+// a bare date to the last second of that day.
+func parseStatsRangeTime(s string, endOfDay bool) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, displayLocation)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return t, true
+}
+
+// getMarketStatsPriceCap reads the market stats page's ?max_price=, where
+// 0 lifts the cap. custom is false, and statsPriceCap applies, when the
+// parameter is absent or not a non-negative integer.
+func getMarketStatsPriceCap(r *http.Request) (maxPrice int64, custom bool) {
+	p, err := strconv.ParseInt(r.URL.Query().Get("max_price"), 10, 64)
+	if err != nil || p < 0 {
+		return statsPriceCap, false
+	}
+	return p, true
+}
+
 func marketStatsHandler(w http.ResponseWriter, r *http.Request) {
 	// --- Read all params ---
 	selectedInterval, startTime := getMarketStatsInterval(r)
+	var endTime, rangeStart, rangeEnd string
+	if start, end, ok := getMarketStatsRange(r); ok {
+		// Event timestamps are stored in the server's zone, so the
+		// bounds must be too for the string comparison to hold.
+		selectedInterval = "custom"
+		startTime = start.Local().Format(time.RFC3339)
+		endTime = end.Local().Format(time.RFC3339)
+		rangeStart, rangeEnd = r.URL.Query().Get("start"), r.URL.Query().Get("end")
+	}
+	maxPrice, customMaxPrice := getMarketStatsPriceCap(r)
 	itemSortBy := r.URL.Query().Get("isort")
 	itemOrder := r.URL.Query().Get("iorder")
 	sellerSortBy := r.URL.Query().Get("ssort")
 	sellerOrder := r.URL.Query().Get("sorder")
+	showNet := r.URL.Query().Get("net") == "true"
 
 	// --- Logging ---
-	log.Printf("[D] [HTTP/Stats] marketStatsHandler: Interval=%s, StartTime=%s", selectedInterval, startTime)
+	log.Printf("[D] [HTTP/Stats] marketStatsHandler: Interval=%s, StartTime=%s, EndTime=%s, MaxPrice=%d, Net=%t", selectedInterval, startTime, endTime, maxPrice, showNet)
 	log.Printf("[D] [HTTP/Stats] marketStatsHandler: ItemSort=%s, ItemOrder=%s", itemSortBy, itemOrder)
 	log.Printf("[D] [HTTP/Stats] marketStatsHandler: SellerSort=%s, SellerOrder=%s", sellerSortBy, sellerOrder)
 	// --- END: Logging ---
 
 	const topLimit = 20
 
-	// --- MODIFICATION: Added price filter to whereConditions ---
-	// This filters out transactions >= 100,000,000z from all stats on this page.
-	var whereConditions = "WHERE event_type = 'SOLD' AND event_timestamp >= ? AND CAST(REPLACE(json_extract(details, '$.price'), ',', '') AS INTEGER) < 50000000"
-	var params = []interface{}{startTime}
-	// --- END MODIFICATION ---
+	outlierFactor := statsOutlierFactor()
+	var outlierIDs []int64
+	if outlierFactor > 0 {
+		var err error
+		if outlierIDs, err = cachedPriceOutliers(selectedInterval, startTime, endTime, maxPrice, outlierFactor); err != nil {
+			logRequestf(r, "[E] [HTTP/Stats] Could not compute price outliers: %v", err)
+		}
+	}
+	whereConditions, params := marketStatsFilter("", startTime, endTime, maxPrice, outlierIDs)
 
-	// --- Build Filter URL for template (Interval ONLY) ---
+	// --- Build Filter URL for template (Interval and net toggle) ---
 	filterValues := url.Values{}
-	filterValues.Set("interval", selectedInterval)
+	if rangeStart != "" {
+		filterValues.Set("start", rangeStart)
+		filterValues.Set("end", rangeEnd)
+	} else {
+		filterValues.Set("interval", selectedInterval)
+	}
+	if customMaxPrice {
+		filterValues.Set("max_price", strconv.FormatInt(maxPrice, 10))
+	}
+	if showNet {
+		filterValues.Set("net", "true")
+	}
 
 	filterString := ""
 	if encodedFilter := filterValues.Encode(); encodedFilter != "" {
@@ -2739,18 +4824,27 @@ func marketStatsHandler(w http.ResponseWriter, r *http.Request) {
 		PageTitle:        "Market Stats",
 		LastScrapeTime:   GetLastScrapeTime(),
 		SelectedInterval: selectedInterval,
+		RangeStart:       rangeStart,
+		RangeEnd:         rangeEnd,
 		Filter:           template.URL(filterString),
+		Net:              showNet,
+		VendFeePercent:   vendFeePercent(),
+		OutliersRejected: len(outlierIDs),
+		OutlierFactor:    outlierFactor,
+		MaxPrice:         maxPrice,
+		CustomMaxPrice:   customMaxPrice,
 	}
 
 	// 1. Get KPIs
 	kpiQuery := fmt.Sprintf(`
-		SELECT COUNT(*), COALESCE(SUM(CAST(REPLACE(json_extract(details, '$.price'), ',', '') AS INTEGER)), 0)
+		SELECT COUNT(*), COALESCE(SUM(`+marketPriceSQL("json_extract(details, '$.price')")+`), 0)
 		FROM market_events %s`, whereConditions)
 	log.Printf("[D] [HTTP/Stats] KPI Query: %s; Params: %v", kpiQuery, params)
 	err := srv.db.QueryRow(kpiQuery, params...).Scan(&data.TotalSoldItems, &data.TotalZenyTransacted)
 	if err != nil {
-		log.Printf("[E] [HTTP/Stats] Could not query market KPIs: %v", err)
+		logRequestf(r, "[E] [HTTP/Stats] Could not query market KPIs: %v", err)
 	}
+	data.TotalZenyNet = netZeny(data.TotalZenyTransacted)
 
 	// 2. Get Top Sold Items (with sorting)
 	itemAllowedSorts := map[string]string{
@@ -2770,9 +4864,8 @@ func marketStatsHandler(w http.ResponseWriter, r *http.Request) {
 	data.ItemSortBy = itemSortBy
 	data.ItemOrder = itemOrder
 
-	// --- MODIFICATION: Handle table alias 'me.' for this query ---
-	// The "Top Sold Items" query aliases market_events as 'me', so we must adjust the where clause.
-	aliasedWhereConditions := strings.ReplaceAll(whereConditions, "details", "me.details")
+	// The "Top Sold Items" query aliases market_events as 'me'.
+	aliasedWhereConditions, _ := marketStatsFilter("me.", startTime, endTime, maxPrice, outlierIDs)
 
 	itemsQuery := fmt.Sprintf(`
 		SELECT
@@ -2780,19 +4873,18 @@ func marketStatsHandler(w http.ResponseWriter, r *http.Request) {
 			me.item_id,
 			idb.name_pt,
 			COUNT(*) as count,
-			COALESCE(SUM(CAST(REPLACE(json_extract(me.details, '$.price'), ',', '') AS INTEGER)), 0) as zeny
+			COALESCE(SUM(`+marketPriceSQL("json_extract(me.details, '$.price')")+`), 0) as zeny
 		FROM market_events me
 		LEFT JOIN internal_item_db idb ON me.item_id = idb.item_id
 		%s
 		GROUP BY me.item_name, me.item_id, idb.name_pt
 		%s
 		LIMIT %d`, aliasedWhereConditions, itemOrderByClause, topLimit)
-	// --- END MODIFICATION ---
 
 	log.Printf("[D] [HTTP/Stats] Top Items Query: %s; Params: %v", itemsQuery, params)
 	itemRows, err := srv.db.Query(itemsQuery, params...)
 	if err != nil {
-		log.Printf("[E] [HTTP/Stats] Could not query top sold items: %v", err)
+		logRequestf(r, "[E] [HTTP/Stats] Could not query top sold items: %v", err)
 	} else {
 		defer itemRows.Close()
 		for itemRows.Next() {
@@ -2801,6 +4893,9 @@ func marketStatsHandler(w http.ResponseWriter, r *http.Request) {
 				log.Printf("[W] [HTTP/Stats] Failed to scan top item row: %v", err)
 				continue
 			}
+			if showNet {
+				item.TotalZeny = netZeny(item.TotalZeny)
+			}
 			data.TopSoldItems = append(data.TopSoldItems, item)
 		}
 	}
@@ -2828,7 +4923,7 @@ func marketStatsHandler(w http.ResponseWriter, r *http.Request) {
 		SELECT
 			json_extract(details, '$.seller') as seller_name,
 			COUNT(*) as count,
-			COALESCE(SUM(CAST(REPLACE(json_extract(details, '$.price'), ',', '') AS INTEGER)), 0) as zeny
+			COALESCE(SUM(`+marketPriceSQL("json_extract(details, '$.price')")+`), 0) as zeny
 		FROM market_events
 		%s
 		GROUP BY seller_name
@@ -2838,7 +4933,7 @@ func marketStatsHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[D] [HTTP/Stats] Top Sellers Query: %s; Params: %v", sellersQuery, params)
 	sellerRows, err := srv.db.Query(sellersQuery, params...)
 	if err != nil {
-		log.Printf("[E] [HTTP/Stats] Could not query top sellers: %v", err)
+		logRequestf(r, "[E] [HTTP/Stats] Could not query top sellers: %v", err)
 	} else {
 		defer sellerRows.Close()
 		for sellerRows.Next() {
@@ -2847,42 +4942,289 @@ func marketStatsHandler(w http.ResponseWriter, r *http.Request) {
 				log.Printf("[W] [HTTP/Stats] Failed to scan top seller row: %v", err)
 				continue
 			}
+			if showNet {
+				seller.TotalZeny = netZeny(seller.TotalZeny)
+			}
 			data.TopSellers = append(data.TopSellers, seller)
 		}
 	}
 
-	// 4. Get Chart Data
-	// This query does not use an alias, so 'whereConditions' works as-is.
-	chartQuery := fmt.Sprintf(`
+	// 4. Get Chart Data
+	// This query does not use an alias, so 'whereConditions' works as-is.
+	chartQuery := fmt.Sprintf(`
+		SELECT
+			strftime('%%Y-%%m-%%dT00:00:00Z', event_timestamp) as day,
+			COUNT(*) as count,
+			COALESCE(SUM(`+marketPriceSQL("json_extract(details, '$.price')")+`), 0) as zeny
+		FROM market_events
+		%s
+		GROUP BY day
+		ORDER BY day ASC`, whereConditions)
+
+	log.Printf("[D] [HTTP/Stats] Chart Query: %s; Params: %v", chartQuery, params)
+	chartRows, err := srv.db.Query(chartQuery, params...)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/Stats] Could not query chart data: %v", err)
+	} else {
+		var salesPoints []MarketSalesPoint
+		defer chartRows.Close()
+		for chartRows.Next() {
+			var point MarketSalesPoint
+			if err := chartRows.Scan(&point.Day, &point.Count, &point.Zeny); err != nil {
+				log.Printf("[W] [HTTP/Stats] Failed to scan chart data row: %v", err)
+				continue
+			}
+			if showNet {
+				point.Zeny = netZeny(point.Zeny)
+			}
+			salesPoints = append(salesPoints, point)
+		}
+		jsonBytes, _ := json.Marshal(salesPoints)
+		data.SalesOverTimeJSON = template.JS(jsonBytes)
+	}
+
+	renderMultiSortTemplate(w, r, "market_stats.html", data, sortParam{"isort", data.ItemSortBy}, sortParam{"ssort", data.SellerSortBy})
+}
+
+// marketStatsFilter builds the WHERE clause and parameters shared by the
+// market stats queries: SOLD events in [startTime, endTime] (endTime ""
+// for open-ended) priced under maxPrice (0 for no cap), minus outlierIDs.
+// prefix qualifies the market_events columns, e.g. "me.". The outliers
+// are bound as one JSON array, so the statement doesn't grow with them.
+func marketStatsFilter(prefix, startTime, endTime string, maxPrice int64, outlierIDs []int64) (string, []interface{}) {
+	conditions := []string{prefix + "event_type = 'SOLD'", prefix + "event_timestamp >= ?"}
+	params := []interface{}{startTime}
+	if endTime != "" {
+		conditions = append(conditions, prefix+"event_timestamp <= ?")
+		params = append(params, endTime)
+	}
+	if maxPrice > 0 {
+		conditions = append(conditions, marketPriceSQL("json_extract("+prefix+"details, '$.price')")+" < ?")
+		params = append(params, maxPrice)
+	}
+	if len(outlierIDs) > 0 {
+		ids, _ := json.Marshal(outlierIDs)
+		conditions = append(conditions, prefix+"id NOT IN (SELECT value FROM json_each(?))")
+		params = append(params, string(ids))
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), params
+}
+
+// statsOutlierWindow is how far back an item's rolling median reaches
+// when judging whether a sale is a price outlier.
+const statsOutlierWindow = 14 * 24 * time.Hour
+
+func statsOutlierFactor() float64 {
+	if appConfig == nil {
+		return 0
+	}
+	return appConfig.StatsOutlierFactor
+}
+
+// soldPrice is one SOLD event as seen by the outlier check.
+type soldPrice struct {
+	ID    int64
+	Item  string
+	At    time.Time
+	Price int64
+}
+
+// priceOutlierCacheKey identifies one cachedPriceOutliers result. Named
+// intervals are keyed by name, since their start moves with every request.
+type priceOutlierCacheKey struct {
+	interval, start, end string
+	maxPrice             int64
+	factor               float64
+}
+
+type priceOutlierCacheEntry struct {
+	ids    []int64
+	expiry time.Time
+}
+
+// priceOutlierCache holds fetchPriceOutliers results for one market
+// scrape interval, which is as often as new sales can show up.
+var (
+	priceOutlierCache      = make(map[priceOutlierCacheKey]priceOutlierCacheEntry)
+	priceOutlierCacheMutex sync.Mutex
+)
+
+// cachedPriceOutliers is fetchPriceOutliers behind priceOutlierCache.
+// interval is the stats page's ?interval= ("custom" for a date range).
+func cachedPriceOutliers(interval, startTime, endTime string, maxPrice int64, factor float64) ([]int64, error) {
+	key := priceOutlierCacheKey{interval: interval, end: endTime, maxPrice: maxPrice, factor: factor}
+	if interval == "custom" {
+		key.start = startTime
+	}
+	now := time.Now()
+
+	priceOutlierCacheMutex.Lock()
+	entry, found := priceOutlierCache[key]
+	priceOutlierCacheMutex.Unlock()
+	if found && now.Before(entry.expiry) {
+		return entry.ids, nil
+	}
+
+	ids, err := fetchPriceOutliers(startTime, endTime, maxPrice, factor)
+	if err != nil {
+		return nil, err
+	}
+
+	priceOutlierCacheMutex.Lock()
+	for k, e := range priceOutlierCache {
+		if !now.Before(e.expiry) {
+			delete(priceOutlierCache, k)
+		}
+	}
+	priceOutlierCache[key] = priceOutlierCacheEntry{ids: ids, expiry: now.Add(marketScrapeInterval)}
+	priceOutlierCacheMutex.Unlock()
+	return ids, nil
+}
+
+// fetchPriceOutliers returns the IDs of SOLD events between startTime and
+// endTime (open-ended when empty) whose price is more than factor times
+// above or below their item's rolling median. Sales from one window
+// before startTime are loaded too, so the first events in the interval
+// still have a history to compare against. Sales at or above maxPrice are
+// ignored unless it is 0.
+func fetchPriceOutliers(startTime, endTime string, maxPrice int64, factor float64) ([]int64, error) {
+	start, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time %q: %w", startTime, err)
+	}
+	if maxPrice == 0 {
+		maxPrice = math.MaxInt64
+	}
+
+	endCondition := ""
+	args := []interface{}{start.Add(-statsOutlierWindow).Format(time.RFC3339)}
+	if endTime != "" {
+		endCondition = " AND event_timestamp <= ?"
+		args = append(args, endTime)
+	}
+	args = append(args, maxPrice)
+
+	rows, err := srv.db.Query(`
+		SELECT id, item_name, event_timestamp, price FROM (
+			SELECT id, item_name, event_timestamp, `+soldPriceSQL+` AS price
+			FROM market_events
+			WHERE event_type = 'SOLD' AND event_timestamp >= ?`+endCondition+`
+		)
+		WHERE price > 0 AND price < ?
+		ORDER BY item_name, event_timestamp`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query sales: %w", err)
+	}
+	defer rows.Close()
+
+	var sales []soldPrice
+	for rows.Next() {
+		var s soldPrice
+		var ts string
+		if err := rows.Scan(&s.ID, &s.Item, &ts, &s.Price); err != nil {
+			return nil, fmt.Errorf("could not scan sale: %w", err)
+		}
+		if s.At, err = time.Parse(time.RFC3339, ts); err != nil {
+			continue
+		}
+		sales = append(sales, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return findPriceOutliers(sales, start, statsOutlierWindow, factor), nil
+}
+
+// findPriceOutliers flags sales at or after start priced more than
+// factor above or below the median of the same item's sales in the
+// preceding window (the sale itself included). sales must be ordered by
+// item, then time. Sales whose window holds fewer than fairPriceMinSales
+// prices are never flagged.
+func findPriceOutliers(sales []soldPrice, start time.Time, window time.Duration, factor float64) []int64 {
+	var outliers []int64
+	lo := 0
+	for i, s := range sales {
+		if i > 0 && sales[i-1].Item != s.Item {
+			lo = i
+		}
+		for sales[lo].At.Before(s.At.Add(-window)) {
+			lo++
+		}
+		if s.At.Before(start) || i+1-lo < fairPriceMinSales {
+			continue
+		}
+		prices := make([]int64, 0, i+1-lo)
+		for _, w := range sales[lo : i+1] {
+			prices = append(prices, w.Price)
+		}
+		sort.Slice(prices, func(a, b int) bool { return prices[a] < prices[b] })
+		median := float64(medianPrice(prices))
+		if price := float64(s.Price); price > median*factor || price*factor < median {
+			outliers = append(outliers, s.ID)
+		}
+	}
+	return outliers
+}
+
+// joinInt64s renders ids as a comma-separated list for an IN clause.
+func joinInt64s(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// sellerVolumeHandler serves /seller/volume?name=X: one seller's daily
+// SOLD count and zeny over the market stats interval (?interval=, and
+// ?net=true for proceeds after the vend fee), as JSON for charting.
+func sellerVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	sellerName := r.URL.Query().Get("name")
+	if sellerName == "" {
+		http.Error(w, "Seller name is required", http.StatusBadRequest)
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "" && !httpx.WantsJSON(r) {
+		http.Error(w, "format must be json", http.StatusBadRequest)
+		return
+	}
+	selectedInterval, startTime := getMarketStatsInterval(r)
+	showNet := r.URL.Query().Get("net") == "true"
+
+	rows, err := srv.db.Query(`
 		SELECT
-			strftime('%%Y-%%m-%%dT00:00:00Z', event_timestamp) as day,
+			strftime('%Y-%m-%dT00:00:00Z', event_timestamp) as day,
 			COUNT(*) as count,
-			COALESCE(SUM(CAST(REPLACE(json_extract(details, '$.price'), ',', '') AS INTEGER)), 0) as zeny
+			COALESCE(SUM(`+marketPriceSQL("json_extract(details, '$.price')")+`), 0) as zeny
 		FROM market_events
-		%s
+		WHERE `+soldSinceWithinCapSQL+` AND json_extract(details, '$.seller') = ?
 		GROUP BY day
-		ORDER BY day ASC`, whereConditions)
-
-	log.Printf("[D] [HTTP/Stats] Chart Query: %s; Params: %v", chartQuery, params)
-	chartRows, err := srv.db.Query(chartQuery, params...)
+		ORDER BY day ASC`, startTime, statsPriceCap, sellerName)
 	if err != nil {
-		log.Printf("[E] [HTTP/Stats] Could not query chart data: %v", err)
-	} else {
-		var salesPoints []MarketSalesPoint
-		defer chartRows.Close()
-		for chartRows.Next() {
-			var point MarketSalesPoint
-			if err := chartRows.Scan(&point.Day, &point.Count, &point.Zeny); err != nil {
-				log.Printf("[W] [HTTP/Stats] Failed to scan chart data row: %v", err)
-				continue
-			}
-			salesPoints = append(salesPoints, point)
+		logRequestf(r, "[E] [HTTP/SellerVolume] Could not query volume for '%s': %v", sellerName, err)
+		http.Error(w, "Could not query seller volume", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	resp := SellerVolume{SellerName: sellerName, Interval: selectedInterval, Net: showNet, Days: []MarketSalesPoint{}}
+	for rows.Next() {
+		var point MarketSalesPoint
+		if err := rows.Scan(&point.Day, &point.Count, &point.Zeny); err != nil {
+			log.Printf("[W] [HTTP/SellerVolume] Failed to scan volume row: %v", err)
+			continue
 		}
-		jsonBytes, _ := json.Marshal(salesPoints)
-		data.SalesOverTimeJSON = template.JS(jsonBytes)
+		if showNet {
+			point.Zeny = netZeny(point.Zeny)
+		}
+		resp.TotalCount += point.Count
+		resp.TotalZeny += point.Zeny
+		resp.Days = append(resp.Days, point)
 	}
 
-	renderTemplate(w, r, "market_stats.html", data)
+	if err := httpx.WriteJSON(w, http.StatusOK, resp); err != nil {
+		log.Printf("[W] [HTTP/SellerVolume] Failed to write volume JSON for '%s': %v", sellerName, err)
+	}
 }
 
 // Reverted to only exclude "Local". "Drop" is now a regular channel.
@@ -2916,31 +5258,44 @@ func aboutHandler(w http.ResponseWriter, r *http.Request) {
 // findItemIDByName orchestrates searching the cache and online for an item ID.
 // This function remains unchanged but is shown for context.
 func findItemIDByName(itemName string, allowRetry bool, slots int) (sql.NullInt64, error) {
+	return resolveItemName(itemName, allowRetry, slots, nil)
+}
+
+// resolveItemName is findItemIDByName with an optional trace; a non-nil
+// trace records each decision and bypasses the online lookup cache.
+func resolveItemName(itemName string, allowRetry bool, slots int, trace *ItemMatchReport) (sql.NullInt64, error) {
 	// 1. Clean the name
 	cleanItemName := reSlotRemover.ReplaceAllString(itemName, " ")
 	cleanItemName = reRefineRemover.ReplaceAllString(cleanItemName, "")
 	cleanItemName = strings.TrimSpace(cleanItemName)
 	cleanItemName = sanitizeString(cleanItemName, itemSanitizer)
+	trace.step("clean", "'%s' cleaned to '%s' (slots %d)", itemName, cleanItemName, slots)
 
 	if strings.TrimSpace(cleanItemName) == "" {
+		trace.step("clean", "Nothing left after cleaning; giving up.")
 		return sql.NullInt64{Valid: false}, nil
 	}
 
 	// 2. Handle special case: "Zeny"
 	if strings.ToLower(cleanItemName) == "zeny" {
 		log.Printf("[D] [ItemID] Detected special item 'Zeny'. Skipping ID search.")
+		trace.step("zeny", "Name is 'Zeny'; it has no item ID.")
 		return sql.NullInt64{Valid: false}, nil
 	}
 
 	// 3. Try local FTS cache first (This is the modified function)
 	// --- MODIFICATION: Pass 'slots' parameter ---
-	if itemID, found := findItemIDInCache(cleanItemName, slots); found {
+	if itemID, found := matchItemInCache(cleanItemName, slots, trace); found {
 		// --- END MODIFICATION ---
 		return itemID, nil
 	}
 
-	// 4. If not found, try online search
-	if itemID, found := findItemIDOnline(cleanItemName, slots); found {
+	// 4. If not found, try online search (queued and deduped)
+	if trace != nil {
+		if itemID, found := matchItemOnline(cleanItemName, slots, trace); found {
+			return itemID, nil
+		}
+	} else if itemID, found := lookupItemIDOnline(cleanItemName, slots); found {
 		return itemID, nil
 	}
 
@@ -2954,13 +5309,15 @@ func findItemIDByName(itemName string, allowRetry bool, slots int) (sql.NullInt6
 
 		if newName != "" && newName != cleanItemName {
 			log.Printf("[D] [ItemID] No results for '%s'. Retrying search without 'card'/'carta' as: '%s'", cleanItemName, newName)
+			trace.step("retry", "Retrying without 'card'/'carta' as '%s'.", newName)
 			// Call recursively, but with allowRetry=false to prevent infinite loops
-			return findItemIDByName(newName, false, slots)
+			return resolveItemName(newName, false, slots, trace)
 		}
 	}
 
 	// 6. All attempts failed
 	log.Printf("[D] [ItemID] All searches for '%s' returned no results or were ambiguous. Storing name only.", cleanItemName)
+	trace.step("give-up", "No unambiguous match for '%s'; the name would be stored without an ID.", cleanItemName)
 	return sql.NullInt64{Valid: false}, nil
 }
 
@@ -3032,6 +5389,9 @@ func createSingleTradingPost(authorName, originalMessage, postType string, items
 		return 0, fmt.Errorf("could not hash token: %w", err)
 	}
 
+	// Online ID lookups can be slow, so they run before the transaction.
+	itemIDs := resolveTradeItemIDs(items, "[Discord]")
+
 	tx, err := srv.db.Begin()
 	if err != nil {
 		return 0, fmt.Errorf("failed to start database transaction: %w", err)
@@ -3070,16 +5430,12 @@ func createSingleTradingPost(authorName, originalMessage, postType string, items
 	}
 	defer stmt.Close()
 
-	for _, item := range items {
+	for i, item := range items {
 		itemName := sanitizeString(item.Name, itemSanitizer)
 		if strings.TrimSpace(itemName) == "" {
 			continue
 		}
-
-		itemID, findErr := findItemIDByName(itemName, true, item.Slots)
-		if findErr != nil {
-			log.Printf("[W] [Discord] Error finding item ID for '%s': %v. Proceeding without ID.", itemName, findErr)
-		}
+		itemID := itemIDs[i]
 
 		paymentMethods := "zeny"
 		if item.PaymentMethods == "rmt" || item.PaymentMethods == "both" {
@@ -3101,6 +5457,9 @@ func createSingleTradingPost(authorName, originalMessage, postType string, items
 		return 0, fmt.Errorf("failed to finalize discord post transaction: %w", err)
 	}
 
+	// 5. Ping any trade watches this post matches
+	go notifyTradeWatches(postID, characterName, postType)
+
 	return postID, nil
 }
 
@@ -3142,34 +5501,32 @@ func CreateTradingPostFromDiscord(authorName string, originalMessage string, tra
 	return postIDs, finalError
 }
 
-// mapItemTypeToDBType converts a user-facing item type (from a URL)
-// into the corresponding database value.
-func mapItemTypeToDBType(selectedType string) string {
-	switch selectedType {
-	case "Healing Item":
-		return "Healing"
-	case "Usable Item":
-		return "Usable"
-	case "Miscellaneous":
-		return "Etc"
-	case "Ammunition":
-		return "Ammo"
-	case "Card":
-		return "Card"
-	case "Monster Egg":
-		return "PetEgg"
-	case "Pet Armor":
-		return "PetArmor" // Covers both PetArmor and PetEquip
-	case "Weapon":
-		return "Weapon"
-	case "Armor":
-		return "Armor" // Covers Armor and ShadowGear
-	case "Cash Shop Item":
-		return "Cash"
-	default:
-		// Return the type as-is if it's not a special case
-		return selectedType
+// mapItemTypeToDBTypes converts a user-facing category tab (from a URL)
+// into the database types grouped under it, so the filter matches
+// exactly what getItemTypeTabs counted. A tab nothing maps to is taken
+// as a raw DB type.
+func mapItemTypeToDBTypes(selectedType string) []string {
+	var dbTypes []string
+	for dbType, tab := range itemCategories() {
+		if tab == selectedType {
+			dbTypes = append(dbTypes, dbType)
+		}
+	}
+	if len(dbTypes) == 0 {
+		return []string{selectedType}
 	}
+	sort.Strings(dbTypes)
+	return dbTypes
+}
+
+// itemTypeFilter builds the "col IN (...)" condition for a category tab.
+func itemTypeFilter(column, selectedType string) (string, []interface{}) {
+	dbTypes := mapItemTypeToDBTypes(selectedType)
+	params := make([]interface{}, len(dbTypes))
+	for i, t := range dbTypes {
+		params[i] = t
+	}
+	return fmt.Sprintf("%s IN (?%s)", column, strings.Repeat(", ?", len(dbTypes)-1)), params
 }
 
 // getItemIDAndNamePT finds the item ID and Portuguese name for a given item.
@@ -3205,18 +5562,153 @@ func fetchItemDetails(itemID int) *RMSItem {
 	return nil // Not found
 }
 
+// itemDetailsHandler serves /item/details?id=123&format=json: the item's
+// internal_item_db record, via the same fetchItemDetails the history
+// page uses.
+func itemDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	itemID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || itemID <= 0 {
+		http.Error(w, "A positive numeric item id is required", http.StatusBadRequest)
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "" && !httpx.WantsJSON(r) {
+		http.Error(w, "format must be json", http.StatusBadRequest)
+		return
+	}
+
+	item := fetchItemDetails(itemID)
+	if item == nil {
+		httpx.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "item not found"})
+		return
+	}
+	if err := httpx.WriteJSON(w, http.StatusOK, item); err != nil {
+		log.Printf("[W] [HTTP/ItemDetails] Failed to write details JSON for item %d: %v", itemID, err)
+	}
+}
+
+// itemsAllHandler serves /items/all.json: every internal_item_db record
+// as newline-delimited JSON, encoded row by row so the dump is never
+// held in memory. ?updated_since=<RFC3339> limits it to rows modified
+// after that time; rows that predate last_modified tracking only come
+// with a full dump.
+func itemsAllHandler(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT item_id, COALESCE(aegis_name, ''), COALESCE(name, ''), COALESCE(name_pt, ''), COALESCE(type, ''),
+			COALESCE(buy, 0), COALESCE(sell, 0), COALESCE(weight, 0), COALESCE(slots, 0),
+			COALESCE(jobs, ''), COALESCE(locations, ''),
+			COALESCE(script, ''), COALESCE(equip_script, ''), COALESCE(unequip_script, ''),
+			COALESCE(last_modified, '')
+		FROM internal_item_db`
+	var params []interface{}
+	if since := r.URL.Query().Get("updated_since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "updated_since must be an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		query += " WHERE last_modified > ?"
+		params = append(params, t.Local().Format(time.RFC3339))
+	}
+	query += " ORDER BY item_id ASC"
+
+	rows, err := srv.db.Query(query, params...)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/ItemsAll] Could not query item db: %v", err)
+		http.Error(w, "Could not query item database", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	var written int
+	for rows.Next() {
+		var rec ItemDBRecord
+		var jobs, locations string
+		if err := rows.Scan(&rec.ItemID, &rec.AegisName, &rec.Name, &rec.NamePT, &rec.Type,
+			&rec.Buy, &rec.Sell, &rec.Weight, &rec.Slots, &jobs, &locations,
+			&rec.Script, &rec.EquipScript, &rec.UnequipScript, &rec.LastModified); err != nil {
+			log.Printf("[W] [HTTP/ItemsAll] Failed to scan item row: %v", err)
+			continue
+		}
+		if json.Valid([]byte(jobs)) {
+			rec.Jobs = json.RawMessage(jobs)
+		}
+		if json.Valid([]byte(locations)) {
+			rec.Locations = json.RawMessage(locations)
+		}
+		if err := enc.Encode(rec); err != nil {
+			logRequestf(r, "[W] [HTTP/ItemsAll] Client went away after %d items: %v", written, err)
+			return
+		}
+		written++
+	}
+	if err := rows.Err(); err != nil {
+		logRequestf(r, "[E] [HTTP/ItemsAll] Item db dump stopped after %d items: %v", written, err)
+	}
+}
+
 // fetchPriceHistory aggregates the lowest/highest price points over time for the graph.
 // This optimized version uses window functions to avoid correlated subqueries.
-func fetchPriceHistory(itemName string) ([]PricePointDetails, error) {
+func fetchPriceHistory(itemNames []string) ([]PricePointDetails, error) {
+	points, err := fetchPriceHistoryPoints(itemNames)
+	if err != nil {
+		return nil, err
+	}
+	return dedupPriceHistory(points, priceHistoryTolerance()), nil
+}
+
+// priceHistoryTolerance returns the minimum percentage move that records
+// a new history point (0 records every change).
+func priceHistoryTolerance() float64 {
+	if appConfig == nil {
+		return 0
+	}
+	return appConfig.PriceHistoryTolerancePercent
+}
+
+// priceMoved reports whether a price changed from prev to cur by more
+// than tolerance percent of prev. A zero tolerance counts any change.
+func priceMoved(prev, cur int, tolerance float64) bool {
+	if tolerance <= 0 || prev == 0 {
+		return prev != cur
+	}
+	return math.Abs(float64(cur-prev)) > float64(prev)*tolerance/100
+}
+
+// dedupPriceHistory keeps the first point and every later point whose
+// lowest or highest price moved by more than tolerance percent from the
+// last kept point.
+func dedupPriceHistory(points []PricePointDetails, tolerance float64) []PricePointDetails {
+	var kept []PricePointDetails
+	for _, p := range points {
+		if len(kept) == 0 {
+			kept = append(kept, p)
+			continue
+		}
+		last := kept[len(kept)-1]
+		if priceMoved(last.LowestPrice, p.LowestPrice, tolerance) || priceMoved(last.HighestPrice, p.HighestPrice, tolerance) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// fetchPriceHistoryPoints returns the lowest and highest listing of every
+// scrape of itemNames, oldest first, before any de-duplication.
+func fetchPriceHistoryPoints(itemNames []string) ([]PricePointDetails, error) {
+	defer logSlowQuery("fetchPriceHistory", time.Now())
+
 	// This query uses a Common Table Expression (CTE) with window functions (ROW_NUMBER)
 	// to find the min and max priced item for each timestamp in a single pass.
 	// This is significantly more efficient than the previous version which used
 	// two separate subqueries, each with its own correlated subquery.
-	const priceChangeQuery = `
+	nameClause, nameParams := nameInClause("name_of_the_item", itemNames)
+	priceChangeQuery := `
 		WITH RankedItems AS (
 			SELECT
 				date_and_time_retrieved,
-				CAST(REPLACE(REPLACE(price, ',', ''), 'z', '') AS INTEGER) as price_int,
+				` + marketPriceSQL("price") + ` as price_int,
 				quantity,
 				store_name,
 				seller_name,
@@ -3225,15 +5717,15 @@ func fetchPriceHistory(itemName string) ([]PricePointDetails, error) {
 				-- Rank items from lowest price (1) to highest
 				ROW_NUMBER() OVER(
 					PARTITION BY date_and_time_retrieved 
-					ORDER BY CAST(REPLACE(REPLACE(price, ',', ''), 'z', '') AS INTEGER) ASC, id DESC
+					ORDER BY ` + marketPriceSQL("price") + ` ASC, id DESC
 				) as rn_asc,
 				-- Rank items from highest price (1) to lowest
 				ROW_NUMBER() OVER(
 					PARTITION BY date_and_time_retrieved 
-					ORDER BY CAST(REPLACE(REPLACE(price, ',', ''), 'z', '') AS INTEGER) DESC, id DESC
+					ORDER BY ` + marketPriceSQL("price") + ` DESC, id DESC
 				) as rn_desc
 			FROM items
-			WHERE name_of_the_item = ?
+			WHERE ` + nameClause + `
 		)
 		-- Select all rows that are *either* the min (rn_asc = 1) or the max (rn_desc = 1)
 		-- Then, group by timestamp and use conditional aggregation to pivot
@@ -3260,14 +5752,13 @@ func fetchPriceHistory(itemName string) ([]PricePointDetails, error) {
 		ORDER BY date_and_time_retrieved ASC;
 	`
 
-	// The original query used two parameters for `itemName`, this one only needs one.
-	rows, err := srv.db.Query(priceChangeQuery, itemName)
+	rows, err := srv.db.Query(priceChangeQuery, nameParams...)
 	if err != nil {
 		return nil, fmt.Errorf("optimized history change query error: %w", err)
 	}
 	defer rows.Close()
 
-	var finalPriceHistory []PricePointDetails
+	var points []PricePointDetails
 	for rows.Next() {
 		var p PricePointDetails
 		var timestampStr string
@@ -3282,35 +5773,255 @@ func fetchPriceHistory(itemName string) ([]PricePointDetails, error) {
 		}
 
 		t, _ := time.Parse(time.RFC3339, timestampStr)
-		p.Timestamp = t.Format("2006-01-02 15:04")
+		p.Timestamp = displayTime(t).Format("2006-01-02 15:04")
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// priceHistoryGranularity validates ?granularity=; anything other than
+// day, week or month means the fine-grained per-scrape history.
+func priceHistoryGranularity(v string) string {
+	switch v {
+	case "day", "week", "month":
+		return v
+	}
+	return ""
+}
+
+// aggregatePriceHistory folds the per-scrape points into one point per
+// day, week (starting Monday) or month: the lowest low and the highest
+// high in each bucket, each keeping its listing's details. An empty
+// granularity returns points unchanged.
+func aggregatePriceHistory(points []PricePointDetails, granularity string) []PricePointDetails {
+	if granularity == "" || len(points) == 0 {
+		return points
+	}
+
+	var out []PricePointDetails
+	var current string
+	for _, p := range points {
+		t, err := time.Parse("2006-01-02 15:04", p.Timestamp)
+		if err != nil {
+			continue
+		}
+		var start time.Time
+		switch granularity {
+		case "week":
+			offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+			start = time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+		case "month":
+			start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		default:
+			start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		key := start.Format("2006-01-02 15:04")
+
+		if key != current {
+			current = key
+			p.Timestamp = key
+			out = append(out, p)
+			continue
+		}
+		b := &out[len(out)-1]
+		if p.LowestPrice < b.LowestPrice {
+			b.LowestPrice, b.LowestQuantity = p.LowestPrice, p.LowestQuantity
+			b.LowestStoreName, b.LowestSellerName = p.LowestStoreName, p.LowestSellerName
+			b.LowestMapName, b.LowestMapCoords = p.LowestMapName, p.LowestMapCoords
+		}
+		if p.HighestPrice > b.HighestPrice {
+			b.HighestPrice, b.HighestQuantity = p.HighestPrice, p.HighestQuantity
+			b.HighestStoreName, b.HighestSellerName = p.HighestStoreName, p.HighestSellerName
+			b.HighestMapName, b.HighestMapCoords = p.HighestMapName, p.HighestMapCoords
+		}
+	}
+	return out
+}
+
+// A price war is a run of at least priceWarMinUndercuts undercuts, each a
+// different seller taking the lowest price by at most
+// priceWarMaxDropPercent, with no more than priceWarMaxGap between them.
+const (
+	priceWarMinUndercuts   = 3
+	priceWarMaxDropPercent = 5
+	priceWarMaxGap         = 6 * time.Hour
+)
+
+// detectPriceWars scans the per-scrape price history (oldest first) for
+// undercut wars and returns them newest first. Price rises in between,
+// e.g. the cheapest stack selling out, don't end a war; a long quiet gap
+// or a single seller lowering their own price doesn't extend one.
+func detectPriceWars(points []PricePointDetails) []PriceWar {
+	var wars []PriceWar
+	var cur *PriceWar
+	var lastCut time.Time
+	closeWar := func() {
+		if cur != nil && cur.Undercuts >= priceWarMinUndercuts && len(cur.Sellers) > 1 {
+			wars = append(wars, *cur)
+		}
+		cur = nil
+	}
+
+	for i := 1; i < len(points); i++ {
+		prev, p := points[i-1], points[i]
+		drop := prev.LowestPrice - p.LowestPrice
+		if drop <= 0 || drop*100 > prev.LowestPrice*priceWarMaxDropPercent || p.LowestSellerName == prev.LowestSellerName {
+			continue
+		}
+		t, err := time.Parse("2006-01-02 15:04", p.Timestamp)
+		if err != nil {
+			continue
+		}
+		if cur != nil && t.Sub(lastCut) > priceWarMaxGap {
+			closeWar()
+		}
+		if cur == nil {
+			cur = &PriceWar{Start: prev.Timestamp, StartPrice: int64(prev.LowestPrice), Sellers: []string{prev.LowestSellerName}}
+		}
+		cur.End, cur.EndPrice = p.Timestamp, int64(p.LowestPrice)
+		cur.Undercuts++
+		if !slices.Contains(cur.Sellers, p.LowestSellerName) {
+			cur.Sellers = append(cur.Sellers, p.LowestSellerName)
+		}
+		lastCut = t
+	}
+	closeWar()
 
-		// De-duplicate: Only add if the price range changed
-		if len(finalPriceHistory) == 0 ||
-			finalPriceHistory[len(finalPriceHistory)-1].LowestPrice != p.LowestPrice ||
-			finalPriceHistory[len(finalPriceHistory)-1].HighestPrice != p.HighestPrice {
-			finalPriceHistory = append(finalPriceHistory, p)
+	slices.Reverse(wars)
+	return wars
+}
+
+// fairPriceMinSales is the fewest recent sales an item needs before the
+// history page shows a fair price; below it the median is too noisy.
+const fairPriceMinSales = 3
+
+// fairPriceWindowDays returns how many days of sales feed the fair price
+// (0 disables it).
+func fairPriceWindowDays() int {
+	if appConfig == nil {
+		return 30
+	}
+	return appConfig.FairPriceWindowDays
+}
+
+// fetchFairPrice returns the median SOLD price of itemNames over the fair
+// price window and the number of sales it was taken from. Sales at or
+// above statsPriceCap are ignored, as on the stats page.
+// A zero price means the window is disabled or there were too few sales.
+func fetchFairPrice(itemNames []string) (int64, int, error) {
+	days := fairPriceWindowDays()
+	if days <= 0 {
+		return 0, 0, nil
+	}
+	since := time.Now().AddDate(0, 0, -days).Format(time.RFC3339)
+	nameClause, params := nameInClause("item_name", itemNames)
+
+	rows, err := srv.db.Query(`
+		SELECT price FROM (
+			SELECT `+marketPriceSQL("json_extract(details, '$.price')")+` AS price
+			FROM market_events
+			WHERE event_type = 'SOLD' AND `+nameClause+` AND event_timestamp >= ?
+		)
+		WHERE price > 0 AND price < ?
+		ORDER BY price ASC`, append(params, since, statsPriceCap)...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not query recent sales: %w", err)
+	}
+	defer rows.Close()
+
+	var prices []int64
+	for rows.Next() {
+		var p int64
+		if err := rows.Scan(&p); err != nil {
+			return 0, 0, fmt.Errorf("could not scan sale price: %w", err)
 		}
+		prices = append(prices, p)
+	}
+	if len(prices) < fairPriceMinSales {
+		return 0, len(prices), nil
+	}
+	return medianPrice(prices), len(prices), nil
+}
+
+// fetchCheapestDiscordOffer returns the cheapest zeny-priced Discord
+// selling offer for itemID, or nil when there is none. Offers are matched
+// by item id only, so unresolved names never pose as this item.
+func fetchCheapestDiscordOffer(itemID int) (*FlatTradingPostItem, error) {
+	if itemID <= 0 {
+		return nil, nil
+	}
+	items, err := queryFlatTradingPostItems(`
+		WHERE p.post_type = 'selling' AND i.item_id = ? AND i.price_zeny > 0
+		ORDER BY i.price_zeny ASC, p.created_at DESC LIMIT 1`, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query Discord offers: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	return &items[0], nil
+}
+
+// compareMarketDiscord pairs the cheapest in-game listing with the
+// cheapest Discord offer. It returns nil when neither source has one.
+func compareMarketDiscord(market *ItemListing, discord *FlatTradingPostItem) *MarketDiscordComparison {
+	if market == nil && discord == nil {
+		return nil
+	}
+	c := &MarketDiscordComparison{}
+	if market != nil {
+		c.MarketPrice = market.Price
+	}
+	if discord != nil {
+		c.DiscordPrice = discord.PriceZeny
+		c.DiscordSeller = discord.CharacterName
+		c.DiscordPosted = discord.CreatedAt
+	}
+	if market == nil || discord == nil {
+		return c
+	}
+	switch {
+	case c.DiscordPrice < c.MarketPrice:
+		c.Cheaper = "discord"
+		c.Difference = c.MarketPrice - c.DiscordPrice
+	case c.MarketPrice < c.DiscordPrice:
+		c.Cheaper = "market"
+		c.Difference = c.DiscordPrice - c.MarketPrice
+	default:
+		c.Cheaper = "same"
 	}
-	return finalPriceHistory, nil
+	return c
+}
+
+// medianPrice returns the median of prices, which must be sorted
+// ascending and non-empty. Even-length inputs average the middle pair.
+func medianPrice(prices []int64) int64 {
+	mid := len(prices) / 2
+	if len(prices)%2 == 1 {
+		return prices[mid]
+	}
+	return (prices[mid-1] + prices[mid]) / 2
 }
 
 // getOverallPriceRange finds the all-time lowest and highest prices for an item.
-func getOverallPriceRange(itemName string) (sql.NullInt64, sql.NullInt64) {
+func getOverallPriceRange(itemNames []string) (sql.NullInt64, sql.NullInt64) {
 	var overallLowest, overallHighest sql.NullInt64
+	nameClause, params := nameInClause("name_of_the_item", itemNames)
 	if err := srv.db.QueryRow(`
-        SELECT MIN(CAST(REPLACE(REPLACE(price, ',', ''), 'z', '') AS INTEGER)),
-               MAX(CAST(REPLACE(REPLACE(price, ',', ''), 'z', '') AS INTEGER))
-        FROM items WHERE name_of_the_item = ?;
-    `, itemName).Scan(&overallLowest, &overallHighest); err != nil {
-		log.Printf("[W] [HTTP] Could not query overall price range for %s: %v", itemName, err)
+        SELECT MIN(`+marketPriceSQL("price")+`),
+               MAX(`+marketPriceSQL("price")+`)
+        FROM items WHERE `+nameClause+`;
+    `, params...).Scan(&overallLowest, &overallHighest); err != nil {
+		log.Printf("[W] [HTTP] Could not query overall price range for %s: %v", itemNames[0], err)
 	}
 	return overallLowest, overallHighest
 }
 
 // countAllListings returns the total number of historical listings for an item.
-func countAllListings(itemName string) (int, error) {
+func countAllListings(itemNames []string) (int, error) {
 	var totalListings int
-	err := srv.db.QueryRow("SELECT COUNT(*) FROM items WHERE name_of_the_item = ?", itemName).Scan(&totalListings)
+	nameClause, params := nameInClause("name_of_the_item", itemNames)
+	err := srv.db.QueryRow("SELECT COUNT(*) FROM items WHERE "+nameClause, params...).Scan(&totalListings)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count all listings: %w", err)
 	}
@@ -3318,40 +6029,37 @@ func countAllListings(itemName string) (int, error) {
 }
 
 // fetchAllListings retrieves a paginated list of all historical listings for an item.
-func fetchAllListings(itemName string, pagination httpx.PaginationData) ([]Item, error) {
+// Deep pages get slow on popular items; fetchListingsAfter is the keyset
+// alternative behind ?cursor=, and next is the cursor that continues from
+// this page there ("" on the last page).
+func fetchAllListings(itemNames []string, pagination httpx.PaginationData) (listings []Item, next string, err error) {
+	nameClause, params := nameInClause("i.name_of_the_item", itemNames)
 	query := `
 		SELECT i.id, i.name_of_the_item, local_db.name_pt, i.item_id, i.quantity, i.price, 
 		       i.store_name, i.seller_name, i.date_and_time_retrieved, i.map_name, 
 			   i.map_coordinates, i.is_available
 		FROM items i 
 		LEFT JOIN internal_item_db local_db ON i.item_id = local_db.item_id 
-		WHERE i.name_of_the_item = ? 
-		ORDER BY i.is_available DESC, i.date_and_time_retrieved DESC 
+		WHERE ` + nameClause + ` 
+		ORDER BY i.is_available DESC, i.date_and_time_retrieved DESC, i.id DESC 
 		LIMIT ? OFFSET ?;
 	`
-	// Use the values from the pagination struct
-	rows, err := srv.db.Query(query, itemName, pagination.ItemsPerPage, pagination.Offset)
+	// Use the values from the pagination struct; one extra row tells
+	// whether there is a next page.
+	rows, err := srv.db.Query(query, append(params, pagination.ItemsPerPage+1, pagination.Offset)...)
 	if err != nil {
-		return nil, fmt.Errorf("all listings query error: %w", err)
+		return nil, "", fmt.Errorf("all listings query error: %w", err)
 	}
 	defer rows.Close()
 
-	var allListings []Item
-	for rows.Next() {
-		var listing Item
-		var timestampStr string
-		if err := rows.Scan(&listing.ID, &listing.Name, &listing.NamePT, &listing.ItemID, &listing.Quantity, &listing.Price, &listing.StoreName, &listing.SellerName, &timestampStr, &listing.MapName, &listing.MapCoordinates, &listing.IsAvailable); err != nil {
-			log.Printf("[W] [HTTP/History] Failed to scan all listing row: %v", err)
-			continue
-		}
-		if parsedTime, err := time.Parse(time.RFC3339, timestampStr); err == nil {
-			listing.Timestamp = parsedTime.Format("2006-01-02 15:04")
-		} else {
-			listing.Timestamp = timestampStr
-		}
-		allListings = append(allListings, listing)
+	listings, last, err := scanListingRows(rows, pagination.ItemsPerPage)
+	if err != nil {
+		return nil, "", err
 	}
-	return allListings, nil
+	if last != nil {
+		next = last.encode()
+	}
+	return listings, next, nil
 }
 
 // playerCountInterval defines the start time and name for a selected interval.
@@ -3438,8 +6146,8 @@ func fetchPlayerHistory(interval playerCountInterval) ([]PlayerCountPoint, map[s
 		}
 
 		if parsedTime, err := time.Parse(time.RFC3339, timestampStr); err == nil {
-			point.Timestamp = parsedTime.Format("2006-01-02 15:04")
-			activeDatesWithData[parsedTime.Format("2006-01-02")] = struct{}{}
+			point.Timestamp = displayTime(parsedTime).Format("2006-01-02 15:04")
+			activeDatesWithData[displayTime(parsedTime).Format("2006-01-02")] = struct{}{}
 		} else {
 			point.Timestamp = timestampStr
 		}
@@ -3506,7 +6214,7 @@ func getHistoricalMaxPlayers() (int, string) {
 	historicalMaxTime := "N/A"
 	if historicalMaxTimestampStr.Valid {
 		if parsedTime, err := time.Parse(time.RFC3339, historicalMaxTimestampStr.String); err == nil {
-			historicalMaxTime = parsedTime.Format("2006-01-02 15:04")
+			historicalMaxTime = displayTime(parsedTime).Format("2006-01-02 15:04")
 		}
 	}
 
@@ -3551,6 +6259,14 @@ func getAllClasses() []string {
 	return allClasses
 }
 
+// characterSorts maps the characters page's ?sort_by= values to columns,
+// shared by the page and its CSV/JSON export.
+var characterSorts = map[string]string{
+	"rank": "rank", "name": "name", "base_level": "base_level", "job_level": "job_level", "experience": "experience",
+	"zeny": "zeny", "class": "class", "guild": "guild_name", "last_updated": "last_updated", "last_active": "last_active",
+	"velocity": "leveling_velocity",
+}
+
 // buildCharacterWhereClause creates the SQL WHERE clause and parameters for filtering characters.
 func buildCharacterWhereClause(searchName, selectedClass, selectedGuild string) (string, []interface{}) {
 	var whereConditions []string
@@ -3568,6 +6284,10 @@ func buildCharacterWhereClause(searchName, selectedClass, selectedGuild string)
 		whereConditions = append(whereConditions, "guild_name = ?")
 		params = append(params, selectedGuild)
 	}
+	if exclude, excludeArgs := excludedNamesCondition("name"); exclude != "" {
+		whereConditions = append(whereConditions, exclude)
+		params = append(params, excludeArgs...)
+	}
 
 	if len(whereConditions) == 0 {
 		return "", nil
@@ -3622,7 +6342,7 @@ func getCharacterChartData(whereClause string, params []interface{}, graphFilter
 func getCharacterStats(whereClause string, params []interface{}) (int, int64) {
 	var totalPlayers int
 	var totalZeny sql.NullInt64
-	countQuery := fmt.Sprintf("SELECT COUNT(*), SUM(zeny) FROM characters %s", whereClause)
+	countQuery := fmt.Sprintf("SELECT COUNT(*), SUM(%s) FROM characters %s", totalZenySQL(), whereClause)
 	if err := srv.db.QueryRow(countQuery, params...).Scan(&totalPlayers, &totalZeny); err != nil {
 		log.Printf("[W] [HTTP/Char] Could not count player characters: %v", err)
 		return 0, 0
@@ -3632,8 +6352,9 @@ func getCharacterStats(whereClause string, params []interface{}) (int, int64) {
 
 // fetchCharacters retrieves the paginated list of characters from the database.
 func fetchCharacters(whereClause string, params []interface{}, orderByClause string, pagination httpx.PaginationData, guildMasters map[string]bool, specialPlayers map[string]bool) ([]PlayerCharacter, error) {
-	query := fmt.Sprintf(`SELECT rank, name, base_level, job_level, experience, class, guild_name, zeny, last_updated, last_active
-		FROM characters %s %s LIMIT ? OFFSET ?`, whereClause, orderByClause)
+	query := fmt.Sprintf(`SELECT rank, name, base_level, job_level, experience, class, guild_name, zeny, last_updated, last_active, leveling_velocity
+		FROM characters
+		%s %s LIMIT ? OFFSET ?`, whereClause, orderByClause)
 
 	queryArgs := append(params, pagination.ItemsPerPage, pagination.Offset)
 
@@ -3647,17 +6368,17 @@ func fetchCharacters(whereClause string, params []interface{}, orderByClause str
 	for rows.Next() {
 		var p PlayerCharacter
 		var lastUpdatedStr, lastActiveStr string
-		if err := rows.Scan(&p.Rank, &p.Name, &p.BaseLevel, &p.JobLevel, &p.Experience, &p.Class, &p.GuildName, &p.Zeny, &lastUpdatedStr, &lastActiveStr); err != nil {
+		if err := rows.Scan(&p.Rank, &p.Name, &p.BaseLevel, &p.JobLevel, &p.Experience, &p.Class, &p.GuildName, &p.Zeny, &lastUpdatedStr, &lastActiveStr, &p.LevelingVelocity); err != nil {
 			log.Printf("[W] [HTTP/Char] Failed to scan player character row: %v", err)
 			continue
 		}
 
 		// Format timestamps
 		if t, err := time.Parse(time.RFC3339, lastUpdatedStr); err == nil {
-			p.LastUpdated = t.Format("2006-01-02 15:04")
+			p.LastUpdated = displayTime(t).Format("2006-01-02 15:04")
 		}
 		if t, err := time.Parse(time.RFC3339, lastActiveStr); err == nil {
-			p.LastActive = t.Format("2006-01-02 15:04")
+			p.LastActive = displayTime(t).Format("2006-01-02 15:04")
 		}
 
 		// Set status flags
@@ -3669,6 +6390,91 @@ func fetchCharacters(whereClause string, params []interface{}, orderByClause str
 	return players, nil
 }
 
+// fetchCharacterExport loads every character matching whereClause, up to
+// maxResultRows(). truncated reports whether more rows matched.
+func fetchCharacterExport(whereClause string, params []interface{}, orderByClause string) (rows []CharacterExportRow, truncated bool, err error) {
+	limit := maxResultRows()
+	query := fmt.Sprintf(`SELECT rank, name, base_level, job_level, experience, zeny, class, COALESCE(guild_name, ''), last_active
+		FROM characters
+		%s %s LIMIT ?`, whereClause, orderByClause)
+
+	queryArgs := append(params, limit+1)
+
+	dbRows, err := srv.db.Query(query, queryArgs...)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not query characters for export: %w", err)
+	}
+	defer dbRows.Close()
+
+	rows = make([]CharacterExportRow, 0)
+	for dbRows.Next() {
+		if len(rows) == limit {
+			truncated = true
+			break
+		}
+		var c CharacterExportRow
+		var lastActiveStr string
+		if err := dbRows.Scan(&c.Rank, &c.Name, &c.BaseLevel, &c.JobLevel, &c.Experience, &c.Zeny, &c.Class, &c.Guild, &lastActiveStr); err != nil {
+			log.Printf("[W] [HTTP/Char] Failed to scan character export row: %v", err)
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, lastActiveStr); err == nil {
+			c.LastActive = displayTime(t).Format("2006-01-02 15:04")
+		}
+		rows = append(rows, c)
+	}
+	return rows, truncated, dbRows.Err()
+}
+
+// characterExportHandler serves /characters?format=csv|json: every
+// character matching the page's name/class/guild filters in the page's
+// sort order, without pagination, capped at MAX_RESULT_ROWS.
+func characterExportHandler(w http.ResponseWriter, r *http.Request, format, searchName, selectedClass, selectedGuild string) {
+	whereClause, params := buildCharacterWhereClause(searchName, selectedClass, selectedGuild)
+	orderByClause, _, _ := httpx.GetSortClause(r, characterSorts, "rank", "ASC")
+
+	characters, truncated, err := fetchCharacterExport(whereClause, params, orderByClause)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/Char] %v", err)
+		http.Error(w, "Could not query for player characters", http.StatusInternalServerError)
+		return
+	}
+
+	if format == "csv" {
+		rows := make([][]string, 0, len(characters))
+		for _, c := range characters {
+			rows = append(rows, []string{
+				strconv.Itoa(c.Rank),
+				c.Name,
+				strconv.Itoa(c.BaseLevel),
+				strconv.Itoa(c.JobLevel),
+				strconv.FormatFloat(c.Experience, 'f', 2, 64),
+				strconv.FormatInt(c.Zeny, 10),
+				c.Class,
+				c.Guild,
+				c.LastActive,
+			})
+		}
+		header := []string{"rank", "name", "base_level", "job_level", "experience", "zeny", "class", "guild", "last_active"}
+		if truncated {
+			// CSV has no room for the flag, so it goes in a header.
+			w.Header().Set("X-Truncated", "true")
+		}
+		if err := httpx.WriteCSV(w, "characters.csv", header, rows); err != nil {
+			log.Printf("[W] [HTTP/Char] Failed to write character CSV: %v", err)
+		}
+		return
+	}
+
+	export := CharacterExport{Characters: characters, Total: len(characters), Truncated: truncated}
+	if truncated {
+		export.Total, _ = getCharacterStats(whereClause, params)
+	}
+	if err := httpx.WriteJSON(w, http.StatusOK, export); err != nil {
+		log.Printf("[W] [HTTP/Char] Failed to write character JSON: %v", err)
+	}
+}
+
 // buildCharacterFilter creates the filter string for pagination and sorting links.
 func buildCharacterFilter(searchName, selectedClass, selectedGuild string, selectedCols, graphFilter []string) template.URL {
 	filterValues := url.Values{}
@@ -3698,12 +6504,12 @@ func buildCharacterFilter(searchName, selectedClass, selectedGuild string, selec
 // fetchGuildDetails fetches the core information for a single guild.
 func fetchGuildDetails(guildName string) (Guild, error) {
 	var g Guild
-	guildQuery := `
+	guildQuery := fmt.Sprintf(`
         SELECT name, level, experience, master, emblem_url, COALESCE(emblem_local_path, ''),
             (SELECT COUNT(*) FROM characters WHERE guild_name = guilds.name),
-            COALESCE((SELECT SUM(zeny) FROM characters WHERE guild_name = guilds.name), 0),
+            COALESCE((SELECT SUM(%s) FROM characters WHERE guild_name = guilds.name), 0),
             COALESCE((SELECT AVG(base_level) FROM characters WHERE guild_name = guilds.name), 0)
-        FROM guilds WHERE name = ?`
+        FROM guilds WHERE name = ?`, totalZenySQL())
 
 	err := srv.db.QueryRow(guildQuery, guildName).Scan(
 		&g.Name, &g.Level, &g.Experience, &g.Master, &g.EmblemURL, &g.EmblemLocalPath,
@@ -3716,14 +6522,17 @@ func fetchGuildDetails(guildName string) (Guild, error) {
 }
 
 // fetchGuildMembersAndStats fetches a guild's member list and class distribution.
-func fetchGuildMembersAndStats(guildName, guildMaster, orderByClause string) ([]PlayerCharacter, map[string]int, error) {
+// The class distribution only covers the returned members, so it is
+// partial when the member list is truncated.
+func fetchGuildMembersAndStats(guildName, guildMaster, orderByClause string) ([]PlayerCharacter, map[string]int, bool, error) {
+	limit := maxResultRows()
 	membersQuery := fmt.Sprintf(`SELECT rank, name, base_level, job_level, experience, class, zeny, last_active 
 		FROM characters
-		WHERE guild_name = ? %s`, orderByClause)
+		WHERE guild_name = ? %s LIMIT ?`, orderByClause)
 
-	rows, err := srv.db.Query(membersQuery, guildName)
+	rows, err := srv.db.Query(membersQuery, guildName, limit+1)
 	if err != nil {
-		return nil, nil, fmt.Errorf("could not query for guild members: %w", err)
+		return nil, nil, false, fmt.Errorf("could not query for guild members: %w", err)
 	}
 	defer rows.Close()
 
@@ -3731,6 +6540,9 @@ func fetchGuildMembersAndStats(guildName, guildMaster, orderByClause string) ([]
 	classDistribution := make(map[string]int)
 
 	for rows.Next() {
+		if len(members) == limit {
+			return members, classDistribution, true, nil
+		}
 		var p PlayerCharacter
 		var lastActiveStr string
 		if err := rows.Scan(&p.Rank, &p.Name, &p.BaseLevel, &p.JobLevel, &p.Experience, &p.Class, &p.Zeny, &lastActiveStr); err != nil {
@@ -3741,13 +6553,13 @@ func fetchGuildMembersAndStats(guildName, guildMaster, orderByClause string) ([]
 		classDistribution[p.Class]++ // Tally class
 
 		if t, err := time.Parse(time.RFC3339, lastActiveStr); err == nil {
-			p.LastActive = t.Format("2006-01-02 15:04")
+			p.LastActive = displayTime(t).Format("2006-01-02 15:04")
 		}
 		p.IsGuildLeader = (p.Name == guildMaster)
 		members = append(members, p)
 	}
 
-	return members, classDistribution, nil
+	return members, classDistribution, false, nil
 }
 
 // fetchGuildChangelog fetches the paginated activity log for a guild.
@@ -3755,11 +6567,11 @@ func fetchGuildMembersAndStats(guildName, guildMaster, orderByClause string) ([]
 // (a tiny fraction of changelog), then filters on the guild name.
 func fetchGuildChangelog(guildName string, r *http.Request, entriesPerPage int) ([]CharacterChangelog, httpx.PaginationData, error) {
 	likePattern := "%" + guildName + "%"
-	guildKinds := []interface{}{changelogKindGuildJoin, changelogKindGuildLeave, changelogKindGuildMove}
+	guildKinds := []interface{}{changelogKindGuildJoin, changelogKindGuildLeave, changelogKindGuildMove, changelogKindGuildMaster}
 
 	var totalChangelogEntries int
 	err := srv.db.QueryRow(
-		`SELECT COUNT(*) FROM character_changelog WHERE event_kind IN (?, ?, ?) AND activity_description LIKE ?`,
+		`SELECT COUNT(*) FROM character_changelog WHERE event_kind IN (?, ?, ?, ?) AND activity_description LIKE ?`,
 		append(append([]interface{}{}, guildKinds...), likePattern)...,
 	).Scan(&totalChangelogEntries)
 	if err != nil {
@@ -3769,7 +6581,7 @@ func fetchGuildChangelog(guildName string, r *http.Request, entriesPerPage int)
 	pagination := httpx.NewPaginationData(r, totalChangelogEntries, entriesPerPage)
 
 	changelogQuery := `SELECT change_time, character_name, activity_description FROM character_changelog
-        WHERE event_kind IN (?, ?, ?) AND activity_description LIKE ?
+        WHERE event_kind IN (?, ?, ?, ?) AND activity_description LIKE ?
         ORDER BY change_time DESC LIMIT ? OFFSET ?`
 
 	queryArgs := append(append([]interface{}{}, guildKinds...), likePattern, pagination.ItemsPerPage, pagination.Offset)
@@ -3785,7 +6597,7 @@ func fetchGuildChangelog(guildName string, r *http.Request, entriesPerPage int)
 		var timestampStr string
 		if err := changelogRows.Scan(&timestampStr, &entry.CharacterName, &entry.ActivityDescription); err == nil {
 			if t, err := time.Parse(time.RFC3339, timestampStr); err == nil {
-				entry.ChangeTime = t.Format("2006-01-02 15:04:05")
+				entry.ChangeTime = displayTime(t).Format("2006-01-02 15:04:05")
 			}
 			changelogEntries = append(changelogEntries, entry)
 		}
@@ -3797,21 +6609,21 @@ func fetchGuildChangelog(guildName string, r *http.Request, entriesPerPage int)
 func fetchCharacterData(charName string) (PlayerCharacter, error) {
 	var p PlayerCharacter
 	var lastUpdatedStr, lastActiveStr string
-	query := `SELECT rank, name, base_level, job_level, experience, class, guild_name, zeny, last_updated, last_active FROM characters WHERE name = ?`
+	query := `SELECT rank, name, base_level, job_level, experience, class, guild_name, zeny, last_updated, last_active, leveling_velocity FROM characters WHERE name = ?`
 
 	err := srv.db.QueryRow(query, charName).Scan(
 		&p.Rank, &p.Name, &p.BaseLevel, &p.JobLevel, &p.Experience, &p.Class,
-		&p.GuildName, &p.Zeny, &lastUpdatedStr, &lastActiveStr,
+		&p.GuildName, &p.Zeny, &lastUpdatedStr, &lastActiveStr, &p.LevelingVelocity,
 	)
 	if err != nil {
 		return p, err
 	}
 
 	if t, err := time.Parse(time.RFC3339, lastUpdatedStr); err == nil {
-		p.LastUpdated = t.Format("2006-01-02 15:04")
+		p.LastUpdated = displayTime(t).Format("2006-01-02 15:04")
 	}
 	if t, err := time.Parse(time.RFC3339, lastActiveStr); err == nil {
-		p.LastActive = t.Format("2006-01-02 15:04")
+		p.LastActive = displayTime(t).Format("2006-01-02 15:04")
 	}
 	p.IsActive = (lastUpdatedStr == lastActiveStr) && lastUpdatedStr != ""
 
@@ -3836,7 +6648,8 @@ func fetchCharacterGuild(guildName sql.NullString) (*Guild, error) {
 	return &g, nil
 }
 
-// fetchCharacterMvpKills retrieves all MVP kills for a single character.
+// fetchCharacterMvpKills retrieves all MVP kills for a single character,
+// with each mob's display offset applied.
 func fetchCharacterMvpKills(charName string) MvpKillEntry {
 	mvpKills := MvpKillEntry{CharacterName: charName, Kills: make(map[string]int)}
 
@@ -3856,7 +6669,7 @@ func fetchCharacterMvpKills(charName string) MvpKillEntry {
 	if err := srv.db.QueryRow(mvpQuery, charName).Scan(scanDest...); err == nil {
 		totalKills := 0
 		for i, mobID := range mvpMobIDs {
-			killCount := *scanDest[i].(*int)
+			killCount := mvpDisplayKills(mobID, *scanDest[i].(*int))
 			mvpKills.Kills[mobID] = killCount
 			totalKills += killCount
 		}
@@ -3912,7 +6725,7 @@ func fetchCharacterChangelog(charName string, searchQuery string, pagination htt
 		var timestampStr string
 		if err := changelogRows.Scan(&timestampStr, &entry.ActivityDescription); err == nil {
 			if t, err := time.Parse(time.RFC3339, timestampStr); err == nil {
-				entry.ChangeTime = t.Format("2006-01-02 15:04:05")
+				entry.ChangeTime = displayTime(t).Format("2006-01-02 15:04:05")
 			} else {
 				entry.ChangeTime = timestampStr
 			}
@@ -3946,37 +6759,73 @@ func parseDropMessage(msg string) map[string]string {
 			"message":  matches[2] + " " + matches[3], // "got Item..."
 		}
 	}
-	return nil
+	return nil
+}
+
+// vendFeePercent returns the configured vending tax (0 when unset).
+func vendFeePercent() float64 {
+	if appConfig == nil {
+		return 0
+	}
+	return appConfig.VendFeePercent
+}
+
+// netZeny returns what a seller keeps from a gross sale after the vend fee.
+func netZeny(gross int64) int64 {
+	return gross - int64(float64(gross)*vendFeePercent()/100)
+}
+
+// formatZeny formats a number with dot separators.
+func formatZeny(zeny int64) string {
+	return groupDigits(zeny, '.')
+}
+
+// formatNumber formats a count (WoE damage, points, MVP kills) with the
+// thousands separator of lang: "." for Portuguese, "," otherwise. It
+// accepts any integer type so templates can pass int and int64 fields.
+func formatNumber(n interface{}, lang string) string {
+	var v int64
+	switch x := n.(type) {
+	case int:
+		v = int64(x)
+	case int32:
+		v = int64(x)
+	case int64:
+		v = x
+	case sql.NullInt64:
+		v = x.Int64
+	default:
+		return fmt.Sprint(n)
+	}
+	if lang == "pt" {
+		return groupDigits(v, '.')
+	}
+	return groupDigits(v, ',')
 }
 
-// formatZeny formats a number with dot separators.
-func formatZeny(zeny int64) string {
-	s := strconv.FormatInt(zeny, 10)
+// groupDigits writes n in base 10 with sep between each group of three
+// digits ("-1234567" -> "-1.234.567").
+func groupDigits(n int64, sep byte) string {
+	s := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
 	if len(s) <= 3 {
-		return s
+		return sign + s
 	}
 
 	var result strings.Builder
-	n := len(s)
-	// Write the first part (1, 2, or 3 digits)
-	result.WriteString(s[:n%3])
-	if n%3 > 0 && n > 3 {
-		result.WriteByte('.')
-	}
-
-	// Write the rest in 3-digit groups
-	for i := n % 3; i < n; i += 3 {
+	result.WriteString(sign)
+	first := len(s) % 3
+	if first == 0 {
+		first = 3
+	}
+	result.WriteString(s[:first])
+	for i := first; i < len(s); i += 3 {
+		result.WriteByte(sep)
 		result.WriteString(s[i : i+3])
-		if i+3 < n {
-			result.WriteByte('.')
-		}
 	}
-
-	// Handle cases like "100000" where the first part is empty
-	if n%3 == 0 && n > 0 {
-		return strings.TrimPrefix(result.String(), ".")
-	}
-
 	return result.String()
 }
 
@@ -4007,6 +6856,26 @@ func getClassImageURL(class string) string {
 	return classImages["Aprendiz"]
 }
 
+// itemImageURL returns the icon URL for an item ID using the
+// ITEM_IMAGE_URL template.
+func itemImageURL(itemID interface{}) string {
+	tmpl := config.DefaultItemImageURL
+	if appConfig != nil && appConfig.ItemImageURL != "" {
+		tmpl = appConfig.ItemImageURL
+	}
+	return fmt.Sprintf(tmpl, itemID)
+}
+
+// itemImageOrigin returns the scheme and host of the item image source
+// for a preconnect hint, or "" when icons are served from this site.
+func itemImageOrigin() string {
+	u, err := url.Parse(itemImageURL(0))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
 // tmplHTML marks a string as safe HTML for the template.
 func tmplHTML(s string) template.HTML {
 	return template.HTML(s)
@@ -4050,8 +6919,13 @@ func dict(values ...interface{}) (map[string]interface{}, error) {
 
 func fetchCharacterResults(wg *sync.WaitGroup, results *[]GlobalSearchCharacterResult, likeQuery string) {
 	defer wg.Done()
-	query := "SELECT name, class, guild_name FROM characters WHERE name LIKE ? LIMIT 10"
-	rows, err := srv.db.Query(query, likeQuery)
+	query := "SELECT name, class, guild_name FROM characters WHERE name LIKE ? ESCAPE '\\'"
+	params := []interface{}{likeQuery}
+	if exclude, excludeArgs := excludedNamesCondition("name"); exclude != "" {
+		query += " AND " + exclude
+		params = append(params, excludeArgs...)
+	}
+	rows, err := srv.db.Query(query+" LIMIT 10", params...)
 	if err != nil {
 		log.Printf("[W] [GlobalSearch] Character search failed: %v", err)
 		return
@@ -4068,7 +6942,7 @@ func fetchCharacterResults(wg *sync.WaitGroup, results *[]GlobalSearchCharacterR
 
 func fetchGuildResults(wg *sync.WaitGroup, results *[]GlobalSearchGuildResult, likeQuery string) {
 	defer wg.Done()
-	query := "SELECT name, master FROM guilds WHERE name LIKE ? OR master LIKE ? LIMIT 10"
+	query := "SELECT name, master FROM guilds WHERE name LIKE ? ESCAPE '\\' OR master LIKE ? ESCAPE '\\' LIMIT 10"
 	rows, err := srv.db.Query(query, likeQuery, likeQuery)
 	if err != nil {
 		log.Printf("[W] [GlobalSearch] Guild search failed: %v", err)
@@ -4088,9 +6962,13 @@ func fetchChatResults(wg *sync.WaitGroup, results *[]GlobalSearchChatResult, lik
 	defer wg.Done()
 	query := `
 		SELECT character_name, message, channel, timestamp FROM chat 
-		WHERE (character_name LIKE ? OR message LIKE ?) AND channel != 'Local' 
-		ORDER BY timestamp DESC LIMIT 20`
-	rows, err := srv.db.Query(query, likeQuery, likeQuery)
+		WHERE (character_name LIKE ? ESCAPE '\' OR message LIKE ? ESCAPE '\') AND channel != 'Local' `
+	params := []interface{}{likeQuery, likeQuery}
+	if exclude, excludeArgs := excludedNamesCondition("character_name"); exclude != "" {
+		query += "AND " + exclude
+		params = append(params, excludeArgs...)
+	}
+	rows, err := srv.db.Query(query+" ORDER BY timestamp DESC LIMIT 20", params...)
 	if err != nil {
 		log.Printf("[W] [GlobalSearch] Chat search failed: %v", err)
 		return
@@ -4101,7 +6979,7 @@ func fetchChatResults(wg *sync.WaitGroup, results *[]GlobalSearchChatResult, lik
 		var r GlobalSearchChatResult
 		if err := rows.Scan(&r.CharacterName, &r.Message, &r.Channel, &r.Timestamp); err == nil {
 			if parsedTime, err := time.Parse(time.RFC3339, r.Timestamp); err == nil {
-				r.FormattedTime = parsedTime.Format("2006-01-02 15:04")
+				r.FormattedTime = displayTime(parsedTime).Format("2006-01-02 15:04")
 			} else {
 				r.FormattedTime = r.Timestamp
 			}
@@ -4117,7 +6995,7 @@ func fetchTradeResults(wg *sync.WaitGroup, results *[]GlobalSearchTradeResult, l
 		FROM trading_post_items i
 		JOIN trading_posts p ON i.post_id = p.id
 		LEFT JOIN internal_item_db local_db ON i.item_id = local_db.item_id
-		WHERE i.item_name LIKE ?
+		WHERE i.item_name LIKE ? ESCAPE '\'
 		GROUP BY p.id, i.item_name
 		ORDER BY p.created_at DESC LIMIT 20`
 
@@ -4136,18 +7014,30 @@ func fetchTradeResults(wg *sync.WaitGroup, results *[]GlobalSearchTradeResult, l
 	}
 }
 
-// globalSearchHandler handles the cross-table search page.
+// minGlobalSearchLen is the shortest query the global search runs. Each
+// search is five unindexed LIKE scans, and a one-letter pattern matches
+// nearly every row.
+const minGlobalSearchLen = 2
+
+// globalSearchHandler handles the cross-table search page. Queries
+// shorter than minGlobalSearchLen are answered 400 without touching the
+// database; /search is also rate limited per IP (see searchRateLimit).
 func globalSearchHandler(w http.ResponseWriter, r *http.Request) {
-	searchQuery := r.URL.Query().Get("q")
+	searchQuery := strings.TrimSpace(r.URL.Query().Get("q"))
 
 	data := GlobalSearchPageData{
 		PageTitle:      "Global Search",
 		LastScrapeTime: GetLastScrapeTime(),
 		SearchQuery:    searchQuery,
+		MinQueryLength: minGlobalSearchLen,
 	}
 
-	if searchQuery != "" {
-		likeQuery := "%" + searchQuery + "%"
+	if searchQuery != "" && utf8.RuneCountInString(searchQuery) < minGlobalSearchLen {
+		data.QueryTooShort = true
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+	} else if searchQuery != "" {
+		likeQuery := "%" + escapeLike(searchQuery) + "%"
 		var wg sync.WaitGroup
 
 		wg.Add(5) // <-- MODIFIED: Changed from 4 to 5
@@ -4171,21 +7061,23 @@ func globalSearchHandler(w http.ResponseWriter, r *http.Request) {
 func fetchMarketResults(wg *sync.WaitGroup, results *[]ItemSummary, likeQuery string) {
 	defer wg.Done()
 
-	// This query finds unique items available on the market that match the search
+	// This query finds unique items available on the market that match the
+	// search. It groups by the raw listing name, which keeps the slot count,
+	// so "Jur [3]" and "Jur" stay separate results.
 	query := `
 		SELECT
 			i.name_of_the_item,
 			local_db.name_pt,
 			MAX(i.item_id) as item_id,
-			MIN(CASE WHEN i.is_available = 1 THEN CAST(REPLACE(i.price, ',', '') AS INTEGER) ELSE NULL END) as lowest_price,
+			MIN(CASE WHEN i.is_available = 1 THEN ` + marketPriceSQL("i.price") + ` ELSE NULL END) as lowest_price,
 			MAX(i.item_id) as highest_price, -- This field isn't used, but ItemSummary needs it
 			SUM(CASE WHEN i.is_available = 1 THEN 1 ELSE 0 END) as listing_count
 		FROM items i
 		LEFT JOIN internal_item_db local_db ON i.item_id = local_db.item_id
-		WHERE (i.name_of_the_item LIKE ? OR local_db.name_pt LIKE ?)
+		WHERE (i.name_of_the_item LIKE ? ESCAPE '\' OR local_db.name_pt LIKE ? ESCAPE '\')
 		  AND i.is_available = 1
 		GROUP BY i.name_of_the_item
-		ORDER BY listing_count DESC
+		ORDER BY listing_count DESC, i.name_of_the_item ASC
 		LIMIT 10
 	`
 
@@ -4201,24 +7093,51 @@ func fetchMarketResults(wg *sync.WaitGroup, results *[]ItemSummary, likeQuery st
 		// Note: Scanning into HighestPrice field even though it's not used,
 		// because the struct expects it.
 		if err := rows.Scan(&r.Name, &r.NamePT, &r.ItemID, &r.LowestPrice, &r.HighestPrice, &r.ListingCount); err == nil {
+			r.Slots = marketSlots(r.Name)
 			*results = append(*results, r)
 		}
 	}
 }
 
+// getDropPlayerInterval maps ?pinterval= to the change_time cutoff for the
+// top-droppers table. Unlike the market stats it defaults to all-time, so
+// an empty start time means no cutoff.
+func getDropPlayerInterval(intervalStr string) (string, string) {
+	now := time.Now()
+	switch intervalStr {
+	case "24h":
+		return intervalStr, now.Add(-24 * time.Hour).Format(time.RFC3339)
+	case "7d":
+		return intervalStr, now.Add(-7 * 24 * time.Hour).Format(time.RFC3339)
+	case "30d":
+		return intervalStr, now.Add(-30 * 24 * time.Hour).Format(time.RFC3339)
+	default:
+		return "all", ""
+	}
+}
+
 // fetchDropStatistics queries and aggregates all item drops from the structured changelog.
-func fetchDropStatistics(itemSortBy, itemOrder, playerSortBy, playerOrder string) ([]DropStatItem, int64, int64, []DropStatPlayer, error) {
+// playerSince, when set, restricts the player rankings to drops at or after that time.
+func fetchDropStatistics(itemSortBy, itemOrder, playerSortBy, playerOrder, playerSince string) ([]DropStatItem, int64, int64, []DropStatPlayer, error) {
 	log.Println("[I] [HTTP/Stats] Fetching drop statistics (Optimized)...")
+	defer logSlowQuery("fetchDropStatistics", time.Now())
+
+	// Pseudo-characters such as "System" are not players; every count
+	// below leaves them out.
+	exclude, excludeArgs := excludedNamesCondition("cl.character_name")
+	if exclude != "" {
+		exclude = " AND " + exclude
+	}
 
 	// 1. Get KPIs (Total Drops, Unique Items)
 	var totalDrops, uniqueDropItems int64
 	kpiQuery := `
 		SELECT
 			COUNT(*),
-			COUNT(DISTINCT SUBSTR(activity_description, 15))
-		FROM character_changelog
-		WHERE event_kind = 'drop'`
-	err := srv.db.QueryRow(kpiQuery).Scan(&totalDrops, &uniqueDropItems)
+			COUNT(DISTINCT SUBSTR(cl.activity_description, 15))
+		FROM character_changelog cl
+		WHERE cl.event_kind = 'drop'` + exclude
+	err := srv.db.QueryRow(kpiQuery, excludeArgs...).Scan(&totalDrops, &uniqueDropItems)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, 0, 0, nil, nil // No drops, not an error
@@ -4232,6 +7151,8 @@ func fetchDropStatistics(itemSortBy, itemOrder, playerSortBy, playerOrder string
 
 	// 2. Define the Common Table Expression (CTE) to get a clean, de-duplicated
 	// list of drops, each with its canonical item_id, name_en, name_pt, and type.
+	// The trailing %s takes extra WHERE conditions (the excluded names and
+	// the player window).
 	const cte = `
 	WITH deduped_logs AS (
 		SELECT
@@ -4248,7 +7169,7 @@ func fetchDropStatistics(itemSortBy, itemOrder, playerSortBy, playerOrder string
 		LEFT JOIN
 			internal_item_db i ON SUBSTR(cl.activity_description, 15) = i.name OR SUBSTR(cl.activity_description, 15) = i.name_pt
 		WHERE
-			cl.event_kind = 'drop'
+			cl.event_kind = 'drop'%s
 		GROUP BY
 			cl.id, cl.change_time, cl.character_name, cl.activity_description
 	)
@@ -4284,9 +7205,9 @@ func fetchDropStatistics(itemSortBy, itemOrder, playerSortBy, playerOrder string
 			COALESCE(t.item_id, t.log_name), 
 			COALESCE(t.name_en, t.log_name), 
 			t.name_pt
-		%s`, cte, itemOrderBy)
+		%s`, fmt.Sprintf(cte, exclude), itemOrderBy)
 
-	rows, err := srv.db.Query(itemQuery)
+	rows, err := srv.db.Query(itemQuery, excludeArgs...)
 	if err != nil {
 		return nil, totalDrops, uniqueDropItems, nil, fmt.Errorf("could not query for item drop stats: %w", err)
 	}
@@ -4302,7 +7223,7 @@ func fetchDropStatistics(itemSortBy, itemOrder, playerSortBy, playerOrder string
 			continue
 		}
 		if parsedTime, pErr := time.Parse(time.RFC3339, lastSeenStr); pErr == nil {
-			item.LastSeen = parsedTime.Format("2006-01-02 15:04")
+			item.LastSeen = displayTime(parsedTime).Format("2006-01-02 15:04")
 		} else {
 			item.LastSeen = lastSeenStr
 		}
@@ -4326,7 +7247,14 @@ func fetchDropStatistics(itemSortBy, itemOrder, playerSortBy, playerOrder string
 	}
 	playerOrderBy := fmt.Sprintf("ORDER BY %s %s, character_name ASC", playerSortCol, playerOrder)
 
-	// This query also groups the results from the CTE.
+	// This query also groups the results from the CTE, limited to the
+	// selected window when there is one.
+	playerWindow := exclude
+	playerArgs := slices.Clone(excludeArgs)
+	if playerSince != "" {
+		playerWindow += " AND cl.change_time >= ?"
+		playerArgs = append(playerArgs, playerSince)
+	}
 	playerQuery := fmt.Sprintf(`
 		%s
 		SELECT
@@ -4337,9 +7265,9 @@ func fetchDropStatistics(itemSortBy, itemOrder, playerSortBy, playerOrder string
 		FROM deduped_logs AS t
 		GROUP BY
 			t.character_name
-		%s`, cte, playerOrderBy)
+		%s`, fmt.Sprintf(cte, playerWindow), playerOrderBy)
 
-	rows, err = srv.db.Query(playerQuery)
+	rows, err = srv.db.Query(playerQuery, playerArgs...)
 	if err != nil {
 		return nil, totalDrops, uniqueDropItems, nil, fmt.Errorf("could not query for player drop stats: %w", err)
 	}
@@ -4390,10 +7318,12 @@ func dropStatsHandler(w http.ResponseWriter, r *http.Request) {
 	playerSortReq, _ := http.NewRequest("GET", fmt.Sprintf("/?sort_by=%s&order=%s", playerSortBy, playerOrder), nil)
 	_, playerSortBy, playerOrder = httpx.GetSortClause(playerSortReq, playerAllowedSorts, "count", "DESC")
 
+	playerInterval, playerSince := getDropPlayerInterval(r.FormValue("pinterval"))
+
 	// --- MODIFIED: Pass all sort params ---
-	stats, total, unique, playerStats, err := fetchDropStatistics(itemSortBy, itemOrder, playerSortBy, playerOrder)
+	stats, total, unique, playerStats, err := fetchDropStatistics(itemSortBy, itemOrder, playerSortBy, playerOrder, playerSince)
 	if err != nil {
-		log.Printf("[E] [HTTP/Stats] Could not fetch drop stats: %v", err)
+		logRequestf(r, "[E] [HTTP/Stats] Could not fetch drop stats: %v", err)
 		http.Error(w, "Could not fetch drop statistics", http.StatusInternalServerError)
 		return
 	}
@@ -4409,9 +7339,10 @@ func dropStatsHandler(w http.ResponseWriter, r *http.Request) {
 		ItemOrder:       itemOrder,
 		PlayerSortBy:    playerSortBy,
 		PlayerOrder:     playerOrder,
+		PlayerInterval:  playerInterval,
 	}
 
-	renderTemplate(w, r, "drop_stats.html", data)
+	renderMultiSortTemplate(w, r, "drop_stats.html", data, sortParam{"isort", itemSortBy}, sortParam{"psort", playerSortBy})
 }
 
 // countCharacterChangelog counts all changelog entries for a character, with an optional search filter.
@@ -4441,8 +7372,9 @@ func countCharacterChangelog(charName, searchQuery string) (int, error) {
 }
 
 // NEW: Helper function for itemHistoryHandler
-func fetchItemDropHistory(itemName string) ([]PlayerDropInfo, error) {
+func fetchItemDropHistory(itemName string) ([]PlayerDropInfo, bool, error) {
 	var dropHistory []PlayerDropInfo
+	limit := maxResultRows()
 
 	// Construct the exact activity description to search for
 	activityDesc := "Dropped item: " + itemName
@@ -4452,15 +7384,19 @@ func fetchItemDropHistory(itemName string) ([]PlayerDropInfo, error) {
 		FROM character_changelog
 		WHERE activity_description = ?
 		ORDER BY change_time DESC
+		LIMIT ?
 	`
 
-	rows, err := srv.db.Query(query, activityDesc)
+	rows, err := srv.db.Query(query, activityDesc, limit+1)
 	if err != nil {
-		return nil, fmt.Errorf("could not query changelog for item drops: %w", err)
+		return nil, false, fmt.Errorf("could not query changelog for item drops: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
+		if len(dropHistory) == limit {
+			return dropHistory, true, nil
+		}
 		var drop PlayerDropInfo
 		var timestampStr string
 		if err := rows.Scan(&drop.PlayerName, &timestampStr); err != nil {
@@ -4469,14 +7405,225 @@ func fetchItemDropHistory(itemName string) ([]PlayerDropInfo, error) {
 		}
 
 		if parsedTime, err := time.Parse(time.RFC3339, timestampStr); err == nil {
-			drop.Timestamp = parsedTime.Format("2006-01-02 15:04")
+			drop.Timestamp = displayTime(parsedTime).Format("2006-01-02 15:04")
 		} else {
 			drop.Timestamp = timestampStr
 		}
 		dropHistory = append(dropHistory, drop)
 	}
 
-	return dropHistory, nil
+	return dropHistory, false, nil
+}
+
+// fetchCharacterDrops returns a character's drop log, newest first, with
+// each item resolved against the local item DB by English or Portuguese
+// name where possible. Each distinct name is resolved once, through the
+// in-memory item cache.
+func fetchCharacterDrops(charName string) ([]CharacterDrop, bool, error) {
+	limit := maxResultRows()
+	rows, err := srv.db.Query(`
+		SELECT substr(activity_description, length('Dropped item: ') + 1), change_time
+		FROM character_changelog
+		WHERE character_name = ? AND event_kind = 'drop'
+		ORDER BY change_time DESC
+		LIMIT ?`, charName, limit+1)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not query character drops: %w", err)
+	}
+	defer rows.Close()
+
+	drops := []CharacterDrop{}
+	truncated := false
+	for rows.Next() {
+		if len(drops) == limit {
+			truncated = true
+			break
+		}
+		var drop CharacterDrop
+		var timestampStr string
+		if err := rows.Scan(&drop.ItemName, &timestampStr); err != nil {
+			log.Printf("[W] [HTTP/CharDrops] Failed to scan drop row: %v", err)
+			continue
+		}
+		if parsedTime, err := time.Parse(time.RFC3339, timestampStr); err == nil {
+			drop.Timestamp = displayTime(parsedTime).Format("2006-01-02 15:04")
+		} else {
+			drop.Timestamp = timestampStr
+		}
+		drops = append(drops, drop)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("could not read character drops: %w", err)
+	}
+	rows.Close()
+
+	resolved := make(map[string]sql.NullInt64)
+	var ids []int64
+	for _, drop := range drops {
+		if _, seen := resolved[drop.ItemName]; seen {
+			continue
+		}
+		id, _ := findItemIDInCache(drop.ItemName, 0)
+		resolved[drop.ItemName] = id
+		if id.Valid {
+			ids = append(ids, id.Int64)
+		}
+	}
+	namesPT, err := itemNamesPT(ids)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not load drop item names: %w", err)
+	}
+	for i := range drops {
+		if id := resolved[drops[i].ItemName]; id.Valid {
+			drops[i].ItemID = &id.Int64
+			drops[i].NamePT = namesPT[id.Int64]
+		}
+	}
+	return drops, truncated, nil
+}
+
+// itemNamesPT returns the Portuguese names of ids from the local item DB,
+// leaving out items without one.
+func itemNamesPT(ids []int64) (map[int64]string, error) {
+	names := make(map[int64]string, len(ids))
+	if len(ids) == 0 {
+		return names, nil
+	}
+	idsJSON, _ := json.Marshal(ids)
+	rows, err := srv.db.Query(`SELECT item_id, name_pt FROM internal_item_db WHERE name_pt IS NOT NULL AND name_pt != '' AND item_id IN (SELECT value FROM json_each(?))`, string(idsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// characterDropsHandler serves /character/drops as a JSON array. The
+// response is always JSON; format=json is accepted for symmetry with the
+// other export endpoints.
+func characterDropsHandler(w http.ResponseWriter, r *http.Request) {
+	charName := r.URL.Query().Get("name")
+	if charName == "" {
+		http.Error(w, "Character name is required", http.StatusBadRequest)
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "" && !httpx.WantsJSON(r) {
+		http.Error(w, "format must be json", http.StatusBadRequest)
+		return
+	}
+
+	drops, truncated, err := fetchCharacterDrops(charName)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/CharDrops] %v", err)
+		http.Error(w, "Could not query character drops", http.StatusInternalServerError)
+		return
+	}
+	// The body stays a bare array, so truncation is flagged in a header.
+	if truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+
+	if err := httpx.WriteJSON(w, http.StatusOK, drops); err != nil {
+		log.Printf("[W] [HTTP/CharDrops] Failed to write drops JSON for '%s': %v", charName, err)
+	}
+}
+
+// characterMvpHandler serves /character/mvp?name=X&format=json: the
+// character's MVP kills per mob ID with the display offset applied.
+// Characters with no record get an empty map rather than a 404.
+func characterMvpHandler(w http.ResponseWriter, r *http.Request) {
+	charName := r.URL.Query().Get("name")
+	if charName == "" {
+		http.Error(w, "Character name is required", http.StatusBadRequest)
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "" && !httpx.WantsJSON(r) {
+		http.Error(w, "format must be json", http.StatusBadRequest)
+		return
+	}
+
+	if err := httpx.WriteJSON(w, http.StatusOK, characterMvpKills(charName)); err != nil {
+		log.Printf("[W] [HTTP/CharMVP] Failed to write MVP kills JSON for '%s': %v", charName, err)
+	}
+}
+
+// characterMvpKills converts the MVP kill counts of charName to their
+// JSON form, dropping mobs with no kills after the display offset.
+func characterMvpKills(charName string) CharacterMvpKills {
+	entry := fetchCharacterMvpKills(charName)
+	resp := CharacterMvpKills{
+		CharacterName: charName,
+		Kills:         make(map[string]int),
+		Names:         make(map[string]string),
+	}
+	for mobID, kills := range entry.Kills {
+		if kills == 0 {
+			continue
+		}
+		resp.Kills[mobID] = kills
+		resp.Names[mobID] = mvpNames[mobID]
+		resp.TotalKills += kills
+	}
+	return resp
+}
+
+// fetchCharacterActivityCalendar counts a character's non-drop changelog
+// entries per day over the last year, keyed "YYYY-MM-DD". The day is
+// taken from the stored timestamp's own date part: SQLite's date() would
+// convert the RFC3339 offset to UTC and shift late-evening entries.
+func fetchCharacterActivityCalendar(charName string) (map[string]int, error) {
+	since := time.Now().AddDate(-1, 0, 0).Format(time.RFC3339)
+	rows, err := srv.db.Query(`
+		SELECT substr(change_time, 1, 10) AS day, COUNT(*)
+		FROM character_changelog
+		WHERE character_name = ?
+		  AND (event_kind IS NULL OR event_kind != 'drop')
+		  AND change_time >= ?
+		GROUP BY day`, charName, since)
+	if err != nil {
+		return nil, fmt.Errorf("could not query activity calendar: %w", err)
+	}
+	defer rows.Close()
+
+	calendar := make(map[string]int)
+	for rows.Next() {
+		var day string
+		var count int
+		if err := rows.Scan(&day, &count); err != nil {
+			return nil, fmt.Errorf("could not scan activity calendar row: %w", err)
+		}
+		calendar[day] = count
+	}
+	return calendar, rows.Err()
+}
+
+// characterActivityCalendarHandler serves /character/activity-calendar,
+// a JSON {date: count} map for rendering an activity heatmap.
+func characterActivityCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	charName := r.URL.Query().Get("name")
+	if charName == "" {
+		http.Error(w, "Character name is required", http.StatusBadRequest)
+		return
+	}
+
+	calendar, err := fetchCharacterActivityCalendar(charName)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/CharCalendar] %v", err)
+		http.Error(w, "Could not query character activity", http.StatusInternalServerError)
+		return
+	}
+
+	if err := httpx.WriteJSON(w, http.StatusOK, calendar); err != nil {
+		log.Printf("[W] [HTTP/CharCalendar] Failed to write calendar JSON for '%s': %v", charName, err)
+	}
 }
 
 // fetchCharacterSpecialHistory retrieves all Drop and Guild logs for a character in one query.
@@ -4485,7 +7632,7 @@ func fetchCharacterSpecialHistory(charName string) (guildHistory []CharacterChan
 		SELECT change_time, activity_description
 		FROM character_changelog
 		WHERE character_name = ?
-		  AND event_kind IN ('drop', 'guild_join', 'guild_leave', 'guild_move')
+		  AND event_kind IN ('drop', 'guild_join', 'guild_leave', 'guild_move', 'guild_master')
 		ORDER BY change_time DESC
 	`
 	rows, err := srv.db.Query(query, charName)
@@ -4506,11 +7653,11 @@ func fetchCharacterSpecialHistory(charName string) (guildHistory []CharacterChan
 
 		// Filter the results into their respective slices
 		if strings.HasPrefix(entry.ActivityDescription, "Dropped item: ") {
-			entry.ChangeTime = parsedTime.Format("2006-01-02 15:04")
+			entry.ChangeTime = displayTime(parsedTime).Format("2006-01-02 15:04")
 			entry.ActivityDescription = strings.TrimPrefix(entry.ActivityDescription, "Dropped item: ")
 			dropHistory = append(dropHistory, entry)
 		} else {
-			entry.ChangeTime = parsedTime.Format("2006-01-02") // Guild history only needs date
+			entry.ChangeTime = displayTime(parsedTime).Format("2006-01-02") // Guild history only needs date
 			guildHistory = append(guildHistory, entry)
 		}
 	}
@@ -4527,7 +7674,7 @@ func getCharacterStatsKPIs() (int64, int64, float64, float64, error) {
 	var totalChars, totalZeny int64
 	var avgBase, avgJob float64
 
-	query := `SELECT COUNT(*), SUM(zeny), AVG(base_level), AVG(job_level) FROM characters`
+	query := fmt.Sprintf("SELECT COUNT(*), SUM(%s), AVG(base_level), AVG(job_level) FROM characters", totalZenySQL())
 	err := srv.db.QueryRow(query).Scan(&totalChars, &totalZeny, &avgBase, &avgJob)
 	if err != nil {
 		return 0, 0, 0, 0, fmt.Errorf("could not query character KPIs: %w", err)
@@ -4575,17 +7722,26 @@ func getCharacterLevelDistribution() ([]LevelDistPoint, error) {
 }
 
 // getTopCharacters fetches a list of characters, ordered by a specific column.
-func getTopCharacters(orderBy string, limit int) ([]PlayerCharacter, error) {
+// whereClause is either empty or a full "WHERE ..." fragment.
+func getTopCharacters(whereClause, orderBy string, limit int) ([]PlayerCharacter, error) {
+	exclude, params := excludedNamesCondition("name")
+	if exclude != "" && whereClause == "" {
+		whereClause = "WHERE " + exclude
+	} else if exclude != "" {
+		whereClause += " AND " + exclude
+	}
+
 	// We re-use PlayerCharacter struct, but only need to populate fields
 	// used by the template table.
 	query := fmt.Sprintf(`
 		SELECT rank, name, class, guild_name, zeny, base_level, job_level, experience
 		FROM characters
+		%s
 		ORDER BY %s
 		LIMIT %d
-	`, orderBy, limit)
+	`, whereClause, orderBy, limit)
 
-	rows, err := srv.db.Query(query)
+	rows, err := srv.db.Query(query, params...)
 	if err != nil {
 		return nil, fmt.Errorf("could not query top characters: %w", err)
 	}
@@ -4622,7 +7778,7 @@ func characterStatsHandler(w http.ResponseWriter, r *http.Request) {
 	var kpiErr error
 	data.TotalCharacters, data.TotalZeny, data.AvgBaseLevel, data.AvgJobLevel, kpiErr = getCharacterStatsKPIs()
 	if kpiErr != nil {
-		log.Printf("[E] [HTTP/CharStats] %v", kpiErr)
+		logRequestf(r, "[E] [HTTP/CharStats] %v", kpiErr)
 		// Don't fail the page, just show 0s
 	}
 
@@ -4641,26 +7797,327 @@ func characterStatsHandler(w http.ResponseWriter, r *http.Request) {
 	// 3. Fetch Level Distribution Chart data
 	levelDist, err := getCharacterLevelDistribution()
 	if err != nil {
-		log.Printf("[E] [HTTP/CharStats] %v", err)
+		logRequestf(r, "[E] [HTTP/CharStats] %v", err)
 	}
 	levelDistJSON, _ := json.Marshal(levelDist)
 	data.LevelDistributionJSON = template.JS(levelDistJSON)
 
 	// 4. Fetch Top 10 Richest
-	data.TopRichestCharacters, err = getTopCharacters("zeny DESC", 10)
+	data.TopRichestCharacters, err = getTopCharacters("", "zeny DESC", 10)
 	if err != nil {
-		log.Printf("[E] [HTTP/CharStats] %v", err)
+		logRequestf(r, "[E] [HTTP/CharStats] %v", err)
 	}
 
 	// 5. Fetch Top 10 by Experience
-	data.TopExperienceCharacters, err = getTopCharacters("base_level DESC, experience DESC", 10)
+	data.TopExperienceCharacters, err = getTopCharacters("", "base_level DESC, experience DESC", 10)
 	if err != nil {
-		log.Printf("[E] [HTTP/CharStats] %v", err)
+		logRequestf(r, "[E] [HTTP/CharStats] %v", err)
 	}
 
 	renderTemplate(w, r, "character_stats.html", data)
 }
 
+// zenyCap is the in-game zeny limit. Characters sitting exactly on it are
+// almost always banks or mules and skew wealth rankings.
+const zenyCap = 2147483647
+
+// fetchGuildWealthRanking returns the top guilds by combined member zeny,
+// reusing the guild page's member aggregation.
+func fetchGuildWealthRanking(memberCondition string, limit int) ([]WealthGuildEntry, error) {
+	query := fmt.Sprintf(`
+		SELECT g.name, g.master, cs.member_count, cs.total_zeny
+		FROM guilds g
+		JOIN (%s) cs ON g.name = cs.guild_name
+		WHERE g.is_active = 1
+		ORDER BY cs.total_zeny DESC
+		LIMIT ?`, guildMemberStatsSQL(memberCondition))
+
+	rows, err := srv.db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not query guild wealth ranking: %w", err)
+	}
+	defer rows.Close()
+
+	var guilds []WealthGuildEntry
+	for rows.Next() {
+		var g WealthGuildEntry
+		if err := rows.Scan(&g.Name, &g.Master, &g.MemberCount, &g.TotalZeny); err != nil {
+			return nil, fmt.Errorf("failed to scan guild wealth row: %w", err)
+		}
+		g.Rank = len(guilds) + 1
+		guilds = append(guilds, g)
+	}
+	return guilds, nil
+}
+
+// wealthStatsHandler serves /stats/wealth: the richest characters and the
+// richest guilds side by side. ?format=json returns the same data as JSON.
+func wealthStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	const defaultLimit, maxLimit = 20, 100
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		limit = maxLimit
+	}
+	excludeCapped := r.FormValue("exclude_capped") == "true"
+
+	var charWhere, memberCondition string
+	if excludeCapped {
+		charWhere = fmt.Sprintf("WHERE zeny < %d", zenyCap)
+		memberCondition = fmt.Sprintf(" AND zeny < %d", zenyCap)
+	}
+
+	data := WealthStatsPageData{
+		PageTitle:               "Wealth Stats",
+		LastCharacterScrapeTime: GetLastCharacterScrapeTime(),
+		Limit:                   limit,
+		ExcludeCapped:           excludeCapped,
+		Characters:              []WealthCharacterEntry{},
+		Guilds:                  []WealthGuildEntry{},
+	}
+
+	players, err := getTopCharacters(charWhere, "zeny DESC", limit)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/Wealth] %v", err)
+	}
+	for i, p := range players {
+		data.Characters = append(data.Characters, WealthCharacterEntry{
+			Rank:      i + 1,
+			Name:      p.Name,
+			Class:     p.Class,
+			GuildName: p.GuildName.String,
+			Zeny:      p.Zeny,
+		})
+	}
+
+	if guilds, err := fetchGuildWealthRanking(memberCondition, limit); err != nil {
+		logRequestf(r, "[E] [HTTP/Wealth] %v", err)
+	} else if guilds != nil {
+		data.Guilds = guilds
+	}
+
+	if httpx.WantsJSON(r) {
+		if err := httpx.WriteJSON(w, http.StatusOK, data); err != nil {
+			logRequestf(r, "[E] [HTTP/Wealth] Could not encode wealth JSON: %v", err)
+		}
+		return
+	}
+
+	filterValues := url.Values{}
+	filterValues.Set("limit", strconv.Itoa(limit))
+	if excludeCapped {
+		filterValues.Set("exclude_capped", "true")
+	}
+	data.Filter = template.URL(filterValues.Encode())
+
+	renderTemplate(w, r, "wealth_stats.html", data)
+}
+
+// classChangeWhere matches class-change changelog rows, including ones
+// written before event_kind was populated.
+const classChangeWhere = `(event_kind = 'class_change'
+	OR (event_kind IS NULL AND activity_description LIKE 'Changed class from %'))`
+
+// parseClassChange extracts the old and new class from a changelog
+// description such as "Changed class from 'Mago' to 'Bruxo'.".
+func parseClassChange(desc string) (from, to string, ok bool) {
+	m := classChangeRegex.FindStringSubmatch(desc)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// rebirthStatsHandler serves /stats/rebirths: recent class changes parsed
+// out of the character changelog, plus the most common transitions.
+func rebirthStatsHandler(w http.ResponseWriter, r *http.Request) {
+	const eventsPerPage, topTransitions = 50, 10
+
+	total, err := queryCount("SELECT COUNT(*) FROM character_changelog WHERE " + classChangeWhere)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/Rebirths] Could not count class changes: %v", err)
+		http.Error(w, "Could not count class changes", http.StatusInternalServerError)
+		return
+	}
+	pagination := httpx.NewPaginationData(r, total, eventsPerPage)
+
+	data := RebirthStatsPageData{
+		PageTitle:               "Rebirth Stats",
+		LastCharacterScrapeTime: GetLastCharacterScrapeTime(),
+		TotalChanges:            total,
+		Events:                  []ClassChangeEvent{},
+		Transitions:             []ClassTransitionCount{},
+		Pagination:              pagination,
+	}
+
+	rows, err := srv.db.Query(`
+		SELECT character_name, change_time, activity_description
+		FROM character_changelog
+		WHERE `+classChangeWhere+`
+		ORDER BY change_time DESC LIMIT ? OFFSET ?`, pagination.ItemsPerPage, pagination.Offset)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/Rebirths] Could not query class changes: %v", err)
+		http.Error(w, "Could not query class changes", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ev ClassChangeEvent
+		var changeTime, desc string
+		if err := rows.Scan(&ev.CharacterName, &changeTime, &desc); err != nil {
+			log.Printf("[W] [HTTP/Rebirths] Failed to scan class change row: %v", err)
+			continue
+		}
+		var ok bool
+		if ev.FromClass, ev.ToClass, ok = parseClassChange(desc); !ok {
+			continue
+		}
+		ev.ChangeTime = changeTime
+		if t, err := time.Parse(time.RFC3339, changeTime); err == nil {
+			ev.ChangeTime = displayTime(t).Format("2006-01-02 15:04")
+		}
+		data.Events = append(data.Events, ev)
+	}
+
+	// Identical descriptions are identical transitions, so grouping on the
+	// raw text is enough; parsing happens on the few grouped rows.
+	tRows, err := srv.db.Query(`
+		SELECT activity_description, COUNT(*) AS n
+		FROM character_changelog
+		WHERE `+classChangeWhere+`
+		GROUP BY activity_description
+		ORDER BY n DESC LIMIT ?`, topTransitions)
+	if err != nil {
+		logRequestf(r, "[W] [HTTP/Rebirths] Could not query class transitions: %v", err)
+	} else {
+		defer tRows.Close()
+		for tRows.Next() {
+			var desc string
+			var tc ClassTransitionCount
+			if err := tRows.Scan(&desc, &tc.Count); err != nil {
+				continue
+			}
+			if from, to, ok := parseClassChange(desc); ok {
+				tc.FromClass, tc.ToClass = from, to
+				data.Transitions = append(data.Transitions, tc)
+			}
+		}
+	}
+
+	if httpx.WantsJSON(r) {
+		if err := httpx.WriteJSON(w, http.StatusOK, data); err != nil {
+			logRequestf(r, "[W] [HTTP/Rebirths] Could not encode rebirth JSON: %v", err)
+		}
+		return
+	}
+	renderTemplate(w, r, "rebirth_stats.html", data)
+}
+
+// Window bounds for /stats/guild-churn, in hours.
+const (
+	guildChurnDefaultHours = 24
+	guildChurnMaxHours     = 30 * 24
+)
+
+// guildChurnWhere matches guild join/leave/move changelog rows, including
+// ones written before event_kind was populated.
+const guildChurnWhere = `(event_kind IN ('guild_join', 'guild_leave', 'guild_move')
+	OR (event_kind IS NULL AND (activity_description LIKE 'Joined guild %'
+		OR activity_description LIKE 'Left guild %'
+		OR activity_description LIKE 'Moved from guild %')))`
+
+// parseGuildChange extracts the guilds from a changelog description
+// written by processGuildData. A join has no from guild and a leave has
+// no to guild.
+func parseGuildChange(desc string) (kind, from, to string, ok bool) {
+	if m := guildMoveRegex.FindStringSubmatch(desc); m != nil {
+		return changelogKindGuildMove, m[1], m[2], true
+	}
+	if m := guildJoinRegex.FindStringSubmatch(desc); m != nil {
+		return changelogKindGuildJoin, "", m[1], true
+	}
+	if m := guildLeaveRegex.FindStringSubmatch(desc); m != nil {
+		return changelogKindGuildLeave, m[1], "", true
+	}
+	return "", "", "", false
+}
+
+// guildChurnHandler serves /stats/guild-churn: characters who joined, left
+// or moved between guilds in the last ?hours= hours, newest first.
+func guildChurnHandler(w http.ResponseWriter, r *http.Request) {
+	const eventsPerPage = 50
+
+	hours := guildChurnDefaultHours
+	if v, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && v > 0 {
+		hours = min(v, guildChurnMaxHours)
+	}
+	since := time.Now().Add(-time.Duration(hours) * time.Hour).Format(time.RFC3339)
+
+	total, err := queryCount("SELECT COUNT(*) FROM character_changelog WHERE "+guildChurnWhere+" AND change_time >= ?", since)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/GuildChurn] Could not count guild changes: %v", err)
+		http.Error(w, "Could not count guild changes", http.StatusInternalServerError)
+		return
+	}
+	pagination := httpx.NewPaginationData(r, total, eventsPerPage)
+
+	data := GuildChurnPageData{
+		PageTitle:           "Guild Churn",
+		LastGuildUpdateTime: GetLastGuildScrapeTime(),
+		Hours:               hours,
+		TotalChanges:        total,
+		Events:              []GuildChangeEvent{},
+		Pagination:          pagination,
+		Filter:              template.URL("&hours=" + strconv.Itoa(hours)),
+	}
+
+	rows, err := srv.db.Query(`
+		SELECT character_name, change_time, activity_description
+		FROM character_changelog
+		WHERE `+guildChurnWhere+` AND change_time >= ?
+		ORDER BY change_time DESC LIMIT ? OFFSET ?`, since, pagination.ItemsPerPage, pagination.Offset)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/GuildChurn] Could not query guild changes: %v", err)
+		http.Error(w, "Could not query guild changes", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ev GuildChangeEvent
+		var changeTime, desc string
+		if err := rows.Scan(&ev.CharacterName, &changeTime, &desc); err != nil {
+			log.Printf("[W] [HTTP/GuildChurn] Failed to scan guild change row: %v", err)
+			continue
+		}
+		var ok bool
+		if ev.Kind, ev.FromGuild, ev.ToGuild, ok = parseGuildChange(desc); !ok {
+			continue
+		}
+		ev.ChangeTime = changeTime
+		if t, err := time.Parse(time.RFC3339, changeTime); err == nil {
+			ev.ChangeTime = displayTime(t).Format("2006-01-02 15:04")
+		}
+		data.Events = append(data.Events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		logRequestf(r, "[W] [HTTP/GuildChurn] Error iterating guild changes: %v", err)
+	}
+
+	if httpx.WantsJSON(r) {
+		if err := httpx.WriteJSON(w, http.StatusOK, data); err != nil {
+			logRequestf(r, "[W] [HTTP/GuildChurn] Could not encode guild churn JSON: %v", err)
+		}
+		return
+	}
+	renderTemplate(w, r, "guild_churn.html", data)
+}
+
 // ... (rest of handlers.go)
 
 // defaultFunc returns the default value if the given value is an empty string.
@@ -1,8 +1,28 @@
 package server
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/denislee/yufa-mt/internal/config"
+	"github.com/denislee/yufa-mt/internal/httpx"
+	"github.com/denislee/yufa-mt/internal/metrics"
+	"github.com/denislee/yufa-mt/internal/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestFormatZeny(t *testing.T) {
@@ -28,6 +48,32 @@ func TestFormatZeny(t *testing.T) {
 	}
 }
 
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		lang string
+		want string
+	}{
+		{0, "en", "0"},
+		{int64(0), "pt", "0"},
+		{999, "en", "999"},
+		{1000, "en", "1,000"},
+		{1000, "pt", "1.000"},
+		{int64(123456), "en", "123,456"},
+		{int64(2147483648), "en", "2,147,483,648"},
+		{int64(12345678901), "pt", "12.345.678.901"},
+		{int64(-1234567), "en", "-1,234,567"},
+		{int64(-100000), "pt", "-100.000"},
+		{5000, "", "5,000"},
+		{"n/a", "en", "n/a"},
+	}
+	for _, tc := range tests {
+		if got := formatNumber(tc.in, tc.lang); got != tc.want {
+			t.Errorf("formatNumber(%v, %q)=%q want %q", tc.in, tc.lang, got, tc.want)
+		}
+	}
+}
+
 func TestFormatRMT(t *testing.T) {
 	if got := formatRMT(0); got != "R$ 0" {
 		t.Errorf("formatRMT(0)=%q want R$ 0", got)
@@ -114,3 +160,2056 @@ func TestItemCacheOperations(t *testing.T) {
 		t.Errorf("Expected to still find Red Potion in cache with ID 501, got %v, %v", idNull, found)
 	}
 }
+
+func TestTradeWatchShouldSend(t *testing.T) {
+	t0 := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	key := "1|seller|elunium"
+
+	if !tradeWatchShouldSend(key, t0) {
+		t.Fatal("first notification should be sent")
+	}
+	if tradeWatchShouldSend(key, t0.Add(time.Minute)) {
+		t.Error("repeat inside the debounce window should be suppressed")
+	}
+	if !tradeWatchShouldSend("2|seller|elunium", t0.Add(time.Minute)) {
+		t.Error("a different watch should not be debounced")
+	}
+	if !tradeWatchShouldSend(key, t0.Add(tradeWatchDebounce)) {
+		t.Error("notification after the debounce window should be sent")
+	}
+}
+
+func TestMedianPrice(t *testing.T) {
+	tests := []struct {
+		in   []int64
+		want int64
+	}{
+		{[]int64{500}, 500},
+		{[]int64{100, 200, 900}, 200},
+		{[]int64{100, 200, 300, 10000}, 250},
+		{[]int64{1, 2}, 1},
+	}
+	for _, tc := range tests {
+		if got := medianPrice(tc.in); got != tc.want {
+			t.Errorf("medianPrice(%v)=%d want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestChatCaptureFilter(t *testing.T) {
+	if got := chatCaptureFilter("", "6121"); got != "tcp port 6121" {
+		t.Errorf("default filter = %q, want tcp port 6121", got)
+	}
+	if got := chatCaptureFilter("tcp port 6121 and host 10.0.0.2", "6121"); got != "tcp port 6121 and host 10.0.0.2" {
+		t.Errorf("SNIFFER_BPF override not used: %q", got)
+	}
+}
+
+func TestMvpDisplayKills(t *testing.T) {
+	cases := map[int]int{0: 0, 2: 0, MvpKillCountOffset: 0, MvpKillCountOffset + 5: 5}
+	for stored, want := range cases {
+		if got := mvpDisplayKills("1038", stored); got != want {
+			t.Errorf("mvpDisplayKills(1038, %d)=%d want %d", stored, got, want)
+		}
+	}
+
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	appConfig = &config.Config{MvpKillOffsets: map[string]int{"1511": 0, "1373": 5}}
+	for _, c := range []struct {
+		mobID        string
+		stored, want int
+	}{
+		{"1511", 0, 0},
+		{"1511", 2, 2},
+		{"1373", 4, 0},
+		{"1373", 7, 2},
+		{"1038", 7, 7 - MvpKillCountOffset}, // unlisted mobs keep the default
+	} {
+		if got := mvpDisplayKills(c.mobID, c.stored); got != c.want {
+			t.Errorf("mvpDisplayKills(%s, %d)=%d want %d", c.mobID, c.stored, got, c.want)
+		}
+	}
+}
+
+func TestRenderSortedTemplateNotice(t *testing.T) {
+	const name = "sort_notice_test.html"
+	templateCache[name] = template.Must(template.New(name).Parse(
+		`{{define "layout.html"}}{{.Page.IgnoredSort}}|{{.Page.EffectiveSort}}{{end}}`))
+	defer delete(templateCache, name)
+
+	cases := []struct {
+		query, sortBy, want string
+	}{
+		{"?sort_by=levle", "level", "levle|level"},
+		{"?sort_by=level", "level", "|"},
+		{"", "level", "|"},
+		{"?sort_by=levle", "", "|"}, // unsorted page
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		renderSortedTemplate(rec, httptest.NewRequest("GET", "/"+c.query, nil), name, struct{}{}, c.sortBy)
+		if got := rec.Body.String(); got != c.want {
+			t.Errorf("%q sorted by %q: got %q, want %q", c.query, c.sortBy, got, c.want)
+		}
+	}
+
+	// Pages with several tables report the first parameter that was dropped.
+	multi := []struct {
+		query, isort, ssort, want string
+	}{
+		{"?isort=name&ssort=zeny", "name", "zeny", "|"},
+		{"?isort=name&ssort=zny", "name", "count", "zny|count"},
+		{"?isort=nmae&ssort=zny", "count", "count", "nmae|count"},
+	}
+	for _, c := range multi {
+		rec := httptest.NewRecorder()
+		renderMultiSortTemplate(rec, httptest.NewRequest("GET", "/"+c.query, nil), name, struct{}{},
+			sortParam{"isort", c.isort}, sortParam{"ssort", c.ssort})
+		if got := rec.Body.String(); got != c.want {
+			t.Errorf("%q: got %q, want %q", c.query, got, c.want)
+		}
+	}
+}
+
+func TestRenderError(t *testing.T) {
+	stubTemplate(t, "error.html", `{{define "layout.html"}}{{.Data.Status}} {{.Data.Message}}{{end}}`)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/character?name=Nobody", nil)
+	req.AddCookie(&http.Cookie{Name: "lang", Value: "en"})
+	renderError(rec, req, http.StatusNotFound, "error_char_not_found")
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "404 Character not found" {
+		t.Errorf("en page: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	renderError(rec, httptest.NewRequest("GET", "/character?name=Nobody", nil), http.StatusNotFound, "error_char_not_found")
+	if rec.Body.String() != "404 Personagem não encontrado" {
+		t.Errorf("pt page: got %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/character?name=Nobody", nil)
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(&http.Cookie{Name: "lang", Value: "en"})
+	renderError(rec, req, http.StatusNotFound, "error_char_not_found")
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), `"error":"Character not found"`) {
+		t.Errorf("JSON: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// A broken error page falls back to plain text with the same status.
+	stubTemplate(t, "error.html", `{{define "layout.html"}}{{.Data.NoSuchField}}{{end}}`)
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/nowhere", nil)
+	req.AddCookie(&http.Cookie{Name: "lang", Value: "en"})
+	renderError(rec, req, http.StatusNotFound, "error_page_not_found")
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "Page not found\n" {
+		t.Errorf("fallback: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestItemImageURL(t *testing.T) {
+	if got := itemImageURL(501); got != "https://static.divine-pride.net/images/items/item/501.png" {
+		t.Errorf("default itemImageURL(501) = %q", got)
+	}
+	if got := itemImageOrigin(); got != "https://static.divine-pride.net" {
+		t.Errorf("default itemImageOrigin() = %q", got)
+	}
+
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	appConfig = &config.Config{ItemImageURL: "/static/items/%d.png"}
+	if got := itemImageURL(int64(4001)); got != "/static/items/4001.png" {
+		t.Errorf("itemImageURL(int64(4001)) = %q", got)
+	}
+	if got := itemImageOrigin(); got != "" {
+		t.Errorf("itemImageOrigin() for a local mirror = %q, want empty", got)
+	}
+}
+
+func TestItemCategories(t *testing.T) {
+	if got := mapItemTypeToDBTypes("Armor"); len(got) != 2 || got[0] != "Armor" || got[1] != "ShadowGear" {
+		t.Errorf("default Armor tab DB types = %v, want [Armor ShadowGear]", got)
+	}
+	if got := itemCategory("shadowgear"); got != "Armor" {
+		t.Errorf("itemCategory(shadowgear) = %q, want Armor", got)
+	}
+	if got := mapItemTypeToDBTypes("Delayconsume"); len(got) != 1 || got[0] != "Delayconsume" {
+		t.Errorf("unmapped tab should be used as a raw DB type, got %v", got)
+	}
+
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	appConfig = &config.Config{ItemCategoryGroups: map[string]string{"ShadowGear": "Shadow Gear"}}
+	if got := mapItemTypeToDBTypes("Armor"); len(got) != 1 || got[0] != "Armor" {
+		t.Errorf("Armor tab with shadow gear split out = %v, want [Armor]", got)
+	}
+	if got := itemCategory("ShadowGear"); got != "Shadow Gear" {
+		t.Errorf("itemCategory(ShadowGear) = %q, want Shadow Gear", got)
+	}
+	clause, params := itemTypeFilter("local_db.type", "Pet Armor")
+	if clause != "local_db.type IN (?, ?)" || len(params) != 2 {
+		t.Errorf("itemTypeFilter(Pet Armor) = %q %v", clause, params)
+	}
+}
+
+func TestStaleDataFor(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, displayLocation)
+	lastScrape := now.Add(-3 * time.Hour).Format("2006-01-02 15:04:05")
+	pageDataSources["test_stale.html"] = pageDataSource{"stale_src_market", func() string { return lastScrape }, time.Hour}
+	pageDataSources["test_never.html"] = pageDataSource{"stale_src_market", func() string { return "Never" }, time.Hour}
+	savedConfig := appConfig
+	defer func() {
+		appConfig = savedConfig
+		delete(pageDataSources, "test_stale.html")
+		delete(pageDataSources, "test_never.html")
+	}()
+
+	appConfig = &config.Config{StaleDataHours: 2}
+	if key, hours, stale := staleDataFor("test_stale.html", now); !stale || key != "stale_src_market" || hours != 2 {
+		t.Errorf("3h-old scrape with a 2h threshold = (%q, %d, %v), want stale", key, hours, stale)
+	}
+	if _, _, stale := staleDataFor("test_never.html", now); stale {
+		t.Error("a scrape that never ran should not be flagged")
+	}
+	if _, _, stale := staleDataFor("about.html", now); stale {
+		t.Error("pages without a data source should not be flagged")
+	}
+
+	appConfig = &config.Config{StaleDataHours: 4}
+	if _, _, stale := staleDataFor("test_stale.html", now); stale {
+		t.Error("3h-old scrape with a 4h threshold should not be flagged")
+	}
+	appConfig = &config.Config{StaleDataHours: 0}
+	if _, _, stale := staleDataFor("test_stale.html", now); stale {
+		t.Error("STALE_DATA_HOURS=0 should disable the banner")
+	}
+}
+
+func TestUpdatedFreshness(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, displayLocation)
+	var lastScrape string
+	pageDataSources["test_fresh.html"] = pageDataSource{"stale_src_market", func() string { return lastScrape }, 10 * time.Minute}
+	savedConfig := appConfig
+	defer func() {
+		appConfig = savedConfig
+		delete(pageDataSources, "test_fresh.html")
+	}()
+
+	appConfig = &config.Config{UpdatedFreshIntervals: 2, UpdatedStaleIntervals: 6}
+	for _, tc := range []struct {
+		age  time.Duration
+		want string
+	}{
+		{5 * time.Minute, "fresh"},
+		{20 * time.Minute, "fresh"},
+		{21 * time.Minute, "stale"},
+		{time.Hour, "stale"},
+		{61 * time.Minute, "very-stale"},
+	} {
+		lastScrape = now.Add(-tc.age).Format("2006-01-02 15:04:05")
+		if got := updatedFreshness("test_fresh.html", now); got != tc.want {
+			t.Errorf("scrape %v old with a 10m interval = %q, want %q", tc.age, got, tc.want)
+		}
+	}
+
+	lastScrape = "Never"
+	if got := updatedFreshness("test_fresh.html", now); got != "" {
+		t.Errorf("a scrape that never ran = %q, want \"\"", got)
+	}
+	if got := updatedFreshness("about.html", now); got != "" {
+		t.Errorf("a page without a data source = %q, want \"\"", got)
+	}
+}
+
+func TestGenerateEventIntervalsDisplayDates(t *testing.T) {
+	saved := displayLocation
+	displayLocation = time.FixedZone("UTC+5", 5*60*60)
+	defer func() { displayLocation = saved }()
+
+	events := []EventDefinition{{Name: "BG", StartTime: "20:00", EndTime: "21:00", Days: []time.Weekday{time.Monday, time.Tuesday}}}
+	viewStart := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC) // Monday
+	viewEnd := viewStart.AddDate(0, 0, 2)
+
+	// Monday's 20:00 UTC event is Tuesday 01:00 on the display clock, the
+	// only display date with data.
+	got := generateEventIntervals(viewStart, viewEnd, events, map[string]struct{}{"2025-01-07": {}})
+	if len(got) != 1 || got[0]["start"] != "2025-01-07 01:00" {
+		t.Errorf("generateEventIntervals = %v, want Monday's event only", got)
+	}
+}
+
+func TestFindPriceOutliers(t *testing.T) {
+	start := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return start.AddDate(0, 0, d) }
+	sales := []soldPrice{
+		{ID: 1, Item: "Apple", At: day(-3), Price: 100},
+		{ID: 2, Item: "Apple", At: day(-2), Price: 110},
+		{ID: 3, Item: "Apple", At: day(-1), Price: 90},
+		{ID: 4, Item: "Apple", At: day(0), Price: 10000}, // typo, 100x the median
+		{ID: 5, Item: "Apple", At: day(1), Price: 105},
+		{ID: 6, Item: "Apple", At: day(2), Price: 1}, // typo, 100x below
+		{ID: 7, Item: "Elunium", At: day(0), Price: 5000},
+		{ID: 8, Item: "Elunium", At: day(1), Price: 900000}, // only 2 sales in window
+		{ID: 9, Item: "Jellopy", At: day(-20), Price: 10},
+		{ID: 10, Item: "Jellopy", At: day(-19), Price: 10},
+		{ID: 11, Item: "Jellopy", At: day(0), Price: 5000}, // old sales fall outside the window
+	}
+
+	got := findPriceOutliers(sales, start, 7*24*time.Hour, 10)
+	if len(got) != 2 || got[0] != 4 || got[1] != 6 {
+		t.Errorf("findPriceOutliers = %v, want [4 6]", got)
+	}
+	if got := findPriceOutliers(sales, day(1), 7*24*time.Hour, 10); len(got) != 1 || got[0] != 6 {
+		t.Errorf("sales before start should not be flagged; got %v", got)
+	}
+}
+
+func TestFetchPriceOutliersEndTime(t *testing.T) {
+	db := openTestDB(t)
+	start := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	for i, price := range []string{"100", "110", "90", "10000"} {
+		ts := start.AddDate(0, 0, i-2).Format(time.RFC3339)
+		if _, err := db.Exec(`INSERT INTO market_events (event_timestamp, event_type, item_name, item_id, details)
+			VALUES (?, 'SOLD', 'Apple', 512, json_object('price', ?))`, ts, price+"z"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := fetchPriceOutliers(start.Format(time.RFC3339), "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != 4 {
+		t.Errorf("open-ended outliers = %v, want [4]", got)
+	}
+	got, err = fetchPriceOutliers(start.Format(time.RFC3339), start.Format(time.RFC3339), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("sales after endTime should not be flagged; got %v", got)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := map[string]string{
+		"plain":      "plain",
+		"100%":       `100\%`,
+		"snake_case": `snake\_case`,
+		`a\b`:        `a\\b`,
+		`%_\`:        `\%\_\\`,
+	}
+	for in, want := range tests {
+		if got := escapeLike(in); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEscapeLikeSQL(t *testing.T) {
+	db := openTestDB(t)
+	for _, in := range []string{"plain", "100%", "snake_case", `a\b`, `%_\`} {
+		var got string
+		if err := db.QueryRow(`SELECT `+escapeLikeSQL("?"), in).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if want := escapeLike(in); got != want {
+			t.Errorf("escapeLikeSQL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTradeWatchNamePatternIsLiteral(t *testing.T) {
+	db := openTestDB(t)
+	for _, q := range []string{
+		`INSERT INTO trading_posts (id, title, post_type, character_name, created_at, edit_token_hash) VALUES (1, 'S>', 'selling', 'Alice', '2025-01-01T00:00:00Z', 'x')`,
+		`INSERT INTO trading_post_items (post_id, item_name, quantity) VALUES (1, '100% Elunium', 1), (1, 'Oridecon_Box', 1)`,
+		`INSERT INTO trade_watches (id, name_pattern, webhook_url, created_at) VALUES
+			(1, '100%', 'http://a', '2025-01-01T00:00:00Z'),
+			(2, '1%', 'http://b', '2025-01-01T00:00:00Z'),
+			(3, 'n_box', 'http://c', '2025-01-01T00:00:00Z'),
+			(4, 'ELUNIUM', 'http://d', '2025-01-01T00:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := db.Query(tradeWatchMatchSQL, 1, "selling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var id int64
+		var webhook, item string
+		if err := rows.Scan(&id, &webhook, &item); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%d:%s", id, item))
+	}
+	slices.Sort(got)
+	if want := []string{"1:100% Elunium", "3:Oridecon_Box", "4:100% Elunium"}; !slices.Equal(got, want) {
+		t.Errorf("matches = %v, want %v", got, want)
+	}
+}
+
+func TestSyncItemNames(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO internal_item_db (item_id, name, name_pt) VALUES (1, 'Old Sword', ''), (2, '', '')`); err != nil {
+		t.Fatal(err)
+	}
+	stubItemCache(t, nil, nil)
+
+	for i := 0; i < 2; i++ {
+		syncItemNames(map[int64]string{1: "New Sword", 2: "Fresh Shield"})
+	}
+
+	names := map[int64]string{}
+	rows, err := db.Query(`SELECT item_id, name FROM internal_item_db`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id int64
+		var name string
+		rows.Scan(&id, &name)
+		names[id] = name
+	}
+	rows.Close()
+	if names[1] != "Old Sword" || names[2] != "Fresh Shield" {
+		t.Errorf("names = %v, want the canonical name kept and the empty one filled", names)
+	}
+
+	var renames int
+	db.QueryRow(`SELECT COUNT(*) FROM item_name_history WHERE item_id = 1 AND old_name = 'Old Sword' AND new_name = 'New Sword'`).Scan(&renames)
+	if renames != 1 {
+		t.Errorf("recorded the rename %d times, want once", renames)
+	}
+	if id, found := findItemIDInCache("New Sword", 0); !found || id.Int64 != 1 {
+		t.Errorf("market name resolves to %v, %v; want item 1", id, found)
+	}
+}
+
+func TestExpandNameAliases(t *testing.T) {
+	renames := [][2]string{
+		{"Old Sword", "New Sword"},
+		{"New Sword", "Newest Sword"},
+		{"Knife", "Dagger"},
+	}
+
+	got := expandNameAliases("New Sword [3] +7", renames)
+	want := []string{"New Sword [3] +7", "Old Sword [3] +7", "Newest Sword [3] +7"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("aliases = %q, want %q", got, want)
+	}
+
+	if got := expandNameAliases("Knife Of Doom", renames); len(got) != 1 {
+		t.Errorf("a longer item name must not match a renamed prefix, got %q", got)
+	}
+	if got := expandNameAliases("Dagger", renames); len(got) != 2 || got[1] != "Knife" {
+		t.Errorf("aliases of Dagger = %q, want [Dagger Knife]", got)
+	}
+}
+
+func TestNaviCommand(t *testing.T) {
+	if got := naviCommand(" Prontera ", "(150,180)"); got != "/navi prontera 150/180" {
+		t.Errorf("naviCommand = %q, want %q", got, "/navi prontera 150/180")
+	}
+}
+
+func TestBucketChatActivity(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	heartbeats := []time.Time{
+		start.Add(-time.Minute),      // before the window
+		start.Add(5 * time.Minute),   // bucket 0
+		start.Add(29 * time.Minute),  // bucket 0
+		start.Add(95 * time.Minute),  // bucket 3
+		start.Add(125 * time.Minute), // after the window
+	}
+
+	points := bucketChatActivity(heartbeats, start, end, 30*time.Minute)
+	if len(points) != 4 {
+		t.Fatalf("got %d buckets, want 4", len(points))
+	}
+	want := []int{1, 0, 0, 1}
+	for i, p := range points {
+		if p.Value != want[i] {
+			t.Errorf("bucket %d (%s) = %d, want %d", i, p.Timestamp, p.Value, want[i])
+		}
+	}
+	if points[1].Timestamp != start.Add(30*time.Minute).Format(time.RFC3339) {
+		t.Errorf("bucket 1 starts at %s", points[1].Timestamp)
+	}
+}
+
+func TestChatActivityWindow(t *testing.T) {
+	cases := []struct {
+		query             string
+		hours, bucketMins int
+	}{
+		{"", 24, 3},
+		{"activity_hours=168&activity_bucket=30", 168, 30},
+		{"activity_hours=99999", 720, 22}, // capped window, widened buckets
+		{"activity_hours=-5&activity_bucket=abc", 24, 3},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/chat?"+c.query, nil)
+		h, b := chatActivityWindow(r)
+		if h != c.hours || b != c.bucketMins {
+			t.Errorf("%q: got %dh/%dm, want %dh/%dm", c.query, h, b, c.hours, c.bucketMins)
+		}
+	}
+}
+
+func TestParseClassChange(t *testing.T) {
+	from, to, ok := parseClassChange("Changed class from 'Mago' to 'Bruxo'.")
+	if !ok || from != "Mago" || to != "Bruxo" {
+		t.Errorf("got %q -> %q (ok=%t), want Mago -> Bruxo", from, to, ok)
+	}
+	if _, _, ok := parseClassChange("Reached base level 99."); ok {
+		t.Error("unrelated changelog entry parsed as a class change")
+	}
+}
+
+func TestParseGuildChange(t *testing.T) {
+	tests := []struct {
+		desc, kind, from, to string
+	}{
+		{"Joined guild 'Valhalla'.", changelogKindGuildJoin, "", "Valhalla"},
+		{"Left guild 'Valhalla'.", changelogKindGuildLeave, "Valhalla", ""},
+		{"Moved from guild 'Valhalla' to 'Asgard'.", changelogKindGuildMove, "Valhalla", "Asgard"},
+	}
+	for _, tt := range tests {
+		kind, from, to, ok := parseGuildChange(tt.desc)
+		if !ok || kind != tt.kind || from != tt.from || to != tt.to {
+			t.Errorf("parseGuildChange(%q) = %q, %q -> %q (ok=%t); want %q, %q -> %q", tt.desc, kind, from, to, ok, tt.kind, tt.from, tt.to)
+		}
+	}
+	if _, _, _, ok := parseGuildChange("Changed class from 'Mago' to 'Bruxo'."); ok {
+		t.Error("unrelated changelog entry parsed as a guild change")
+	}
+}
+
+func TestAggregatePriceHistory(t *testing.T) {
+	points := []PricePointDetails{
+		{Timestamp: "2024-03-04 10:00", LowestPrice: 500, HighestPrice: 900, LowestStoreName: "a"},
+		{Timestamp: "2024-03-06 12:00", LowestPrice: 300, HighestPrice: 800, LowestStoreName: "b"},
+		{Timestamp: "2024-03-10 23:59", LowestPrice: 400, HighestPrice: 1200, HighestStoreName: "c"},
+		{Timestamp: "2024-03-11 00:00", LowestPrice: 700, HighestPrice: 700},
+	}
+
+	if got := aggregatePriceHistory(points, ""); len(got) != len(points) {
+		t.Fatalf("no granularity: got %d points, want %d", len(got), len(points))
+	}
+
+	weekly := aggregatePriceHistory(points, "week")
+	if len(weekly) != 2 {
+		t.Fatalf("week: got %d buckets, want 2", len(weekly))
+	}
+	w := weekly[0]
+	if w.Timestamp != "2024-03-04 00:00" || w.LowestPrice != 300 || w.LowestStoreName != "b" ||
+		w.HighestPrice != 1200 || w.HighestStoreName != "c" {
+		t.Errorf("week bucket = %+v", w)
+	}
+	if weekly[1].Timestamp != "2024-03-11 00:00" {
+		t.Errorf("second week starts %s, want 2024-03-11 00:00", weekly[1].Timestamp)
+	}
+
+	if monthly := aggregatePriceHistory(points, "month"); len(monthly) != 1 || monthly[0].LowestPrice != 300 {
+		t.Errorf("month: got %+v", monthly)
+	}
+	if priceHistoryGranularity("year") != "" || priceHistoryGranularity("week") != "week" {
+		t.Error("priceHistoryGranularity accepted an unknown value or rejected a known one")
+	}
+}
+
+func TestSanitizersKeepAccentsAndPunctuation(t *testing.T) {
+	items := map[string]string{
+		"Poção Vermelha":       "Poção Vermelha",
+		"Baphomet's Horn [1]":  "Baphomet's Horn [1]",
+		"Sword Mace: Lv.1 +7":  "Sword Mace: Lv.1 +7",
+		"Chapéu de Poring <b>": "Chapéu de Poring b",
+		"Carta Orc Herói;--":   "Carta Orc Herói--",
+	}
+	for in, want := range items {
+		if got := sanitizeString(in, itemSanitizer); got != want {
+			t.Errorf("item %q: got %q, want %q", in, got, want)
+		}
+	}
+
+	names := map[string]string{
+		"João Ninja": "João Ninja",
+		"Çéu_42!":    "Çéu42",
+	}
+	for in, want := range names {
+		if got := sanitizeString(in, nameSanitizer); got != want {
+			t.Errorf("name %q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMatchItemInCacheTrace(t *testing.T) {
+	stubItemCache(t, map[string]int64{"red potion_0": 501, "red potion box_0": 12000}, []cachedItem{
+		{id: 501, name: "Red Potion"},
+		{id: 12000, name: "Red Potion Box"},
+	})
+
+	trace := &ItemMatchReport{}
+	id, found := matchItemInCache("Red Potio", 0, trace)
+	if !found || id.Int64 != 501 {
+		t.Fatalf("got %v (found %t), want 501", id, found)
+	}
+	if len(trace.LocalCandidates) != 2 || trace.LocalCandidates[0].Distance != 1 {
+		t.Errorf("candidates = %+v, want both potions with Red Potion at distance 1", trace.LocalCandidates)
+	}
+	if n := len(trace.Steps); n == 0 || trace.Steps[n-1].Stage != "local-levenshtein" {
+		t.Errorf("last step = %+v, want the levenshtein decision", trace.Steps)
+	}
+
+	// A nil trace must behave exactly like findItemIDInCache.
+	if id, found := matchItemInCache("Red Potio", 0, nil); !found || id.Int64 != 501 {
+		t.Errorf("nil trace: got %v (found %t)", id, found)
+	}
+}
+
+func TestGetDropPlayerInterval(t *testing.T) {
+	for _, v := range []string{"", "all", "bogus"} {
+		if name, since := getDropPlayerInterval(v); name != "all" || since != "" {
+			t.Errorf("getDropPlayerInterval(%q) = %q, %q; want all-time", v, name, since)
+		}
+	}
+
+	name, since := getDropPlayerInterval("7d")
+	if name != "7d" {
+		t.Fatalf("name = %q, want 7d", name)
+	}
+	start, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		t.Fatalf("since %q is not RFC3339: %v", since, err)
+	}
+	if d := time.Since(start); d < 7*24*time.Hour-time.Minute || d > 7*24*time.Hour+time.Minute {
+		t.Errorf("7d window starts %v ago", d)
+	}
+}
+
+func TestTradeCurrencyBothPosts(t *testing.T) {
+	both := FlatTradingPostItem{PaymentMethods: "both", PriceZeny: 1500000, PriceRMT: 25}
+
+	for _, tc := range []struct {
+		currency            string
+		wantZeny, wantRMT   int64
+		wantSortColumn      string
+		wantConditionColumn string
+	}{
+		{"zeny", 1500000, 0, "i.price_zeny", "i.price_zeny"},
+		{"rmt", 0, 25, "i.price_rmt", "i.price_rmt"},
+		{"all", 1500000, 25, "i.price_zeny", ""},
+	} {
+		cond := tradeCurrencyCondition(tc.currency)
+		if tc.wantConditionColumn == "" {
+			if cond != "" {
+				t.Errorf("%s: condition = %q, want none", tc.currency, cond)
+			}
+		} else if !strings.Contains(cond, tc.wantConditionColumn) || !strings.Contains(cond, "'both'") {
+			t.Errorf("%s: condition %q does not admit both posts on %s", tc.currency, cond, tc.wantConditionColumn)
+		}
+
+		if expr := tradePriceSortExpr(tc.currency); !strings.Contains(expr, "THEN "+tc.wantSortColumn+" ") {
+			t.Errorf("%s: sort expr %q does not sort on %s", tc.currency, expr, tc.wantSortColumn)
+		}
+
+		items := []FlatTradingPostItem{both}
+		applyTradeCurrencyView(items, tc.currency)
+		if items[0].PriceZeny != tc.wantZeny || items[0].PriceRMT != tc.wantRMT {
+			t.Errorf("%s: shown prices = %d zeny / %d rmt, want %d / %d",
+				tc.currency, items[0].PriceZeny, items[0].PriceRMT, tc.wantZeny, tc.wantRMT)
+		}
+	}
+}
+
+func TestDeriveMarketEvents(t *testing.T) {
+	potA := Item{Name: "Red Potion", ItemID: 501, Quantity: 10, Price: "50", SellerName: "Alice", StoreName: "A"}
+	potB := Item{Name: "Red Potion", ItemID: 501, Quantity: 5, Price: "45", SellerName: "Bob", StoreName: "B"}
+	potA2 := potA
+	potA2.Quantity = 4
+
+	snapshots := []marketSnapshot{
+		{Timestamp: "t1", Items: []Item{potA, potB}},
+		{Timestamp: "t2", Items: []Item{potA2}}, // Alice sold 6, Bob left
+		{Timestamp: "t3", Items: []Item{potA2, potB}},
+	}
+	active := map[string]map[string]bool{"t2": {"Alice": true}}
+
+	events := deriveMarketEvents("Red Potion", snapshots, active)
+	var got []string
+	for _, ev := range events {
+		got = append(got, ev.Timestamp+" "+ev.EventType)
+	}
+	want := []string{"t1 ADDED", "t2 SOLD", "t2 REMOVED", "t2 ADDED", "t3 ADDED"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	if events[1].Details != marketEventDetails(potA) || events[2].Details != marketEventDetails(potB) {
+		t.Errorf("removal details = %q / %q, want the removed listings", events[1].Details, events[2].Details)
+	}
+	if events[3].Details != marketEventDetails(potA2) || events[4].Details != marketEventDetails(potB) || events[4].After != "t2" {
+		t.Errorf("added events = %+v / %+v, want Alice's new stack and Bob's return after t2", events[3], events[4])
+	}
+
+	if events := deriveMarketEvents("Red Potion", nil, nil); len(events) != 0 {
+		t.Errorf("no snapshots: got %v", events)
+	}
+}
+
+func TestBackfillMarketEventsWindow(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO items (name_of_the_item, item_id, quantity, price, store_name, seller_name, date_and_time_retrieved, map_name, map_coordinates, is_available) VALUES
+		('Red Potion', 501, 10, '50', 'A', 'Alice', 't1', 'prontera', '1,1', 0),
+		('Red Potion', 501, 5, '45', 'B', 'Bob', 't1', 'prontera', '1,1', 0),
+		('Red Potion', 501, 5, '45', 'B', 'Bob', 't3', 'prontera', '1,1', 1)`); err != nil {
+		t.Fatal(err)
+	}
+	// The scraper saw Alice leave at t2, between the two snapshots.
+	alice := marketEventDetails(Item{Quantity: 10, Price: "50", SellerName: "Alice", StoreName: "A"})
+	if _, err := db.Exec(`INSERT INTO market_events (event_timestamp, event_type, item_name, item_id, details) VALUES ('t2', 'SOLD', 'Red Potion', 501, ?)`, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := backfillMarketEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 {
+		t.Errorf("created = %d, want only the t1 ADDED event", created)
+	}
+	var removals int
+	db.QueryRow(`SELECT COUNT(*) FROM market_events WHERE event_type != 'ADDED'`).Scan(&removals)
+	if removals != 1 {
+		t.Errorf("removal events = %d, want the live one left alone", removals)
+	}
+}
+
+func TestRenderTemplateBuffersErrors(t *testing.T) {
+	const name = "render_error_test.html"
+	templateCache[name] = template.Must(template.New(name).Parse(
+		`{{define "layout.html"}}<p>before</p>{{.Data.Missing}}{{end}}`))
+	defer delete(templateCache, name)
+
+	saved := appConfig
+	defer func() { appConfig = saved }()
+
+	// Buffered: the half-rendered page is dropped for a clean 500.
+	appConfig = &config.Config{RenderBufferKB: 64}
+	rec := httptest.NewRecorder()
+	renderTemplate(rec, httptest.NewRequest("GET", "/", nil), name, struct{}{})
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "before") {
+		t.Errorf("buffered: got %d %q, want a bare 500", rec.Code, rec.Body.String())
+	}
+
+	// Streaming: what was written before the error has already gone out.
+	appConfig = &config.Config{RenderBufferKB: 0}
+	rec = httptest.NewRecorder()
+	renderTemplate(rec, httptest.NewRequest("GET", "/", nil), name, struct{}{})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "before") {
+		t.Errorf("streaming: got %d %q, want the partial page", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPageWriterSpillsPastLimit(t *testing.T) {
+	rec := httptest.NewRecorder()
+	pw := newPageWriter(rec, 8)
+	pw.Write([]byte("12345"))
+	if rec.Body.Len() != 0 {
+		t.Fatalf("wrote %q before the limit", rec.Body.String())
+	}
+	pw.Write([]byte("6789"))
+	if !pw.streaming || rec.Body.String() != "123456789" {
+		t.Fatalf("after spill: streaming=%t body=%q", pw.streaming, rec.Body.String())
+	}
+	pw.Write([]byte("0"))
+	if err := pw.flush(); err != nil || rec.Body.String() != "1234567890" {
+		t.Errorf("flush: %v, body=%q", err, rec.Body.String())
+	}
+}
+
+func TestDetectPriceWars(t *testing.T) {
+	pt := func(ts string, price int, seller string) PricePointDetails {
+		return PricePointDetails{Timestamp: ts, LowestPrice: price, LowestSellerName: seller}
+	}
+	points := []PricePointDetails{
+		pt("2024-05-01 10:00", 10000, "Alice"),
+		pt("2024-05-01 10:30", 9900, "Bob"),
+		pt("2024-05-01 11:00", 9800, "Alice"),
+		pt("2024-05-01 12:00", 12000, "Carol"), // cheapest sold out
+		pt("2024-05-01 13:00", 11900, "Bob"),
+		// Quiet for a day, then one big drop and one self-undercut: no war.
+		pt("2024-05-02 15:00", 8000, "Dave"),
+		pt("2024-05-02 15:30", 7990, "Dave"),
+	}
+
+	wars := detectPriceWars(points)
+	if len(wars) != 1 {
+		t.Fatalf("got %d wars (%+v), want 1", len(wars), wars)
+	}
+	w := wars[0]
+	if w.Start != "2024-05-01 10:00" || w.End != "2024-05-01 13:00" || w.Undercuts != 3 {
+		t.Errorf("war = %+v, want 10:00-13:00 with 3 undercuts", w)
+	}
+	if w.StartPrice != 10000 || w.EndPrice != 11900 || !slices.Equal(w.Sellers, []string{"Alice", "Bob"}) {
+		t.Errorf("war = %+v, want 10000 -> 11900 between Alice and Bob", w)
+	}
+
+	if wars := detectPriceWars(points[:3]); len(wars) != 0 {
+		t.Errorf("two undercuts should not count as a war, got %+v", wars)
+	}
+}
+
+func TestSearchByItemID(t *testing.T) {
+	for _, tc := range []struct {
+		query, mode string
+		want        bool
+	}{
+		{"1201", "auto", true},
+		{"Knife", "auto", false},
+		{"1201", "name", false},
+		{"Knife", "id", true},
+		{"1201", itemSearchMode("bogus"), true},
+	} {
+		if got := searchByItemID(tc.query, tc.mode); got != tc.want {
+			t.Errorf("searchByItemID(%q, %q) = %t, want %t", tc.query, tc.mode, got, tc.want)
+		}
+	}
+
+	clause, params, err := buildItemSearchClause("1201", "id", "i")
+	if err != nil || clause != "i.item_id = ?" || len(params) != 1 {
+		t.Errorf("id mode: got %q %v %v", clause, params, err)
+	}
+	if p := searchModeParam("auto"); p != "" {
+		t.Errorf("searchModeParam(auto) = %q, want empty", p)
+	}
+	if p := searchModeParam("name"); p != "&search_mode=name" {
+		t.Errorf("searchModeParam(name) = %q", p)
+	}
+}
+
+func TestCompareMarketDiscord(t *testing.T) {
+	market := &ItemListing{Price: 12000}
+	discord := &FlatTradingPostItem{PriceZeny: 9000, CharacterName: "Bob"}
+
+	if c := compareMarketDiscord(nil, nil); c != nil {
+		t.Errorf("no sources: got %+v, want nil", c)
+	}
+	if c := compareMarketDiscord(market, discord); c.Cheaper != "discord" || c.Difference != 3000 || c.DiscordSeller != "Bob" {
+		t.Errorf("both sources: got %+v", c)
+	}
+	if c := compareMarketDiscord(&ItemListing{Price: 9000}, discord); c.Cheaper != "same" || c.Difference != 0 {
+		t.Errorf("equal prices: got %+v", c)
+	}
+	if c := compareMarketDiscord(market, nil); c.MarketPrice != 12000 || c.DiscordPrice != 0 || c.Cheaper != "" {
+		t.Errorf("market only: got %+v", c)
+	}
+	if c := compareMarketDiscord(nil, discord); c.DiscordPrice != 9000 || c.MarketPrice != 0 || c.Cheaper != "" {
+		t.Errorf("discord only: got %+v", c)
+	}
+}
+
+func TestExcludedNamesCondition(t *testing.T) {
+	orig := appConfig
+	defer func() { appConfig = orig }()
+
+	appConfig = &config.Config{ExcludedCharacterNames: []string{"System", "GM Bot"}}
+	clause, params := excludedNamesCondition("cl.character_name")
+	if clause != "cl.character_name NOT IN (?, ?)" || !slices.Equal(params, []interface{}{"System", "GM Bot"}) {
+		t.Errorf("got %q %v", clause, params)
+	}
+
+	appConfig = &config.Config{}
+	if clause, params := excludedNamesCondition("name"); clause != "" || params != nil {
+		t.Errorf("no names: got %q %v, want no condition", clause, params)
+	}
+}
+
+func TestDedupPriceHistory(t *testing.T) {
+	pt := func(low, high int) PricePointDetails {
+		return PricePointDetails{LowestPrice: low, HighestPrice: high}
+	}
+	points := []PricePointDetails{pt(1000, 2000), pt(1000, 2000), pt(1010, 2000), pt(1010, 2100), pt(900, 2100)}
+
+	if got := dedupPriceHistory(points, 0); len(got) != 4 {
+		t.Errorf("exact: kept %d points, want 4 (only the repeat dropped)", len(got))
+	}
+	// 1010 is a 1% move and 2100 a 5% move from the last kept point.
+	got := dedupPriceHistory(points, 2)
+	if len(got) != 3 || got[1].HighestPrice != 2100 || got[2].LowestPrice != 900 {
+		t.Errorf("2%%: got %+v, want the first point, the high jump and the low drop", got)
+	}
+	if got := dedupPriceHistory(nil, 2); len(got) != 0 {
+		t.Errorf("empty history: got %d points", len(got))
+	}
+}
+
+func TestFeatureGate(t *testing.T) {
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	appConfig = &config.Config{DisabledFeatures: []string{"guild-churn"}}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	serve := func(name string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/x", nil)
+		r.Header.Set("Accept", "application/json")
+		featureGate(name, ok)(w, r)
+		return w.Code
+	}
+
+	if code := serve("guild-churn"); code != http.StatusNotFound {
+		t.Errorf("disabled by DISABLED_FEATURES: got %d, want 404", code)
+	}
+	if code := serve("rebirth-stats"); code != http.StatusOK {
+		t.Errorf("enabled by default: got %d, want 200", code)
+	}
+
+	featureFlagMutex.Lock()
+	featureFlagOverrides = map[string]bool{"guild-churn": true, "rebirth-stats": false}
+	featureFlagMutex.Unlock()
+	defer func() { featureFlagOverrides = make(map[string]bool) }()
+
+	if code := serve("guild-churn"); code != http.StatusOK {
+		t.Errorf("admin override on: got %d, want 200", code)
+	}
+	if code := serve("rebirth-stats"); code != http.StatusNotFound {
+		t.Errorf("admin override off: got %d, want 404", code)
+	}
+}
+
+func TestChatPacketAndActivityConcurrency(t *testing.T) {
+	activityLogMutex.Lock()
+	savedLog := lastActivityLog
+	lastActivityLog = time.Time{}
+	activityLogMutex.Unlock()
+	savedPacket := lastChatPacketTime.Load()
+	defer func() {
+		activityLogMutex.Lock()
+		lastActivityLog = savedLog
+		activityLogMutex.Unlock()
+		lastChatPacketTime.Store(savedPacket)
+	}()
+
+	base := time.Date(2024, 5, 1, 15, 4, 50, 0, time.UTC)
+	var wg sync.WaitGroup
+	var claims atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			markChatPacket(base.Add(time.Duration(i) * time.Millisecond))
+			_ = GetLastChatPacketTime()
+			if claimActivityMinute(base.Add(time.Duration(i) * time.Millisecond)) {
+				claims.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := claims.Load(); n != 1 {
+		t.Errorf("%d goroutines claimed the same minute, want 1", n)
+	}
+	if got := lastChatPacketTime.Load(); got != base.Unix() {
+		t.Errorf("last packet = %d, want %d", got, base.Unix())
+	}
+	// 20s later is a new minute even though less than a minute has passed.
+	if !claimActivityMinute(base.Add(20 * time.Second)) {
+		t.Error("next minute was not claimed")
+	}
+	if claimActivityMinute(base.Add(-time.Minute)) {
+		t.Error("an earlier minute was claimed again")
+	}
+}
+
+func TestCheapestCardOffers(t *testing.T) {
+	if got := marketBaseName("Boots [1] +4 [Poring]"); got != "Boots" {
+		t.Errorf("marketBaseName = %q, want Boots", got)
+	}
+
+	offers := []CardOffer{
+		{ItemID: 2406, BaseName: "Boots", Source: "market", Price: 90000},
+		{ItemID: 2406, BaseName: "Boots", Source: "discord", Price: 70000},
+		{BaseName: "Muffler", Source: "discord", Price: 50000},
+		{BaseName: "muffler", Source: "market", Price: 60000},
+		{ItemID: 2104, BaseName: "Buckler", Source: "market", Price: 80000},
+	}
+	got := cheapestCardOffers(offers)
+	if len(got) != 3 {
+		t.Fatalf("got %d offers, want one per base item: %+v", len(got), got)
+	}
+	if got[0].BaseName != "Muffler" || got[1].Source != "discord" || got[1].Price != 70000 || got[2].BaseName != "Buckler" {
+		t.Errorf("got %+v, want Muffler 50k, Boots 70k (discord), Buckler 80k", got)
+	}
+}
+
+func TestBuildPriceHistogram(t *testing.T) {
+	if got := buildPriceHistogram(nil, 10); len(got) != 0 {
+		t.Errorf("no sales: got %+v", got)
+	}
+
+	got := buildPriceHistogram([]int64{100, 100, 100}, 10)
+	if len(got) != 1 || got[0] != (PriceBucket{Min: 100, Max: 100, Count: 3}) {
+		t.Errorf("single price: got %+v, want one bucket of 3", got)
+	}
+
+	got = buildPriceHistogram([]int64{1000, 1100, 1900, 2000, 2999, 3000}, 4)
+	want := []PriceBucket{{1000, 1500, 2}, {1501, 2001, 2}, {2002, 2502, 0}, {2503, 3000, 2}}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestRunScrapeCountsFailures(t *testing.T) {
+	count := func(result string) float64 {
+		return testutil.ToFloat64(metrics.Scrapes.WithLabelValues("zeny", result))
+	}
+	okBefore, failBefore := count("success"), count("failure")
+
+	runScrape("Zeny", func() error { return nil })
+	runScrape("Zeny", func() error { return errors.New("scraped 0 zeny records") })
+	runScrape("Zeny", func() error { panic("boom") })
+	runScrape("Zeny", func() error { return nil })
+
+	if got := count("success") - okBefore; got != 2 {
+		t.Errorf("success count = %v, want 2", got)
+	}
+	if got := count("failure") - failBefore; got != 2 {
+		t.Errorf("failure count = %v, want 2", got)
+	}
+}
+
+func TestSavePlayerCharacters(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO characters (rank, name, base_level, job_level, experience, class, last_updated, last_active)
+		VALUES (1, 'Alice', 50, 30, 10, 'Knight', '2025-01-01T00:00:00Z', '2025-01-01T00:00:00Z')`); err != nil {
+		t.Fatal(err)
+	}
+
+	savePlayerCharacters([]PlayerCharacter{
+		{Rank: 1, Name: "Alice", BaseLevel: 51, JobLevel: 30, Experience: 2, Class: "Knight"},
+		{Rank: 2, Name: "Bob", BaseLevel: 10, JobLevel: 5, Experience: 0, Class: "Acolyte"},
+	})
+
+	var level, chars, changes int
+	if err := db.QueryRow(`SELECT base_level FROM characters WHERE name = 'Alice'`).Scan(&level); err != nil || level != 51 {
+		t.Errorf("Alice base_level = %d, %v; want 51", level, err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM characters`).Scan(&chars); err != nil || chars != 2 {
+		t.Errorf("characters = %d, %v; want 2", chars, err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM character_changelog WHERE character_name = 'Alice'`).Scan(&changes); err != nil || changes == 0 {
+		t.Errorf("Alice changelog entries = %d, %v; want the level-up", changes, err)
+	}
+}
+
+func TestScrapeSanityChecks(t *testing.T) {
+	good := PlayerCharacter{Rank: 1, Name: "Bob", BaseLevel: 99, JobLevel: 70, Experience: 12.5, Class: "Cavaleiro"}
+	if p := characterSanityProblem(good); p != "" {
+		t.Errorf("valid character flagged: %s", p)
+	}
+	bad := []PlayerCharacter{
+		{Rank: 1, Name: "", BaseLevel: 10, JobLevel: 10, Class: "Mago"},
+		{Rank: 1, Name: `Bob\",\"level`, BaseLevel: 10, JobLevel: 10, Class: "Mago"},
+		{Rank: 1, Name: "Bob", BaseLevel: 0, JobLevel: 10, Class: "Mago"},
+		{Rank: 1, Name: "Bob", BaseLevel: 150, JobLevel: 10, Class: "Mago"},
+		{Rank: 1, Name: "Bob", BaseLevel: 10, JobLevel: 10, Experience: 250, Class: "Mago"},
+		{Rank: 1, Name: "Bob", BaseLevel: 10, JobLevel: 10, Class: "<span>"},
+	}
+	for _, p := range bad {
+		if characterSanityProblem(p) == "" {
+			t.Errorf("malformed character passed: %+v", p)
+		}
+	}
+	if p := guildSanityProblem(Guild{Name: "G", Level: 0, Master: "M"}); p == "" {
+		t.Error("guild level 0 passed")
+	}
+
+	// One odd row is dropped; a page where most rows fail is dropped whole.
+	kept, problems := filterSane([]PlayerCharacter{good, good, bad[2]}, characterSanityProblem)
+	if len(kept) != 2 || len(problems) != 1 {
+		t.Errorf("one bad row: kept %d, problems %v", len(kept), problems)
+	}
+	kept, problems = filterSane([]PlayerCharacter{good, bad[2], bad[3]}, characterSanityProblem)
+	if kept != nil || len(problems) != 2 {
+		t.Errorf("mostly bad page: kept %v, problems %v", kept, problems)
+	}
+}
+
+func TestListingCursorRoundTrip(t *testing.T) {
+	c := listingCursor{Available: true, Retrieved: "2025-01-02T03:04:05Z", ID: 42}
+	got, err := decodeListingCursor(c.encode())
+	if err != nil || got != c {
+		t.Errorf("round trip = %+v, %v; want %+v", got, err, c)
+	}
+	for _, bad := range []string{"", "!!", "MXwyMDI1fDE", c.encode() + "x"} {
+		if _, err := decodeListingCursor(bad); err == nil {
+			t.Errorf("decodeListingCursor(%q) should fail", bad)
+		}
+	}
+}
+
+// openTestDB points srv at a fresh, empty database for the rest of the
+// test.
+func openTestDB(tb testing.TB) *sql.DB {
+	db, err := storage.Open(filepath.Join(tb.TempDir(), "test.db"), nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	savedSrv := srv
+	srv = &App{db: db}
+	// Cached outliers belong to whichever database computed them.
+	priceOutlierCacheMutex.Lock()
+	clear(priceOutlierCache)
+	priceOutlierCacheMutex.Unlock()
+	tb.Cleanup(func() {
+		srv = savedSrv
+		db.Close()
+	})
+	return db
+}
+
+// stubTemplate replaces the cached template name with one parsed from
+// body for the rest of the test, restoring the original afterwards.
+func stubTemplate(t *testing.T, name, body string) {
+	t.Helper()
+	saved, ok := templateCache[name]
+	templateCache[name] = template.Must(template.New(name).Parse(body))
+	t.Cleanup(func() {
+		if ok {
+			templateCache[name] = saved
+		} else {
+			delete(templateCache, name)
+		}
+	})
+}
+
+// stubItemCache replaces the item-name cache with exact and fuzzy for
+// the rest of the test, restoring the original afterwards. A nil exact
+// leaves the cache unloaded.
+func stubItemCache(t *testing.T, exact map[string]int64, fuzzy []cachedItem) {
+	t.Helper()
+	itemCacheMu.Lock()
+	defer itemCacheMu.Unlock()
+	savedExact, savedFuzzy, savedLoaded := itemExactCache, itemFuzzyCache, itemCacheLoaded
+	itemExactCache, itemFuzzyCache, itemCacheLoaded = exact, fuzzy, exact != nil
+	t.Cleanup(func() {
+		itemCacheMu.Lock()
+		itemExactCache, itemFuzzyCache, itemCacheLoaded = savedExact, savedFuzzy, savedLoaded
+		itemCacheMu.Unlock()
+	})
+}
+
+// openListingHistoryDB points srv at a fresh database holding n listings
+// of "Apple": the newest tenth still available, several per timestamp so
+// the id tiebreak matters.
+func openListingHistoryDB(tb testing.TB, n int) {
+	db := openTestDB(tb)
+	tx, err := db.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO items (name_of_the_item, item_id, quantity, price, store_name, seller_name, date_and_time_retrieved, map_name, map_coordinates, is_available)
+		VALUES ('Apple', 512, 1, ?, 'Shop', 'Seller', ?, 'prontera', '150,150', ?)`)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		retrieved := start.Add(time.Duration(i/3) * time.Minute).Format(time.RFC3339)
+		if _, err := stmt.Exec(fmt.Sprintf("%d", 100+i), retrieved, i >= n-n/10); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestFetchListingsAfterMatchesOffsetPages(t *testing.T) {
+	const total, perPage = 230, 50
+	openListingHistoryDB(t, total)
+	names := []string{"Apple"}
+
+	var keyset []Item
+	var cursor *listingCursor
+	for pages := 0; ; pages++ {
+		if pages > total/perPage+1 {
+			t.Fatal("cursor paging did not terminate")
+		}
+		page, next, err := fetchListingsAfter(names, cursor, perPage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyset = append(keyset, page...)
+		if next == "" {
+			break
+		}
+		c, err := decodeListingCursor(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor = &c
+	}
+
+	var offset []Item
+	var cursors []string
+	for page := 1; page <= (total+perPage-1)/perPage; page++ {
+		p := httpx.PaginationData{ItemsPerPage: perPage, Offset: (page - 1) * perPage}
+		rows, next, err := fetchAllListings(names, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset = append(offset, rows...)
+		cursors = append(cursors, next)
+	}
+	// A numbered page hands out the cursor that continues after it.
+	if c, err := decodeListingCursor(cursors[0]); err != nil || c.ID != offset[perPage-1].ID {
+		t.Errorf("page 1 cursor = %+v (%v), want the position of row %d", c, err, offset[perPage-1].ID)
+	}
+	if last := cursors[len(cursors)-1]; last != "" {
+		t.Errorf("last page cursor = %q, want none", last)
+	}
+
+	if len(keyset) != total || len(offset) != total {
+		t.Fatalf("got %d keyset and %d offset rows, want %d", len(keyset), len(offset), total)
+	}
+	for i := range keyset {
+		if keyset[i].ID != offset[i].ID {
+			t.Fatalf("row %d: keyset id %d, offset id %d", i, keyset[i].ID, offset[i].ID)
+		}
+	}
+	if !keyset[0].IsAvailable || keyset[total-1].IsAvailable {
+		t.Error("available listings should come first")
+	}
+}
+
+// BenchmarkListingPageAtDepth compares fetching one history page deep
+// into a popular item by OFFSET and by keyset cursor.
+func BenchmarkListingPageAtDepth(b *testing.B) {
+	const total, perPage, depth = 50000, 50, 45000
+	openListingHistoryDB(b, total)
+	names := []string{"Apple"}
+
+	b.Run("offset", func(b *testing.B) {
+		p := httpx.PaginationData{ItemsPerPage: perPage, Offset: depth}
+		for i := 0; i < b.N; i++ {
+			if _, _, err := fetchAllListings(names, p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// The cursor the previous page would have handed out.
+	_, token, err := fetchAllListings(names, httpx.PaginationData{ItemsPerPage: 1, Offset: depth - 1})
+	if err != nil {
+		b.Fatalf("could not load the row before depth: %v", err)
+	}
+	cursor, err := decodeListingCursor(token)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("keyset", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := fetchListingsAfter(names, &cursor, perPage); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSpreadPercent(t *testing.T) {
+	tests := []struct {
+		lowest, highest int64
+		want            float64
+	}{
+		{100, 150, 50},
+		{100, 100, 0},
+		{3, 4, 33.33},
+		{1000, 100000, 9900},
+		{0, 500, 0},
+	}
+	for _, tt := range tests {
+		if got := spreadPercent(tt.lowest, tt.highest); got != tt.want {
+			t.Errorf("spreadPercent(%d, %d) = %v, want %v", tt.lowest, tt.highest, got, tt.want)
+		}
+	}
+}
+
+func TestResetGuildHistory(t *testing.T) {
+	db := openTestDB(t)
+	for _, q := range []string{
+		`INSERT INTO guilds (rank, name, level, experience, master, last_updated)
+			VALUES (1, 'Foo', 1, 0, 'Alice', '2025-01-01T00:00:00Z'), (2, 'FooBar', 1, 0, 'Bob', '2025-01-01T00:00:00Z')`,
+		`INSERT INTO guild_member_history (guild_name, timestamp, member_count)
+			VALUES ('Foo', '2025-01-01T00:00:00Z', 2), ('Foo', '2025-01-02T00:00:00Z', 3), ('FooBar', '2025-01-01T00:00:00Z', 1)`,
+		`INSERT INTO character_changelog (character_name, change_time, activity_description, event_kind) VALUES
+			('Alice', '2025-01-01T00:00:00Z', 'Joined guild ''Foo''.', 'guild_join'),
+			('Carol', '2025-01-01T00:00:00Z', 'Left guild ''Foo''.', NULL),
+			('Dave', '2025-01-01T00:00:00Z', 'Moved from guild ''FooBar'' to ''Foo''.', 'guild_move'),
+			('Erin', '2025-01-01T00:00:00Z', 'Moved from guild ''Foo'' to ''FooBar''.', 'guild_move'),
+			('Alice', '2025-01-02T00:00:00Z', 'Guild ''Foo'' leadership changed from ''Bob'' to ''Alice''.', 'guild_master'),
+			('Bob', '2025-01-01T00:00:00Z', 'Joined guild ''FooBar''.', 'guild_join'),
+			('Alice', '2025-01-03T00:00:00Z', 'Reached base level 50 in guild ''Foo''.', 'level')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := resetGuildHistory("  ", true); err == nil {
+		t.Fatal("an empty guild name should be rejected")
+	}
+
+	counts, err := resetGuildHistory("Foo", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (GuildResetCounts{Changelog: 5, MemberHistory: 2}); counts != want {
+		t.Errorf("counts = %+v, want %+v", counts, want)
+	}
+	var left int
+	db.QueryRow("SELECT COUNT(*) FROM character_changelog").Scan(&left)
+	if left != 2 {
+		t.Errorf("%d changelog rows left, want FooBar's join and the unrelated entry", left)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM guilds").Scan(&left)
+	if left != 2 {
+		t.Errorf("%d guild rows left without snapshot, want 2", left)
+	}
+
+	if counts, err = resetGuildHistory("Foo", true); err != nil || counts.Guild != 1 {
+		t.Errorf("reset with snapshot = %+v, %v; want the guild row deleted", counts, err)
+	}
+}
+
+func TestParseMarketPrice(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int64
+	}{
+		{"1,234,567z", 1234567},
+		{"1,234,567", 1234567},
+		{"1234567", 1234567},
+		{"500z", 500},
+		{" 1,000 z ", 1000},
+		{"1.234.567z", 1234567},
+		{"1.5kk", 1500000},
+		{"2kk", 2000000},
+		{"1.2KKz", 1200000},
+		{"750k", 750000},
+		{"1,5k", 15000},
+		{"0z", 0},
+		{"", 0},
+		{"free", 0},
+	}
+	for _, c := range cases {
+		if got := parseMarketPrice(c.in); got != c.want {
+			t.Errorf("parseMarketPrice(%q) = %d, want %d", c.in, got, c.want)
+		}
+	}
+	for _, p := range []int64{0, 7, 1000, 1234567, 49999999} {
+		if got := parseMarketPrice(formatMarketPrice(p)); got != p {
+			t.Errorf("parseMarketPrice(formatMarketPrice(%d)) = %d", p, got)
+		}
+	}
+}
+
+func TestMarketPriceSQLMatchesGo(t *testing.T) {
+	db := openTestDB(t)
+	for _, in := range []string{"1,234,567z", "1234567", "500z", " 1,000 z ", "1.234.567z", "1.5kk", "2kk", "1.2KKz", "750k", "1,5k", "0z"} {
+		var got int64
+		if err := db.QueryRow("SELECT "+marketPriceSQL("price")+" FROM (SELECT ? AS price)", in).Scan(&got); err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		if want := parseMarketPrice(in); got != want {
+			t.Errorf("SQL parsed %q as %d, Go as %d", in, got, want)
+		}
+	}
+}
+
+func TestSummaryAPI(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO items (name_of_the_item, item_id, quantity, price, store_name, seller_name, date_and_time_retrieved, is_available) VALUES
+		('Apple', 512, 1, '1,500z', 's', 'a', '2025-01-01T00:00:00Z', 1),
+		('Apple', 512, 1, '900z', 's', 'b', '2025-01-01T00:00:00Z', 1),
+		('Potion', 501, 1, '50z', 's', 'c', '2025-01-01T00:00:00Z', 0)`); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) SummaryAPIResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		summaryHandler(rec, httptest.NewRequest("GET", "/api/summary"+query, nil))
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+			t.Fatalf("%s: status %d, content type %q", query, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		var resp SummaryAPIResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := get("")
+	if resp.TotalUniqueItems != 1 || len(resp.Items) != 1 {
+		t.Fatalf("default response = %+v, want only the available Apple", resp)
+	}
+	if item := resp.Items[0]; item.Name != "Apple" || item.LowestPrice != 900 || item.HighestPrice != 1500 || item.ListingCount != 2 {
+		t.Errorf("Apple = %+v", item)
+	}
+
+	resp = get("?only_available=false&sort_by=name&order=ASC")
+	if resp.TotalUniqueItems != 2 || len(resp.Items) != 2 || resp.Items[0].Name != "Apple" || resp.SortBy != "name" || resp.Order != "ASC" {
+		t.Errorf("all items by name = %+v", resp)
+	}
+}
+
+func TestLevelingVelocity(t *testing.T) {
+	db := openTestDB(t)
+	at := func(days float64) string {
+		return time.Now().Add(-time.Duration(days * 24 * float64(time.Hour))).Format(time.RFC3339)
+	}
+	if _, err := db.Exec(`INSERT INTO character_changelog (character_name, change_time, activity_description, event_kind) VALUES
+		('Alice', ?, 'Leveled up to Base Level 10!', 'level_base'),
+		('Alice', ?, 'Gained 5.00% experience (now at 80.00%).', 'exp_gain'),
+		('Alice', ?, 'Leveled up to Base Level 11!', 'level_base'),
+		('Alice', ?, 'Gained 20.00% experience (now at 20.00%).', 'exp_gain'),
+		('Alice', ?, 'Lost 1.00% experience (now at 19.00%).', 'exp_loss'),
+		('Bob', ?, 'Leveled up to Base Level 50!', 'level_base'),
+		('Bob', ?, 'Gained 10.00% experience (now at 10.00%).', 'exp_gain')`,
+		at(30), at(10), at(3), at(3), at(1), at(2), at(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO characters (rank, name, base_level, job_level, experience, class, last_updated, last_active, leveling_velocity) VALUES
+		(1, 'Alice', 11, 1, 19, 'Knight', '', '', 0),
+		(2, 'Bob', 50, 1, 10, 'Priest', '', '', 0),
+		(3, 'Carol', 70, 1, 0, 'Wizard', '', '', 5)`); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storeLevelingVelocities(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice: 80% at level 10 -> 20% at 11 is +40, then -1.
+	// Bob: no earlier snapshot, so from level 49 at 0%: +110.
+	// Carol: nothing in the window, so her old value is cleared.
+	want := map[string]float64{"Alice": 39.0 / levelingVelocityDays, "Bob": 110.0 / levelingVelocityDays, "Carol": 0}
+	for name, w := range want {
+		p, err := fetchCharacterData(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(p.LevelingVelocity-w) > 1e-9 {
+			t.Errorf("%s velocity = %v, want %v", name, p.LevelingVelocity, w)
+		}
+	}
+}
+
+func TestCharacterProfileJSON(t *testing.T) {
+	db := openTestDB(t)
+	for _, q := range []string{
+		`INSERT INTO guilds (rank, name, level, experience, master, last_updated) VALUES (1, 'Foo', 10, 0, 'Alice', '2025-01-01T00:00:00Z')`,
+		`INSERT INTO characters (rank, name, base_level, job_level, experience, class, guild_name, last_updated, last_active)
+			VALUES (1, 'Alice', 99, 70, 12.5, 'Priest', 'Foo', '2025-01-02T00:00:00Z', '2025-01-02T00:00:00Z')`,
+		`INSERT INTO character_changelog (character_name, change_time, activity_description, event_kind) VALUES
+			('Alice', '2025-01-01T00:00:00Z', 'Joined guild ''Foo''.', 'guild_join'),
+			('Alice', '2025-01-02T00:00:00Z', 'Reached base level 99.', 'level')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	characterDetailHandler(rec, httptest.NewRequest("GET", "/character?name=Alice&format=json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var profile CharacterProfile
+	if err := json.Unmarshal(rec.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Character.Name != "Alice" || profile.Character.GuildName != "Foo" || !profile.Character.IsGuildLeader {
+		t.Errorf("character = %+v", profile.Character)
+	}
+	if profile.Guild == nil || profile.Guild.Name != "Foo" {
+		t.Errorf("guild = %+v", profile.Guild)
+	}
+	if profile.ChangelogTotal != 2 || len(profile.Changelog) != 2 || profile.DropHistory == nil {
+		t.Errorf("changelog = %d/%d entries, drops %v", len(profile.Changelog), profile.ChangelogTotal, profile.DropHistory)
+	}
+
+	rec = httptest.NewRecorder()
+	characterDetailHandler(rec, httptest.NewRequest("GET", "/character?name=Nobody&format=json", nil))
+	if rec.Code != http.StatusNotFound || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("unknown character: status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestFetchCharacterDrops(t *testing.T) {
+	db := openTestDB(t)
+	stubItemCache(t, map[string]int64{"elunium_0": 985, "oridecon_0": 984},
+		[]cachedItem{{id: 985, name: "Elunium"}, {id: 984, name: "Oridecon"}})
+	for _, q := range []string{
+		`INSERT INTO internal_item_db (item_id, name, name_pt) VALUES (985, 'Elunium', 'Elunium PT'), (984, 'Oridecon', NULL)`,
+		`INSERT INTO character_changelog (character_name, change_time, activity_description, event_kind) VALUES
+			('Alice', '2025-01-01T00:00:00Z', 'Dropped item: Elunium', 'drop'),
+			('Alice', '2025-01-02T00:00:00Z', 'Dropped item: Oridecon', 'drop'),
+			('Alice', '2025-01-03T00:00:00Z', 'Dropped item: Mystery Thing', 'drop'),
+			('Alice', '2025-01-04T00:00:00Z', 'Dropped item: Elunium', 'drop')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	drops, truncated, err := fetchCharacterDrops("Alice")
+	if err != nil || truncated {
+		t.Fatalf("err %v, truncated %v", err, truncated)
+	}
+	var got []string
+	for _, d := range drops {
+		id := int64(0)
+		if d.ItemID != nil {
+			id = *d.ItemID
+		}
+		got = append(got, fmt.Sprintf("%s/%d/%s", d.ItemName, id, d.NamePT))
+	}
+	want := []string{"Elunium/985/Elunium PT", "Mystery Thing/0/", "Oridecon/984/", "Elunium/985/Elunium PT"}
+	if !slices.Equal(got, want) {
+		t.Errorf("drops = %v, want %v", got, want)
+	}
+}
+
+func TestBackfillTradeItemIDs(t *testing.T) {
+	db := openTestDB(t)
+	stubItemCache(t, map[string]int64{"red potion_0": 501}, []cachedItem{{id: 501, name: "Red Potion"}})
+	// A cached miss keeps the unknown name from going online.
+	storeOnlineLookup("mystery thing_0", onlineLookupResult{expiry: time.Now().Add(time.Hour)})
+
+	recent := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	old := time.Now().AddDate(0, 0, -3).Format(time.RFC3339)
+	for _, q := range []string{
+		`INSERT INTO trading_posts (id, title, post_type, character_name, created_at, edit_token_hash) VALUES
+			(1, 'new', 'selling', 'Alice', '` + recent + `', 'x'), (2, 'old', 'selling', 'Bob', '` + old + `', 'x')`,
+		`INSERT INTO trading_post_items (post_id, item_name, quantity) VALUES
+			(1, 'Red Potion', 1), (1, 'Mystery Thing', 1), (2, 'Red Potion', 1)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backfillTradeItemIDs()
+
+	got := map[string]sql.NullInt64{}
+	rows, err := db.Query("SELECT post_id || ':' || item_name, item_id FROM trading_post_items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var id sql.NullInt64
+		if err := rows.Scan(&key, &id); err != nil {
+			t.Fatal(err)
+		}
+		got[key] = id
+	}
+	if id := got["1:Red Potion"]; id.Int64 != 501 {
+		t.Errorf("recent Red Potion = %v, want 501", id)
+	}
+	if got["1:Mystery Thing"].Valid || got["2:Red Potion"].Valid {
+		t.Errorf("unknown or out-of-window items were filled in: %v", got)
+	}
+}
+
+func TestFullListCSV(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO items (name_of_the_item, item_id, quantity, price, store_name, seller_name, date_and_time_retrieved, map_name, map_coordinates, is_available) VALUES
+		('Apple', 512, 3, '1,500z', 'Fruit, Inc', 'Alice', '2025-01-01T00:00:00Z', 'prontera', '150,180', 1),
+		('Potion', 501, 1, '50z', 'Shop', 'Bob', '2025-01-01T00:00:00Z', 'prontera', '100,100', 0)`); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	fullListHandler(rec, httptest.NewRequest("GET", "/full-list?format=csv", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Disposition") != "attachment; filename=full-list.csv" {
+		t.Fatalf("status %d, disposition %q", rec.Code, rec.Header().Get("Content-Disposition"))
+	}
+	want := "item_id,name_of_the_item,name_pt,quantity,price,store_name,seller_name,map_name,map_coordinates,is_available,date_and_time_retrieved\n" +
+		"512,Apple,,3,1500,\"Fruit, Inc\",Alice,prontera,\"150,180\",true,2025-01-01T00:00:00Z\n"
+	if body := rec.Body.String(); body != want {
+		t.Errorf("body = %q, want only the available listing:\n%q", body, want)
+	}
+
+	rec = httptest.NewRecorder()
+	fullListHandler(rec, httptest.NewRequest("GET", "/full-list?format=csv&only_available=false&store_name=Shop", nil))
+	if lines := strings.Count(rec.Body.String(), "\n"); lines != 2 || !strings.Contains(rec.Body.String(), "Potion") {
+		t.Errorf("filtered export = %q, want the header and the Potion row", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	fullListHandler(rec, httptest.NewRequest("GET", "/full-list?format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("format=xml: status %d, want 400", rec.Code)
+	}
+}
+
+func TestGlobalSearchQueryLength(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO guilds (rank, name, level, experience, master, last_updated) VALUES (1, 'Alpha', 1, 0, 'Bob', '2025-01-01T00:00:00Z')`); err != nil {
+		t.Fatal(err)
+	}
+	stubTemplate(t, "search.html", `{{define "layout.html"}}{{.Data.QueryTooShort}} {{len .Data.GuildResults}}{{end}}`)
+
+	cases := []struct {
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{"a", http.StatusBadRequest, "true 0"},
+		{" %  ", http.StatusBadRequest, "true 0"},
+		{"al", http.StatusOK, "false 1"},
+		{"%%", http.StatusOK, "false 0"}, // wildcards match literally
+		{"", http.StatusOK, "false 0"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		globalSearchHandler(rec, httptest.NewRequest("GET", "/search?q="+url.QueryEscape(c.query), nil))
+		if rec.Code != c.wantCode || rec.Body.String() != c.wantBody {
+			t.Errorf("q=%q: got %d %q, want %d %q", c.query, rec.Code, rec.Body.String(), c.wantCode, c.wantBody)
+		}
+	}
+}
+
+func TestItemNameIndexSearch(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO internal_item_db (item_id, name, name_pt) VALUES
+		(501, 'Red Potion', 'Poção Vermelha'),
+		(502, 'Orange Potion', 'Poção Laranja'),
+		(909, 'Jellopy', NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	// Renames go through the update trigger.
+	if _, err := db.Exec(`UPDATE internal_item_db SET name_pt = 'Jellopy PT' WHERE item_id = 909`); err != nil {
+		t.Fatal(err)
+	}
+	rebuildItemNameIndex()
+
+	search := func(q string) []int64 {
+		t.Helper()
+		var stats AdminDashboardData
+		performRMSCacheSearch(httptest.NewRequest("GET", "/admin?rms_query="+url.QueryEscape(q), nil), &stats)
+		var ids []int64
+		for _, r := range stats.RMSCacheSearchResults {
+			ids = append(ids, int64(r.ItemID))
+		}
+		return ids
+	}
+	cases := map[string][]int64{
+		"red pot":         {501},
+		"potion":          {501, 502},
+		"pocao lar":       {502}, // diacritics are folded
+		"jellopy p":       {909},
+		`"red OR jellopy`: nil, // OR is a word, not an operator
+		"otion":           nil, // prefix, not substring
+	}
+	for q, want := range cases {
+		if got := search(q); !slices.Equal(got, want) {
+			t.Errorf("search(%q) = %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestPriceWatch(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO internal_item_db (item_id, name) VALUES (501, 'Red Potion')`); err != nil {
+		t.Fatal(err)
+	}
+
+	var payloads []PriceWatchPayload
+	failWebhook := false
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failWebhook {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var p PriceWatchPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decode webhook body: %v", err)
+		}
+		payloads = append(payloads, p)
+	}))
+	defer hook.Close()
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	appConfig = &config.Config{PriceWatchWebhookURL: hook.URL}
+
+	post := func(form url.Values) string {
+		t.Helper()
+		form.Set("csrf_token", "0123456789abcdef0123456789abcdef")
+		r := httptest.NewRequest("POST", "/watch", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: priceWatchCSRFCookie, Value: "0123456789abcdef0123456789abcdef"})
+		rec := httptest.NewRecorder()
+		priceWatchHandler(rec, r)
+		loc, _ := url.Parse(rec.Header().Get("Location"))
+		return loc.Query().Get("status")
+	}
+
+	// A post without the cookie's token is refused.
+	forged := httptest.NewRequest("POST", "/watch", strings.NewReader("item_id=501&target_price=1k&csrf_token=guess"))
+	forged.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	forged.AddCookie(&http.Cookie{Name: priceWatchCSRFCookie, Value: "0123456789abcdef0123456789abcdef"})
+	rec := httptest.NewRecorder()
+	priceWatchHandler(rec, forged)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("forged post: status %d, want 403", rec.Code)
+	}
+	for _, c := range []struct{ form, want string }{
+		{"item_id=501&target_price=1k", "added"},
+		{"item_id=501&target_price=abc", "invalid"},
+		{"item_id=999&target_price=100", "unknown_item"},
+		{"item_id=501&target_price=1.2k", "added"}, // retargets the same watch
+	} {
+		values, _ := url.ParseQuery(c.form)
+		if got := post(values); got != c.want {
+			t.Errorf("POST %s: status %q, want %q", c.form, got, c.want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	priceWatchHandler(rec, httptest.NewRequest("GET", "/watch?format=json", nil))
+	var page PriceWatchPageData
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Watches) != 1 || page.Watches[0].TargetPrice != 1200 || page.Watches[0].Name != "Red Potion" {
+		t.Fatalf("watches = %+v, want one Red Potion watch at 1200", page.Watches)
+	}
+	other := httptest.NewRequest("GET", "/watch?format=json", nil)
+	other.RemoteAddr = "198.51.100.1:1234"
+	rec = httptest.NewRecorder()
+	priceWatchHandler(rec, other)
+	if strings.Contains(rec.Body.String(), "Red Potion") {
+		t.Error("another visitor sees the watch")
+	}
+
+	setPrice := func(price string) {
+		t.Helper()
+		if _, err := db.Exec(`DELETE FROM items`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO items (name_of_the_item, item_id, quantity, price, store_name, seller_name, date_and_time_retrieved, is_available)
+			VALUES ('Red Potion', 501, 1, ?, 'Cheap Shop', 'Bob', '2025-01-01T00:00:00Z', 1),
+			       ('Red Potion', 501, 1, '5,000z', 'Dear Shop', 'Eve', '2025-01-01T00:00:00Z', 1)`, price); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setPrice("1,100z")
+	checkPriceWatches()
+	if len(payloads) != 1 || payloads[0].Price != 1100 || payloads[0].StoreName != "Cheap Shop" || payloads[0].SellerName != "Bob" {
+		t.Fatalf("after breach: payloads = %+v", payloads)
+	}
+	checkPriceWatches()
+	if len(payloads) != 1 {
+		t.Fatalf("same breach notified %d times, want once", len(payloads))
+	}
+
+	setPrice("1,300z") // back above target re-arms
+	checkPriceWatches()
+	failWebhook = true
+	setPrice("1,000z")
+	checkPriceWatches() // failed delivery leaves the watch armed
+	failWebhook = false
+	checkPriceWatches()
+	if len(payloads) != 2 || payloads[1].Price != 1000 {
+		t.Fatalf("after second breach: payloads = %+v", payloads)
+	}
+
+	values := url.Values{"action": {"delete"}, "id": {fmt.Sprint(page.Watches[0].ID)}}
+	if got := post(values); got != "removed" {
+		t.Errorf("delete: status %q", got)
+	}
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM watchlist`).Scan(&n)
+	if n != 0 {
+		t.Errorf("%d watches left after delete", n)
+	}
+}
+
+func TestActivityEventTypeFilter(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO market_events (event_timestamp, event_type, item_name, item_id, details) VALUES
+		('2025-01-01T00:00:05Z', 'ADDED', 'Jellopy', 909, '{}'),
+		('2025-01-01T00:00:04Z', 'SOLD', 'Jellopy', 909, '{}'),
+		('2025-01-01T00:00:03Z', 'REMOVED', 'Jellopy', 909, '{}'),
+		('2025-01-01T00:00:02Z', 'REMOVED_SINGLE', 'Jellopy', 909, '{}'),
+		('2025-01-01T00:00:01Z', 'NEW_LOW', 'Jellopy', 909, '{}')`); err != nil {
+		t.Fatal(err)
+	}
+	stubTemplate(t, "activity.html", `{{define "layout.html"}}{{range .Data.MarketEvents}}{{.EventType}} {{end}}|{{range .Data.EventTypeOptions}}{{if .Selected}}{{.Type}} {{end}}{{end}}{{end}}`)
+
+	cases := []struct {
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{"", http.StatusOK, "ADDED SOLD REMOVED REMOVED_SINGLE NEW_LOW |"},
+		{"event_type=NEW_LOW&event_type=removed", http.StatusOK, "REMOVED NEW_LOW |REMOVED NEW_LOW "},
+		{"sold_only=true", http.StatusOK, "SOLD |SOLD "},
+		{"sold_only=true&event_type=SOLD&event_type=ADDED", http.StatusOK, "ADDED SOLD |ADDED SOLD "},
+		{"event_type=BOUGHT", http.StatusBadRequest, "unknown event_type \"BOUGHT\"\n"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		activityHandler(rec, httptest.NewRequest("GET", "/activity?"+c.query, nil))
+		if rec.Code != c.wantCode || rec.Body.String() != c.wantBody {
+			t.Errorf("%s: got %d %q, want %d %q", c.query, rec.Code, rec.Body.String(), c.wantCode, c.wantBody)
+		}
+	}
+}
+
+func TestMarketStatsDateRange(t *testing.T) {
+	db := openTestDB(t)
+	for day := 3; day <= 6; day++ {
+		ts := time.Date(2025, 1, day, 12, 0, 0, 0, displayLocation).Local().Format(time.RFC3339)
+		if _, err := db.Exec(`INSERT INTO market_events (event_timestamp, event_type, item_name, item_id, details)
+			VALUES (?, 'SOLD', 'Jellopy', 909, '{"price":"1,000z","seller":"Bob"}')`, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stubTemplate(t, "market_stats.html", `{{define "layout.html"}}{{.Data.SelectedInterval}} {{.Data.TotalSoldItems}}{{end}}`)
+
+	noon := func(day int) string {
+		return url.QueryEscape(time.Date(2025, 1, day, 12, 0, 0, 0, displayLocation).Format(time.RFC3339))
+	}
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{"start=2025-01-04&end=2025-01-05", "custom 2"},
+		{"start=2025-01-05&end=2025-01-05", "custom 1"},
+		{"start=" + noon(4) + "&end=" + noon(6), "custom 3"},
+		{"interval=all&start=2025-01-06&end=2025-01-04", "all 4"}, // end before start
+		{"interval=all&start=yesterday&end=2025-01-04", "all 4"},
+		{"interval=all&start=2025-01-04", "all 4"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		marketStatsHandler(rec, httptest.NewRequest("GET", "/stats/market?"+c.query, nil))
+		if got := rec.Body.String(); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.query, got, c.want)
+		}
+	}
+}
+
+func TestMarketStatsPriceCap(t *testing.T) {
+	db := openTestDB(t)
+	ts := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	for _, price := range []string{"1,000z", "60,000,000z", "200,000,000z"} {
+		if _, err := db.Exec(`INSERT INTO market_events (event_timestamp, event_type, item_name, item_id, details)
+			VALUES (?, 'SOLD', 'Jellopy', 909, json_object('price', ?, 'seller', 'Bob'))`, ts, price); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stubTemplate(t, "market_stats.html", `{{define "layout.html"}}{{.Data.MaxPrice}} {{.Data.TotalSoldItems}} {{len .Data.TopSellers}}{{end}}`)
+
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{"", "50000000 1 1"},
+		{"max_price=100000000", "100000000 2 1"},
+		{"max_price=0", "0 3 1"},
+		{"max_price=-1", "50000000 1 1"},
+		{"max_price=lots", "50000000 1 1"},
+		{"max_price=500", "500 0 0"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		marketStatsHandler(rec, httptest.NewRequest("GET", "/stats/market?"+c.query, nil))
+		if got := rec.Body.String(); got != c.want {
+			t.Errorf("%q: got %q, want %q", c.query, got, c.want)
+		}
+	}
+}
+
+func TestMarketStatsFilter(t *testing.T) {
+	db := openTestDB(t)
+	ts := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	for _, price := range []string{"100z", "110z", "9,000z"} {
+		if _, err := db.Exec(`INSERT INTO market_events (event_timestamp, event_type, item_name, item_id, details)
+			VALUES (?, 'SOLD', 'Jellopy', 909, json_object('price', ?))`, ts, price); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
+	for _, prefix := range []string{"", "me."} {
+		where, params := marketStatsFilter(prefix, start, "", statsPriceCap, []int64{3})
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM market_events me `+where, params...).Scan(&n); err != nil {
+			t.Fatalf("%q: %v", prefix, err)
+		}
+		if n != 2 {
+			t.Errorf("%q: counted %d sales, want the 2 that aren't outliers", prefix, n)
+		}
+	}
+}
+
+func TestFillHistoricalPrices(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO items (name_of_the_item, item_id, quantity, price, store_name, seller_name, date_and_time_retrieved, map_name, map_coordinates, is_available) VALUES
+		('Jellopy', 909, 1, '10z', 'S', 'A', '2025-01-01T00:00:00Z', 'prontera', '1,1', 0),
+		('Jellopy', 909, 1, '30z', 'S', 'A', '2025-01-02T00:00:00Z', 'prontera', '1,1', 0),
+		('Jellopy', 909, 1, '20z', 'S', 'B', '2025-01-02T00:00:00Z', 'prontera', '1,1', 0),
+		('Apple', 512, 1, '5z', 'S', 'A', '2025-01-02T00:00:00Z', 'prontera', '1,1', 0)`); err != nil {
+		t.Fatal(err)
+	}
+
+	items := []ItemSummary{{Name: "Jellopy"}, {Name: "Apple", ListingCount: 1}}
+	if err := fillHistoricalPrices(items); err != nil {
+		t.Fatal(err)
+	}
+	if j := items[0]; !j.IsHistorical || j.LowestPrice.Int64 != 20 || j.HighestPrice.Int64 != 30 {
+		t.Errorf("Jellopy = %+v, want 20-30 from its last scrape", j)
+	}
+	if items[1].IsHistorical {
+		t.Error("a listed item should keep its live prices")
+	}
+}
+
+func TestFillRecentSales(t *testing.T) {
+	db := openTestDB(t)
+	recent := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	old := time.Now().Add(-8 * 24 * time.Hour).Format(time.RFC3339)
+	if _, err := db.Exec(`INSERT INTO market_events (event_timestamp, event_type, item_name, item_id, details) VALUES
+		(?, 'SOLD', 'Jellopy', 909, '{}'),
+		(?, 'SOLD', 'Jellopy', 909, '{}'),
+		(?, 'ADDED', 'Jellopy', 909, '{}'),
+		(?, 'SOLD', 'Jellopy', 909, '{}'),
+		(?, 'SOLD', 'Red Potion', 501, '{}')`, recent, recent, recent, old, recent); err != nil {
+		t.Fatal(err)
+	}
+
+	items := []ItemSummary{{Name: "Jellopy", ItemID: 909}, {Name: "Red Potion", ItemID: 501}, {Name: "Unknown"}, {Name: "Apple", ItemID: 512}}
+	if err := fillRecentSales(items); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{2, 1, 0, 0} {
+		if items[i].RecentSales != want {
+			t.Errorf("%s: RecentSales = %d, want %d", items[i].Name, items[i].RecentSales, want)
+		}
+	}
+}
+
+func TestMarketSlots(t *testing.T) {
+	cases := map[string]int{
+		"Jur":                   0,
+		"Jur [3]":               3,
+		"Boots [1] +4 [Poring]": 1,
+		"Jur +7 [Hydra]":        0,
+		"Jur [Hydra] [2]":       0,
+	}
+	for name, want := range cases {
+		if got := marketSlots(name); got != want {
+			t.Errorf("marketSlots(%q) = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestGlobalSearchMarketSlots(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO internal_item_db (item_id, name, name_pt) VALUES
+		(1251, 'Jur', 'Jur'), (1250, 'Jur', 'Jur')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO items (name_of_the_item, item_id, quantity, price, store_name, seller_name, date_and_time_retrieved, map_name, map_coordinates, is_available) VALUES
+		('Jur [3]', 1251, 1, '90,000z', 'Shop', 'Bob', '2025-01-01T00:00:00Z', 'prontera', '150,150', 1),
+		('Jur [3]', 1251, 1, '95,000z', 'Shop', 'Ann', '2025-01-01T00:00:00Z', 'prontera', '151,150', 1),
+		('Jur', 1250, 1, '40,000z', 'Shop', 'Bob', '2025-01-01T00:00:00Z', 'prontera', '150,150', 1)`); err != nil {
+		t.Fatal(err)
+	}
+	stubTemplate(t, "search.html", `{{define "layout.html"}}{{range .Data.MarketResults}}{{.Name}}/{{.Slots}}/{{.ListingCount}};{{end}}{{end}}`)
+
+	rec := httptest.NewRecorder()
+	globalSearchHandler(rec, httptest.NewRequest("GET", "/search?q=jur", nil))
+	if got, want := rec.Body.String(), "Jur [3]/3/2;Jur/0/1;"; got != want {
+		t.Errorf("market results = %q, want %q", got, want)
+	}
+}
+
+func TestExpireStaleListings(t *testing.T) {
+	db := openTestDB(t)
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	appConfig = &config.Config{StaleListingHours: 2}
+
+	now := time.Now()
+	at := func(d time.Duration) string { return now.Add(-d).Format(time.RFC3339) }
+	available := func() string {
+		var names string
+		if err := db.QueryRow(`SELECT COALESCE(GROUP_CONCAT(name_of_the_item, ','), '') FROM (SELECT name_of_the_item FROM items WHERE is_available = 1 ORDER BY id)`).Scan(&names); err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+	// scrape leaves the database the way a successful scrapeData at
+	// scrapedAt does: every available listing stamped with that time.
+	scrape := func(scrapedAt string) {
+		if _, err := db.Exec(`UPDATE items SET last_seen = ? WHERE is_available = 1`, scrapedAt); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO scrape_history (timestamp) VALUES (?)`, scrapedAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.Exec(`INSERT INTO items (name_of_the_item, item_id, quantity, price, store_name, seller_name, date_and_time_retrieved, map_name, map_coordinates, is_available, last_seen) VALUES
+		('Apple', 1, 1, '1z', 'S', 'A', ?, 'prontera', '1,1', 1, NULL),
+		('Sold', 2, 1, '1z', 'S', 'A', ?, 'prontera', '1,1', 0, ?),
+		('Jellopy', 3, 1, '1z', 'S', 'A', ?, 'prontera', '1,1', 1, NULL)`,
+		at(48*time.Hour), at(48*time.Hour), at(47*time.Hour), at(10*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The latest scrape succeeded, so every available listing was just seen.
+	scrape(at(10 * time.Minute))
+	expireStaleListings()
+	if got := available(); got != "Apple,Jellopy" {
+		t.Errorf("after a recent scrape, available = %q, want every listing", got)
+	}
+
+	// Same market, but the last successful scrape was 3 hours ago and
+	// every one since has failed.
+	scrape(at(3 * time.Hour))
+	expireStaleListings()
+	if got := available(); got != "" {
+		t.Errorf("after missed scrapes, available = %q, want none", got)
+	}
+	var soldSeen string
+	if err := db.QueryRow(`SELECT last_seen FROM items WHERE name_of_the_item = 'Sold'`).Scan(&soldSeen); err != nil || soldSeen != at(47*time.Hour) {
+		t.Errorf("unavailable listing touched: last_seen = %q, %v", soldSeen, err)
+	}
+}
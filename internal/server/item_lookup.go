@@ -0,0 +1,103 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// onlineLookupCacheTTL is how long an online lookup result (hit or
+	// miss) is reused before rodatabase is asked again.
+	onlineLookupCacheTTL = 10 * time.Minute
+	// onlineLookupCacheMax caps the result cache; expired entries are
+	// swept when it fills, and the whole cache is dropped if that is not
+	// enough.
+	onlineLookupCacheMax = 500
+)
+
+type onlineLookupResult struct {
+	id     sql.NullInt64
+	found  bool
+	expiry time.Time
+}
+
+var (
+	onlineLookupGroup   singleflight.Group
+	onlineLookupSem     chan struct{}
+	onlineLookupSemOnce sync.Once
+
+	onlineLookupCache   = make(map[string]onlineLookupResult)
+	onlineLookupCacheMu sync.Mutex
+)
+
+// onlineLookupConcurrency returns how many online lookups may run at once.
+func onlineLookupConcurrency() int {
+	if appConfig == nil || appConfig.OnlineLookupConcurrency < 1 {
+		return 2
+	}
+	return appConfig.OnlineLookupConcurrency
+}
+
+// lookupItemIDOnline is the queued front for findItemIDOnline. Results
+// are cached for onlineLookupCacheTTL, concurrent lookups of the same
+// name and slot count share one scrape, and at most
+// ONLINE_LOOKUP_CONCURRENCY scrapes run at a time.
+func lookupItemIDOnline(cleanItemName string, slots int) (sql.NullInt64, bool) {
+	key := fmt.Sprintf("%s_%d", strings.ToLower(cleanItemName), slots)
+
+	onlineLookupCacheMu.Lock()
+	cached, ok := onlineLookupCache[key]
+	onlineLookupCacheMu.Unlock()
+	if ok && time.Now().Before(cached.expiry) {
+		log.Printf("[D] [ItemID/Online] Using cached online result for '%s' (found: %t).", cleanItemName, cached.found)
+		return cached.id, cached.found
+	}
+
+	v, _, shared := onlineLookupGroup.Do(key, func() (interface{}, error) {
+		onlineLookupSemOnce.Do(func() {
+			onlineLookupSem = make(chan struct{}, onlineLookupConcurrency())
+		})
+		select {
+		case onlineLookupSem <- struct{}{}:
+		default:
+			log.Printf("[D] [ItemID/Online] %d lookups running; queuing '%s'.", cap(onlineLookupSem), cleanItemName)
+			onlineLookupSem <- struct{}{}
+		}
+		defer func() { <-onlineLookupSem }()
+
+		id, found := findItemIDOnline(cleanItemName, slots)
+		res := onlineLookupResult{id: id, found: found, expiry: time.Now().Add(onlineLookupCacheTTL)}
+		storeOnlineLookup(key, res)
+		return res, nil
+	})
+	if shared {
+		log.Printf("[D] [ItemID/Online] Deduped online lookup for '%s' with one already in flight.", cleanItemName)
+	}
+	res := v.(onlineLookupResult)
+	return res.id, res.found
+}
+
+// storeOnlineLookup caches res under key, making room when the cache is full.
+func storeOnlineLookup(key string, res onlineLookupResult) {
+	onlineLookupCacheMu.Lock()
+	defer onlineLookupCacheMu.Unlock()
+
+	if len(onlineLookupCache) >= onlineLookupCacheMax {
+		now := time.Now()
+		for k, e := range onlineLookupCache {
+			if now.After(e.expiry) {
+				delete(onlineLookupCache, k)
+			}
+		}
+		if len(onlineLookupCache) >= onlineLookupCacheMax {
+			onlineLookupCache = make(map[string]onlineLookupResult)
+		}
+	}
+	onlineLookupCache[key] = res
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/denislee/yufa-mt/internal/httpx"
+)
+
+// ItemMatchStep is one decision point of the item-name resolver.
+type ItemMatchStep struct {
+	Stage  string `json:"Stage"`
+	Detail string `json:"Detail"`
+}
+
+// ItemMatchCandidate is an item the resolver considered. Distance is the
+// Levenshtein distance to the cleaned name (EN or PT, whichever is
+// closer) and is only set for candidates that reached that stage.
+type ItemMatchCandidate struct {
+	ID       int64  `json:"ID"`
+	Name     string `json:"Name"`
+	NamePT   string `json:"NamePT,omitempty"`
+	Distance int    `json:"Distance"`
+}
+
+// ItemMatchReport is the trace of one findItemIDByName run, as returned
+// by /admin/debug/item-match.
+type ItemMatchReport struct {
+	Input           string               `json:"Input"`
+	Slots           int                  `json:"Slots"`
+	Steps           []ItemMatchStep      `json:"Steps"`
+	LocalCandidates []ItemMatchCandidate `json:"LocalCandidates"`
+	OnlineResults   []ItemMatchCandidate `json:"OnlineResults"`
+	Resolved        bool                 `json:"Resolved"`
+	ItemID          int64                `json:"ItemID,omitempty"`
+	ItemName        string               `json:"ItemName,omitempty"`
+	Error           string               `json:"Error,omitempty"`
+}
+
+// step appends a decision to the trace. A nil trace ignores it, so the
+// resolver can call this unconditionally.
+func (t *ItemMatchReport) step(stage, format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	t.Steps = append(t.Steps, ItemMatchStep{Stage: stage, Detail: fmt.Sprintf(format, args...)})
+}
+
+// adminDebugItemMatchHandler runs the item-name resolver for ?name= and
+// ?slots= and returns every decision it made as JSON. Nothing is written:
+// the online lookup cache is bypassed and no trading post is touched.
+func adminDebugItemMatchHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	slots, _ := strconv.Atoi(r.FormValue("slots"))
+
+	report := &ItemMatchReport{
+		Input:           name,
+		Slots:           slots,
+		Steps:           []ItemMatchStep{},
+		LocalCandidates: []ItemMatchCandidate{},
+		OnlineResults:   []ItemMatchCandidate{},
+	}
+	id, err := resolveItemName(name, true, slots, report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if id.Valid {
+		report.Resolved = true
+		report.ItemID = id.Int64
+		if details := fetchItemDetails(int(id.Int64)); details != nil {
+			report.ItemName = details.Name
+		}
+	}
+
+	if err := httpx.WriteJSON(w, http.StatusOK, report); err != nil {
+		logRequestf(r, "[W] [Admin/ItemMatch] Could not encode report: %v", err)
+	}
+}
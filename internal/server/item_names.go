@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// maxAliasHops bounds how many renames itemNameAliases follows from the
+// requested name, so a cyclic history (A -> B -> A) cannot loop forever.
+const maxAliasHops = 8
+
+// recordItemRename stores a rename of itemID in item_name_history. It is
+// a no-op when there was no previous name or the name did not change.
+func recordItemRename(itemID int64, lang, oldName, newName string) {
+	if oldName == "" || oldName == newName || srv == nil || srv.db == nil {
+		return
+	}
+	_, err := srv.db.Exec(`
+		INSERT INTO item_name_history (item_id, lang, old_name, new_name, changed_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		itemID, lang, oldName, newName, time.Now().Format(time.RFC3339))
+	if err != nil {
+		log.Printf("[E] [ItemID/Rename] Failed to record rename of item %d (%s): %v", itemID, lang, err)
+		return
+	}
+	log.Printf("[I] [ItemID/Rename] Item %d (%s) renamed from '%s' to '%s'", itemID, lang, oldName, newName)
+}
+
+// syncItemNames compares the English base names seen on the market
+// (item_id -> name) with internal_item_db. An item without a name there
+// takes the market one. A differing name is recorded as a rename and
+// added to the in-memory cache, but the item DB keeps its canonical name:
+// the market name resolves through the rename history instead.
+func syncItemNames(scraped map[int64]string) {
+	if len(scraped) == 0 {
+		return
+	}
+	ensureItemCache()
+
+	type rename struct {
+		id       int64
+		old, new string
+	}
+	var fills, renames []rename
+
+	itemCacheMu.Lock()
+	for i, item := range itemFuzzyCache {
+		name, ok := scraped[item.id]
+		if !ok || name == "" || name == item.name {
+			continue
+		}
+		key := fmt.Sprintf("%s_%d", strings.ToLower(name), item.slots)
+		if item.name == "" {
+			fills = append(fills, rename{id: item.id, new: name})
+			itemFuzzyCache[i].name = name
+		} else if itemExactCache[key] != item.id {
+			renames = append(renames, rename{id: item.id, old: item.name, new: name})
+		}
+		itemExactCache[key] = item.id
+	}
+	itemCacheMu.Unlock()
+
+	for _, fill := range fills {
+		_, err := srv.db.Exec("UPDATE internal_item_db SET name = ?, last_modified = ? WHERE item_id = ? AND COALESCE(name, '') = ''",
+			fill.new, time.Now().Format(time.RFC3339), fill.id)
+		if err != nil {
+			log.Printf("[E] [ItemID/Rename] Failed to fill in name of item %d: %v", fill.id, err)
+		}
+	}
+	for _, rn := range renames {
+		// The canonical name never changes, so the same rename shows up
+		// again after every restart; record it once.
+		var known bool
+		err := srv.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM item_name_history WHERE item_id = ? AND lang = 'en' AND old_name = ? AND new_name = ?)`,
+			rn.id, rn.old, rn.new).Scan(&known)
+		if err != nil {
+			log.Printf("[E] [ItemID/Rename] Failed to check rename history of item %d: %v", rn.id, err)
+			continue
+		}
+		if !known {
+			recordItemRename(rn.id, "en", rn.old, rn.new)
+		}
+	}
+}
+
+// itemNameAliases returns name followed by every market name the same
+// item has been listed under, following English renames in both
+// directions. Market suffixes (" [slots]", " +refine", cards) carry over,
+// so "Old Sword [3] +7" and "New Sword [3] +7" are aliases of each other.
+func itemNameAliases(name string) []string {
+	rows, err := srv.db.Query("SELECT old_name, new_name FROM item_name_history WHERE lang = 'en'")
+	if err != nil {
+		log.Printf("[W] [ItemID/Rename] Could not load rename history: %v", err)
+		return []string{name}
+	}
+	defer rows.Close()
+
+	var renames [][2]string
+	for rows.Next() {
+		var pair [2]string
+		if err := rows.Scan(&pair[0], &pair[1]); err != nil {
+			continue
+		}
+		renames = append(renames, pair)
+	}
+	return expandNameAliases(name, renames)
+}
+
+// expandNameAliases applies the (old, new) rename pairs to name until no
+// new aliases appear. The result always starts with name itself.
+func expandNameAliases(name string, renames [][2]string) []string {
+	aliases := []string{name}
+	seen := map[string]bool{name: true}
+	frontier := []string{name}
+	for hop := 0; hop < maxAliasHops && len(frontier) > 0; hop++ {
+		var next []string
+		for _, n := range frontier {
+			for _, pair := range renames {
+				for _, dir := range [][2]string{{pair[0], pair[1]}, {pair[1], pair[0]}} {
+					suffix, ok := marketNameSuffix(n, dir[0])
+					if !ok {
+						continue
+					}
+					alias := dir[1] + suffix
+					if !seen[alias] {
+						seen[alias] = true
+						aliases = append(aliases, alias)
+						next = append(next, alias)
+					}
+				}
+			}
+		}
+		frontier = next
+	}
+	return aliases
+}
+
+// marketNameSuffix reports whether the market name is base plus an
+// optional slot/refine/card suffix, and returns that suffix.
+func marketNameSuffix(name, base string) (string, bool) {
+	if base == "" || !strings.HasPrefix(name, base) {
+		return "", false
+	}
+	suffix := name[len(base):]
+	if suffix == "" || strings.HasPrefix(suffix, " [") || strings.HasPrefix(suffix, " +") {
+		return suffix, true
+	}
+	return "", false
+}
+
+// renamedItemIDs returns the IDs of items that were once named something
+// containing query (case-insensitive), so searches for an old name still
+// find the item.
+func renamedItemIDs(query string) ([]int, error) {
+	rows, err := srv.db.Query(`
+		SELECT DISTINCT item_id FROM item_name_history
+		WHERE LOWER(old_name) LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(query))+"%")
+	if err != nil {
+		return nil, fmt.Errorf("could not query item rename history: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("could not scan renamed item id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// nameInClause builds "column IN (?, ...)" for names.
+func nameInClause(column string, names []string) (string, []interface{}) {
+	params := make([]interface{}, len(names))
+	for i, n := range names {
+		params[i] = n
+	}
+	return fmt.Sprintf("%s IN (?%s)", column, strings.Repeat(", ?", len(names)-1)), params
+}
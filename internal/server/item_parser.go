@@ -1,16 +1,29 @@
 package server
 
 import (
+	"log"
+
 	"github.com/denislee/yufa-mt/data"
 	"github.com/denislee/yufa-mt/internal/itemdb"
 )
 
 // populateItemDBOnStartup hydrates the local internal_item_db SQLite
-// table from the embedded seed YAML item dumps.
+// table from the embedded seed YAML item dumps, then rebuilds its name
+// index.
 func populateItemDBOnStartup() {
 	if err := itemdb.Populate(srv.db, data.Seed, itemdb.DefaultFiles); err != nil {
 		// itemdb.Populate already logs per-file warnings; this only fires
 		// for a hard transaction failure.
 		panic(err)
 	}
+	rebuildItemNameIndex()
+}
+
+// rebuildItemNameIndex refills internal_item_db_fts from internal_item_db.
+// Triggers keep the index current afterwards; the rebuild covers rows
+// written before the index existed.
+func rebuildItemNameIndex() {
+	if _, err := srv.db.Exec(`INSERT INTO internal_item_db_fts(internal_item_db_fts) VALUES ('rebuild')`); err != nil {
+		log.Printf("[W] [ItemDB] Could not rebuild the item name index: %v", err)
+	}
 }
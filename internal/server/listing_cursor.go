@@ -0,0 +1,119 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Popular items have tens of thousands of historical listings, and
+// LIMIT/OFFSET has to walk past every skipped row, so deep pages of the
+// history table get slow. ?cursor= pages by position instead: each page
+// starts strictly after the last row of the previous one in the table's
+// (is_available DESC, date_and_time_retrieved DESC, id DESC) order, which
+// idx_items_name_available_time answers with a single index range.
+
+// listingCursor is the position of one row in an item's listing history.
+type listingCursor struct {
+	Available bool
+	Retrieved string // date_and_time_retrieved as stored (RFC3339)
+	ID        int
+}
+
+// encode returns the cursor as an opaque, URL-safe token.
+func (c listingCursor) encode() string {
+	available := 0
+	if c.Available {
+		available = 1
+	}
+	raw := fmt.Sprintf("%d|%s|%d", available, c.Retrieved, c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeListingCursor parses a token made by listingCursor.encode.
+func decodeListingCursor(token string) (listingCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return listingCursor{}, fmt.Errorf("invalid cursor encoding: %w", err)
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 || (parts[0] != "0" && parts[0] != "1") {
+		return listingCursor{}, errors.New("malformed cursor")
+	}
+	if _, err := time.Parse(time.RFC3339, parts[1]); err != nil {
+		return listingCursor{}, fmt.Errorf("invalid cursor time: %w", err)
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil || id < 1 {
+		return listingCursor{}, errors.New("invalid cursor id")
+	}
+	return listingCursor{Available: parts[0] == "1", Retrieved: parts[1], ID: id}, nil
+}
+
+// fetchListingsAfter returns up to limit listings that follow cursor in
+// the history table's order, or the first page when cursor is nil. next
+// is the token for the following page, or "" on the last one.
+func fetchListingsAfter(itemNames []string, cursor *listingCursor, limit int) (listings []Item, next string, err error) {
+	nameClause, params := nameInClause("i.name_of_the_item", itemNames)
+	query := `
+		SELECT i.id, i.name_of_the_item, local_db.name_pt, i.item_id, i.quantity, i.price,
+		       i.store_name, i.seller_name, i.date_and_time_retrieved, i.map_name,
+		       i.map_coordinates, i.is_available
+		FROM items i
+		LEFT JOIN internal_item_db local_db ON i.item_id = local_db.item_id
+		WHERE ` + nameClause
+	if cursor != nil {
+		query += ` AND (i.is_available, i.date_and_time_retrieved, i.id) < (?, ?, ?)`
+		params = append(params, cursor.Available, cursor.Retrieved, cursor.ID)
+	}
+	query += `
+		ORDER BY i.is_available DESC, i.date_and_time_retrieved DESC, i.id DESC
+		LIMIT ?`
+
+	// One extra row tells whether there is a next page.
+	rows, err := srv.db.Query(query, append(params, limit+1)...)
+	if err != nil {
+		return nil, "", fmt.Errorf("keyset listings query error: %w", err)
+	}
+	defer rows.Close()
+
+	listings, last, err := scanListingRows(rows, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	if last != nil {
+		next = last.encode()
+	}
+	return listings, next, nil
+}
+
+// scanListingRows reads up to limit history rows. When another row
+// follows the limit, it also returns the cursor of the last row kept.
+func scanListingRows(rows *sql.Rows, limit int) ([]Item, *listingCursor, error) {
+	var listings []Item
+	var last listingCursor
+	for rows.Next() {
+		if len(listings) == limit {
+			return listings, &last, rows.Err()
+		}
+		var listing Item
+		var timestampStr string
+		if err := rows.Scan(&listing.ID, &listing.Name, &listing.NamePT, &listing.ItemID, &listing.Quantity, &listing.Price, &listing.StoreName, &listing.SellerName, &timestampStr, &listing.MapName, &listing.MapCoordinates, &listing.IsAvailable); err != nil {
+			log.Printf("[W] [HTTP/History] Failed to scan all listing row: %v", err)
+			continue
+		}
+		last = listingCursor{Available: listing.IsAvailable, Retrieved: timestampStr, ID: listing.ID}
+		if parsedTime, err := time.Parse(time.RFC3339, timestampStr); err == nil {
+			listing.Timestamp = displayTime(parsedTime).Format("2006-01-02 15:04")
+		} else {
+			listing.Timestamp = timestampStr
+		}
+		listings = append(listings, listing)
+	}
+	return listings, nil, rows.Err()
+}
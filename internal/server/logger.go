@@ -1,10 +1,14 @@
 package server
 
 import (
+	"fmt"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
 	"strings"
+
+	"github.com/denislee/yufa-mt/internal/middleware"
 )
 
 // initLogger configures slog as the default logger. Format and level
@@ -54,3 +58,13 @@ func (w stdlogWriter) Write(p []byte) (int, error) {
 	w.logger.Info(msg)
 	return len(p), nil
 }
+
+// logRequestf is log.Printf for handler errors: it appends the request's
+// X-Request-ID so a user-reported failure can be matched to its log line.
+func logRequestf(r *http.Request, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if id := middleware.RequestIDFrom(r.Context()); id != "" {
+		msg += " (request_id=" + id + ")"
+	}
+	log.Print(msg)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// vacuumInFlight is set while vacuumDatabase runs so a second click on
+// the admin button can't queue another exclusive-lock rebuild behind it.
+var vacuumInFlight atomic.Bool
+
+// compactPlayerHistorySQL downsamples player_history rows older than the
+// cutoff to one row per hour. The row kept for each hour is the one with
+// the most active players (count minus sellers), so the peaks that
+// getHistoricalMaxPlayers reports survive compaction.
+const compactPlayerHistorySQL = `
+	DELETE FROM player_history
+	WHERE timestamp < ?
+	  AND timestamp NOT IN (
+		SELECT timestamp FROM (
+			SELECT timestamp, ROW_NUMBER() OVER (
+				PARTITION BY substr(timestamp, 1, 13)
+				ORDER BY MAX(count - COALESCE(seller_count, 0), 0) DESC, count DESC, timestamp ASC
+			) AS rn
+			FROM player_history
+			WHERE timestamp < ?
+		) WHERE rn = 1
+	  )`
+
+// compactPlayerHistory is the daily job that bounds player_history growth.
+// It is a no-op when PLAYER_HISTORY_RETENTION_DAYS is 0.
+func compactPlayerHistory() error {
+	days := 90
+	if appConfig != nil {
+		days = appConfig.PlayerHistoryRetentionDays
+	}
+	if days <= 0 {
+		return nil
+	}
+
+	playerCountMutex.Lock()
+	defer playerCountMutex.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -days).Format(time.RFC3339)
+	log.Printf("[I] [Maintenance/PlayerHistory] Compacting player history older than %s...", cutoff)
+
+	res, err := srv.db.Exec(compactPlayerHistorySQL, cutoff, cutoff)
+	if err != nil {
+		log.Printf("[E] [Maintenance/PlayerHistory] Failed to compact player history: %v", err)
+		return err
+	}
+	removed, _ := res.RowsAffected()
+	log.Printf("[I] [Maintenance/PlayerHistory] Compaction complete. Removed %d rows.", removed)
+	return nil
+}
+
+// expireStaleListings is the periodic guard against phantom availability.
+// It marks unavailable the listings the market scrape has not seen for
+// STALE_LISTING_HOURS (last_seen, or date_and_time_retrieved for rows the
+// scrape has not stamped yet). Every successful scrape stamps all the
+// listings it leaves available, so in practice this expires the whole
+// market once scrapes have been failing for that long. It is a no-op when
+// STALE_LISTING_HOURS is 0.
+func expireStaleListings() error {
+	hours := 0
+	if appConfig != nil {
+		hours = appConfig.StaleListingHours
+	}
+	if hours <= 0 {
+		return nil
+	}
+
+	marketMutex.Lock()
+	defer marketMutex.Unlock()
+
+	maxAge := time.Duration(hours) * time.Hour
+	cutoff := time.Now().Add(-maxAge).Format(time.RFC3339)
+	res, err := srv.db.Exec(`UPDATE items SET is_available = 0
+		WHERE is_available = 1 AND COALESCE(last_seen, date_and_time_retrieved) < ?`, cutoff)
+	if err != nil {
+		log.Printf("[E] [Maintenance/Listings] Failed to expire stale listings: %v", err)
+		return err
+	}
+	flipped, _ := res.RowsAffected()
+	if flipped > 0 {
+		log.Printf("[W] [Maintenance/Listings] Marked %d listings not seen since %s unavailable. Is the market scraper failing?", flipped, cutoff)
+	}
+	return nil
+}
+
+// dbSizeBytes returns the size of the main database in bytes, taken from
+// SQLite's page accounting so it is right regardless of the file path or
+// pending WAL contents.
+func dbSizeBytes() (int64, error) {
+	var pageCount, pageSize int64
+	if err := srv.db.QueryRow("PRAGMA page_count").Scan(&pageCount); err != nil {
+		return 0, err
+	}
+	if err := srv.db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pageCount * pageSize, nil
+}
+
+// vacuumDatabase rebuilds the database file with VACUUM, reclaiming the
+// space left by cleanup jobs, then refreshes planner statistics with
+// ANALYZE. VACUUM holds an exclusive lock for its whole run, so scrapers
+// and page loads that write will wait on busy_timeout until it finishes.
+// It returns the database size before and after.
+func vacuumDatabase() (before, after int64, err error) {
+	if !vacuumInFlight.CompareAndSwap(false, true) {
+		return 0, 0, fmt.Errorf("a vacuum is already running")
+	}
+	defer vacuumInFlight.Store(false)
+
+	if before, err = dbSizeBytes(); err != nil {
+		return 0, 0, fmt.Errorf("could not read database size: %w", err)
+	}
+
+	start := time.Now()
+	log.Printf("[I] [Maintenance/Vacuum] Starting VACUUM (%d bytes). Writers will block until it finishes.", before)
+	if _, err = srv.db.Exec("VACUUM"); err != nil {
+		return before, 0, fmt.Errorf("VACUUM failed: %w", err)
+	}
+	if _, err = srv.db.Exec("ANALYZE"); err != nil {
+		return before, 0, fmt.Errorf("ANALYZE failed: %w", err)
+	}
+
+	if after, err = dbSizeBytes(); err != nil {
+		return before, 0, fmt.Errorf("could not read database size: %w", err)
+	}
+	log.Printf("[I] [Maintenance/Vacuum] VACUUM and ANALYZE finished in %s: %d -> %d bytes.", time.Since(start).Round(time.Millisecond), before, after)
+	return before, after, nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Market prices are stored as the text the scraper saw, normally
+// "1,234,567z". Older rows and hand-entered data also hold "1234567",
+// "1.234.567z" or shorthand like "1.5kk". parseMarketPrice and
+// marketPriceSQL read all of these the same way, so a price compares
+// equal whether it was parsed in Go or in a query:
+//
+//   - commas are thousands separators and are dropped;
+//   - a trailing "z" (and surrounding spaces) is dropped;
+//   - a "k" or "kk" suffix multiplies by 1,000 or 1,000,000, and a dot
+//     before it is a decimal point ("1.5kk" is 1,500,000);
+//   - without a suffix, dots are thousands separators too.
+
+// formatMarketPrice mirrors the historical price-string format ("1,234,567z")
+// so the lowest-price comparisons in scrapeData keep matching old rows.
+func formatMarketPrice(p int64) string {
+	s := strconv.FormatInt(p, 10)
+	n := len(s)
+	if n <= 3 {
+		return s + "z"
+	}
+	var b strings.Builder
+	b.Grow(n + n/3 + 1)
+	first := n % 3
+	if first > 0 {
+		b.WriteString(s[:first])
+		if n > first {
+			b.WriteByte(',')
+		}
+	}
+	for i := first; i < n; i += 3 {
+		b.WriteString(s[i : i+3])
+		if i+3 < n {
+			b.WriteByte(',')
+		}
+	}
+	b.WriteByte('z')
+	return b.String()
+}
+
+// parseMarketPrice is the inverse of formatMarketPrice and also accepts
+// the other formats above. It returns 0 for strings that don't hold a
+// number.
+func parseMarketPrice(s string) int64 {
+	s = strings.TrimRight(strings.ReplaceAll(strings.TrimSpace(s), ",", ""), "zZ ")
+	var mult float64
+	switch lower := strings.ToLower(s); {
+	case strings.HasSuffix(lower, "kk"):
+		s, mult = s[:len(s)-2], 1_000_000
+	case strings.HasSuffix(lower, "k"):
+		s, mult = s[:len(s)-1], 1_000
+	default:
+		p, err := strconv.ParseInt(strings.ReplaceAll(s, ".", ""), 10, 64)
+		if err != nil {
+			return 0
+		}
+		return p
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return int64(math.Round(f * mult))
+}
+
+// marketPriceSQL returns an SQLite expression that reads the price text
+// in column (e.g. "i.price" or "json_extract(details, '$.price')") as an
+// INTEGER the way parseMarketPrice does. Use it instead of ad hoc
+// REPLACE/CAST chains so every page ranks and sums prices alike. The
+// expression holds no '%', so it is safe inside fmt.Sprintf formats.
+func marketPriceSQL(column string) string {
+	p := fmt.Sprintf("RTRIM(REPLACE(TRIM(%s), ',', ''), 'zZ ')", column)
+	return fmt.Sprintf(`(CASE
+		WHEN LOWER(SUBSTR(%[1]s, -2)) = 'kk' THEN CAST(ROUND(CAST(SUBSTR(%[1]s, 1, LENGTH(%[1]s) - 2) AS REAL) * 1000000) AS INTEGER)
+		WHEN LOWER(SUBSTR(%[1]s, -1)) = 'k' THEN CAST(ROUND(CAST(SUBSTR(%[1]s, 1, LENGTH(%[1]s) - 1) AS REAL) * 1000) AS INTEGER)
+		ELSE CAST(REPLACE(%[1]s, '.', '') AS INTEGER)
+	END)`, p)
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"time"
@@ -93,6 +94,76 @@ type ItemSummary struct {
 	LowestPrice  sql.NullInt64
 	HighestPrice sql.NullInt64
 	ListingCount int
+	// Slots is the slot count in Name; only global search results set it.
+	Slots int
+	// IsHistorical marks Lowest/HighestPrice as the last prices seen
+	// before the item was delisted rather than current listings.
+	IsHistorical bool
+	// RecentSales counts the item's SOLD events in the last
+	// summarySalesWindow.
+	RecentSales int
+}
+
+// ItemDBRecord is one line of the /items/all.json dump: a full
+// internal_item_db row. Jobs and Locations are the stored JSON objects.
+type ItemDBRecord struct {
+	ItemID        int64           `json:"ItemID"`
+	AegisName     string          `json:"AegisName"`
+	Name          string          `json:"Name"`
+	NamePT        string          `json:"NamePT,omitempty"`
+	Type          string          `json:"Type"`
+	Buy           int64           `json:"Buy"`
+	Sell          int64           `json:"Sell"`
+	Weight        int64           `json:"Weight"`
+	Slots         int             `json:"Slots"`
+	Jobs          json.RawMessage `json:"Jobs,omitempty"`
+	Locations     json.RawMessage `json:"Locations,omitempty"`
+	Script        string          `json:"Script,omitempty"`
+	EquipScript   string          `json:"EquipScript,omitempty"`
+	UnequipScript string          `json:"UnequipScript,omitempty"`
+	LastModified  string          `json:"LastModified,omitempty"`
+}
+
+// ErrorPageData holds the data for error.html. Message is the
+// handler's specific reason, e.g. "Character not found".
+type ErrorPageData struct {
+	Status     int
+	StatusText string
+	Message    string
+	PageTitle  string
+}
+
+// SummaryJSONItem is one item in the /summary.json and /api/summary
+// responses. Prices are 0 for items with no listings unless IsHistorical.
+type SummaryJSONItem struct {
+	ItemID       int    `json:"ItemID"`
+	Name         string `json:"Name"`
+	NamePT       string `json:"NamePT,omitempty"`
+	LowestPrice  int64  `json:"LowestPrice"`
+	HighestPrice int64  `json:"HighestPrice"`
+	ListingCount int    `json:"ListingCount"`
+	IsHistorical bool   `json:"IsHistorical,omitempty"`
+	RecentSales  int    `json:"RecentSales,omitempty"`
+}
+
+// SummaryJSON is the /summary.json response: every item currently on
+// sale, optionally narrowed to one category tab with ?type=. The summary
+// is not paginated, so TotalItems always equals len(Items).
+type SummaryJSON struct {
+	Type       string            `json:"Type,omitempty"`
+	TotalItems int               `json:"TotalItems"`
+	Items      []SummaryJSONItem `json:"Items"`
+}
+
+// SummaryAPIResponse is the /api/summary response: the summary page's
+// item list for the same query, type, only_available, include_historical,
+// sort_by and order parameters.
+type SummaryAPIResponse struct {
+	TotalUniqueItems int               `json:"TotalUniqueItems"`
+	LastScrapeTime   string            `json:"LastScrapeTime"`
+	SortBy           string            `json:"SortBy"`
+	Order            string            `json:"Order"`
+	Items            []SummaryJSONItem `json:"Items"`
 }
 
 type ItemListing struct {
@@ -172,6 +243,9 @@ type PlayerCharacter struct {
 	IsActive      bool
 	IsGuildLeader bool
 	IsSpecial     bool
+	// LevelingVelocity is the exp % gained per day over the last
+	// levelingVelocityDays, stored by the character scrape.
+	LevelingVelocity float64
 }
 
 type Guild struct {
@@ -199,44 +273,73 @@ type MvpHeader struct {
 }
 
 type SummaryPageData struct {
-	Items            []ItemSummary
-	SearchQuery      string
-	SortBy           string
-	Order            string
-	ShowAll          bool
-	LastScrapeTime   string
-	ItemTypes        []ItemTypeTab
-	ItemTypesTotal   int
-	SelectedType     string
-	TotalVisitors    int
-	TotalUniqueItems int
-	PageTitle        string
+	Items             []ItemSummary
+	SearchQuery       string
+	SearchMode        string       // auto, id or name (see itemSearchMode)
+	SearchModeParam   template.URL // "&search_mode=..." for links; empty for auto
+	SortBy            string
+	Order             string
+	ShowAll           bool
+	IncludeHistorical bool // fill in last-seen prices for delisted items
+	LastScrapeTime    string
+	ItemTypes         []ItemTypeTab
+	ItemTypesTotal    int
+	SelectedType      string
+	TotalVisitors     int
+	TotalUniqueItems  int
+	PageTitle         string
 }
 
 type PageData struct {
-	Items          []Item
-	SearchQuery    string
-	StoreNameQuery string
-	AllStoreNames  []string
-	SortBy         string
-	Order          string
-	ShowAll        bool
-	LastScrapeTime string
-	VisibleColumns map[string]bool
-	AllColumns     []Column
-	ColumnParams   template.URL
-	ItemTypes      []ItemTypeTab
-	ItemTypesTotal int
-	SelectedType   string
-	PageTitle      string
+	Items           []Item
+	SearchQuery     string
+	SearchMode      string
+	SearchModeParam template.URL
+	StoreNameQuery  string
+	AllStoreNames   []string
+	SortBy          string
+	Order           string
+	ShowAll         bool
+	LastScrapeTime  string
+	VisibleColumns  map[string]bool
+	AllColumns      []Column
+	ColumnParams    template.URL
+	ItemTypes       []ItemTypeTab
+	ItemTypesTotal  int
+	SelectedType    string
+	PageTitle       string
+}
+
+// MarketActivityGroup is one item's event counts in the activity
+// page's grouped (?group=item) mode.
+type MarketActivityGroup struct {
+	ItemName string
+	NamePT   sql.NullString
+	ItemID   int
+	Added    int
+	Sold     int
+	Delisted int
+	Total    int
+}
+
+// ActivityEventTypeOption is one event type checkbox of the activity
+// filter. LabelKey is the i18n key of its label.
+type ActivityEventTypeOption struct {
+	Type     string
+	LabelKey string
+	Selected bool
 }
 
 type ActivityPageData struct {
 	MarketEvents   []MarketEvent
+	ItemGroups     []MarketActivityGroup
 	LastScrapeTime string
 
-	SearchQuery string
-	SoldOnly    bool
+	SearchQuery      string
+	EventTypes       []string // selected event types; empty means all
+	EventTypeOptions []ActivityEventTypeOption
+	GroupByItem      bool
+	SelectedInterval string // only set when GroupByItem
 
 	Pagination httpx.PaginationData
 	PageTitle  string
@@ -257,9 +360,51 @@ type HistoryPageData struct {
 	LastScrapeTime     string
 	Pagination         httpx.PaginationData
 	TotalListings      int
+	CursorPaging       bool   // listings paged by ?cursor= instead of ?page=
+	NextCursor         string // ?cursor= of the next page; "" on the last
 	PageTitle          string
 	Filter             template.URL
 	DropHistory        []PlayerDropInfo
+	DropsTruncated     bool // DropHistory hit MAX_RESULT_ROWS
+	Net                bool // show net proceeds next to current prices
+
+	// Median recent sale price; 0 when there were too few sales.
+	FairPrice      int64
+	FairPriceSales int
+	FairPriceDays  int
+
+	// Chart bucket size: "day", "week", "month", or "" for every scrape.
+	Granularity string
+
+	// Undercut wars found in the price history, newest first.
+	PriceWars []PriceWar
+
+	// Cheapest market listing vs cheapest Discord offer; nil when neither exists.
+	MarketVsDiscord *MarketDiscordComparison
+}
+
+// MarketDiscordComparison puts the cheapest in-game listing next to the
+// cheapest Discord zeny offer. A zero price means that source has none.
+// Cheaper is "market", "discord" or "same" when both are present, and
+// Difference is how much the cheaper side saves.
+type MarketDiscordComparison struct {
+	MarketPrice   int64
+	DiscordPrice  int64
+	DiscordSeller string
+	DiscordPosted string
+	Cheaper       string
+	Difference    int64
+}
+
+// PriceWar is a stretch of price history where competing sellers kept
+// undercutting each other's lowest price by small amounts.
+type PriceWar struct {
+	Start      string
+	End        string
+	StartPrice int64
+	EndPrice   int64
+	Undercuts  int
+	Sellers    []string
 }
 
 type PlayerCountPoint struct {
@@ -304,6 +449,8 @@ type CharacterPageData struct {
 	Pagination   httpx.PaginationData
 	TotalPlayers int
 	TotalZeny    int64
+	// IncludesBank is true when TotalZeny counts bank zeny.
+	IncludesBank bool
 
 	ClassDistributionJSON template.JS
 	GraphFilter           map[string]bool
@@ -311,6 +458,29 @@ type CharacterPageData struct {
 	PageTitle             string
 }
 
+// StoreSummary is one row of the /stores directory. ItemCount is the
+// number of listings currently available.
+type StoreSummary struct {
+	StoreName      string
+	SellerName     string
+	MapName        string
+	MapCoordinates string
+	ItemCount      int
+	LastSeen       string
+}
+
+type StoresPageData struct {
+	Stores         []StoreSummary
+	LastScrapeTime string
+	SearchQuery    string
+	SortBy         string
+	Order          string
+	Pagination     httpx.PaginationData
+	TotalStores    int
+	PageTitle      string
+	Filter         template.URL
+}
+
 type GuildPageData struct {
 	Guilds              []Guild
 	LastGuildUpdateTime string
@@ -326,10 +496,15 @@ type GuildPageData struct {
 type GuildDetailPageData struct {
 	Guild                 Guild
 	Members               []PlayerCharacter
+	MembersTruncated      bool // Members hit MAX_RESULT_ROWS
 	LastScrapeTime        string
 	ClassDistributionJSON template.JS
 	HasChartData          bool
 
+	// Member count over time; the chart needs at least two points.
+	MemberHistoryJSON template.JS
+	HasMemberHistory  bool
+
 	SortBy string
 	Order  string
 
@@ -339,6 +514,53 @@ type GuildDetailPageData struct {
 	Filter              template.URL
 }
 
+// GuildMemberPoint is one point of a guild's member-count history.
+type GuildMemberPoint struct {
+	Timestamp string `json:"Timestamp"`
+	Members   int    `json:"Members"`
+}
+
+// GuildRosterMember is one row of the /guild/roster export.
+type GuildRosterMember struct {
+	Name       string `json:"Name"`
+	BaseLevel  int    `json:"BaseLevel"`
+	JobLevel   int    `json:"JobLevel"`
+	Class      string `json:"Class"`
+	Zeny       int64  `json:"Zeny"`
+	LastActive string `json:"LastActive"`
+	IsLeader   bool   `json:"IsLeader"`
+}
+
+// GuildRoster is the JSON body of the /guild/roster export.
+type GuildRoster struct {
+	Guild     string              `json:"Guild"`
+	Master    string              `json:"Master"`
+	Members   []GuildRosterMember `json:"Members"`
+	Truncated bool                `json:"Truncated,omitempty"`
+}
+
+// CharacterExportRow is one row of the /characters export.
+type CharacterExportRow struct {
+	Rank       int     `json:"Rank"`
+	Name       string  `json:"Name"`
+	BaseLevel  int     `json:"BaseLevel"`
+	JobLevel   int     `json:"JobLevel"`
+	Experience float64 `json:"Experience"`
+	Zeny       int64   `json:"Zeny"`
+	Class      string  `json:"Class"`
+	Guild      string  `json:"Guild"`
+	LastActive string  `json:"LastActive"`
+}
+
+// CharacterExport is the JSON body of /characters?format=json. Total is
+// the number of matching characters, which exceeds len(Characters) when
+// Truncated.
+type CharacterExport struct {
+	Characters []CharacterExportRow `json:"Characters"`
+	Total      int                  `json:"Total"`
+	Truncated  bool                 `json:"Truncated,omitempty"`
+}
+
 type WoeGuildClassRank struct {
 	Class          string
 	MemberCount    int64
@@ -351,6 +573,17 @@ type WoeGuildClassRank struct {
 	KillDeathRatio float64
 }
 
+// WoeGuildClassExport is the /woe/by-class.json body: one event's
+// per-guild, per-class WoE totals, keyed by guild name.
+type WoeGuildClassExport struct {
+	SeasonID   int                            `json:"SeasonID"`
+	EventID    int                            `json:"EventID"`
+	EventDate  string                         `json:"EventDate"`
+	GuildQuery string                         `json:"GuildQuery,omitempty"`
+	Class      string                         `json:"Class,omitempty"`
+	Guilds     map[string][]WoeGuildClassRank `json:"Guilds"`
+}
+
 // WoeGuildRank holds aggregated WoE stats for an entire guild.
 type WoeGuildRank struct {
 	GuildName      sql.NullString
@@ -365,6 +598,42 @@ type WoeGuildRank struct {
 	KillDeathRatio float64
 }
 
+// WoeMatchupSide is one guild's totals for a single WoE event.
+type WoeMatchupSide struct {
+	Members int64
+	Kills   int64
+	Deaths  int64
+	Damage  int64
+	Points  int64
+}
+
+// WoeMatchupEvent pairs two guilds' results for one event. A nil side
+// means that guild did not take part.
+type WoeMatchupEvent struct {
+	EventID   int
+	SeasonID  int
+	EventDate string
+	A         *WoeMatchupSide
+	B         *WoeMatchupSide
+	Leader    string // "a" or "b" when both took part and one scored more points
+}
+
+// WoeMatchupPageData holds all data for the woe_matchup.html template.
+type WoeMatchupPageData struct {
+	PageTitle  string
+	GuildA     string
+	GuildB     string
+	AllGuilds  []string
+	Events     []WoeMatchupEvent
+	TotalA     WoeMatchupSide
+	TotalB     WoeMatchupSide
+	EventsA    int
+	EventsB    int
+	EventsBoth int
+	WinsA      int
+	WinsB      int
+}
+
 // WoeCharacterRank holds per-character WoE ranking data.
 type WoeCharacterRank struct {
 	Name         string
@@ -450,12 +719,38 @@ type StoreDetailPageData struct {
 	Items          []Item
 	LastScrapeTime string
 
+	// Other carts the same seller has open right now, excluding this one.
+	RelatedStores []StoreSummary
+
 	SortBy    string
 	Order     string
 	PageTitle string
 	Filter    template.URL
 }
 
+// StoreInventoryItem is one deduped listing in the store JSON response.
+// IsAvailable is false for items that were in the cart on an earlier
+// scrape but have since faded (sold or removed).
+type StoreInventoryItem struct {
+	ItemID      int    `json:"ItemID"`
+	Name        string `json:"Name"`
+	NamePT      string `json:"NamePT,omitempty"`
+	Quantity    int    `json:"Quantity"`
+	Price       int64  `json:"Price"`
+	LastSeen    string `json:"LastSeen"`
+	IsAvailable bool   `json:"IsAvailable"`
+}
+
+// StoreInventory is the ?format=json response of the store detail page.
+type StoreInventory struct {
+	StoreName      string               `json:"StoreName"`
+	SellerName     string               `json:"SellerName"`
+	MapName        string               `json:"MapName"`
+	MapCoordinates string               `json:"MapCoordinates"`
+	LastSeen       string               `json:"LastSeen"`
+	Items          []StoreInventoryItem `json:"Items"`
+}
+
 type MvpKillPageData struct {
 	Players        []MvpKillEntry
 	Headers        []MvpHeader
@@ -463,6 +758,8 @@ type MvpKillPageData struct {
 	Order          string
 	LastScrapeTime string
 	PageTitle      string
+	NonzeroOnly    bool
+	Filter         template.URL
 }
 
 type CharacterDetailPageData struct {
@@ -481,6 +778,28 @@ type CharacterDetailPageData struct {
 	ChangelogSearchQuery string
 }
 
+// CharacterProfileInfo is PlayerCharacter with GuildName as a plain
+// string ("" for no guild); the outer field shadows the embedded one.
+type CharacterProfileInfo struct {
+	PlayerCharacter
+	GuildName string `json:"GuildName"`
+}
+
+// CharacterProfile is the /character?name=X&format=json response: the
+// whole character page in one object. Changelog is the page of entries
+// selected by ?page= and ?changelog_query=, as on the HTML page.
+type CharacterProfile struct {
+	Character           CharacterProfileInfo `json:"Character"`
+	Guild               *Guild               `json:"Guild"`
+	MvpKills            CharacterMvpKills    `json:"MvpKills"`
+	GuildHistory        []CharacterChangelog `json:"GuildHistory"`
+	DropHistory         []CharacterChangelog `json:"DropHistory"`
+	Changelog           []CharacterChangelog `json:"Changelog"`
+	ChangelogTotal      int                  `json:"ChangelogTotal"`
+	ChangelogPagination httpx.PaginationData `json:"ChangelogPagination"`
+	LastScrapeTime      string               `json:"LastScrapeTime"`
+}
+
 type CharacterChangelog struct {
 	ID                  int
 	CharacterName       string
@@ -523,6 +842,17 @@ type RMSCacheSearchResult struct {
 	NamePT sql.NullString
 }
 
+// TradeWatch is a saved trading-post query that notifies a webhook when
+// a new post matches it.
+type TradeWatch struct {
+	ID          int64
+	ItemID      sql.NullInt64
+	NamePattern string
+	PostType    string // "buying", "selling", or "" for both
+	WebhookURL  string
+	CreatedAt   string
+}
+
 type AdminDashboardData struct {
 	Message               string
 	AllGuilds             []GuildInfo
@@ -564,6 +894,13 @@ type AdminDashboardData struct {
 	TradingPostNextPage    int
 	TradingPostTotal       int
 
+	TradeWatches []TradeWatch
+
+	FeatureFlags []FeatureFlag
+
+	ScrapeDriftCounts map[string]int
+	ScrapeDriftAlerts []ScrapeDriftAlert
+
 	TradeParseResult     *GeminiTradeResult
 	OriginalTradeMessage string
 	TradeParseError      string
@@ -619,16 +956,68 @@ type TradingPost struct {
 	Items         []TradingPostItem
 }
 
-type TradingPostPageData struct {
-	Items          []FlatTradingPostItem
+// ItemOffersPageData backs /item/all: live market listings and trading
+// post entries for one item.
+type ItemOffersPageData struct {
+	ItemName       string
+	NamePT         string
+	ItemID         int64
+	Listings       []Item
+	Trades         []FlatTradingPostItem
 	LastScrapeTime string
-	FilterType     string
-	SearchQuery    string
-	FilterCurrency string
-	SortBy         string
-	Order          string
 	PageTitle      string
-	Filter         template.URL
+}
+
+// ItemLocation is one vending spot in the /item/locations response.
+type ItemLocation struct {
+	MapName        string `json:"MapName"`
+	MapCoordinates string `json:"MapCoordinates"`
+	Listings       int    `json:"Listings"`
+	CheapestPrice  int64  `json:"CheapestPrice"`
+}
+
+// ItemLocations is the /item/locations JSON response. Locations is empty,
+// not null, when the item isn't on sale anywhere.
+type ItemLocations struct {
+	ItemName  string         `json:"ItemName"`
+	ItemID    int64          `json:"ItemID,omitempty"`
+	Locations []ItemLocation `json:"Locations"`
+}
+
+// CardOffer is the cheapest offer of one base item carrying a card, as
+// returned by /search/by-card. Source is "market" or "discord"; PostID is
+// only set for Discord offers, the map fields only for market ones.
+type CardOffer struct {
+	ItemID         int64  `json:"ItemID,omitempty"`
+	BaseName       string `json:"BaseName"`
+	Listing        string `json:"Listing"`
+	Source         string `json:"Source"`
+	Price          int64  `json:"Price"`
+	Seller         string `json:"Seller"`
+	StoreName      string `json:"StoreName,omitempty"`
+	MapName        string `json:"MapName,omitempty"`
+	MapCoordinates string `json:"MapCoordinates,omitempty"`
+	PostID         int    `json:"PostID,omitempty"`
+}
+
+// CardSearchResult is the /search/by-card JSON response, cheapest first.
+type CardSearchResult struct {
+	Card   string      `json:"Card"`
+	Offers []CardOffer `json:"Offers"`
+}
+
+type TradingPostPageData struct {
+	Items           []FlatTradingPostItem
+	LastScrapeTime  string
+	FilterType      string
+	SearchQuery     string
+	SearchMode      string
+	SearchModeParam template.URL
+	FilterCurrency  string
+	SortBy          string
+	Order           string
+	PageTitle       string
+	Filter          template.URL
 }
 
 // timeAgo formats an RFC3339 timestamp as a human-readable relative time string.
@@ -689,7 +1078,12 @@ type ChatPageData struct {
 	ActiveChannel     string       `json:"-"`
 	QueryFilter       template.URL `json:"-"`
 	SearchQuery       string       `json:"-"`
+	SearchMode        string       `json:"-"` // "", "phrase" or "regex"
+	SearchError       string       `json:"-"` // why a regex search was rejected
+	SearchTruncated   bool         `json:"-"` // regex scan hit its row or time limit
 	ActivityGraphJSON template.JS  `json:"-"`
+	ActivityHours     int          `json:"-"` // window the activity graph covers
+	SnifferDisabled   bool         `json:"-"`
 }
 
 type DropStatPlayer struct {
@@ -711,6 +1105,7 @@ type DropStatsPageData struct {
 	ItemOrder       string
 	PlayerSortBy    string
 	PlayerOrder     string
+	PlayerInterval  string // window of the player rankings: 24h, 7d, 30d or all
 }
 
 // XPCalculatorPageData holds all data for the xp_calculator.html template
@@ -740,6 +1135,32 @@ type PageViewSummary struct {
 	Hits int
 }
 
+// ReferrerCount is one row of the referrers report.
+type ReferrerCount struct {
+	Referrer string `json:"Referrer"`
+	Views    int    `json:"Views"`
+	Visitors int    `json:"Visitors"`
+}
+
+// UAFamilyCount is how many views one User-Agent family made.
+type UAFamilyCount struct {
+	Family string `json:"Family"`
+	Views  int    `json:"Views"`
+}
+
+// AdminReferrersPageData holds the /admin/views/referrers report; it is
+// also the ?format=json body.
+type AdminReferrersPageData struct {
+	Days          int             `json:"Days"`
+	HumanViews    int             `json:"HumanViews"`
+	BotViews      int             `json:"BotViews"`
+	HumanVisitors int             `json:"HumanVisitors"`
+	BotVisitors   int             `json:"BotVisitors"`
+	DirectViews   int             `json:"DirectViews"`
+	TopReferrers  []ReferrerCount `json:"TopReferrers"`
+	UAFamilies    []UAFamilyCount `json:"UAFamilies"`
+}
+
 // GlobalSearchCharacterResult holds a single character result.
 type GlobalSearchCharacterResult struct {
 	Name      string
@@ -782,6 +1203,8 @@ type GlobalSearchPageData struct {
 	TradeResults     []GlobalSearchTradeResult
 	MarketResults    []ItemSummary
 	HasResults       bool
+	QueryTooShort    bool
+	MinQueryLength   int
 }
 
 type DropStatItem struct {
@@ -792,6 +1215,24 @@ type DropStatItem struct {
 	LastSeen string // Formatted as "YYYY-MM-DD HH:MM"
 }
 
+// CharacterDrop is one entry of the /character/drops JSON array.
+type CharacterDrop struct {
+	ItemName  string `json:"ItemName"`
+	NamePT    string `json:"NamePT,omitempty"`
+	ItemID    *int64 `json:"ItemID"`
+	Timestamp string `json:"Timestamp"`
+}
+
+// CharacterMvpKills is the /character/mvp JSON response. Kills holds
+// only MVPs with at least one kill after their display offset; Names maps
+// those mob IDs to display names.
+type CharacterMvpKills struct {
+	CharacterName string            `json:"CharacterName"`
+	TotalKills    int               `json:"TotalKills"`
+	Kills         map[string]int    `json:"Kills"`
+	Names         map[string]string `json:"Names"`
+}
+
 type PlayerDropInfo struct {
 	PlayerName string
 	Timestamp  string // Formatted as "YYYY-MM-DD HH:MM"
@@ -820,11 +1261,23 @@ type MarketSalesPoint struct {
 	Zeny  int64  `json:"Zeny"`
 }
 
+// SellerVolume is the /seller/volume JSON response.
+type SellerVolume struct {
+	SellerName string             `json:"SellerName"`
+	Interval   string             `json:"Interval"`
+	Net        bool               `json:"Net"`
+	TotalCount int64              `json:"TotalCount"`
+	TotalZeny  int64              `json:"TotalZeny"`
+	Days       []MarketSalesPoint `json:"Days"`
+}
+
 // MarketStatsPageData holds all data for the market_stats.html template.
 type MarketStatsPageData struct {
 	PageTitle           string
 	LastScrapeTime      string
-	SelectedInterval    string
+	SelectedInterval    string // "custom" when RangeStart and RangeEnd are set
+	RangeStart          string // ?start= as given, when a valid range was requested
+	RangeEnd            string
 	TotalSoldItems      int64
 	TotalZenyTransacted int64
 	SalesOverTimeJSON   template.JS
@@ -835,6 +1288,13 @@ type MarketStatsPageData struct {
 	SellerSortBy        string // e.g., "name", "count", "zeny"
 	SellerOrder         string // "ASC" or "DESC"
 	Filter              template.URL
+	Net                 bool    // zeny sums are net of the vend fee
+	VendFeePercent      float64 // configured vend fee, for display
+	TotalZenyNet        int64
+	OutliersRejected    int     // SOLD events dropped as price outliers
+	OutlierFactor       float64 // configured STATS_OUTLIER_FACTOR, 0 when off
+	MaxPrice            int64   // sales priced at or above it are left out; 0 when uncapped
+	CustomMaxPrice      bool    // MaxPrice came from ?max_price=
 }
 
 // LevelDistPoint holds data for a single bar in the level distribution chart.
@@ -857,3 +1317,138 @@ type CharacterStatsPageData struct {
 	TopExperienceCharacters []PlayerCharacter
 	GraphFilter             map[string]bool
 }
+
+// WealthCharacterEntry is one row of the richest-characters ranking.
+type WealthCharacterEntry struct {
+	Rank      int    `json:"Rank"`
+	Name      string `json:"Name"`
+	Class     string `json:"Class"`
+	GuildName string `json:"GuildName,omitempty"`
+	Zeny      int64  `json:"Zeny"`
+}
+
+// WealthGuildEntry is one row of the richest-guilds ranking; TotalZeny is
+// the sum of its members' zeny.
+type WealthGuildEntry struct {
+	Rank        int    `json:"Rank"`
+	Name        string `json:"Name"`
+	Master      string `json:"Master"`
+	MemberCount int    `json:"MemberCount"`
+	TotalZeny   int64  `json:"TotalZeny"`
+}
+
+// ClassChangeEvent is one parsed "Changed class from X to Y" changelog row.
+type ClassChangeEvent struct {
+	CharacterName string `json:"CharacterName"`
+	FromClass     string `json:"FromClass"`
+	ToClass       string `json:"ToClass"`
+	ChangeTime    string `json:"ChangeTime"`
+}
+
+// ClassTransitionCount is how many characters made one class transition.
+type ClassTransitionCount struct {
+	FromClass string `json:"FromClass"`
+	ToClass   string `json:"ToClass"`
+	Count     int    `json:"Count"`
+}
+
+// RebirthStatsPageData holds all data for rebirth_stats.html and doubles
+// as the ?format=json response body.
+type RebirthStatsPageData struct {
+	PageTitle               string                 `json:"-"`
+	LastCharacterScrapeTime string                 `json:"LastCharacterScrapeTime"`
+	TotalChanges            int                    `json:"TotalChanges"`
+	Events                  []ClassChangeEvent     `json:"Events"`
+	Transitions             []ClassTransitionCount `json:"Transitions"`
+	Pagination              httpx.PaginationData   `json:"-"`
+}
+
+// GuildChangeEvent is one parsed guild join, leave or move changelog row.
+// Kind is the changelog event kind; FromGuild is empty for a join and
+// ToGuild is empty for a leave.
+type GuildChangeEvent struct {
+	CharacterName string `json:"CharacterName"`
+	Kind          string `json:"Kind"`
+	FromGuild     string `json:"FromGuild"`
+	ToGuild       string `json:"ToGuild"`
+	ChangeTime    string `json:"ChangeTime"`
+}
+
+// GuildChurnPageData holds all data for guild_churn.html and doubles as
+// the ?format=json response body.
+type GuildChurnPageData struct {
+	PageTitle           string               `json:"-"`
+	LastGuildUpdateTime string               `json:"LastGuildUpdateTime"`
+	Hours               int                  `json:"Hours"`
+	TotalChanges        int                  `json:"TotalChanges"`
+	Events              []GuildChangeEvent   `json:"Events"`
+	Pagination          httpx.PaginationData `json:"-"`
+	Filter              template.URL         `json:"-"`
+}
+
+// PriceSpread is one row of /stats/spread: the gap between an item's
+// cheapest and most expensive current listing. SpreadPercent is relative
+// to the cheapest.
+type PriceSpread struct {
+	Name          string  `json:"Name"`
+	NamePT        string  `json:"NamePT,omitempty"`
+	ItemID        int     `json:"ItemID"`
+	LowestPrice   int64   `json:"LowestPrice"`
+	HighestPrice  int64   `json:"HighestPrice"`
+	Spread        int64   `json:"Spread"`
+	SpreadPercent float64 `json:"SpreadPercent"`
+	ListingCount  int     `json:"ListingCount"`
+}
+
+// PriceSpreadPageData holds all data for price_spread.html and doubles
+// as the ?format=json response body.
+type PriceSpreadPageData struct {
+	PageTitle      string               `json:"-"`
+	LastScrapeTime string               `json:"LastScrapeTime"`
+	TotalItems     int                  `json:"TotalItems"`
+	Items          []PriceSpread        `json:"Items"`
+	SortBy         string               `json:"-"`
+	Order          string               `json:"-"`
+	Pagination     httpx.PaginationData `json:"-"`
+	Filter         template.URL         `json:"-"`
+}
+
+// PriceWatch is one item on a visitor's /watch list. LowestPrice is the
+// cheapest current listing (0 when none); Breached is set once the watch
+// has notified, until the price is back above TargetPrice.
+type PriceWatch struct {
+	ID          int64  `json:"ID"`
+	ItemID      int64  `json:"ItemID"`
+	Name        string `json:"Name"`
+	NamePT      string `json:"NamePT,omitempty"`
+	TargetPrice int64  `json:"TargetPrice"`
+	LowestPrice int64  `json:"LowestPrice"`
+	Breached    bool   `json:"Breached"`
+	CreatedAt   string `json:"CreatedAt"`
+}
+
+// PriceWatchPageData holds all data for price_watch.html and doubles as
+// the ?format=json response body. Status is the outcome of the last form
+// post ("added", "removed", "invalid", "unknown_item" or "limit").
+type PriceWatchPageData struct {
+	PageTitle            string       `json:"-"`
+	LastScrapeTime       string       `json:"LastScrapeTime"`
+	Watches              []PriceWatch `json:"Watches"`
+	NotificationsEnabled bool         `json:"NotificationsEnabled"`
+	MaxWatches           int          `json:"MaxWatches"`
+	Status               string       `json:"-"`
+	ItemID               int64        `json:"-"`
+	CSRFToken            string       `json:"-"`
+}
+
+// WealthStatsPageData holds all data for the wealth_stats.html template.
+// It doubles as the ?format=json response body.
+type WealthStatsPageData struct {
+	PageTitle               string                 `json:"-"`
+	LastCharacterScrapeTime string                 `json:"LastCharacterScrapeTime"`
+	Limit                   int                    `json:"Limit"`
+	ExcludeCapped           bool                   `json:"ExcludeCapped"`
+	Characters              []WealthCharacterEntry `json:"Characters"`
+	Guilds                  []WealthGuildEntry     `json:"Guilds"`
+	Filter                  template.URL           `json:"-"`
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/denislee/yufa-mt/internal/httpx"
+)
+
+const (
+	priceDistributionDefaultBuckets = 10
+	priceDistributionMaxBuckets     = 50
+	priceDistributionMaxDays        = 365
+)
+
+// PriceBucket is one histogram bar: sales priced Min..Max inclusive.
+type PriceBucket struct {
+	Min   int64 `json:"Min"`
+	Max   int64 `json:"Max"`
+	Count int   `json:"Count"`
+}
+
+// PriceDistribution is the /item/price-distribution JSON response.
+// Excluded counts sales dropped as outliers (STATS_OUTLIER_FACTOR);
+// sales at or above statsPriceCap are never loaded.
+type PriceDistribution struct {
+	ItemName string        `json:"ItemName"`
+	ItemID   int64         `json:"ItemID,omitempty"`
+	Days     int           `json:"Days"`
+	Sales    int           `json:"Sales"`
+	Excluded int           `json:"Excluded"`
+	Min      int64         `json:"Min"`
+	Max      int64         `json:"Max"`
+	Median   int64         `json:"Median"`
+	Buckets  []PriceBucket `json:"Buckets"`
+}
+
+// buildPriceHistogram splits sorted prices into at most n equal-width
+// buckets spanning their range. Fewer buckets are used when the range
+// holds fewer distinct prices than n.
+func buildPriceHistogram(prices []int64, n int) []PriceBucket {
+	if len(prices) == 0 || n < 1 {
+		return []PriceBucket{}
+	}
+	lo, hi := prices[0], prices[len(prices)-1]
+	if span := hi - lo + 1; int64(n) > span {
+		n = int(span)
+	}
+	width := (hi - lo + int64(n)) / int64(n) // ceil((hi-lo+1)/n)
+
+	buckets := make([]PriceBucket, n)
+	for i := range buckets {
+		buckets[i].Min = lo + int64(i)*width
+		buckets[i].Max = buckets[i].Min + width - 1
+	}
+	buckets[n-1].Max = hi
+	for _, p := range prices {
+		i := int((p - lo) / width)
+		if i >= n {
+			i = n - 1
+		}
+		buckets[i].Count++
+	}
+	return buckets
+}
+
+// itemPriceDistributionHandler serves /item/price-distribution?name=...:
+// a histogram of the item's SOLD prices over the last ?days= (default the
+// fair price window) in ?buckets= bars.
+func itemPriceDistributionHandler(w http.ResponseWriter, r *http.Request) {
+	itemName := strings.TrimSpace(r.URL.Query().Get("name"))
+	if itemName == "" {
+		http.Error(w, "Item name is required", http.StatusBadRequest)
+		return
+	}
+	buckets, _ := strconv.Atoi(r.URL.Query().Get("buckets"))
+	if buckets < 1 {
+		buckets = priceDistributionDefaultBuckets
+	}
+	buckets = min(buckets, priceDistributionMaxBuckets)
+	days, _ := strconv.Atoi(r.URL.Query().Get("days"))
+	if days < 1 {
+		days = max(fairPriceWindowDays(), 1)
+	}
+	days = min(days, priceDistributionMaxDays)
+
+	itemID := resolveItemID(itemName)
+	match, param := "item_name = ?", interface{}(itemName)
+	if itemID > 0 {
+		match, param = "item_id = ?", itemID
+	}
+
+	// Load one outlier window before the start too, so the first sales in
+	// range have a rolling median to be judged against.
+	start := time.Now().AddDate(0, 0, -days)
+	outlierFactor := statsOutlierFactor()
+	loadFrom := start
+	if outlierFactor > 0 {
+		loadFrom = start.Add(-statsOutlierWindow)
+	}
+	sales, err := fetchItemSales(match, param, loadFrom)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/PriceDist] %v", err)
+		http.Error(w, "Could not query item sales", http.StatusInternalServerError)
+		return
+	}
+
+	excluded := make(map[int64]bool)
+	if outlierFactor > 0 {
+		for _, id := range findPriceOutliers(sales, start, statsOutlierWindow, outlierFactor) {
+			excluded[id] = true
+		}
+	}
+	prices := make([]int64, 0, len(sales))
+	for _, s := range sales {
+		if s.At.Before(start) || excluded[s.ID] {
+			continue
+		}
+		prices = append(prices, s.Price)
+	}
+	sort.Slice(prices, func(a, b int) bool { return prices[a] < prices[b] })
+
+	resp := PriceDistribution{
+		ItemName: itemName,
+		ItemID:   itemID,
+		Days:     days,
+		Sales:    len(prices),
+		Excluded: len(excluded),
+		Buckets:  buildPriceHistogram(prices, buckets),
+	}
+	if len(prices) > 0 {
+		resp.Min, resp.Max, resp.Median = prices[0], prices[len(prices)-1], medianPrice(prices)
+	}
+
+	if err := httpx.WriteJSON(w, http.StatusOK, resp); err != nil {
+		log.Printf("[W] [HTTP/PriceDist] Failed to write distribution JSON for '%s': %v", itemName, err)
+	}
+}
+
+// fetchItemSales loads an item's SOLD events since from, oldest first,
+// below statsPriceCap. match is a fixed column condition with one
+// placeholder bound to param. Item is left empty so findPriceOutliers
+// treats every name the item was sold under as one series.
+func fetchItemSales(match string, param interface{}, from time.Time) ([]soldPrice, error) {
+	rows, err := srv.db.Query(`
+		SELECT id, event_timestamp, price FROM (
+			SELECT id, event_timestamp,
+				`+marketPriceSQL("json_extract(details, '$.price')")+` AS price
+			FROM market_events
+			WHERE event_type = 'SOLD' AND `+match+` AND event_timestamp >= ?
+		)
+		WHERE price > 0 AND price < ?
+		ORDER BY event_timestamp`, param, from.Format(time.RFC3339), statsPriceCap)
+	if err != nil {
+		return nil, fmt.Errorf("could not query sales: %w", err)
+	}
+	defer rows.Close()
+
+	var sales []soldPrice
+	for rows.Next() {
+		var s soldPrice
+		var ts string
+		if err := rows.Scan(&s.ID, &ts, &s.Price); err != nil {
+			return nil, fmt.Errorf("could not scan sale: %w", err)
+		}
+		if s.At, err = time.Parse(time.RFC3339, ts); err != nil {
+			continue
+		}
+		sales = append(sales, s)
+	}
+	return sales, rows.Err()
+}
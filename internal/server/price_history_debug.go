@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/denislee/yufa-mt/internal/httpx"
+)
+
+// priceHistoryPreviewTolerances are always previewed next to the
+// configured and the requested tolerance, to show the trend.
+var priceHistoryPreviewTolerances = []float64{0, 1, 2, 5, 10}
+
+// PriceHistoryTolerancePoints is how many chart points one tolerance
+// leaves for the previewed item.
+type PriceHistoryTolerancePoints struct {
+	TolerancePercent float64 `json:"TolerancePercent"`
+	Points           int     `json:"Points"`
+}
+
+// PriceHistoryPreview is the /admin/debug/price-history response.
+type PriceHistoryPreview struct {
+	ItemName            string                        `json:"ItemName"`
+	Scrapes             int                           `json:"Scrapes"`
+	ConfiguredTolerance float64                       `json:"ConfiguredTolerance"`
+	Tolerances          []PriceHistoryTolerancePoints `json:"Tolerances"`
+}
+
+// adminDebugPriceHistoryHandler shows how many history chart points the
+// item ?name= would get under the configured tolerance, an optional
+// ?tolerance= percentage and a fixed ladder of tolerances. Nothing is
+// changed; set PRICE_HISTORY_TOLERANCE_PERCENT to apply one.
+func adminDebugPriceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	tolerances := append(slices.Clone(priceHistoryPreviewTolerances), priceHistoryTolerance())
+	if v := r.FormValue("tolerance"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 || t >= 100 {
+			http.Error(w, "tolerance must be a percentage in [0, 100)", http.StatusBadRequest)
+			return
+		}
+		tolerances = append(tolerances, t)
+	}
+	slices.Sort(tolerances)
+	tolerances = slices.Compact(tolerances)
+
+	points, err := fetchPriceHistoryPoints(itemNameAliases(name))
+	if err != nil {
+		logRequestf(r, "[E] [Admin/PriceHistory] Could not load history for '%s': %v", name, err)
+		http.Error(w, "Could not load price history", http.StatusInternalServerError)
+		return
+	}
+
+	preview := PriceHistoryPreview{
+		ItemName:            name,
+		Scrapes:             len(points),
+		ConfiguredTolerance: priceHistoryTolerance(),
+		Tolerances:          make([]PriceHistoryTolerancePoints, 0, len(tolerances)),
+	}
+	for _, t := range tolerances {
+		preview.Tolerances = append(preview.Tolerances, PriceHistoryTolerancePoints{
+			TolerancePercent: t,
+			Points:           len(dedupPriceHistory(points, t)),
+		})
+	}
+
+	if err := httpx.WriteJSON(w, http.StatusOK, preview); err != nil {
+		logRequestf(r, "[W] [Admin/PriceHistory] Could not encode preview: %v", err)
+	}
+}
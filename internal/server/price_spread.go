@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+
+	"github.com/denislee/yufa-mt/internal/httpx"
+)
+
+// priceSpreadSQL lists items with at least two current listings and the
+// gap between their cheapest and most expensive one. Items whose cheapest
+// listing is free or unparseable are left out, since the relative spread
+// is undefined for them.
+var priceSpreadSQL = `
+	FROM (
+		SELECT
+			i.name_of_the_item,
+			MAX(i.item_id) as item_id, ` + currentPriceRangeColumns + `
+		FROM items i
+		WHERE i.is_available = 1
+		GROUP BY i.name_of_the_item
+	) AS t
+	LEFT JOIN internal_item_db local_db ON t.item_id = local_db.item_id
+	WHERE t.listing_count > 1 AND t.lowest_price > 0`
+
+// spreadPercent is how much more the highest price asks than the lowest,
+// as a percentage of the lowest, rounded to two decimals.
+func spreadPercent(lowest, highest int64) float64 {
+	if lowest <= 0 {
+		return 0
+	}
+	return math.Round(float64(highest-lowest)/float64(lowest)*10000) / 100
+}
+
+// priceSpreadHandler serves /stats/spread: items ranked by how far apart
+// their cheapest and most expensive current listings are, which points
+// at mispriced listings worth buying and reselling.
+func priceSpreadHandler(w http.ResponseWriter, r *http.Request) {
+	const itemsPerPage = 50
+
+	total, err := queryCount("SELECT COUNT(*) " + priceSpreadSQL)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/Spread] Could not count items: %v", err)
+		http.Error(w, "Could not count items", http.StatusInternalServerError)
+		return
+	}
+	pagination := httpx.NewPaginationData(r, total, itemsPerPage)
+
+	allowedSorts := map[string]string{
+		"spread_pct":    "spread_ratio",
+		"spread":        "spread",
+		"lowest_price":  "t.lowest_price",
+		"highest_price": "t.highest_price",
+		"listings":      "t.listing_count",
+		"name":          "t.name_of_the_item",
+	}
+	orderByClause, sortBy, order := httpx.GetSortClause(r, allowedSorts, "spread_pct", "DESC")
+
+	rows, err := srv.db.Query(fmt.Sprintf(`
+		SELECT t.name_of_the_item, COALESCE(local_db.name_pt, ''), t.item_id,
+			t.lowest_price, t.highest_price, t.listing_count,
+			t.highest_price - t.lowest_price AS spread,
+			(t.highest_price - t.lowest_price) * 1.0 / t.lowest_price AS spread_ratio
+		%s
+		%s, t.name_of_the_item ASC
+		LIMIT ? OFFSET ?`, priceSpreadSQL, orderByClause), pagination.ItemsPerPage, pagination.Offset)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/Spread] Could not query price spreads: %v", err)
+		http.Error(w, "Could not query price spreads", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := []PriceSpread{}
+	for rows.Next() {
+		var item PriceSpread
+		var ratio float64
+		if err := rows.Scan(&item.Name, &item.NamePT, &item.ItemID, &item.LowestPrice, &item.HighestPrice, &item.ListingCount, &item.Spread, &ratio); err != nil {
+			log.Printf("[W] [HTTP/Spread] Failed to scan price spread row: %v", err)
+			continue
+		}
+		item.SpreadPercent = spreadPercent(item.LowestPrice, item.HighestPrice)
+		items = append(items, item)
+	}
+
+	data := PriceSpreadPageData{
+		PageTitle:      "Price Spread",
+		LastScrapeTime: GetLastScrapeTime(),
+		TotalItems:     total,
+		Items:          items,
+		SortBy:         sortBy,
+		Order:          order,
+		Pagination:     pagination,
+		Filter:         template.URL("&sort_by=" + url.QueryEscape(sortBy) + "&order=" + order),
+	}
+
+	if httpx.WantsJSON(r) {
+		if err := httpx.WriteJSON(w, http.StatusOK, data); err != nil {
+			logRequestf(r, "[W] [HTTP/Spread] Could not encode price spread JSON: %v", err)
+		}
+		return
+	}
+	renderSortedTemplate(w, r, "price_spread.html", data, data.SortBy)
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/denislee/yufa-mt/internal/httpx"
+	"github.com/denislee/yufa-mt/internal/visitor"
+)
+
+// Visitors keep a list of items with a target price on /watch, keyed by
+// their visitor hash. After each market scrape checkPriceWatches compares
+// every watched item's cheapest current listing with the targets and
+// POSTs each new breach to PRICE_WATCH_WEBHOOK_URL. A watch fires once
+// per breach: it is marked breached when it notifies and re-armed when
+// the price is back above target or the item has no listings left.
+
+// maxWatchesPerVisitor caps a visitor's list so one client can't grow
+// the table without bound.
+const maxWatchesPerVisitor = 50
+
+// priceWatchCheckBudget bounds how long one check may spend posting
+// webhooks. Breaches it doesn't get to stay armed for the next scrape.
+const priceWatchCheckBudget = 2 * time.Minute
+
+// priceWatchCSRFCookie holds the token the /watch forms must echo back,
+// so another site can't add or remove watches on a visitor's behalf.
+const priceWatchCSRFCookie = "watch_csrf"
+
+// priceWatchRunning is set while a background check is in progress.
+var priceWatchRunning atomic.Bool
+
+// watchedLowestPricesSQL returns the cheapest priced, available listing
+// of every item in watchlist rows matching its condition. SQLite fills
+// the bare columns from the row that holds the MIN.
+func watchedLowestPricesSQL(condition string) string {
+	return `
+		SELECT item_id, name_of_the_item, store_name, seller_name, MIN(` + marketPriceSQL("price") + `) AS price
+		FROM items
+		WHERE is_available = 1 AND ` + marketPriceSQL("price") + ` > 0
+		  AND item_id IN (SELECT item_id FROM watchlist WHERE ` + condition + `)
+		GROUP BY item_id`
+}
+
+// PriceWatchPayload is the JSON body POSTed for a breached watch. Content
+// makes it directly usable as a Discord webhook; the other fields are for
+// custom consumers.
+type PriceWatchPayload struct {
+	Content     string `json:"content"`
+	WatchID     int64  `json:"watch_id"`
+	VisitorHash string `json:"visitor_hash"`
+	ItemID      int64  `json:"item_id"`
+	ItemName    string `json:"item_name"`
+	Price       int64  `json:"price"`
+	TargetPrice int64  `json:"target_price"`
+	StoreName   string `json:"store_name"`
+	SellerName  string `json:"seller_name"`
+}
+
+func priceWatchWebhookURL() string {
+	if appConfig == nil {
+		return ""
+	}
+	return appConfig.PriceWatchWebhookURL
+}
+
+// startPriceWatchCheck runs checkPriceWatches in the background, so slow
+// webhooks don't hold up the scraper. A check still running from the
+// previous scrape is left to finish rather than joined by a second one.
+func startPriceWatchCheck() {
+	if !priceWatchRunning.CompareAndSwap(false, true) {
+		log.Println("[W] [Scraper/PriceWatch] Previous price watch check is still running, skipping this one.")
+		return
+	}
+	go func() {
+		defer priceWatchRunning.Store(false)
+		checkPriceWatches()
+	}()
+}
+
+// checkPriceWatches notifies the watches whose item is now listed at or
+// below target and re-arms the ones whose breach is over. A failed
+// webhook leaves the watch armed, so the next scrape tries again.
+func checkPriceWatches() {
+	webhookURL := priceWatchWebhookURL()
+	if webhookURL == "" {
+		return
+	}
+
+	rows, err := srv.db.Query(`
+		SELECT w.id, w.visitor_hash, w.item_id, w.target_price, w.breached,
+			COALESCE(best.name_of_the_item, ''), COALESCE(best.price, 0),
+			COALESCE(best.store_name, ''), COALESCE(best.seller_name, '')
+		FROM watchlist w
+		LEFT JOIN (` + watchedLowestPricesSQL("1") + `) best ON best.item_id = w.item_id`)
+	if err != nil {
+		log.Printf("[E] [Scraper/PriceWatch] Failed to check price watches: %v", err)
+		return
+	}
+	type watchState struct {
+		payload  PriceWatchPayload
+		breached bool
+	}
+	var watches []watchState
+	for rows.Next() {
+		var ws watchState
+		p := &ws.payload
+		if err := rows.Scan(&p.WatchID, &p.VisitorHash, &p.ItemID, &p.TargetPrice, &ws.breached, &p.ItemName, &p.Price, &p.StoreName, &p.SellerName); err != nil {
+			log.Printf("[W] [Scraper/PriceWatch] Failed to scan price watch row: %v", err)
+			continue
+		}
+		watches = append(watches, ws)
+	}
+	rows.Close()
+
+	deadline := time.Now().Add(priceWatchCheckBudget)
+	var notified, rearmed, deferred int
+	for _, ws := range watches {
+		p := ws.payload
+		below := p.Price > 0 && p.Price <= p.TargetPrice
+		switch {
+		case below && !ws.breached:
+			if time.Now().After(deadline) {
+				deferred++
+				continue
+			}
+			p.Content = fmt.Sprintf("%s is listed for %sz (target %sz) at %s by %s",
+				p.ItemName, formatZeny(p.Price), formatZeny(p.TargetPrice), p.StoreName, p.SellerName)
+			if err := postWebhook(webhookURL, p); err != nil {
+				log.Printf("[W] [Scraper/PriceWatch] Webhook for watch %d failed: %v", p.WatchID, err)
+				continue
+			}
+			setPriceWatchBreached(p.WatchID, true)
+			notified++
+		case !below && ws.breached:
+			setPriceWatchBreached(p.WatchID, false)
+			rearmed++
+		}
+	}
+	if notified > 0 || rearmed > 0 {
+		log.Printf("[I] [Scraper/PriceWatch] Notified %d price watches, re-armed %d.", notified, rearmed)
+	}
+	if deferred > 0 {
+		log.Printf("[W] [Scraper/PriceWatch] Ran out of time; %d breached watches are left for the next scrape.", deferred)
+	}
+}
+
+func setPriceWatchBreached(id int64, breached bool) {
+	if _, err := srv.db.Exec("UPDATE watchlist SET breached = ? WHERE id = ?", breached, id); err != nil {
+		log.Printf("[W] [Scraper/PriceWatch] Failed to update watch %d: %v", id, err)
+	}
+}
+
+// fetchPriceWatches lists a visitor's watches, newest first, with each
+// item's name and current lowest price.
+func fetchPriceWatches(visitorHash string) ([]PriceWatch, error) {
+	rows, err := srv.db.Query(`
+		SELECT w.id, w.item_id, COALESCE(db.name, ''), COALESCE(db.name_pt, ''),
+			w.target_price, COALESCE(best.price, 0), w.breached, w.created_at
+		FROM watchlist w
+		LEFT JOIN internal_item_db db ON db.item_id = w.item_id
+		LEFT JOIN (`+watchedLowestPricesSQL("visitor_hash = ?")+`) best ON best.item_id = w.item_id
+		WHERE w.visitor_hash = ?
+		ORDER BY w.id DESC`, visitorHash, visitorHash)
+	if err != nil {
+		return nil, fmt.Errorf("could not query price watches: %w", err)
+	}
+	defer rows.Close()
+
+	watches := []PriceWatch{}
+	for rows.Next() {
+		var pw PriceWatch
+		if err := rows.Scan(&pw.ID, &pw.ItemID, &pw.Name, &pw.NamePT, &pw.TargetPrice, &pw.LowestPrice, &pw.Breached, &pw.CreatedAt); err != nil {
+			log.Printf("[W] [HTTP/PriceWatch] Failed to scan price watch row: %v", err)
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, pw.CreatedAt); err == nil {
+			pw.CreatedAt = displayTime(t).Format("2006-01-02 15:04")
+		}
+		watches = append(watches, pw)
+	}
+	return watches, rows.Err()
+}
+
+// updatePriceWatches applies a /watch form post for visitorHash and
+// returns the status shown on the page. action=delete removes watch id;
+// otherwise item_id is watched at target_price, which accepts the
+// market's formats ("1,500,000", "1.5kk"). Watching an item again
+// replaces its target and re-arms it.
+func updatePriceWatches(r *http.Request, visitorHash string) string {
+	if r.FormValue("action") == "delete" {
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			return "invalid"
+		}
+		if _, err := srv.db.Exec("DELETE FROM watchlist WHERE id = ? AND visitor_hash = ?", id, visitorHash); err != nil {
+			logRequestf(r, "[E] [HTTP/PriceWatch] Failed to delete watch %d: %v", id, err)
+			return "invalid"
+		}
+		return "removed"
+	}
+
+	itemID, err := strconv.ParseInt(r.FormValue("item_id"), 10, 64)
+	target := parseMarketPrice(r.FormValue("target_price"))
+	if err != nil || itemID < 1 || target < 1 {
+		return "invalid"
+	}
+	var known bool
+	if err := srv.db.QueryRow("SELECT EXISTS(SELECT 1 FROM internal_item_db WHERE item_id = ?)", itemID).Scan(&known); err != nil || !known {
+		return "unknown_item"
+	}
+
+	res, err := srv.db.Exec(`
+		INSERT INTO watchlist (visitor_hash, item_id, target_price, created_at)
+		SELECT ?, ?, ?, ?
+		WHERE (SELECT COUNT(*) FROM watchlist WHERE visitor_hash = ? AND item_id != ?) < ?
+		ON CONFLICT(visitor_hash, item_id) DO UPDATE SET target_price = excluded.target_price, breached = 0`,
+		visitorHash, itemID, target, time.Now().Format(time.RFC3339), visitorHash, itemID, maxWatchesPerVisitor)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/PriceWatch] Failed to save watch for item %d: %v", itemID, err)
+		return "invalid"
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "limit"
+	}
+	return "added"
+}
+
+// priceWatchCSRFToken returns the visitor's /watch form token, issuing
+// a new cookie when there is none yet.
+func priceWatchCSRFToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(priceWatchCSRFCookie); err == nil && len(c.Value) == 32 {
+		return c.Value
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logRequestf(r, "[E] [HTTP/PriceWatch] Could not generate a form token: %v", err)
+		return ""
+	}
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     priceWatchCSRFCookie,
+		Value:    token,
+		Path:     "/watch",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// validPriceWatchCSRF reports whether a /watch form post carries the
+// token from the visitor's cookie.
+func validPriceWatchCSRF(r *http.Request) bool {
+	c, err := r.Cookie(priceWatchCSRFCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.FormValue("csrf_token"))) == 1
+}
+
+// priceWatchHandler serves /watch. GET lists the visitor's watches (as
+// JSON with ?format=json); POST adds, retargets or removes one and
+// redirects back with the outcome in ?status=. Posts must carry the form
+// token from the page.
+func priceWatchHandler(w http.ResponseWriter, r *http.Request) {
+	visitorHash := visitor.Hash(r)
+
+	if r.Method == http.MethodPost {
+		if !validPriceWatchCSRF(r) {
+			http.Error(w, "Invalid form token", http.StatusForbidden)
+			return
+		}
+		status := updatePriceWatches(r, visitorHash)
+		http.Redirect(w, r, "/watch?status="+url.QueryEscape(status), http.StatusSeeOther)
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "" && !httpx.WantsJSON(r) {
+		http.Error(w, "format must be json", http.StatusBadRequest)
+		return
+	}
+
+	watches, err := fetchPriceWatches(visitorHash)
+	if err != nil {
+		logRequestf(r, "[E] [HTTP/PriceWatch] %v", err)
+		renderError(w, r, http.StatusInternalServerError, "error_watches_load")
+		return
+	}
+	itemID, _ := strconv.ParseInt(r.URL.Query().Get("item_id"), 10, 64)
+
+	data := PriceWatchPageData{
+		PageTitle:            "Price Watch",
+		LastScrapeTime:       GetLastScrapeTime(),
+		Watches:              watches,
+		NotificationsEnabled: priceWatchWebhookURL() != "",
+		MaxWatches:           maxWatchesPerVisitor,
+		Status:               r.URL.Query().Get("status"),
+		ItemID:               max(itemID, 0),
+		CSRFToken:            priceWatchCSRFToken(w, r),
+	}
+
+	if httpx.WantsJSON(r) {
+		if err := httpx.WriteJSON(w, http.StatusOK, data); err != nil {
+			logRequestf(r, "[W] [HTTP/PriceWatch] Could not encode price watch JSON: %v", err)
+		}
+		return
+	}
+	renderTemplate(w, r, "price_watch.html", data)
+}
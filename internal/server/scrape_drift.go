@@ -0,0 +1,189 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The character and guild parsers read regexes against the upstream's
+// Next.js payload. When the page layout changes they tend to keep
+// matching the same number of fields but capture the wrong text, so each
+// parsed page is also sanity-checked here. Suspect pages are logged,
+// counted for the admin dashboard, and saved under scrapeSampleDir().
+
+const (
+	// scrapeDriftMaxSamples caps the saved pages; older ones are deleted.
+	scrapeDriftMaxSamples = 20
+	// scrapeDriftRecent is how many alerts the admin dashboard lists.
+	scrapeDriftRecent = 10
+)
+
+// ScrapeDriftAlert is one suspect page seen by a scraper.
+type ScrapeDriftAlert struct {
+	Time       string
+	Scraper    string
+	Page       int
+	Problem    string
+	SampleFile string
+}
+
+var scrapeDrift struct {
+	sync.Mutex
+	counts map[string]int
+	recent []ScrapeDriftAlert
+}
+
+// scrapeSampleDir is where suspect pages are saved, next to the DB like
+// emblemDir. Returns "" if the config is not yet loaded.
+func scrapeSampleDir() string {
+	if appConfig == nil || appConfig.DBPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(appConfig.DBPath), "scrape_samples")
+}
+
+// hasMarkup reports whether a parsed field contains HTML or escaped JSON,
+// the usual sign that a regex captured past the field it was meant for.
+func hasMarkup(s string) bool {
+	return strings.ContainsAny(s, `<>"\`)
+}
+
+// characterSanityProblem describes what is wrong with a parsed character,
+// or returns "" if it looks valid.
+func characterSanityProblem(p PlayerCharacter) string {
+	switch {
+	case strings.TrimSpace(p.Name) == "" || hasMarkup(p.Name):
+		return fmt.Sprintf("bad name %q", p.Name)
+	case p.Rank < 1:
+		return fmt.Sprintf("%s: rank %d", p.Name, p.Rank)
+	case p.BaseLevel < 1 || p.BaseLevel > 99:
+		return fmt.Sprintf("%s: base level %d outside 1-99", p.Name, p.BaseLevel)
+	case p.JobLevel < 1 || p.JobLevel > 99:
+		return fmt.Sprintf("%s: job level %d outside 1-99", p.Name, p.JobLevel)
+	case p.Experience < 0 || p.Experience > 100:
+		return fmt.Sprintf("%s: experience %.2f%% outside 0-100", p.Name, p.Experience)
+	case strings.TrimSpace(p.Class) == "" || hasMarkup(p.Class):
+		return fmt.Sprintf("%s: bad class %q", p.Name, p.Class)
+	}
+	return ""
+}
+
+// guildSanityProblem is characterSanityProblem for guilds.
+func guildSanityProblem(g Guild) string {
+	switch {
+	case strings.TrimSpace(g.Name) == "" || hasMarkup(g.Name):
+		return fmt.Sprintf("bad name %q", g.Name)
+	case g.Level < 1 || g.Level > 50:
+		return fmt.Sprintf("%s: level %d outside 1-50", g.Name, g.Level)
+	case g.Experience < 0:
+		return fmt.Sprintf("%s: experience %d", g.Name, g.Experience)
+	case strings.TrimSpace(g.Master) == "" || hasMarkup(g.Master):
+		return fmt.Sprintf("%s: bad master %q", g.Name, g.Master)
+	}
+	return ""
+}
+
+// filterSane drops the rows problem rejects and reports what it found. A
+// page where most rows fail is dropped whole: that is parser drift, not
+// a few odd rows, and keeping the rest would still write garbage.
+func filterSane[T any](rows []T, problem func(T) string) (kept []T, problems []string) {
+	kept = make([]T, 0, len(rows))
+	for _, row := range rows {
+		if p := problem(row); p != "" {
+			problems = append(problems, p)
+			continue
+		}
+		kept = append(kept, row)
+	}
+	if len(problems)*2 > len(rows) {
+		return nil, problems
+	}
+	return kept, problems
+}
+
+// reportScrapeDrift records a suspect page: it logs the problem, counts
+// it for the admin dashboard, and saves the raw page for debugging.
+func reportScrapeDrift(scraper string, page int, body, problem string) {
+	now := time.Now()
+	log.Printf("[E] [Scraper/Drift] %s page %d looks malformed, the upstream layout may have changed: %s", scraper, page, problem)
+
+	alert := ScrapeDriftAlert{
+		Time:    now.Format(time.RFC3339),
+		Scraper: scraper,
+		Page:    page,
+		Problem: problem,
+	}
+	if dir := scrapeSampleDir(); dir != "" && body != "" {
+		name := fmt.Sprintf("%s-%s-p%d.html", scraper, now.Format("20060102-150405"), page)
+		if err := saveScrapeSample(dir, name, body); err != nil {
+			log.Printf("[W] [Scraper/Drift] Could not save sample page: %v", err)
+		} else {
+			alert.SampleFile = name
+		}
+	}
+
+	scrapeDrift.Lock()
+	defer scrapeDrift.Unlock()
+	if scrapeDrift.counts == nil {
+		scrapeDrift.counts = make(map[string]int)
+	}
+	scrapeDrift.counts[scraper]++
+	scrapeDrift.recent = append(scrapeDrift.recent, alert)
+	if len(scrapeDrift.recent) > scrapeDriftRecent {
+		scrapeDrift.recent = scrapeDrift.recent[len(scrapeDrift.recent)-scrapeDriftRecent:]
+	}
+}
+
+// saveScrapeSample writes body to dir/name and prunes the oldest samples
+// beyond scrapeDriftMaxSamples.
+func saveScrapeSample(dir, name, body string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	type sample struct {
+		name string
+		mod  time.Time
+	}
+	var samples []sample
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && !e.IsDir() {
+			samples = append(samples, sample{e.Name(), info.ModTime()})
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].mod.After(samples[j].mod) })
+	for _, s := range samples[min(len(samples), scrapeDriftMaxSamples):] {
+		os.Remove(filepath.Join(dir, s.name))
+	}
+	return nil
+}
+
+// scrapeDriftSummary returns the per-scraper alert counts since startup
+// and the most recent alerts, newest first.
+func scrapeDriftSummary() (map[string]int, []ScrapeDriftAlert) {
+	scrapeDrift.Lock()
+	defer scrapeDrift.Unlock()
+
+	counts := make(map[string]int, len(scrapeDrift.counts))
+	for k, v := range scrapeDrift.counts {
+		counts[k] = v
+	}
+	recent := make([]ScrapeDriftAlert, len(scrapeDrift.recent))
+	for i, a := range scrapeDrift.recent {
+		recent[len(recent)-1-i] = a
+	}
+	return counts, recent
+}
@@ -3,9 +3,11 @@ package server
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -15,6 +17,8 @@ import (
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+
+	"github.com/denislee/yufa-mt/internal/storage"
 )
 
 const (
@@ -61,8 +65,8 @@ var (
 	ptNameMutex        sync.Mutex
 	ptNameRegex        = regexp.MustCompile(`<h1 class="item-title-db">([^<]+)</h1>`)
 	slotRemoverRegex   = regexp.MustCompile(`\s*\[\d+\]\s*`)
-	lastChatPacketTime atomic.Int64 // Stores Unix timestamp
-	lastActivityLog    time.Time
+	lastChatPacketTime atomic.Int64 // Unix seconds; use markChatPacket / GetLastChatPacketTime
+	lastActivityLog    time.Time    // Minute last logged; guarded by activityLogMutex, see claimActivityMinute
 	activityLogMutex   sync.Mutex
 
 	// Pre-compiled regexes used by scrapers (avoids re-compilation on every call)
@@ -127,6 +131,16 @@ const (
 	parseRetryDelay = 2 * time.Second
 )
 
+// pagesFailedError reports the pages of a paginated scrape that were
+// given up on after maxParseRetries, or nil if there were none. The pages
+// that did parse are still saved; the error only marks the run failed.
+func pagesFailedError(failed, lastPage int) error {
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d pages failed to scrape", failed, lastPage)
+}
+
 // ScraperClient holds a shared HTTP client and user agent for all scrapers.
 type ScraperClient struct {
 	Client    *http.Client
@@ -288,6 +302,7 @@ const (
 	changelogKindGuildJoin   = "guild_join"
 	changelogKindGuildLeave  = "guild_leave"
 	changelogKindGuildMove   = "guild_move"
+	changelogKindGuildMaster = "guild_master"
 	changelogKindLevelBase   = "level_base"
 	changelogKindLevelJob    = "level_job"
 	changelogKindExpGain     = "exp_gain"
@@ -313,7 +328,7 @@ func logCharacterActivity(changelogStmt *sql.Stmt, charName, kind, description s
 	}
 }
 
-func scrapeAndStorePlayerCount() {
+func scrapeAndStorePlayerCount() error {
 	log.Println("[I] [Scraper/PlayerCount] Checking player and seller count...")
 	const url = "https://projetoyufa.com/en/info"
 
@@ -321,7 +336,7 @@ func scrapeAndStorePlayerCount() {
 	bodyContent, err := scraperClient.getPage(url, "[Counter]")
 	if err != nil {
 		log.Printf("[E] [Scraper/PlayerCount] Failed to fetch player info page: %v", err)
-		return
+		return err
 	}
 
 	if enablePlayerCountDebugLogs {
@@ -332,7 +347,7 @@ func scrapeAndStorePlayerCount() {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(bodyContent))
 	if err != nil {
 		log.Printf("[E] [Scraper/PlayerCount] Failed to parse player info page HTML: %v", err)
-		return
+		return err
 	}
 
 	// --- THIS IS THE UPDATED SECTION ---
@@ -370,7 +385,7 @@ func scrapeAndStorePlayerCount() {
 
 	if !found {
 		log.Println("[W] [Scraper/PlayerCount] Could not find player count on the info page after successful load. The selector `span` with text matching regex 'Online\\s+(\\d+)' may need updating.")
-		return
+		return errors.New("player count not found on the info page")
 	}
 
 	playerCountMutex.Lock()
@@ -391,7 +406,7 @@ func scrapeAndStorePlayerCount() {
 	err = srv.db.QueryRow("SELECT count, seller_count FROM player_history ORDER BY timestamp DESC LIMIT 1").Scan(&lastPlayerCount, &lastSellerCount)
 	if err != nil && err != sql.ErrNoRows {
 		log.Printf("[W] [Scraper/PlayerCount] Could not query for last player/seller count: %v", err)
-		return
+		return err
 	}
 
 	if enablePlayerCountDebugLogs {
@@ -406,7 +421,7 @@ func scrapeAndStorePlayerCount() {
 		if enablePlayerCountDebugLogs {
 			log.Printf("[D] [Scraper/PlayerCount] Player/seller count unchanged (%d players, %d sellers). No update needed.", onlineCount, sellerCount)
 		}
-		return
+		return nil
 	}
 
 	if enablePlayerCountDebugLogs {
@@ -414,14 +429,18 @@ func scrapeAndStorePlayerCount() {
 	}
 
 	retrievalTime := time.Now().Format(time.RFC3339)
-	_, err = srv.db.Exec("INSERT INTO player_history (timestamp, count, seller_count) VALUES (?, ?, ?)", retrievalTime, onlineCount, sellerCount)
+	err = storage.RetryBusy("player count insert", func() error {
+		_, err := srv.db.Exec("INSERT INTO player_history (timestamp, count, seller_count) VALUES (?, ?, ?)", retrievalTime, onlineCount, sellerCount)
+		return err
+	})
 	if err != nil {
 		log.Printf("[E] [Scraper/PlayerCount] Failed to insert new player/seller count: %v", err)
-		return
+		return err
 	}
 	InvalidateUpdateTimeCache("timestamp", "player_history")
 
 	log.Printf("[I] [Scraper/PlayerCount] Player/seller count updated. New values: %d players, %d sellers", onlineCount, sellerCount)
+	return nil
 }
 
 // checkAndLogCharacterActivity contains the logic for detecting and logging player changes.
@@ -440,14 +459,25 @@ func checkAndLogCharacterActivity(changelogStmt *sql.Stmt, p PlayerCharacter, ol
 		lastActiveTime = p.LastUpdated // Active
 	}
 
+	// Any change above the noise floor is logged; only changes of at
+	// least ActivityMinExpDelta count as activity, so small passive gains
+	// (e.g. party share while AFK) don't bump last_active.
 	expDelta := p.Experience - oldPlayer.Experience
+	expActive := math.Abs(expDelta) >= activityMinExpDelta()
 	if !baseLeveledUp {
 		if expDelta > 0.001 {
 			logCharacterActivity(changelogStmt, p.Name, changelogKindExpGain, fmt.Sprintf("Gained %.2f%% experience (now at %.2f%%).", expDelta, p.Experience))
-			lastActiveTime = p.LastUpdated // Active
+			if expActive {
+				lastActiveTime = p.LastUpdated // Active
+			}
 		} else if expDelta < -0.001 {
 			logCharacterActivity(changelogStmt, p.Name, changelogKindExpLoss, fmt.Sprintf("Lost %.2f%% experience (now at %.2f%%).", -expDelta, p.Experience))
-			lastActiveTime = p.LastUpdated // Active
+			if expActive {
+				lastActiveTime = p.LastUpdated // Active
+			}
+		}
+		if !expActive && math.Abs(expDelta) > 0.001 && enableCharacterScraperDebugLogs {
+			log.Printf("[D] [Scraper/Char] Player '%s' exp delta %.4f%% is below the activity threshold; last_active unchanged.", p.Name, expDelta)
 		}
 	} else if expDelta > 0.001 {
 		// Log experience gain even on level up, but only if it's positive
@@ -467,6 +497,24 @@ func checkAndLogCharacterActivity(changelogStmt *sql.Stmt, p PlayerCharacter, ol
 	return lastActiveTime
 }
 
+// activityMinExpDelta is the experience change (in percentage points)
+// that counts as activity. Defaults to the historical 0.001 noise floor.
+func activityMinExpDelta() float64 {
+	if appConfig == nil {
+		return 0.001
+	}
+	return appConfig.ActivityMinExpDelta
+}
+
+// activityMinZenyDelta is the zeny change that counts as activity; 0
+// means any change does.
+func activityMinZenyDelta() int64 {
+	if appConfig == nil {
+		return 0
+	}
+	return int64(appConfig.ActivityMinZenyDelta)
+}
+
 // fetchExistingPlayers queries the DB for all player data needed for comparison.
 func fetchExistingPlayers() (map[string]PlayerCharacter, error) {
 	if enableCharacterScraperDebugLogs {
@@ -534,29 +582,83 @@ func cleanupStalePlayers(scrapedPlayerNames map[string]bool, existingPlayers map
 	log.Printf("[I] [Scraper/Char] Cleanup complete. Removed %d stale player records in total.", len(stalePlayers))
 }
 
-// savePlayerCharacters handles the database transaction to update player data.
-// It accepts a complete slice of players to minimize transaction duration.
-func savePlayerCharacters(players []PlayerCharacter) {
-	characterMutex.Lock()
-	defer characterMutex.Unlock()
+// levelingVelocityDays is the window LevelingVelocity averages over.
+const levelingVelocityDays = 7
 
-	log.Println("[I] [Scraper/Char] DB update started. Processing scraped data...")
+// Level and exp values parsed back out of the changelog descriptions
+// ("Leveled up to Base Level 12!", "Gained 1.23% experience (now at
+// 45.67%)."). Unqualified, so each resolves against the innermost
+// character_changelog in scope.
+const (
+	changelogBaseLevelSQL = `CAST(SUBSTR(activity_description, 26) AS INTEGER)`
+	changelogExpNowSQL    = `CAST(SUBSTR(activity_description, INSTR(activity_description, 'now at ') + 7) AS REAL)`
+)
 
-	// 1. Fetch existing player data for comparison
-	existingPlayers, err := fetchExistingPlayers()
-	if err != nil {
-		log.Printf("[E] [Scraper/Char] %v", err)
-		// Continue with an empty map, logging will just be incomplete
-	}
+// levelingVelocitySQL totals how far each character moved since ? (in exp
+// %, 100 per base level) and divides by the window length in days (the
+// first ?), giving exp % per day. The changelog is read as one level/exp
+// snapshot per scrape: a scrape without a level-up moved by the logged
+// exp delta, and one with a level-up by (level - previous level) * 100 +
+// (exp - previous exp), the previous values coming from the character's
+// last snapshot before it. A character with no earlier snapshot is taken
+// to have come from one level lower at 0%.
+const levelingVelocitySQL = `
+	SELECT character_name, SUM(delta) / ? AS leveling_velocity
+	FROM (
+		SELECT character_name,
+			CASE WHEN level IS NULL THEN amount
+			ELSE (level - COALESCE((
+					SELECT ` + changelogBaseLevelSQL + ` FROM character_changelog p
+					WHERE p.event_kind = 'level_base' AND p.character_name = s.character_name AND p.change_time < s.change_time
+					ORDER BY p.change_time DESC LIMIT 1), level - 1)) * 100
+				+ exp - COALESCE((
+					SELECT CASE event_kind WHEN 'level_base' THEN 0 ELSE ` + changelogExpNowSQL + ` END FROM character_changelog p
+					WHERE p.event_kind IN ('exp_gain', 'exp_loss', 'level_base') AND p.character_name = s.character_name AND p.change_time < s.change_time
+					ORDER BY p.change_time DESC, p.event_kind = 'level_base' LIMIT 1), 0)
+			END AS delta
+		FROM (
+			SELECT character_name, change_time,
+				MAX(CASE WHEN event_kind = 'level_base' THEN ` + changelogBaseLevelSQL + ` END) AS level,
+				COALESCE(MAX(CASE WHEN event_kind != 'level_base' THEN ` + changelogExpNowSQL + ` END), 0) AS exp,
+				SUM(CASE event_kind
+					WHEN 'exp_gain' THEN CAST(SUBSTR(activity_description, 8, INSTR(activity_description, '%') - 8) AS REAL)
+					WHEN 'exp_loss' THEN -CAST(SUBSTR(activity_description, 6, INSTR(activity_description, '%') - 6) AS REAL)
+					ELSE 0
+				END) AS amount
+			FROM character_changelog
+			WHERE event_kind IN ('exp_gain', 'exp_loss', 'level_base') AND change_time >= ?
+			GROUP BY character_name, change_time
+		) s
+	)
+	GROUP BY character_name`
+
+// levelingVelocityArgs are the parameters for levelingVelocitySQL.
+func levelingVelocityArgs() []interface{} {
+	since := time.Now().AddDate(0, 0, -levelingVelocityDays).Format(time.RFC3339)
+	return []interface{}{float64(levelingVelocityDays), since}
+}
 
-	// Generate the timestamp
-	updateTime := time.Now().Format(time.RFC3339)
+// storeLevelingVelocities recomputes characters.leveling_velocity from
+// the changelog, zeroing characters that did not move in the window.
+func storeLevelingVelocities(tx *sql.Tx) error {
+	if _, err := tx.Exec(`UPDATE characters SET leveling_velocity = 0 WHERE leveling_velocity != 0`); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE characters SET leveling_velocity = v.leveling_velocity
+		FROM (`+levelingVelocitySQL+`) v
+		WHERE v.character_name = characters.name`, levelingVelocityArgs()...)
+	return err
+}
 
-	// 2. Prepare database transaction and statements
+// upsertPlayerCharacters writes players and their changelog entries in
+// one transaction stamped updateTime, refreshes every character's
+// leveling velocity, then commits unless the scrape looks partial (see
+// the safety check below). A busy error from any step
+// is returned as is, so the caller can retry the whole transaction.
+func upsertPlayerCharacters(players []PlayerCharacter, existingPlayers map[string]PlayerCharacter, updateTime string) (map[string]bool, int, error) {
 	tx, err := srv.db.Begin()
 	if err != nil {
-		log.Printf("[E] [Scraper/Char] Failed to begin transaction: %v", err)
-		return
+		return nil, 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback() // Rollback on error or if safety check fails
 
@@ -573,8 +675,7 @@ func savePlayerCharacters(players []PlayerCharacter) {
 			last_active=excluded.last_active
 	`)
 	if err != nil {
-		log.Printf("[E] [Scraper/Char] Failed to prepare characters upsert statement: %v", err)
-		return
+		return nil, 0, fmt.Errorf("failed to prepare characters upsert statement: %w", err)
 	}
 	defer stmt.Close()
 
@@ -583,15 +684,13 @@ func savePlayerCharacters(players []PlayerCharacter) {
 		VALUES (?, ?, ?, ?)
 	`)
 	if err != nil {
-		log.Printf("[E] [Scraper/Char] Failed to prepare changelog statement: %v", err)
-		return
+		return nil, 0, fmt.Errorf("failed to prepare changelog statement: %w", err)
 	}
 	defer changelogStmt.Close()
 
 	scrapedPlayerNames := make(map[string]bool)
 	totalProcessed := 0
 
-	// 3. Process all players from the slice
 	for _, p := range players {
 		p.LastUpdated = updateTime // Use the new timestamp
 		scrapedPlayerNames[p.Name] = true
@@ -608,6 +707,9 @@ func savePlayerCharacters(players []PlayerCharacter) {
 
 		// Upsert the player
 		if _, err := stmt.Exec(p.Rank, p.Name, p.BaseLevel, p.JobLevel, p.Experience, p.Class, p.LastUpdated, lastActiveTime); err != nil {
+			if storage.IsBusy(err) {
+				return nil, 0, err
+			}
 			log.Printf("[W] [Scraper/Char] Failed to upsert character for player %s: %v", p.Name, err)
 		}
 	}
@@ -616,8 +718,7 @@ func savePlayerCharacters(players []PlayerCharacter) {
 	// We check the count AFTER processing the channel but BEFORE committing.
 	var currentDBCount int
 	if err := srv.db.QueryRow("SELECT COUNT(*) FROM characters").Scan(&currentDBCount); err != nil {
-		log.Printf("[E] [Scraper/Char] Failed to query current character count for safety check: %v. Aborting update.", err)
-		return // Implicit rollback via defer
+		return nil, 0, fmt.Errorf("failed to query current character count for safety check, aborting update: %w", err)
 	}
 
 	const safetyThresholdRatio = 0.80
@@ -625,41 +726,78 @@ func savePlayerCharacters(players []PlayerCharacter) {
 		minAcceptableCount := int(float64(currentDBCount) * safetyThresholdRatio)
 
 		if totalProcessed < minAcceptableCount {
-			log.Printf("[W] [Scraper/Char] SAFETY ABORT: Scraped %d characters, but DB contains %d. This is a drop of over %.0f%%. Rolling back to prevent data loss/stale cleanup.",
+			return nil, 0, fmt.Errorf("SAFETY ABORT: Scraped %d characters, but DB contains %d. This is a drop of over %.0f%%. Rolling back to prevent data loss/stale cleanup",
 				totalProcessed, currentDBCount, (1.0-safetyThresholdRatio)*100)
-			return // Implicit rollback via defer
 		}
 	}
 	// --- END SAFETY CHECK ---
 
-	// 4. Commit the transaction
+	if err := storeLevelingVelocities(tx); err != nil {
+		return nil, 0, fmt.Errorf("failed to store leveling velocities: %w", err)
+	}
+
 	if err := tx.Commit(); err != nil {
-		log.Printf("[E] [Scraper/Char] Failed to commit transaction: %v", err)
-		return
+		return nil, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return scrapedPlayerNames, totalProcessed, nil
+}
+
+// savePlayerCharacters handles the database transaction to update player data.
+// It accepts a complete slice of players to minimize transaction duration.
+func savePlayerCharacters(players []PlayerCharacter) error {
+	characterMutex.Lock()
+	defer characterMutex.Unlock()
+
+	log.Println("[I] [Scraper/Char] DB update started. Processing scraped data...")
+
+	// 1. Fetch existing player data for comparison
+	existingPlayers, err := fetchExistingPlayers()
+	if err != nil {
+		log.Printf("[E] [Scraper/Char] %v", err)
+		// Continue with an empty map, logging will just be incomplete
+	}
+
+	// Generate the timestamp
+	updateTime := time.Now().Format(time.RFC3339)
+
+	// 2. Upsert everything in one transaction, retried as a whole while
+	// another writer holds the database.
+	var scrapedPlayerNames map[string]bool
+	var totalProcessed int
+	err = storage.RetryBusy("character upsert", func() error {
+		var err error
+		scrapedPlayerNames, totalProcessed, err = upsertPlayerCharacters(players, existingPlayers, updateTime)
+		return err
+	})
+	if err != nil {
+		log.Printf("[E] [Scraper/Char] %v", err)
+		return err
 	}
 	InvalidateUpdateTimeCache("last_updated", "characters")
 	log.Printf("[I] [Scraper/Char] Saved/updated %d records.", totalProcessed)
 
 	if totalProcessed == 0 {
 		log.Println("[W] [Scraper/Char] Scraper processed 0 total characters. This might be a parsing error. Skipping stale player cleanup to avoid wiping data.")
-		return
+		return errors.New("scraped 0 characters")
 	}
 
-	// 5. Clean up stale records (outside the transaction)
+	// 3. Clean up stale records (outside the transaction)
 	// We only run this if the safety check passed (transaction committed)
 	cleanupStalePlayers(scrapedPlayerNames, existingPlayers)
 
 	log.Printf("[I] [Scraper/Char] Scrape and update process complete.")
+	return nil
 }
 
 // scrapePlayerCharacters is the concurrent "producer" for character data.
-func scrapePlayerCharacters() {
+func scrapePlayerCharacters() error {
 	log.Println("[I] [Scraper/Char] Starting player character scrape...")
 
 	const firstPageURL = "https://projetoyufa.com/rankings?page=1"
 	lastPage, firstPageBody := scraperClient.findLastPageAndBody(firstPageURL, "[Characters]")
 
 	var allScrapedPlayers []PlayerCharacter
+	failedPages := 0
 
 	log.Printf("[I] [Scraper/Char] Scraping all %d pages...", lastPage)
 	for page := 1; page <= lastPage; page++ {
@@ -704,6 +842,7 @@ func scrapePlayerCharacters() {
 			allScrapedPlayers = append(allScrapedPlayers, pagePlayers...)
 			log.Printf("[D] [Scraper/Char] Scraped page %d/%d, collected %d chars.", page, lastPage, len(pagePlayers))
 		} else {
+			failedPages++
 			log.Printf("[E] [Scraper/Char] Failed to scrape page %d/%d after all retries.", page,
 				lastPage)
 		}
@@ -711,7 +850,10 @@ func scrapePlayerCharacters() {
 	}
 
 	log.Printf("[I] [Scraper/Char] Finished scraping all pages. Found %d total characters. Saving to DB...", len(allScrapedPlayers))
-	savePlayerCharacters(allScrapedPlayers)
+	if err := savePlayerCharacters(allScrapedPlayers); err != nil {
+		return err
+	}
+	return pagesFailedError(failedPages, lastPage)
 }
 
 // parseCharacterPage contains all the parsing logic for a character page.
@@ -730,6 +872,8 @@ func parseCharacterPage(bodyContent string, pageIndex, attempt int) ([]PlayerCha
 
 	if len(rankMatches) != numChars || len(baseLevelMatches) != numChars || len(jobLevelMatches) != numChars || len(expMatches) != numChars || len(classMatches) != numChars {
 		log.Printf("[W] [Scraper/Char] Mismatch in regex match counts on page %d. Skipping page. (Ranks: %d, Names: %d, Classes: %d)", pageIndex, len(rankMatches), len(nameMatches), len(classMatches))
+		reportScrapeDrift("characters", pageIndex, bodyContent, fmt.Sprintf("match counts differ (ranks %d, names %d, base %d, job %d, exp %d, classes %d)",
+			len(rankMatches), numChars, len(baseLevelMatches), len(jobLevelMatches), len(expMatches), len(classMatches)))
 		return nil, nil // Data integrity issue, don't retry, just return no players
 	}
 
@@ -751,11 +895,16 @@ func parseCharacterPage(bodyContent string, pageIndex, attempt int) ([]PlayerCha
 			Name:       name,
 		})
 	}
+
+	pagePlayers, problems := filterSane(pagePlayers, characterSanityProblem)
+	if len(problems) > 0 {
+		reportScrapeDrift("characters", pageIndex, bodyContent, fmt.Sprintf("%d/%d characters failed sanity checks, e.g. %s", len(problems), numChars, problems[0]))
+	}
 	return pagePlayers, nil
 }
 
 // processGuildData handles all database transactions for updating guilds and member associations.
-func processGuildData(allGuilds map[string]Guild, allMembers map[string]string) {
+func processGuildData(allGuilds map[string]Guild, allMembers map[string]string) error {
 	characterMutex.Lock()
 	defer characterMutex.Unlock()
 
@@ -765,7 +914,7 @@ func processGuildData(allGuilds map[string]Guild, allMembers map[string]string)
 	var currentGuildCount int
 	if err := srv.db.QueryRow("SELECT COUNT(*) FROM guilds WHERE is_active = 1").Scan(&currentGuildCount); err != nil {
 		log.Printf("[E] [Scraper/Guild] Failed to query current guild count for safety check: %v. Aborting update.", err)
-		return
+		return err
 	}
 
 	scrapedCount := len(allGuilds)
@@ -779,7 +928,7 @@ func processGuildData(allGuilds map[string]Guild, allMembers map[string]string)
 		if scrapedCount < minAcceptableCount {
 			log.Printf("[W] [Scraper/Guild] SAFETY ABORT: Scraped %d guilds, but DB contains %d active. This is a drop of over %.0f%%. Keeping existing data to prevent partial wipe.",
 				scrapedCount, currentGuildCount, (1.0-safetyThresholdRatio)*100)
-			return
+			return fmt.Errorf("safety abort: scraped %d guilds, but DB contains %d active", scrapedCount, currentGuildCount)
 		}
 	}
 	// --- END SAFETY CHECK ---
@@ -799,11 +948,27 @@ func processGuildData(allGuilds map[string]Guild, allMembers map[string]string)
 		oldGuildRows.Close()
 	}
 
+	// Previous masters, keyed by guild name, so leadership changes can be
+	// logged after the upsert. Guilds missing here are new and never log.
+	oldMasters := make(map[string]string)
+	masterRows, err := srv.db.Query("SELECT name, master FROM guilds")
+	if err != nil {
+		log.Printf("[W] [Scraper/Guild] Could not fetch old guild masters for comparison: %v", err)
+	} else {
+		for masterRows.Next() {
+			var guildName, master string
+			if err := masterRows.Scan(&guildName, &master); err == nil {
+				oldMasters[guildName] = master
+			}
+		}
+		masterRows.Close()
+	}
+
 	// 2. Start transaction
 	tx, errDb := srv.db.Begin()
 	if errDb != nil {
 		log.Printf("[E] [Scraper/Guild] Failed to begin transaction for guilds update: %v", errDb)
-		return
+		return errDb
 	}
 	defer tx.Rollback()
 
@@ -812,7 +977,7 @@ func processGuildData(allGuilds map[string]Guild, allMembers map[string]string)
 	// rankings stay in the table for historical reference with is_active = 0.
 	if _, err := tx.Exec("UPDATE guilds SET is_active = 0"); err != nil {
 		log.Printf("[E] [Scraper/Guild] Failed to mark guilds inactive: %v", err)
-		return
+		return err
 	}
 
 	// 4. Upsert guild information
@@ -830,7 +995,7 @@ func processGuildData(allGuilds map[string]Guild, allMembers map[string]string)
 	`)
 	if err != nil {
 		log.Printf("[E] [Scraper/Guild] Failed to prepare guilds upsert statement: %v", err)
-		return
+		return err
 	}
 	defer guildStmt.Close()
 
@@ -845,13 +1010,13 @@ func processGuildData(allGuilds map[string]Guild, allMembers map[string]string)
 	log.Printf("[D] [Scraper/Guild] Updating 'characters' table with guild associations for %d members...", len(allMembers))
 	if _, err := tx.Exec("UPDATE characters SET guild_name = NULL"); err != nil {
 		log.Printf("[E] [Scraper/Guild] Failed to clear existing guild names from characters table: %v", err)
-		return
+		return err
 	}
 
 	charStmt, err := tx.Prepare("UPDATE characters SET guild_name = ? WHERE name = ?")
 	if err != nil {
 		log.Printf("[E] [Scraper/Guild] Failed to prepare character guild update statement: %v", err)
-		return
+		return err
 	}
 	defer charStmt.Close()
 
@@ -866,6 +1031,23 @@ func processGuildData(allGuilds map[string]Guild, allMembers map[string]string)
 	}
 	log.Printf("[D] [Scraper/Guild] Successfully associated %d characters with their guilds.", updateCount)
 
+	// 4b. Snapshot member counts for the guild page's history chart
+	memberCounts := make(map[string]int, len(allGuilds))
+	for _, guildName := range allMembers {
+		memberCounts[guildName]++
+	}
+	historyStmt, err := tx.Prepare("INSERT OR REPLACE INTO guild_member_history (guild_name, timestamp, member_count) VALUES (?, ?, ?)")
+	if err != nil {
+		log.Printf("[E] [Scraper/Guild] Failed to prepare guild member history statement: %v", err)
+	} else {
+		defer historyStmt.Close()
+		for _, g := range allGuilds {
+			if _, err := historyStmt.Exec(g.Name, updateTime, memberCounts[g.Name]); err != nil {
+				log.Printf("[W] [Scraper/Guild] Failed to record member count for guild '%s': %v", g.Name, err)
+			}
+		}
+	}
+
 	// 5. Log guild changes
 	changelogStmt, err := tx.Prepare(`
 		INSERT INTO character_changelog (character_name, change_time, activity_description, event_kind)
@@ -903,12 +1085,34 @@ func processGuildData(allGuilds map[string]Guild, allMembers map[string]string)
 				logCharacterActivity(changelogStmt, charName, changelogKindGuildMove, fmt.Sprintf("Moved from guild '%s' to '%s'.", oldGuild, newGuild))
 			}
 		}
+
+		for _, g := range allGuilds {
+			oldMaster, known := oldMasters[g.Name]
+			if !known || oldMaster == "" || g.Master == "" || oldMaster == g.Master {
+				continue
+			}
+			// Attach the entry to the new master; fall back to the old one
+			// if the new master isn't a tracked character yet.
+			target := ""
+			for _, candidate := range []string{g.Master, oldMaster} {
+				var exists int
+				if err := tx.QueryRow("SELECT COUNT(*) FROM characters WHERE name = ?", candidate).Scan(&exists); err == nil && exists > 0 {
+					target = candidate
+					break
+				}
+			}
+			if target == "" {
+				log.Printf("[D] [Scraper/Guild] Guild '%s' master changed from '%s' to '%s', but neither is a known character. Not logging.", g.Name, oldMaster, g.Master)
+				continue
+			}
+			logCharacterActivity(changelogStmt, target, changelogKindGuildMaster, fmt.Sprintf("Guild '%s' leadership changed from '%s' to '%s'.", g.Name, oldMaster, g.Master))
+		}
 	}
 
 	// 6. Commit
 	if err := tx.Commit(); err != nil {
 		log.Printf("[E] [Scraper/Guild] Failed to commit guilds and characters transaction: %v", err)
-		return
+		return err
 	}
 	InvalidateUpdateTimeCache("last_updated", "guilds")
 
@@ -916,10 +1120,11 @@ func processGuildData(allGuilds map[string]Guild, allMembers map[string]string)
 
 	// 7. Refresh on-disk processed emblems (magenta -> transparent).
 	processGuildEmblems()
+	return nil
 }
 
 // scrapeGuilds is the concurrent "producer" for guild data.
-func scrapeGuilds() {
+func scrapeGuilds() error {
 	log.Println("[I] [Scraper/Guild] Starting guild and character-guild association scrape...")
 
 	const firstPageURL = "https://projetoyufa.com/rankings/guild?page=1"
@@ -932,6 +1137,7 @@ func scrapeGuilds() {
 	allMembers := make(map[string]string) // Map[characterName]guildName
 	var mu sync.Mutex
 	var wg sync.WaitGroup
+	var failedPages atomic.Int32
 	sem := make(chan struct{}, 5)
 
 	log.Printf("[I] [Scraper/Guild] Scraping all %d pages...", lastPage)
@@ -976,6 +1182,7 @@ func scrapeGuilds() {
 				mu.Unlock()
 				log.Printf("[D] [Scraper/Guild] Scraped page %d/%d, found %d guilds.", pageIndex, lastPage, len(pageGuilds))
 			} else {
+				failedPages.Add(1)
 				log.Printf("[E] [Scraper/Guild] Failed to scrape page %d/%d after all retries.", pageIndex, lastPage)
 			}
 		}(page)
@@ -985,11 +1192,14 @@ func scrapeGuilds() {
 	log.Printf("[I] [Scraper/Guild] Finished scraping all pages. Found %d unique guilds.", len(allGuilds))
 	if len(allGuilds) == 0 {
 		log.Println("[W] [Scraper/Guild] Scrape finished with 0 total guilds found. Guild/character tables will not be updated.")
-		return
+		return errors.New("scraped 0 guilds")
 	}
 
 	// Call the dedicated database function
-	processGuildData(allGuilds, allMembers)
+	if err := processGuildData(allGuilds, allMembers); err != nil {
+		return err
+	}
+	return pagesFailedError(int(failedPages.Load()), lastPage)
 }
 
 // guildsPerPage matches the ranking page size and is used to compute a
@@ -1012,6 +1222,8 @@ func parseGuildPage(bodyContent string, pageIndex, attempt int) ([]Guild, map[st
 	if len(levelMatches) != numGuilds || len(masterMatches) != numGuilds || len(membersMatches) != numGuilds || len(expMatches) != numGuilds {
 		log.Printf("[W] [Scraper/Guild] Mismatch in regex match counts on page %d. Skipping page. (Names: %d, Levels: %d, Masters: %d, Members: %d, Exp: %d)",
 			pageIndex, len(nameMatches), len(levelMatches), len(masterMatches), len(membersMatches), len(expMatches))
+		reportScrapeDrift("guilds", pageIndex, bodyContent, fmt.Sprintf("match counts differ (names %d, levels %d, masters %d, members %d, exp %d)",
+			numGuilds, len(levelMatches), len(masterMatches), len(membersMatches), len(expMatches)))
 		return nil, nil, nil // Data integrity issue, don't retry
 	}
 
@@ -1027,11 +1239,22 @@ func parseGuildPage(bodyContent string, pageIndex, attempt int) ([]Guild, map[st
 
 		pageGuilds = append(pageGuilds, Guild{Rank: rank, Name: name, Level: level, Experience: exp, Master: master})
 
+		if guildSanityProblem(pageGuilds[len(pageGuilds)-1]) != "" {
+			continue // Reported below; don't attach members to a bad guild name
+		}
 		members := guildMemberName.FindAllStringSubmatch(membersMatches[i][1], -1)
 		for _, member := range members {
 			pageMembers[member[1]] = name // charName -> guildName
 		}
 	}
+
+	pageGuilds, problems := filterSane(pageGuilds, guildSanityProblem)
+	if len(problems) > 0 {
+		reportScrapeDrift("guilds", pageIndex, bodyContent, fmt.Sprintf("%d/%d guilds failed sanity checks, e.g. %s", len(problems), numGuilds, problems[0]))
+		if len(pageGuilds) == 0 {
+			return nil, nil, nil // Whole page rejected, don't retry
+		}
+	}
 	return pageGuilds, pageMembers, nil
 }
 
@@ -1046,7 +1269,7 @@ func formatWithCommas(n int64) string {
 }
 
 // processZenyData handles fetching old zeny data, comparing, and updating the database.
-func processZenyData(allZenyInfo map[string]int64) {
+func processZenyData(allZenyInfo map[string]int64) error {
 	characterMutex.Lock()
 	defer characterMutex.Unlock()
 
@@ -1056,7 +1279,7 @@ func processZenyData(allZenyInfo map[string]int64) {
 	var currentZenyCount int
 	if err := srv.db.QueryRow("SELECT COUNT(*) FROM characters WHERE zeny > 0").Scan(&currentZenyCount); err != nil {
 		log.Printf("[E] [Scraper/Zeny] Failed to query current zeny count for safety check: %v. Aborting update.", err)
-		return
+		return err
 	}
 
 	scrapedCount := len(allZenyInfo)
@@ -1068,7 +1291,7 @@ func processZenyData(allZenyInfo map[string]int64) {
 		if scrapedCount < minAcceptableCount {
 			log.Printf("[W] [Scraper/Zeny] SAFETY ABORT: Scraped %d zeny records, but DB has %d characters with zeny. This is a drop of over %.0f%%. Aborting update to prevent partial data.",
 				scrapedCount, currentZenyCount, (1.0-safetyThresholdRatio)*100)
-			return
+			return fmt.Errorf("safety abort: scraped %d zeny records, but DB has %d characters with zeny", scrapedCount, currentZenyCount)
 		}
 	}
 	// --- END SAFETY CHECK ---
@@ -1097,14 +1320,14 @@ func processZenyData(allZenyInfo map[string]int64) {
 	tx, err := srv.db.Begin()
 	if err != nil {
 		log.Printf("[E] [Scraper/Zeny] Failed to begin transaction: %v", err)
-		return
+		return err
 	}
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare("UPDATE characters SET zeny = ?, last_active = ? WHERE name = ?")
 	if err != nil {
 		log.Printf("[E] [Scraper/Zeny] Failed to prepare update statement: %v", err)
-		return
+		return err
 	}
 	defer stmt.Close()
 
@@ -1150,8 +1373,20 @@ func processZenyData(allZenyInfo map[string]int64) {
 			logCharacterActivity(changelogStmt, name, kind, description)
 		}
 
+		// Small zeny changes are recorded but keep the previous last_active.
+		lastActive := updateTime
+		if exists && oldInfo.Zeny.Valid && oldInfo.LastActive != "" {
+			delta := newZeny - oldInfo.Zeny.Int64
+			if delta < 0 {
+				delta = -delta
+			}
+			if delta < activityMinZenyDelta() {
+				lastActive = oldInfo.LastActive
+			}
+		}
+
 		// Update the database
-		res, err := stmt.Exec(newZeny, updateTime, name)
+		res, err := stmt.Exec(newZeny, lastActive, name)
 		if err != nil {
 			log.Printf("[W] [Scraper/Zeny] Failed to update zeny for '%s': %v", name, err)
 			continue
@@ -1163,9 +1398,10 @@ func processZenyData(allZenyInfo map[string]int64) {
 
 	if err := tx.Commit(); err != nil {
 		log.Printf("[E] [Scraper/Zeny] Failed to commit transaction: %v", err)
-		return
+		return err
 	}
 	log.Printf("[I] [Scraper/Zeny] Database update complete. Updated activity for %d characters. %d characters were unchanged.", updatedCount, unchangedCount)
+	return nil
 }
 
 // resolveStreamedPlaceholders replays the React Suspense client-side swap that
@@ -1193,7 +1429,7 @@ func resolveStreamedPlaceholders(doc *goquery.Document) int {
 }
 
 // scrapeZeny is now only responsible for concurrent scraping.
-func scrapeZeny() {
+func scrapeZeny() error {
 	log.Println("[I] [Scraper/Zeny] Starting Zeny ranking scrape...")
 
 	const firstPageURL = "https://projetoyufa.com/rankings/zeny?page=1"
@@ -1202,6 +1438,7 @@ func scrapeZeny() {
 	allZenyInfo := make(map[string]int64) // Map[characterName]zeny
 	var mu sync.Mutex
 	var wg sync.WaitGroup
+	var failedPages atomic.Int32
 	sem := make(chan struct{}, 5) // Concurrency semaphore
 
 	log.Printf("[I] [Scraper/Zeny] Scraping all %d pages...", lastPage)
@@ -1308,6 +1545,7 @@ func scrapeZeny() {
 			} else if validRows > 0 {
 				log.Printf("[D] [Scraper/Zeny] Scraped page %d/%d successfully (%d rows).", pageIndex, lastPage, validRows)
 			} else {
+				failedPages.Add(1)
 				log.Printf("[E] [Scraper/Zeny] Failed to scrape page %d/%d after all retries.", pageIndex, lastPage)
 			}
 		}(page)
@@ -1318,11 +1556,14 @@ func scrapeZeny() {
 
 	if len(allZenyInfo) == 0 {
 		log.Println("[W] [Scraper/Zeny] No zeny information was scraped. Skipping database update.")
-		return
+		return errors.New("scraped 0 zeny records")
 	}
 
 	// Call the dedicated database function
-	processZenyData(allZenyInfo)
+	if err := processZenyData(allZenyInfo); err != nil {
+		return err
+	}
+	return pagesFailedError(int(failedPages.Load()), lastPage)
 }
 
 // in scraper.go
@@ -1449,33 +1690,6 @@ func buildMarketItemName(fi flightItem) string {
 	return name
 }
 
-// formatMarketPrice mirrors the historical price-string format ("1,234,567z")
-// so the lowest-price comparisons in scrapeData keep matching old rows.
-func formatMarketPrice(p int64) string {
-	s := strconv.FormatInt(p, 10)
-	n := len(s)
-	if n <= 3 {
-		return s + "z"
-	}
-	var b strings.Builder
-	b.Grow(n + n/3 + 1)
-	first := n % 3
-	if first > 0 {
-		b.WriteString(s[:first])
-		if n > first {
-			b.WriteByte(',')
-		}
-	}
-	for i := first; i < n; i += 3 {
-		b.WriteString(s[i : i+3])
-		if i+3 < n {
-			b.WriteByte(',')
-		}
-	}
-	b.WriteByte('z')
-	return b.String()
-}
-
 // in scraper.go
 
 // determineRemovalType encapsulates the logic for deciding if an item was sold or just removed.
@@ -1528,7 +1742,7 @@ func bulkInsertItems(tx *sql.Tx, items []Item, retrievalTime string) error {
 }
 
 // scrapeData performs the main market scraping logic with performance optimizations.
-func scrapeData() {
+func scrapeData() error {
 	log.Println("[I] [Scraper/Market] Starting scrape...")
 
 	const requestURL = "https://projetoyufa.com/market"
@@ -1537,6 +1751,7 @@ func scrapeData() {
 	var err error
 	scrapedItemsByName := make(map[string][]Item)
 	activeSellers := make(map[string]bool)
+	scrapedBaseNames := make(map[int64]string)
 
 	// 1. Network & Parsing Phase (Retry Logic)
 	for attempt := 1; attempt <= maxParseRetries; attempt++ {
@@ -1556,6 +1771,7 @@ func scrapeData() {
 
 		scrapedItemsByName = make(map[string][]Item)
 		activeSellers = make(map[string]bool)
+		scrapedBaseNames = make(map[int64]string)
 
 		for _, shop := range shops {
 			sellerName := strings.TrimSpace(shop.Char.Name)
@@ -1591,6 +1807,9 @@ func scrapeData() {
 					MapCoordinates: mapCoords,
 				}
 				scrapedItemsByName[name] = append(scrapedItemsByName[name], item)
+				if fi.CartItem.NameID > 0 {
+					scrapedBaseNames[int64(fi.CartItem.NameID)] = fi.CartItem.Item.NameEnglish
+				}
 			}
 		}
 
@@ -1606,7 +1825,7 @@ func scrapeData() {
 
 	if len(scrapedItemsByName) == 0 {
 		log.Println("[W] [Scraper/Market] Scraper found 0 items. Skipping update.")
-		return
+		return errors.New("scraped 0 items")
 	}
 
 	retrievalTime := time.Now().Format(time.RFC3339)
@@ -1618,14 +1837,14 @@ func scrapeData() {
 	tx, err := srv.db.Begin()
 	if err != nil {
 		log.Printf("[E] [Scraper/Market] Failed to begin transaction: %v", err)
-		return
+		return err
 	}
 	defer tx.Rollback()
 
 	_, err = tx.Exec("INSERT OR IGNORE INTO scrape_history (timestamp) VALUES (?)", retrievalTime)
 	if err != nil {
 		log.Printf("[E] [Scraper/Market] Failed to log scrape history: %v", err)
-		return
+		return err
 	}
 
 	// Single pass over the available-items snapshot — derive seller sizes,
@@ -1639,7 +1858,7 @@ func scrapeData() {
 	rows, err := tx.Query("SELECT name_of_the_item, item_id, quantity, price, store_name, seller_name, map_name, map_coordinates FROM items WHERE is_available = 1")
 	if err != nil {
 		log.Printf("[E] [Scraper/Market] Could not get list of all available items: %v", err)
-		return
+		return err
 	}
 	for rows.Next() {
 		var item Item
@@ -1663,21 +1882,21 @@ func scrapeData() {
 	stmtInsertEvent, err := tx.Prepare(`INSERT INTO market_events (event_timestamp, event_type, item_name, item_id, details) VALUES (?, ?, ?, ?, ?)`)
 	if err != nil {
 		log.Printf("[E] [Scraper/Market] Failed to prepare insert event statement: %v", err)
-		return
+		return err
 	}
 	defer stmtInsertEvent.Close()
 
 	stmtUpdateUnavailable, err := tx.Prepare(`UPDATE items SET is_available = 0 WHERE name_of_the_item = ?`)
 	if err != nil {
 		log.Printf("[E] [Scraper/Market] Failed to prepare update unavailable statement: %v", err)
-		return
+		return err
 	}
 	defer stmtUpdateUnavailable.Close()
 
-	stmtGetLowestPrice, err := tx.Prepare(`SELECT MIN(CAST(REPLACE(price, ',', '') AS INTEGER)) FROM items WHERE name_of_the_item = ?`)
+	stmtGetLowestPrice, err := tx.Prepare(`SELECT MIN(` + marketPriceSQL("price") + `) FROM items WHERE name_of_the_item = ?`)
 	if err != nil {
 		log.Printf("[E] [Scraper/Market] Failed to prepare get lowest price statement: %v", err)
-		return
+		return err
 	}
 	defer stmtGetLowestPrice.Close()
 
@@ -1756,11 +1975,10 @@ func scrapeData() {
 			}
 
 			var lowestPriceListingInBatch Item
-			lowestPriceInBatch := -1
+			lowestPriceInBatch := int64(-1)
 			for _, item := range currentScrapedItems {
-				priceStr := strings.ReplaceAll(item.Price, ",", "")
-				currentPrice, convErr := strconv.Atoi(priceStr)
-				if convErr != nil {
+				currentPrice := parseMarketPrice(item.Price)
+				if currentPrice <= 0 {
 					continue
 				}
 				if lowestPriceInBatch == -1 || currentPrice < lowestPriceInBatch {
@@ -1769,7 +1987,7 @@ func scrapeData() {
 				}
 			}
 
-			if lowestPriceInBatch != -1 && (!historicalLowestPrice.Valid || int64(lowestPriceInBatch) < historicalLowestPrice.Int64) {
+			if lowestPriceInBatch != -1 && (!historicalLowestPrice.Valid || lowestPriceInBatch < historicalLowestPrice.Int64) {
 				details, _ := json.Marshal(map[string]interface{}{
 					"price":      lowestPriceListingInBatch.Price,
 					"quantity":   lowestPriceListingInBatch.Quantity,
@@ -1816,16 +2034,26 @@ func scrapeData() {
 	if len(allNewItems) > 0 {
 		if err := bulkInsertItems(tx, allNewItems, retrievalTime); err != nil {
 			log.Printf("[E] [Scraper/Market] Bulk insert failed, rolling back: %v", err)
-			return // Rollback occurs via defer
+			return err // Rollback occurs via defer
 		}
 	}
 
+	// Every listing still available was seen by this scrape: the rest were
+	// flipped above and new ones were just inserted.
+	if _, err := tx.Exec("UPDATE items SET last_seen = ? WHERE is_available = 1", retrievalTime); err != nil {
+		log.Printf("[E] [Scraper/Market] Failed to stamp last seen time, rolling back: %v", err)
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		log.Printf("[E] [Scraper/Market] Failed to commit transaction: %v", err)
-		return
+		return err
 	}
 	InvalidateUpdateTimeCache("timestamp", "scrape_history")
+	syncItemNames(scrapedBaseNames)
+	startPriceWatchCheck()
 	log.Printf("[I] [Scraper/Market] Scrape complete. Unchanged: %d groups. Updated: %d groups. Newly Added: %d groups. Removed: %d groups.", itemsUnchanged, itemsUpdated, itemsAdded, itemsRemoved)
+	return nil
 }
 
 func areItemSetsIdentical(setA, setB []Item) bool {
@@ -1865,7 +2093,7 @@ func fetchAllCharacterNames() (map[string]bool, error) {
 // processMvpKills handles all database logic for the MVP scraper.
 // OPTIMIZATION: Removed the memory-heavy fetchExistingMvpKills() call.
 // Logic for keeping the highest kill count is now handled natively by SQLite's MAX() function in the UPSERT.
-func processMvpKills(allMvpKills map[string]map[string]int) {
+func processMvpKills(allMvpKills map[string]map[string]int) error {
 	characterMutex.Lock()
 	defer characterMutex.Unlock()
 
@@ -1874,14 +2102,14 @@ func processMvpKills(allMvpKills map[string]map[string]int) {
 	allCharacterNames, err := fetchAllCharacterNames()
 	if err != nil {
 		log.Printf("[E] [Scraper/MVP] %v. Aborting update.", err)
-		return
+		return err
 	}
 
 	// 2. Begin transaction
 	tx, err := srv.db.Begin()
 	if err != nil {
 		log.Printf("[E] [Scraper/MVP] Failed to begin transaction: %v", err)
-		return
+		return err
 	}
 	defer tx.Rollback()
 
@@ -1910,7 +2138,7 @@ func processMvpKills(allMvpKills map[string]map[string]int) {
 	stmt, err := tx.Prepare(queryStr)
 	if err != nil {
 		log.Printf("[E] [Scraper/MVP] Failed to prepare MVP kills upsert statement: %v", err)
-		return
+		return err
 	}
 	defer stmt.Close()
 
@@ -1936,10 +2164,11 @@ func processMvpKills(allMvpKills map[string]map[string]int) {
 	// 5. Commit
 	if err := tx.Commit(); err != nil {
 		log.Printf("[E] [Scraper/MVP] Failed to commit transaction: %v", err)
-		return
+		return err
 	}
 	log.Printf("[I] [Scraper/MVP] Saved/updated MVP kill records for %d characters.", updateCount)
 	log.Printf("[I] [Scraper/MVP] Scrape and update process complete.")
+	return nil
 }
 
 // WoEEventStats holds summary info about a WoE event for season detection.
@@ -1999,7 +2228,7 @@ func getLastWoeEventStats(tx *sql.Tx) (WoEEventStats, error) {
 
 // processWoeCharacterData handles saving the scraped WoE rankings to the database,
 // now with season detection logic.
-func processWoeCharacterData(allWoeChars map[string]WoeCharacterRank) {
+func processWoeCharacterData(allWoeChars map[string]WoeCharacterRank) error {
 	characterMutex.Lock() // Using characterMutex as WoE data relates to characters
 	defer characterMutex.Unlock()
 
@@ -2009,7 +2238,7 @@ func processWoeCharacterData(allWoeChars map[string]WoeCharacterRank) {
 
 	if len(allWoeChars) == 0 {
 		log.Println("[W] [Scraper/WoE] Scraped 0 WoE characters. Aborting event save.")
-		return
+		return errors.New("scraped 0 WoE characters")
 	}
 
 	// Calculate stats for the *newly scraped* data
@@ -2024,7 +2253,7 @@ func processWoeCharacterData(allWoeChars map[string]WoeCharacterRank) {
 	tx, err := srv.db.Begin()
 	if err != nil {
 		log.Printf("[E] [Scraper/WoE] Failed to begin transaction: %v", err)
-		return
+		return err
 	}
 	defer tx.Rollback()
 
@@ -2032,7 +2261,7 @@ func processWoeCharacterData(allWoeChars map[string]WoeCharacterRank) {
 	lastEventStats, err := getLastWoeEventStats(tx)
 	if err != nil {
 		log.Printf("[E] [Scraper/WoE] Could not get last event stats: %v", err)
-		return // Don't proceed if we can't check
+		return err // Don't proceed if we can't check
 	}
 
 	// 2. Season Detection Logic
@@ -2064,12 +2293,12 @@ func processWoeCharacterData(allWoeChars map[string]WoeCharacterRank) {
 		res, err := tx.Exec(`INSERT INTO woe_seasons (start_date) VALUES (?)`, eventTime)
 		if err != nil {
 			log.Printf("[E] [Scraper/WoE] Failed to create new woe_season entry: %v", err)
-			return
+			return err
 		}
 		newSeasonID, err := res.LastInsertId()
 		if err != nil {
 			log.Printf("[E] [Scraper/WoE] Failed to get new season_id: %v", err)
-			return
+			return err
 		}
 		currentSeasonID = newSeasonID
 		log.Printf("[I] [Scraper/WoE] Created new WoE Season with ID: %d", currentSeasonID)
@@ -2079,13 +2308,13 @@ func processWoeCharacterData(allWoeChars map[string]WoeCharacterRank) {
 	res, err := tx.Exec(`INSERT INTO woe_events (season_id, event_date, is_season_summary) VALUES (?, ?, 0)`, currentSeasonID, eventTime)
 	if err != nil {
 		log.Printf("[E] [Scraper/WoE] Failed to create new woe_event entry: %v", err)
-		return
+		return err
 	}
 
 	newEventID, err := res.LastInsertId()
 	if err != nil {
 		log.Printf("[E] [Scraper/WoE] Failed to get new event_id: %v", err)
-		return
+		return err
 	}
 
 	if enableWoeScraperDebugLogs {
@@ -2102,7 +2331,7 @@ func processWoeCharacterData(allWoeChars map[string]WoeCharacterRank) {
 	`)
 	if err != nil {
 		log.Printf("[E] [Scraper/WoE] Failed to prepare event rankings insert statement: %v", err)
-		return
+		return err
 	}
 	defer stmt.Close()
 
@@ -2124,15 +2353,16 @@ func processWoeCharacterData(allWoeChars map[string]WoeCharacterRank) {
 	// 7. Commit
 	if err := tx.Commit(); err != nil {
 		log.Printf("[E] [Scraper/WoE] Failed to commit transaction: %v", err)
-		return
+		return err
 	}
 	log.Printf("[I] [Scraper/WoE] Database update complete. Saved %d WoE character records for new event ID %d in season %d.", updateCount, newEventID, currentSeasonID)
+	return nil
 }
 
 // scrapeWoeCharacterRankings scrapes the WoE character rankings from the website using goquery, handling pagination.
 // This function's logic remains the same, as it's the "producer".
 // The "consumer" (processWoeCharacterData) has been changed.
-func scrapeWoeCharacterRankings() {
+func scrapeWoeCharacterRankings() error {
 	log.Println("[I] [Scraper/WoE] Starting WoE character ranking scrape...")
 
 	const firstPageURL = "https://projetoyufa.com/rankings/woe?page=1" // Base URL for finding last page
@@ -2149,7 +2379,7 @@ func scrapeWoeCharacterRankings() {
 	rows, err := srv.db.Query("SELECT name FROM characters")
 	if err != nil {
 		log.Printf("[E] [Scraper/WoE] Could not query characters table for Names: %v. Aborting WoE scrape.", err)
-		return
+		return err
 	}
 	defer rows.Close()
 	for rows.Next() {
@@ -2161,13 +2391,14 @@ func scrapeWoeCharacterRankings() {
 	log.Printf("[D] [Scraper/WoE] Found %d characters in the main table.", len(existingCharNames))
 	if len(existingCharNames) == 0 {
 		log.Printf("[W] [Scraper/WoE] Main 'characters' table appears empty. Cannot link WoE stats. Aborting.")
-		return
+		return errors.New("characters table is empty")
 	}
 	// --- End Step 2 ---
 
 	// --- Step 3: Scrape all pages concurrently ---
 	var mu sync.Mutex
 	var wg sync.WaitGroup
+	var failedPages atomic.Int32
 	sem := make(chan struct{}, 5)
 	var totalParsedCount, totalMatchedCount int32
 
@@ -2315,6 +2546,7 @@ func scrapeWoeCharacterRankings() {
 			if parseFailed {
 				// Already logged above.
 			} else if validRows == 0 {
+				failedPages.Add(1)
 				log.Printf("[E] [Scraper/WoE] Failed to scrape page %d/%d after all retries.", pageIndex, lastPage)
 			}
 		}(page)
@@ -2327,14 +2559,17 @@ func scrapeWoeCharacterRankings() {
 
 	if len(allWoeChars) == 0 {
 		log.Println("[W] [Scraper/WoE] No WoE character information could be matched/parsed across all pages. Skipping database update.")
-		return
+		return errors.New("no WoE characters matched")
 	}
 
-	processWoeCharacterData(allWoeChars)
+	if err := processWoeCharacterData(allWoeChars); err != nil {
+		return err
+	}
+	return pagesFailedError(int(failedPages.Load()), lastPage)
 }
 
 // scrapeMvpKills is now only responsible for concurrent scraping.
-func scrapeMvpKills() {
+func scrapeMvpKills() error {
 	log.Println("[I] [Scraper/MVP] Starting MVP kill count scrape...")
 
 	const firstPageURL = "https://projetoyufa.com/rankings/mvp?page=1"
@@ -2343,6 +2578,7 @@ func scrapeMvpKills() {
 	allMvpKills := make(map[string]map[string]int) // Map[characterName]Map[mobID]killCount
 	var mu sync.Mutex
 	var wg sync.WaitGroup
+	var failedPages atomic.Int32
 	sem := make(chan struct{}, 5)
 
 	log.Printf("[I] [Scraper/MVP] Scraping all %d pages...", lastPage)
@@ -2408,6 +2644,7 @@ func scrapeMvpKills() {
 			if numPlayerBlocks > 0 {
 				log.Printf("[D] [Scraper/MVP] Scraped page %d/%d, found %d characters with MVP kills.", pageIndex, lastPage, len(pageKills))
 			} else {
+				failedPages.Add(1)
 				log.Printf("[E] [Scraper/MVP] Failed to scrape page %d/%d after all retries.", pageIndex, lastPage)
 			}
 		}(page)
@@ -2418,11 +2655,14 @@ func scrapeMvpKills() {
 
 	if len(allMvpKills) == 0 {
 		log.Println("[W] [Scraper/MVP] No MVP kills found after scrape. Skipping database update.")
-		return
+		return errors.New("scraped 0 MVP kill records")
 	}
 
 	// Call the dedicated database function
-	processMvpKills(allMvpKills)
+	if err := processMvpKills(allMvpKills); err != nil {
+		return err
+	}
+	return pagesFailedError(int(failedPages.Load()), lastPage)
 }
 
 const ptNameDelay = 3 * time.Second // Delay between requests
@@ -2499,7 +2739,7 @@ func fetchAndUpdatePortugueseName(itemID int) (string, error) {
 	}
 
 	// 4. Update the DB
-	_, err = srv.db.Exec("UPDATE internal_item_db SET name_pt = ? WHERE item_id = ?", fetchedName, itemID)
+	_, err = srv.db.Exec("UPDATE internal_item_db SET name_pt = ?, last_modified = ? WHERE item_id = ?", fetchedName, time.Now().Format(time.RFC3339), itemID)
 	if err != nil {
 		return "", fmt.Errorf("failed to update database for item %d: %w", itemID, err)
 	}
@@ -2512,10 +2752,10 @@ func fetchAndUpdatePortugueseName(itemID int) (string, error) {
 }
 
 // populateMissingPortugueseNames is the background job function
-func populateMissingPortugueseNames() {
+func populateMissingPortugueseNames() error {
 	if !ptNameMutex.TryLock() {
 		log.Println("[I] [Scraper/PT-Name] Portuguese name population job is already running. Skipping.")
-		return
+		return nil
 	}
 	defer ptNameMutex.Unlock()
 
@@ -2525,7 +2765,7 @@ func populateMissingPortugueseNames() {
 	rows, err := srv.db.Query("SELECT item_id FROM internal_item_db WHERE name_pt IS NULL OR name_pt = ''")
 	if err != nil {
 		log.Printf("[E] [Scraper/PT-Name] Failed to query for items: %v", err)
-		return
+		return err
 	}
 	defer rows.Close()
 
@@ -2539,13 +2779,57 @@ func populateMissingPortugueseNames() {
 
 	if len(itemIDs) == 0 {
 		log.Println("[I] [Scraper/PT-Name] No items need Portuguese names. Job complete.")
-		return
+		return nil
 	}
 
 	log.Printf("[I] [Scraper/PT-Name] Found %d items to update.", len(itemIDs))
+	successCount, failCount := fillPortugueseNames(itemIDs)
+	log.Printf("[I] [Scraper/PT-Name] Job finished. Successfully updated: %d, Failed: %d", successCount, failCount)
+	return nil
+}
 
-	// 2. Loop, fetch, update, and sleep
-	var successCount, failCount int
+// populateMarketPortugueseNames resolves name_pt only for items that
+// have appeared on the market, which is far smaller than the full item
+// DB and is what the market pages actually display.
+func populateMarketPortugueseNames() error {
+	if !ptNameMutex.TryLock() {
+		log.Println("[I] [Scraper/PT-Name] Portuguese name population job is already running. Skipping.")
+		return nil
+	}
+	defer ptNameMutex.Unlock()
+
+	rows, err := srv.db.Query(`
+		SELECT DISTINCT i.item_id
+		FROM items i
+		JOIN internal_item_db local_db ON local_db.item_id = i.item_id
+		WHERE i.item_id > 0 AND (local_db.name_pt IS NULL OR local_db.name_pt = '')`)
+	if err != nil {
+		log.Printf("[E] [Scraper/PT-Name] Failed to query market items without a PT name: %v", err)
+		return err
+	}
+	var itemIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			itemIDs = append(itemIDs, id)
+		}
+	}
+	rows.Close()
+
+	if len(itemIDs) == 0 {
+		log.Println("[I] [Scraper/PT-Name] Every market item already has a Portuguese name.")
+		return nil
+	}
+
+	log.Printf("[I] [Scraper/PT-Name] Found %d market items without a Portuguese name.", len(itemIDs))
+	successCount, failCount := fillPortugueseNames(itemIDs)
+	log.Printf("[I] [Scraper/PT-Name] Market pass finished. Filled: %d, Failed: %d", successCount, failCount)
+	return nil
+}
+
+// fillPortugueseNames fetches and stores the PT name of each item ID,
+// pausing ptNameDelay between requests. Callers hold ptNameMutex.
+func fillPortugueseNames(itemIDs []int) (successCount, failCount int) {
 	for i, itemID := range itemIDs {
 		ptName, err := fetchPortugueseName(itemID)
 		if err != nil {
@@ -2553,7 +2837,7 @@ func populateMissingPortugueseNames() {
 			failCount++
 		} else {
 			// Update the DB
-			_, err := srv.db.Exec("UPDATE internal_item_db SET name_pt = ? WHERE item_id = ?", ptName, itemID)
+			_, err := srv.db.Exec("UPDATE internal_item_db SET name_pt = ?, last_modified = ? WHERE item_id = ?", ptName, time.Now().Format(time.RFC3339), itemID)
 			if err != nil {
 				log.Printf("[E] [Scraper/PT-Name] [%d/%d] Failed to update DB for item %d: %v", i+1, len(itemIDs), itemID, err)
 				failCount++
@@ -2566,12 +2850,11 @@ func populateMissingPortugueseNames() {
 			}
 		}
 
-		// 3. Add the requested delay to avoid being blocked
+		// Add the requested delay to avoid being blocked
 		if i < len(itemIDs)-1 { // Don't sleep after the last item
 			time.Sleep(ptNameDelay)
 		}
 	}
-
-	log.Printf("[I] [Scraper/PT-Name] Job finished. Successfully updated: %d, Failed: %d", successCount, failCount)
+	return successCount, failCount
 }
 
@@ -13,6 +13,7 @@ import (
 	"time"
 	"unicode"
 
+	"github.com/denislee/yufa-mt/internal/storage"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
@@ -20,6 +21,41 @@ import (
 	"golang.org/x/text/transform"
 )
 
+// chatSystemChannels are the chat channels written only by the capture
+// itself, under a pseudo-character name rather than a player's.
+var chatSystemChannels = []string{"Drop", "Announcement", "Event", "System"}
+
+// chatBatchSize returns how many captured messages are buffered before
+// they are flushed without waiting for the ticker.
+func chatBatchSize() int {
+	if appConfig == nil {
+		return 200
+	}
+	return appConfig.ChatBatchSize
+}
+
+// chatFlushInterval returns how often a partial chat batch is flushed.
+func chatFlushInterval() time.Duration {
+	if appConfig == nil {
+		return 5 * time.Second
+	}
+	return time.Duration(appConfig.ChatFlushSeconds) * time.Second
+}
+
+// flushChatBatch saves batch and returns an empty slice that reuses its
+// backing array. A failed save is logged and the batch dropped, so a
+// stuck database can't grow the buffer without bound.
+func flushChatBatch(batch []ChatMessage, reason string) []ChatMessage {
+	if len(batch) == 0 {
+		return batch
+	}
+	log.Printf("[I] [Scraper/Chat] Flushing %d batched messages to DB (%s).", len(batch), reason)
+	if err := saveChatMessagesToDB(batch); err != nil {
+		log.Printf("[E] [Scraper/Chat] Error flushing message batch to DB: %v", err)
+	}
+	return batch[:0]
+}
+
 // saveChatMessagesToDB inserts a batch of new messages in a single transaction.
 func saveChatMessagesToDB(messages []ChatMessage) error {
 	if len(messages) == 0 {
@@ -44,6 +80,21 @@ func saveChatMessagesToDB(messages []ChatMessage) error {
 		return nil
 	}
 
+	err := storage.RetryBusy("chat insert", func() error {
+		return insertChatMessages(dedupedMessages)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[I] [Scraper/Chat] Saved %d new chat messages to DB (out of %d batched).", len(dedupedMessages), len(messages))
+	return nil
+}
+
+// insertChatMessages writes messages in one transaction. A failed row is
+// logged and skipped, but a locked database aborts the whole transaction
+// so saveChatMessagesToDB can retry it.
+func insertChatMessages(messages []ChatMessage) error {
 	tx, err := srv.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to start transaction: %w", err)
@@ -58,37 +109,56 @@ func saveChatMessagesToDB(messages []ChatMessage) error {
 	defer stmt.Close()
 
 	now := time.Now().Format(time.RFC3339)
-	for _, msg := range dedupedMessages {
+	for _, msg := range messages {
 		// --- MODIFIED: Exec call includes msg.Channel ---
 		if _, err := stmt.Exec(now, msg.Channel, msg.CharacterName, msg.Message); err != nil {
+			if storage.IsBusy(err) {
+				return err
+			}
 			log.Printf("[W] [Scraper/Chat] Failed to insert message from '%s' (%s): %v", msg.CharacterName, msg.Channel, err)
 			// Continue inserting other messages
 		}
 	}
 
-	log.Printf("[I] [Scraper/Chat] Saved %d new chat messages to DB (out of %d batched).", len(dedupedMessages), len(messages))
 	return tx.Commit()
 }
 
-func logChatActivityPeriodically() {
+// markChatPacket records that the sniffer saw a packet at t. It feeds the
+// navbar freshness indicator and the capture loop's disconnect watchdog.
+func markChatPacket(t time.Time) {
+	lastChatPacketTime.Store(t.Unix())
+}
+
+// claimActivityMinute reports whether now falls in a minute that has not
+// been logged yet, marking it as logged if so. Exactly one caller wins
+// each minute, so the heartbeat insert can run without holding the lock.
+func claimActivityMinute(now time.Time) bool {
+	minute := now.Truncate(time.Minute)
+
 	activityLogMutex.Lock()
 	defer activityLogMutex.Unlock()
+	if !minute.After(lastActivityLog) {
+		return false // Already logged this minute
+	}
+	lastActivityLog = minute
+	return true
+}
 
+func logChatActivityPeriodically() {
 	now := time.Now()
-	// Check if we've already logged an entry within the last minute
-	if now.Sub(lastActivityLog) < 1*time.Minute {
-		return // Already logged this minute
+	if !claimActivityMinute(now) {
+		return
 	}
 
-	// It's a new minute (or the first log), so update the timestamp
-	lastActivityLog = now
-
 	// Store the timestamp truncated to the minute (e.g., 15:04:00)
 	timestamp := now.Truncate(time.Minute).Format(time.RFC3339)
 
-	// Use "INSERT OR IGNORE" to avoid errors on duplicate (which shouldn't
-	// happen with the mutex, but it's safer)
-	_, err := srv.db.Exec("INSERT OR IGNORE INTO chat_activity_log (timestamp) VALUES (?)", timestamp)
+	// Use "INSERT OR IGNORE" to avoid errors on duplicate (a restart
+	// within the same minute re-claims it)
+	err := storage.RetryBusy("chat activity heartbeat", func() error {
+		_, err := srv.db.Exec("INSERT OR IGNORE INTO chat_activity_log (timestamp) VALUES (?)", timestamp)
+		return err
+	})
 	if err != nil {
 		log.Printf("[E] [Scraper/Chat] Failed to log chat activity heartbeat: %v", err)
 	} else if enableChatScraperDebugLogs {
@@ -115,13 +185,53 @@ func logSystemStatusMessage(message string) {
 	}
 }
 
+// chatSnifferEnabled reports whether live chat capture runs in this
+// process. When it doesn't, the chat pages only show stored history.
+func chatSnifferEnabled() bool {
+	return appConfig == nil || !(appConfig.DisableScrapers || appConfig.DisableChatSniffer)
+}
+
+// chatCaptureFilter returns the BPF expression for the capture handle: the
+// SNIFFER_BPF override when set, otherwise every TCP packet on port.
+func chatCaptureFilter(bpf, port string) string {
+	if bpf != "" {
+		return bpf
+	}
+	return fmt.Sprintf("tcp port %s", port)
+}
+
+// checkCaptureDevice fails with the list of available devices when the
+// configured interface doesn't exist, instead of leaving the user with
+// pcap's terser OpenLive error. If the device list itself can't be
+// read, the check is skipped and OpenLive reports the problem.
+func checkCaptureDevice(device string) error {
+	devs, err := pcap.FindAllDevs()
+	if err != nil {
+		log.Printf("[W] [Scraper/Chat] Could not list capture devices to validate '%s': %v", device, err)
+		return nil
+	}
+	names := make([]string, 0, len(devs))
+	for _, d := range devs {
+		if d.Name == device {
+			return nil
+		}
+		names = append(names, d.Name)
+	}
+	return fmt.Errorf("capture interface '%s' (SNIFFER_INTERFACE) not found; available: %s", device, strings.Join(names, ", "))
+}
+
 // startChatPacketCapture is the new long-running service to replace processChatLogFile
 func startChatPacketCapture(ctx context.Context) {
 	log.Println("[I] [Scraper/Chat] Initializing live packet capture...")
 
 	// --- 1. Find Network Device ---
 	device := appConfig.ChatCaptureDevice
-	if device == "" {
+	if device != "" {
+		if err := checkCaptureDevice(device); err != nil {
+			log.Printf("[E] [Scraper/Chat] %v. Chat capture disabled.", err)
+			return
+		}
+	} else {
 		// Use Go's standard 'net' package to find a suitable device.
 		ifaces, err := net.Interfaces()
 		if err != nil {
@@ -139,21 +249,21 @@ func startChatPacketCapture(ctx context.Context) {
 				addrs, err := i.Addrs()
 				if err == nil && len(addrs) > 0 {
 					device = i.Name
-					log.Printf("[I] [Scraper/Chat] No CHAT_CAPTURE_DEVICE set. Auto-selected device: %s", device)
+					log.Printf("[I] [Scraper/Chat] No SNIFFER_INTERFACE set. Auto-selected device: %s", device)
 					break
 				}
 			}
 		}
 
 		if device == "" {
-			log.Printf("[E] [Scraper/Chat] Could not find a suitable non-loopback network device. Please set CHAT_CAPTURE_DEVICE. Chat capture disabled.")
+			log.Printf("[E] [Scraper/Chat] Could not find a suitable non-loopback network device. Please set SNIFFER_INTERFACE. Chat capture disabled.")
 			return
 		}
 	}
 
 	// --- 2. Get Port ---
 	port := appConfig.ChatCapturePort
-	if port == "" {
+	if port == "" && appConfig.ChatCaptureBPF == "" {
 		port = "6121" // Default Ragnarok Online Char Server port
 		log.Printf("[W] [Scraper/Chat] CHAT_CAPTURE_PORT not set. Defaulting to %s. This may not be correct.", port)
 	}
@@ -167,7 +277,7 @@ func startChatPacketCapture(ctx context.Context) {
 	defer handle.Close()
 
 	// --- 4. Set BPF Filter ---
-	filter := fmt.Sprintf("tcp port %s", port)
+	filter := chatCaptureFilter(appConfig.ChatCaptureBPF, port)
 	if err := handle.SetBPFFilter(filter); err != nil {
 		log.Printf("[E] [Scraper/Chat] Failed to set BPF filter (%s): %v", filter, err)
 		return
@@ -176,9 +286,11 @@ func startChatPacketCapture(ctx context.Context) {
 
 	// --- 5. Start Packet Processing Loop ---
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	var newMessages []ChatMessage
-	flushTicker := time.NewTicker(5 * time.Second) // Flush messages to DB every 5s
+	batchSize := chatBatchSize()
+	newMessages := make([]ChatMessage, 0, batchSize)
+	flushTicker := time.NewTicker(chatFlushInterval())
 	defer flushTicker.Stop()
+	log.Printf("[I] [Scraper/Chat] Batching up to %d messages, flushing every %s.", batchSize, chatFlushInterval())
 
 	// Status tracking
 	isConnected := false
@@ -189,25 +301,12 @@ func startChatPacketCapture(ctx context.Context) {
 		case <-ctx.Done():
 			// Shutdown signal received
 			log.Println("[I] [Scraper/Chat] Stopping packet capture...")
-			if len(newMessages) > 0 {
-				log.Println("[I] [Scraper/Chat] Flushing final batch of chat messages...")
-				// Call and check error
-				if err := saveChatMessagesToDB(newMessages); err != nil {
-					log.Printf("[E] [Scraper/Chat] Error flushing final message batch to DB: %v", err)
-				}
-			}
+			flushChatBatch(newMessages, "shutdown")
 			return
 
 		case <-flushTicker.C:
 			// 1. Periodic DB Flush
-			if len(newMessages) > 0 {
-				log.Printf("[I] [Scraper/Chat] Flushing %d batched messages to DB.", len(newMessages))
-				// Call and check error
-				if err := saveChatMessagesToDB(newMessages); err != nil {
-					log.Printf("[E] [Scraper/Chat] Error flushing message batch to DB: %v", err)
-				}
-				newMessages = nil // Clear the batch
-			}
+			newMessages = flushChatBatch(newMessages, "interval")
 
 			// 2. Watchdog: Check for Disconnection
 			lastUnix := lastChatPacketTime.Load()
@@ -222,7 +321,7 @@ func startChatPacketCapture(ctx context.Context) {
 		case packet := <-packetSource.Packets():
 
 			// 1. Update the "last seen" time (for navbar and watchdog)
-			lastChatPacketTime.Store(time.Now().Unix())
+			markChatPacket(time.Now())
 
 			// 2. Check for Reconnection
 			if !isConnected {
@@ -454,6 +553,9 @@ func startChatPacketCapture(ctx context.Context) {
 							CharacterName: charName,
 							Message:       chatMsg,
 						})
+						if len(newMessages) >= batchSize {
+							newMessages = flushChatBatch(newMessages, "batch full")
+						}
 					} else if enableChatScraperDebugLogs {
 						log.Printf("[D] [Scraper/Chat] Parsed an empty message. Discarding.")
 					}
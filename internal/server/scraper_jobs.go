@@ -2,18 +2,46 @@ package server
 
 import (
 	"context"
+	"log"
 	"log/slog"
+	"runtime/debug"
 	"sync"
 	"time"
+
+	"github.com/denislee/yufa-mt/internal/metrics"
+)
+
+// Scrape intervals that pageDataSources also uses to judge how fresh a
+// page's data is.
+const (
+	marketScrapeInterval      = 3 * time.Minute
+	playerCountScrapeInterval = 1 * time.Minute
+	characterScrapeInterval   = 6 * time.Hour
+	guildScrapeInterval       = 1 * time.Hour
 )
 
 // Job defines a background task with its function and schedule.
 type Job struct {
 	Name     string
-	Func     func()
+	Func     func() error
 	Interval time.Duration
 }
 
+// runScrape runs fn and counts the run in the yufa_scrapes_total metric
+// under name. A run fails if fn returns an error or panics; scrapers log
+// their own errors, and the panic is logged and recovered here so one bad
+// page can't take the whole server down.
+func runScrape(name string, fn func() error) {
+	ok := false
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("[E] [Scraper] %s scrape panicked: %v\n%s", name, p, debug.Stack())
+		}
+		metrics.ObserveScrape(name, ok)
+	}()
+	ok = fn() == nil
+}
+
 // runJobOnTicker executes a job immediately and then on its scheduled interval.
 // It stops when the provided context is canceled.
 func runJobOnTicker(ctx context.Context, job Job) {
@@ -23,7 +51,7 @@ func runJobOnTicker(ctx context.Context, job Job) {
 	slog.Info("Starting initial background job run", "job", job.Name)
 	go func() {
 		// Run initial run immediately on startup in a separate goroutine so it doesn't block other tickers starting
-		job.Func()
+		runScrape(job.Name, job.Func)
 	}()
 
 	for {
@@ -33,7 +61,7 @@ func runJobOnTicker(ctx context.Context, job Job) {
 			return
 		case <-ticker.C:
 			slog.Info("Starting scheduled background job scrape", "job", job.Name)
-			job.Func()
+			runScrape(job.Name, job.Func)
 		}
 	}
 }
@@ -45,14 +73,18 @@ func startBackgroundJobs(ctx context.Context, wg *sync.WaitGroup) {
 	}
 	// Define all scheduled jobs
 	jobs := []Job{
-		{Name: "Market", Func: scrapeData, Interval: 3 * time.Minute},
-		{Name: "Player Count", Func: scrapeAndStorePlayerCount, Interval: 1 * time.Minute},
-		{Name: "Player Character", Func: scrapePlayerCharacters, Interval: 6 * time.Hour},
-		{Name: "Guild", Func: scrapeGuilds, Interval: 1 * time.Hour},
+		{Name: "Market", Func: scrapeData, Interval: marketScrapeInterval},
+		{Name: "Player Count", Func: scrapeAndStorePlayerCount, Interval: playerCountScrapeInterval},
+		{Name: "Player Character", Func: scrapePlayerCharacters, Interval: characterScrapeInterval},
+		{Name: "Guild", Func: scrapeGuilds, Interval: guildScrapeInterval},
 		{Name: "Zeny", Func: scrapeZeny, Interval: 6 * time.Hour},
 		{Name: "MVP Kill", Func: scrapeMvpKills, Interval: 5 * time.Minute},
 		// {Name: "PT-Name-Populator", Func: populateMissingPortugueseNames, Interval: 6 * time.Hour},
+		{Name: "Market PT-Name", Func: populateMarketPortugueseNames, Interval: 6 * time.Hour},
 		{Name: "WoE-Char-Rankings", Func: scrapeWoeCharacterRankings, Interval: 12 * time.Hour},
+		{Name: "Player History Compaction", Func: compactPlayerHistory, Interval: 24 * time.Hour},
+		{Name: "Stale Listing Cleanup", Func: expireStaleListings, Interval: 15 * time.Minute},
+		{Name: "Trade Item ID Backfill", Func: backfillTradeItemIDs, Interval: 30 * time.Minute},
 	}
 
 	for _, job := range jobs {
@@ -63,6 +95,10 @@ func startBackgroundJobs(ctx context.Context, wg *sync.WaitGroup) {
 		}(job)
 	}
 
+	if !chatSnifferEnabled() {
+		slog.Info("DISABLE_CHAT_SNIFFER is set; skipping chat packet capture")
+		return
+	}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
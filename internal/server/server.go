@@ -17,9 +17,11 @@ import (
 
 	"github.com/denislee/yufa-mt/internal/config"
 	"github.com/denislee/yufa-mt/internal/i18n"
+	"github.com/denislee/yufa-mt/internal/metrics"
 	"github.com/denislee/yufa-mt/internal/middleware"
 	"github.com/denislee/yufa-mt/internal/storage"
 	"github.com/denislee/yufa-mt/internal/visitor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // appConfig holds the validated config loaded by cmd/server/main.go and
@@ -31,35 +33,93 @@ var appConfig *config.Config
 // pageViewLog, visitorTracker, and the batch flusher live in
 // visitor_logger.go.
 
+// searchRateLimit applies the SEARCH_RATE_PER_MIN per-IP limit to h, or
+// returns h unchanged when the limit is disabled.
+func searchRateLimit(h http.HandlerFunc) http.HandlerFunc {
+	if appConfig == nil || appConfig.SearchRatePerMinute <= 0 {
+		return h
+	}
+	return middleware.NewRateLimiter(float64(appConfig.SearchRatePerMinute), appConfig.SearchRateBurst).Limit(h)
+}
+
+// bulkRateLimit holds each client IP to a few full dumps per hour on
+// bulk-sync endpoints, which read an entire table per hit.
+func bulkRateLimit(h http.HandlerFunc) http.HandlerFunc {
+	const perMinute, burst = 0.1, 3 // one every 10 minutes after a burst of 3
+	return middleware.NewRateLimiter(perMinute, burst).Limit(h)
+}
+
+// serveMetrics serves /metrics on its own listener at addr, so it can be
+// bound to an interface the public can't reach, until ctx is cancelled.
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	slog.Info("Metrics server started", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Error("Metrics server failed", "addr", addr, "error", err)
+	}
+}
+
 // registerRoutes sets up all the HTTP handlers for the application.
 func registerRoutes() *http.ServeMux {
 	initStaticAssetHashes()
 	mux := http.NewServeMux()
 
 	// --- Public Routes ---
-	// Wrap public routes with the visitorTracker middleware
-	mux.HandleFunc("/", visitorTracker(summaryHandler))
+	// Wrap public routes with the visitorTracker middleware. Routes in
+	// featureGate can be switched off at runtime (see feature_flags.go).
+	mux.HandleFunc("/", visitorTracker(rootHandler))
+	mux.HandleFunc("/summary", visitorTracker(summaryHandler))
+	mux.HandleFunc("/summary.json", visitorTracker(summaryHandler))
+	mux.HandleFunc("/api/summary", visitorTracker(summaryHandler))
 	mux.HandleFunc("/full-list", visitorTracker(fullListHandler))
 	mux.HandleFunc("/item", visitorTracker(itemHistoryHandler))
+	mux.HandleFunc("/item/details", visitorTracker(itemDetailsHandler))
+	mux.HandleFunc("/item/all", visitorTracker(itemOffersHandler))
+	mux.HandleFunc("/item/locations", visitorTracker(itemLocationsHandler))
+	mux.HandleFunc("/item/price-distribution", visitorTracker(itemPriceDistributionHandler))
+	mux.HandleFunc("/items/all.json", featureGate("items-all-json", bulkRateLimit(visitorTracker(itemsAllHandler))))
 	mux.HandleFunc("/activity", visitorTracker(activityHandler))
 	mux.HandleFunc("/players", visitorTracker(playerCountHandler))
 	mux.HandleFunc("/characters", visitorTracker(characterHandler))
 	mux.HandleFunc("/guilds", visitorTracker(guildHandler))
 	mux.HandleFunc("/guild", visitorTracker(guildDetailHandler))
+	mux.HandleFunc("/guild/roster", featureGate("guild-roster", visitorTracker(guildRosterHandler)))
 	mux.HandleFunc("/mvp-kills", visitorTracker(mvpKillsHandler))
 	mux.HandleFunc("/character", visitorTracker(characterDetailHandler))
+	mux.HandleFunc("/character/drops", visitorTracker(characterDropsHandler))
+	mux.HandleFunc("/character/mvp", visitorTracker(characterMvpHandler))
+	mux.HandleFunc("/character/activity-calendar", featureGate("activity-calendar", visitorTracker(characterActivityCalendarHandler)))
 	mux.HandleFunc("/character-changelog", visitorTracker(characterChangelogHandler))
 	mux.HandleFunc("/store", visitorTracker(storeDetailHandler))
+	mux.HandleFunc("/store/navi", visitorTracker(storeNaviHandler))
+	mux.HandleFunc("/stores", visitorTracker(storesHandler))
+	mux.HandleFunc("/seller/volume", featureGate("seller-volume", visitorTracker(sellerVolumeHandler)))
 	mux.HandleFunc("/discord", visitorTracker(tradingPostListHandler))
 	mux.HandleFunc("/woe", visitorTracker(woeRankingsHandler))
+	mux.HandleFunc("/woe/matchup", featureGate("woe-matchup", visitorTracker(woeMatchupHandler)))
+	mux.HandleFunc("/woe/by-class.json", featureGate("woe-by-class-json", visitorTracker(woeByClassJSONHandler)))
 	mux.HandleFunc("/chat", visitorTracker(chatHandler))
 	mux.HandleFunc("/xp-calculator", visitorTracker(xpCalculatorHandler))
 	mux.HandleFunc("/about", visitorTracker(aboutHandler))
 	mux.HandleFunc("/set-lang", i18n.SetLangHandler)
-	mux.HandleFunc("/search", visitorTracker(globalSearchHandler))
+	mux.HandleFunc("/search", searchRateLimit(visitorTracker(globalSearchHandler)))
+	mux.HandleFunc("/search/by-card", searchRateLimit(visitorTracker(cardSearchHandler)))
 	mux.HandleFunc("/stats/drops", visitorTracker(dropStatsHandler))
 	mux.HandleFunc("/stats/market", visitorTracker(marketStatsHandler))
+	mux.HandleFunc("/stats/spread", visitorTracker(priceSpreadHandler))
+	mux.HandleFunc("/watch", featureGate("price-watch", visitorTracker(priceWatchHandler)))
 	mux.HandleFunc("/stats/characters", visitorTracker(characterStatsHandler))
+	mux.HandleFunc("/stats/wealth", featureGate("wealth-stats", visitorTracker(wealthStatsHandler)))
+	mux.HandleFunc("/stats/rebirths", featureGate("rebirth-stats", visitorTracker(rebirthStatsHandler)))
+	mux.HandleFunc("/stats/guild-churn", featureGate("guild-churn", visitorTracker(guildChurnHandler)))
 
 	// --- Static Assets ---
 	// /static/* is served from in-memory pre-gzipped bytes (see
@@ -107,11 +167,16 @@ func registerAdminRoutes() *http.ServeMux {
 	// Core Admin
 	adminRouter.HandleFunc("/", adminHandler) // Handles /admin/
 	adminRouter.HandleFunc("/parse-trade", adminParseTradeHandler)
+	adminRouter.HandleFunc("/debug/item-match", adminDebugItemMatchHandler)
+	adminRouter.HandleFunc("/debug/price-history", adminDebugPriceHistoryHandler)
 	adminRouter.HandleFunc("/views/delete-visitor", adminDeleteVisitorViewsHandler)
+	adminRouter.HandleFunc("/views/referrers", adminReferrersHandler)
 	adminRouter.HandleFunc("/guild/update-emblem", adminUpdateGuildEmblemHandler)
+	adminRouter.HandleFunc("/guild/reset", adminResetGuildHandler)
 	adminRouter.HandleFunc("/character/clear-last-active", adminClearLastActiveHandler)
 	adminRouter.HandleFunc("/character/clear-mvp-kills", adminClearMvpKillsHandler)
 	adminRouter.HandleFunc("/backfill/drops", adminBackfillDropLogsHandler)
+	adminRouter.HandleFunc("/backfill/market-events", adminBackfillMarketEventsHandler)
 
 	// Admin RMS Cache Management
 	adminRouter.HandleFunc("/cache", adminCacheActionHandler)
@@ -124,23 +189,29 @@ func registerAdminRoutes() *http.ServeMux {
 	adminRouter.HandleFunc("/trading-post/reparse", adminReparseTradingPostHandler)
 	adminRouter.HandleFunc("/trading/clear-items", adminClearTradingPostItemsHandler)
 	adminRouter.HandleFunc("/trading/clear-posts", adminClearTradingPostsHandler)
+	adminRouter.HandleFunc("/trade-watch/add", adminAddTradeWatchHandler)
+	adminRouter.HandleFunc("/trade-watch/delete", adminDeleteTradeWatchHandler)
 
 	// Admin Manual Scrape Triggers
 	adminRouter.HandleFunc("/scrape/market", adminTriggerScrapeHandler(scrapeData, "Market"))
 	adminRouter.HandleFunc("/scrape/players", adminTriggerScrapeHandler(scrapeAndStorePlayerCount, "Player-Count"))
-	adminRouter.HandleFunc("/scrape/characters", adminTriggerScrapeHandler(scrapePlayerCharacters, "Character"))
+	adminRouter.HandleFunc("/scrape/characters", adminTriggerScrapeHandler(scrapePlayerCharacters, "Player-Character"))
 	adminRouter.HandleFunc("/scrape/guilds", adminTriggerScrapeHandler(scrapeGuilds, "Guild"))
 	adminRouter.HandleFunc("/scrape/emblems", adminTriggerScrapeHandler(processGuildEmblems, "Emblem-Process"))
 	adminRouter.HandleFunc("/scrape/zeny", adminTriggerScrapeHandler(scrapeZeny, "Zeny"))
-	adminRouter.HandleFunc("/scrape/mvp", adminTriggerScrapeHandler(scrapeMvpKills, "MVP"))
+	adminRouter.HandleFunc("/scrape/mvp", adminTriggerScrapeHandler(scrapeMvpKills, "MVP-Kill"))
 	adminRouter.HandleFunc("/scrape/pt-names", adminTriggerScrapeHandler(populateMissingPortugueseNames, "PT-Name-Populator"))
+	adminRouter.HandleFunc("/scrape/market-pt-names", adminTriggerScrapeHandler(populateMarketPortugueseNames, "Market-PT-Name"))
 	adminRouter.HandleFunc("/scrape/woe", adminTriggerScrapeHandler(scrapeWoeCharacterRankings, "WoE-Char-Rankings"))
+	adminRouter.HandleFunc("/scrape/stale-listings", adminTriggerScrapeHandler(expireStaleListings, "Stale-Listing-Cleanup"))
 
 	// Admin Chat Management
 	adminRouter.HandleFunc("/chat/delete", adminDeleteChatHandler)
 	adminRouter.HandleFunc("/chat/edit", adminEditChatHandler)
 
 	adminRouter.HandleFunc("/cleanup/guild-history", adminCleanupGuildHistoryHandler)
+	adminRouter.HandleFunc("/maintenance/vacuum", adminVacuumHandler)
+	adminRouter.HandleFunc("/features/toggle", adminToggleFeatureHandler)
 
 	return adminRouter
 }
@@ -152,7 +223,21 @@ func registerAdminRoutes() *http.ServeMux {
 // passed in.
 func Run(cfg *config.Config) {
 	appConfig = cfg
+	middleware.SetTrustedProxies(cfg.TrustedProxies)
 	initLogger()
+	configureSanitizers(cfg)
+	i18n.DetectBrowserLanguage = cfg.DetectBrowserLanguage
+
+	if cfg.DisplayTimezone != "" {
+		loc, err := time.LoadLocation(cfg.DisplayTimezone)
+		if err != nil {
+			// config.Load already validated it, so this only fires if the
+			// tzdata disappeared between then and now.
+			slog.Warn("Invalid DISPLAY_TIMEZONE, using server local time", "tz", cfg.DisplayTimezone, "error", err)
+		} else {
+			displayLocation = loc
+		}
+	}
 
 	dbh, err := initDB(cfg.DBPath)
 	if err != nil {
@@ -160,13 +245,22 @@ func Run(cfg *config.Config) {
 		os.Exit(1)
 	}
 	srv = &App{db: dbh, cfg: cfg}
-	visitorLogger = visitor.New(dbh)
+	visitorLogger = visitor.New(dbh, visitor.Options{
+		ChannelSize:   cfg.PageViewChannelSize,
+		BatchSize:     cfg.PageViewBatchSize,
+		FlushInterval: time.Duration(cfg.PageViewFlushSeconds) * time.Second,
+	})
+	metrics.RegisterPageViewQueue(visitorLogger.QueueLen)
 	defer func() {
 		if err := storage.Close(srv.db); err != nil {
 			slog.Error("Failed to close database", "error", err)
 		}
 	}()
 
+	if err := loadFeatureFlags(); err != nil {
+		slog.Warn("Feature flag overrides not loaded, using DISABLED_FEATURES only", "error", err)
+	}
+
 	// Run this synchronously on startup before starting other services
 	populateItemDBOnStartup()
 
@@ -188,15 +282,21 @@ func Run(cfg *config.Config) {
 	adminUser = cfg.AdminUser
 	adminPass = cfg.AdminPassword
 	if adminPass == "" {
-		slog.Info("ADMIN_PASSWORD not set. Generating a new random password (will be printed once, below).")
 		adminPass = generateRandomPassword(16)
+		if err := writeAdminPasswordFile(adminPass); err != nil {
+			slog.Error("ADMIN_PASSWORD not set and the generated password could not be saved; set ADMIN_PASSWORD or LOG_ADMIN_PASSWORD=1", "file", adminPasswordFile, "error", err)
+		} else {
+			slog.Info("ADMIN_PASSWORD not set. Generated a new random password.", "file", adminPasswordFile)
+		}
 	} else {
 		slog.Info("Loaded admin password from ADMIN_PASSWORD environment variable.")
 	}
 
-	slog.Info("==================================================")
-	slog.Info("Admin Credentials", "user", adminUser, "pass", adminPass)
-	slog.Info("==================================================")
+	if cfg.LogAdminPassword {
+		slog.Info("==================================================")
+		slog.Info("Admin Credentials", "user", adminUser, "pass", adminPass)
+		slog.Info("==================================================")
+	}
 
 	// Start Background Services with the cancellable context. The WaitGroup
 	// lets Run block on a clean shutdown of every background goroutine
@@ -217,6 +317,11 @@ func Run(cfg *config.Config) {
 
 	// --- Setup Routers ---
 	mux := registerRoutes()
+	if cfg.MetricsAddr == "" {
+		mux.Handle("/metrics", promhttp.Handler())
+	} else {
+		go serveMetrics(ctx, cfg.MetricsAddr)
+	}
 
 	// --- Server Start and Shutdown ---
 	// Wrap dynamic routes in the per-request Gzip middleware, but route
@@ -232,7 +337,7 @@ func Run(cfg *config.Config) {
 		}
 		gzWrapped.ServeHTTP(w, r)
 	})
-	server := &http.Server{Addr: cfg.HTTPAddr, Handler: handler}
+	server := &http.Server{Addr: cfg.HTTPAddr, Handler: middleware.RequestID(handler)}
 
 	// Goroutine to handle server shutdown when context is cancelled
 	go func() {
@@ -247,9 +352,16 @@ func Run(cfg *config.Config) {
 		}
 	}()
 
-	// Start server and block
-	slog.Info("Web server started", "addr", cfg.HTTPAddr)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	// Start server and block. With a certificate the server speaks HTTPS,
+	// and net/http negotiates HTTP/2 over TLS on its own.
+	if cfg.TLSCert != "" {
+		slog.Info("Web server started", "addr", cfg.HTTPAddr, "tls", true)
+		err = server.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+	} else {
+		slog.Info("Web server started", "addr", cfg.HTTPAddr)
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		slog.Error("Web server failed to start", "error", err)
 		os.Exit(1)
 	}
@@ -18,7 +18,7 @@ func TestTemplatesParseAndRenderShell(t *testing.T) {
 			t.Errorf("%s: nil template in cache", name)
 			continue
 		}
-		if name == "admin.html" || name == "admin_edit_post.html" {
+		if name == "admin.html" || name == "admin_edit_post.html" || name == "admin_referrers.html" {
 			continue
 		}
 		if tmpl.Lookup("layout.html") == nil {
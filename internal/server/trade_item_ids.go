@@ -0,0 +1,101 @@
+package server
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// Resolving a trade item's ID can mean an online rodatabase search that
+// takes seconds, so posts resolve their items before opening the write
+// transaction. Items that still have no ID (rodatabase down or slow) are
+// saved without one and retried by backfillTradeItemIDs.
+
+// resolveTradeItemIDs looks up the item ID of each item, indexed like
+// items. Items whose sanitized name is empty get an invalid ID.
+func resolveTradeItemIDs(items []GeminiTradeItem, logPrefix string) []sql.NullInt64 {
+	ids := make([]sql.NullInt64, len(items))
+	for i, item := range items {
+		itemName := sanitizeString(item.Name, itemSanitizer)
+		if strings.TrimSpace(itemName) == "" {
+			continue
+		}
+		itemID, err := findItemIDByName(itemName, true, item.Slots)
+		if err != nil {
+			log.Printf("[W] %s Error finding item ID for '%s': %v. Proceeding without ID.", logPrefix, itemName, err)
+		}
+		ids[i] = itemID
+	}
+	return ids
+}
+
+// tradeItemIDRetryHours returns how long after posting an item without
+// an ID is retried; 0 disables the retries.
+func tradeItemIDRetryHours() int {
+	if appConfig == nil {
+		return 24
+	}
+	return appConfig.TradeItemIDRetryHours
+}
+
+// backfillTradeItemIDs retries the ID lookup for items of recent trading
+// posts saved without one. Lookups run outside any transaction; each
+// resolved name is then written in a single UPDATE.
+func backfillTradeItemIDs() error {
+	hours := tradeItemIDRetryHours()
+	if hours <= 0 {
+		return nil
+	}
+	since := time.Now().Add(-time.Duration(hours) * time.Hour).Format(time.RFC3339)
+
+	rows, err := srv.db.Query(`
+		SELECT DISTINCT i.item_name, i.slots
+		FROM trading_post_items i
+		JOIN trading_posts p ON p.id = i.post_id
+		WHERE i.item_id IS NULL AND p.created_at >= ?`, since)
+	if err != nil {
+		log.Printf("[E] [Maintenance/TradeIDs] Failed to query items without an ID: %v", err)
+		return err
+	}
+	type pending struct {
+		name  string
+		slots int
+	}
+	var items []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.name, &p.slots); err != nil {
+			log.Printf("[W] [Maintenance/TradeIDs] Failed to scan item row: %v", err)
+			continue
+		}
+		items = append(items, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("[E] [Maintenance/TradeIDs] Failed to read items without an ID: %v", err)
+		return err
+	}
+
+	var resolved, updated int64
+	for _, p := range items {
+		itemID, err := findItemIDByName(p.name, true, p.slots)
+		if err != nil || !itemID.Valid {
+			continue
+		}
+		res, err := srv.db.Exec(`UPDATE trading_post_items SET item_id = ?
+			WHERE item_id IS NULL AND item_name = ? AND slots = ?
+			  AND post_id IN (SELECT id FROM trading_posts WHERE created_at >= ?)`, itemID, p.name, p.slots, since)
+		if err != nil {
+			log.Printf("[W] [Maintenance/TradeIDs] Failed to store item ID %d for '%s': %v", itemID.Int64, p.name, err)
+			continue
+		}
+		n, _ := res.RowsAffected()
+		resolved++
+		updated += n
+	}
+	if resolved > 0 {
+		log.Printf("[I] [Maintenance/TradeIDs] Resolved %d of %d pending item names (%d trade items updated).", resolved, len(items), updated)
+	}
+	return nil
+}
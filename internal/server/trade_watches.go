@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tradeWatchDebounce is how long a watch stays quiet for the same
+// character and item after firing, so a burst of identical reposts
+// produces a single notification.
+const tradeWatchDebounce = 15 * time.Minute
+
+// tradeWatchMatchSQL finds the watches hit by a post's items. Items and
+// watches that both carry a resolved item_id are compared by ID; when
+// either side lacks one, the watch's name_pattern is used as a literal
+// substring.
+var tradeWatchMatchSQL = `
+	SELECT DISTINCT w.id, w.webhook_url, i.item_name
+	FROM trade_watches w
+	JOIN trading_post_items i ON i.post_id = ?
+	WHERE (w.post_type IS NULL OR w.post_type = '' OR w.post_type = ?)
+	  AND (
+		(w.item_id IS NOT NULL AND i.item_id IS NOT NULL AND i.item_id = w.item_id)
+		OR ((w.item_id IS NULL OR i.item_id IS NULL)
+			AND COALESCE(w.name_pattern, '') != ''
+			AND i.item_name LIKE '%' || ` + escapeLikeSQL("w.name_pattern") + ` || '%' ESCAPE '\')
+	  )`
+
+var (
+	tradeWatchMutex    sync.Mutex
+	tradeWatchLastSent = make(map[string]time.Time)
+	webhookClient      = &http.Client{Timeout: 10 * time.Second}
+)
+
+// TradeWatchPayload is the JSON body POSTed to a watch's webhook. Content
+// makes it directly usable as a Discord webhook; the other fields are for
+// custom consumers.
+type TradeWatchPayload struct {
+	Content       string `json:"content"`
+	PostID        int64  `json:"post_id"`
+	PostType      string `json:"post_type"`
+	CharacterName string `json:"character_name"`
+	ItemName      string `json:"item_name"`
+}
+
+// tradeWatchShouldSend reports whether key may fire at now, recording the
+// send if so. Expired keys are pruned on the way.
+func tradeWatchShouldSend(key string, now time.Time) bool {
+	tradeWatchMutex.Lock()
+	defer tradeWatchMutex.Unlock()
+
+	for k, t := range tradeWatchLastSent {
+		if now.Sub(t) >= tradeWatchDebounce {
+			delete(tradeWatchLastSent, k)
+		}
+	}
+	if _, recent := tradeWatchLastSent[key]; recent {
+		return false
+	}
+	tradeWatchLastSent[key] = now
+	return true
+}
+
+// notifyTradeWatches checks a newly created trading post against the
+// saved watches and POSTs to each matching webhook. It is called in its
+// own goroutine after the post's transaction commits.
+func notifyTradeWatches(postID int64, characterName, postType string) {
+	rows, err := srv.db.Query(tradeWatchMatchSQL, postID, postType)
+	if err != nil {
+		log.Printf("[E] [Discord/Watch] Failed to match post %d against trade watches: %v", postID, err)
+		return
+	}
+
+	type match struct {
+		watchID    int64
+		webhookURL string
+		itemName   string
+	}
+	var matches []match
+	for rows.Next() {
+		var m match
+		if err := rows.Scan(&m.watchID, &m.webhookURL, &m.itemName); err != nil {
+			log.Printf("[W] [Discord/Watch] Failed to scan trade watch match: %v", err)
+			continue
+		}
+		matches = append(matches, m)
+	}
+	rows.Close()
+
+	now := time.Now()
+	for _, m := range matches {
+		key := fmt.Sprintf("%d|%s|%s", m.watchID, strings.ToLower(characterName), strings.ToLower(m.itemName))
+		if !tradeWatchShouldSend(key, now) {
+			log.Printf("[D] [Discord/Watch] Watch %d already notified for '%s' by '%s', skipping.", m.watchID, m.itemName, characterName)
+			continue
+		}
+
+		payload := TradeWatchPayload{
+			Content:       fmt.Sprintf("%s is %s %s (post #%d)", characterName, postType, m.itemName, postID),
+			PostID:        postID,
+			PostType:      postType,
+			CharacterName: characterName,
+			ItemName:      m.itemName,
+		}
+		if err := postWebhook(m.webhookURL, payload); err != nil {
+			log.Printf("[W] [Discord/Watch] Webhook for watch %d failed: %v", m.watchID, err)
+			continue
+		}
+		log.Printf("[I] [Discord/Watch] Notified watch %d: '%s' %s '%s'.", m.watchID, characterName, postType, m.itemName)
+	}
+}
+
+// postWebhook POSTs payload as JSON to webhookURL and fails on any
+// non-2xx answer. Trade watches and price watches both notify this way.
+func postWebhook(webhookURL string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// fetchTradeWatches lists all saved watches for the admin dashboard.
+func fetchTradeWatches() ([]TradeWatch, error) {
+	rows, err := srv.db.Query(`
+		SELECT id, item_id, COALESCE(name_pattern, ''), COALESCE(post_type, ''), webhook_url, created_at
+		FROM trade_watches ORDER BY id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("could not query trade watches: %w", err)
+	}
+	defer rows.Close()
+
+	var watches []TradeWatch
+	for rows.Next() {
+		var tw TradeWatch
+		if err := rows.Scan(&tw.ID, &tw.ItemID, &tw.NamePattern, &tw.PostType, &tw.WebhookURL, &tw.CreatedAt); err != nil {
+			log.Printf("[W] [Admin/Watch] Failed to scan trade watch row: %v", err)
+			continue
+		}
+		watches = append(watches, tw)
+	}
+	return watches, nil
+}
@@ -6,9 +6,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/denislee/yufa-mt/internal/config"
 )
 
 // capitalizeASCII title-cases a single ASCII word ("buying" -> "Buying").
@@ -20,6 +24,38 @@ func capitalizeASCII(s string) string {
 	return strings.ToUpper(s[:1]) + s[1:]
 }
 
+// displayLocation is the zone timestamps are shown in: DISPLAY_TIMEZONE,
+// or the server's local zone when unset. Stored values stay RFC3339.
+var displayLocation = time.Local
+
+// displayTime converts t to the display timezone. Every timestamp
+// formatted for a page or JSON response should go through it.
+func displayTime(t time.Time) time.Time {
+	return t.In(displayLocation)
+}
+
+// slowQueryThreshold is SLOW_QUERY_MS as a duration; 0 means slow
+// queries are not logged.
+func slowQueryThreshold() time.Duration {
+	if appConfig == nil {
+		return 0
+	}
+	return time.Duration(appConfig.SlowQueryMS) * time.Millisecond
+}
+
+// logSlowQuery logs the named query when it has been running since start
+// for longer than SLOW_QUERY_MS. Call it once the rows are drained, or as
+// `defer logSlowQuery("name", time.Now())` to time a whole function.
+func logSlowQuery(name string, start time.Time) {
+	threshold := slowQueryThreshold()
+	if threshold <= 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed > threshold {
+		log.Printf("[W] [DB/Slow] %s took %s (threshold %s)", name, elapsed.Round(time.Millisecond), threshold)
+	}
+}
+
 // updateTimeCacheKey is a struct key for the GetLastUpdateTime cache. Using
 // a struct avoids per-call fmt.Sprintf allocations on this hot helper, which
 // is called multiple times per request.
@@ -51,6 +87,22 @@ func InvalidateUpdateTimeCache(columnName, tableName string) {
 	updateTimeCacheMutex.Unlock()
 }
 
+// adminPasswordFile is where a generated admin password is saved.
+const adminPasswordFile = "data/pwd.txt"
+
+// writeAdminPasswordFile saves a generated admin password to
+// adminPasswordFile, readable only by the owner.
+func writeAdminPasswordFile(password string) error {
+	if err := os.MkdirAll(filepath.Dir(adminPasswordFile), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(adminPasswordFile, []byte(password+"\n"), 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file; tighten it explicitly.
+	return os.Chmod(adminPasswordFile, 0600)
+}
+
 func generateRandomPassword(length int) string {
 	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	b := make([]byte, length)
@@ -92,7 +144,7 @@ func GetLastUpdateTime(columnName, tableName string) string {
 	if lastTimestamp.Valid {
 		parsedTime, err := time.Parse(time.RFC3339, lastTimestamp.String)
 		if err == nil {
-			resultValue = parsedTime.Format("2006-01-02 15:04:05")
+			resultValue = displayTime(parsedTime).Format("2006-01-02 15:04:05")
 		} else {
 			resultValue = "Never" // Handle parse error
 		}
@@ -121,6 +173,53 @@ func queryCount(query string, params ...any) (int, error) {
 	return n, err
 }
 
+// excludedCharacterNames returns the pseudo-character names (such as
+// "System") kept out of player stats, chat listings and leaderboards.
+func excludedCharacterNames() []string {
+	if appConfig == nil {
+		return config.DefaultExcludedCharacterNames
+	}
+	return appConfig.ExcludedCharacterNames
+}
+
+// excludedNamesCondition returns a "column NOT IN (...)" condition for
+// excludedCharacterNames and its params, or "" when none are configured.
+func excludedNamesCondition(column string) (string, []interface{}) {
+	names := excludedCharacterNames()
+	if len(names) == 0 {
+		return "", nil
+	}
+	params := make([]interface{}, len(names))
+	for i, n := range names {
+		params[i] = n
+	}
+	return fmt.Sprintf("%s NOT IN (?%s)", column, strings.Repeat(", ?", len(names)-1)), params
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally.
+// The pattern must be used with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// escapeLikeSQL is escapeLike for a value that is already in the
+// database: it wraps the SQL expression expr in the same escaping.
+func escapeLikeSQL(expr string) string {
+	return `REPLACE(REPLACE(REPLACE(` + expr + `, '\', '\\'), '%', '\%'), '_', '\_')`
+}
+
+// ftsPrefixQuery turns free text into an FTS5 MATCH expression where
+// every word must start a word of the indexed text ("red pot" finds
+// "Red Potion"). Words are quoted, so FTS operators in s match
+// literally. Returns "" when s has no words.
+func ftsPrefixQuery(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"*`
+	}
+	return strings.Join(words, " ")
+}
+
 // GetLastScrapeTime gets the timestamp of the last market scrape.
 func GetLastScrapeTime() string {
 	return GetLastUpdateTime("timestamp", "scrape_history")
@@ -156,5 +255,5 @@ func GetLastChatPacketTime() string {
 	}
 	parsedTime := time.Unix(unixTime, 0)
 	// Format it to match the other GetLast...Time functions
-	return parsedTime.Format("2006-01-02 15:04:05")
+	return displayTime(parsedTime).Format("2006-01-02 15:04:05")
 }
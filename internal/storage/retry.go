@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// busyRetryDelays is the backoff between attempts in RetryBusy. It sits on
+// top of the connection's _busy_timeout, for writers that still lose the
+// race when a scrape and the visitor flush commit at the same time.
+var busyRetryDelays = []time.Duration{
+	50 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+}
+
+// IsBusy reports whether err is SQLite's "database is locked" error
+// (SQLITE_BUSY or SQLITE_LOCKED).
+func IsBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// RetryBusy runs fn and, while it fails with a busy error, retries it a
+// few times with short backoff. fn must be safe to re-run, so a
+// transaction should be begun and committed inside it. op names the
+// operation in logs. The last error is returned unchanged.
+func RetryBusy(op string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if !IsBusy(err) {
+			if attempt > 0 {
+				slog.Info("SQLite write succeeded after retry", "op", op, "retries", attempt, "error", err)
+			}
+			return err
+		}
+		if attempt == len(busyRetryDelays) {
+			slog.Warn("SQLite write still locked, giving up", "op", op, "retries", attempt, "error", err)
+			return err
+		}
+		slog.Warn("SQLite database locked, retrying", "op", op, "attempt", attempt+1, "delay", busyRetryDelays[attempt], "error", err)
+		time.Sleep(busyRetryDelays[attempt])
+	}
+}
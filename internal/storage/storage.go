@@ -105,8 +105,34 @@ const (
 		"locations" TEXT,
 		"script" TEXT,
 		"equip_script" TEXT,
-		"unequip_script" TEXT
+		"unequip_script" TEXT,
+		"last_modified" TEXT
 	);`
+	// internal_item_db_fts indexes item names for prefix search; the
+	// triggers keep it in step with internal_item_db.
+	createInternalItemFTSTableSQL = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS internal_item_db_fts USING fts5(
+		name,
+		name_pt,
+		content='internal_item_db',
+		content_rowid='item_id'
+	);`
+	createInternalItemTriggersSQL = `
+	CREATE TRIGGER IF NOT EXISTS internal_item_db_ai AFTER INSERT ON internal_item_db BEGIN
+		INSERT INTO internal_item_db_fts(rowid, name, name_pt)
+		VALUES (new.item_id, new.name, new.name_pt);
+	END;
+	CREATE TRIGGER IF NOT EXISTS internal_item_db_ad AFTER DELETE ON internal_item_db BEGIN
+		INSERT INTO internal_item_db_fts(internal_item_db_fts, rowid, name, name_pt)
+		VALUES ('delete', old.item_id, old.name, old.name_pt);
+	END;
+	CREATE TRIGGER IF NOT EXISTS internal_item_db_au AFTER UPDATE ON internal_item_db BEGIN
+		INSERT INTO internal_item_db_fts(internal_item_db_fts, rowid, name, name_pt)
+		VALUES ('delete', old.item_id, old.name, old.name_pt);
+		INSERT INTO internal_item_db_fts(rowid, name, name_pt)
+		VALUES (new.item_id, new.name, new.name_pt);
+	END;
+	`
 )
 
 const (
@@ -128,6 +154,13 @@ const (
 		"last_updated" TEXT NOT NULL,
 		"is_active" INTEGER NOT NULL DEFAULT 1
 	);`
+	createGuildMemberHistoryTableSQL = `
+	CREATE TABLE IF NOT EXISTS guild_member_history (
+		"guild_name" TEXT NOT NULL,
+		"timestamp" TEXT NOT NULL,
+		"member_count" INTEGER NOT NULL,
+		PRIMARY KEY (guild_name, timestamp)
+	);`
 	createCharactersTableSQL = `
 	CREATE TABLE IF NOT EXISTS characters (
 		"rank" INTEGER NOT NULL,
@@ -243,6 +276,40 @@ const (
 		"card4" TEXT,
 		FOREIGN KEY(post_id) REFERENCES trading_posts(id) ON DELETE CASCADE
 	);`
+	createTradeWatchesTableSQL = `
+	CREATE TABLE IF NOT EXISTS trade_watches (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"item_id" INTEGER,
+		"name_pattern" TEXT,
+		"post_type" TEXT, -- 'buying', 'selling', or NULL for both
+		"webhook_url" TEXT NOT NULL,
+		"created_at" TEXT NOT NULL
+	);`
+	createWatchlistTableSQL = `
+	CREATE TABLE IF NOT EXISTS watchlist (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"visitor_hash" TEXT NOT NULL,
+		"item_id" INTEGER NOT NULL,
+		"target_price" INTEGER NOT NULL,
+		"breached" INTEGER NOT NULL DEFAULT 0, -- 1 once notified, until the price is back above target
+		"created_at" TEXT NOT NULL,
+		UNIQUE(visitor_hash, item_id)
+	);`
+	createFeatureFlagsTableSQL = `
+	CREATE TABLE IF NOT EXISTS feature_flags (
+		"name" TEXT NOT NULL PRIMARY KEY,
+		"enabled" INTEGER NOT NULL,
+		"updated_at" TEXT NOT NULL
+	);`
+	createItemNameHistoryTableSQL = `
+	CREATE TABLE IF NOT EXISTS item_name_history (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"item_id" INTEGER NOT NULL,
+		"lang" TEXT NOT NULL, -- 'en' or 'pt'
+		"old_name" TEXT NOT NULL,
+		"new_name" TEXT NOT NULL,
+		"changed_at" TEXT NOT NULL
+	);`
 )
 
 const (
@@ -305,12 +372,46 @@ func applyMigrations(db *sql.DB) error {
 	if err := addColumnIfMissing(db, "characters", "zeny", "INTEGER NOT NULL DEFAULT 0"); err != nil {
 		return err
 	}
+	if err := addColumnIfMissing(db, "characters", "bank_zeny", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "visitors", "is_bot", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "page_views", "referrer", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "page_views", "ua_family", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "page_views", "is_bot", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// leveling_velocity is exp % per day over the last week, refreshed
+	// by every character scrape.
+	if err := addColumnIfMissing(db, "characters", "leveling_velocity", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
 	if err := addColumnIfMissing(db, "guilds", "is_active", "INTEGER NOT NULL DEFAULT 1"); err != nil {
 		return err
 	}
 	if err := addColumnIfMissing(db, "guilds", "emblem_local_path", "TEXT"); err != nil {
 		return err
 	}
+	// last_seen is when the market scrape last saw an available listing;
+	// unchanged listings keep their original date_and_time_retrieved.
+	// Rows written before it existed stay NULL until the next scrape.
+	if err := addColumnIfMissing(db, "items", "last_seen", "TEXT"); err != nil {
+		return err
+	}
+	// last_modified backs /items/all.json?updated_since=. Rows written
+	// before it existed stay NULL and only appear in full dumps.
+	if err := addColumnIfMissing(db, "internal_item_db", "last_modified", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_internal_db_last_modified ON internal_item_db (last_modified);`); err != nil {
+		return fmt.Errorf("failed to create idx_internal_db_last_modified: %w", err)
+	}
 	// event_kind lets readers filter by category without scanning the
 	// free-form activity_description. New rows set it at insert time;
 	// existing rows get backfilled below.
@@ -340,6 +441,7 @@ UPDATE character_changelog SET event_kind = CASE
 	WHEN activity_description LIKE 'Joined guild %' THEN 'guild_join'
 	WHEN activity_description LIKE 'Left guild %' THEN 'guild_leave'
 	WHEN activity_description LIKE 'Moved from guild %' THEN 'guild_move'
+	WHEN activity_description LIKE 'Guild % leadership changed from %' THEN 'guild_master'
 	WHEN activity_description LIKE 'Leveled up to Base Level%' THEN 'level_base'
 	WHEN activity_description LIKE 'Leveled up to Job Level%' THEN 'level_job'
 	WHEN activity_description LIKE 'Gained %experience%' THEN 'exp_gain'
@@ -413,6 +515,7 @@ func createTables(db *sql.DB) error {
 		{"scrape_history", createHistoryTableSQL},
 		{"player_history", createPlayerHistoryTableSQL},
 		{"guilds", createGuildsTableSQL},
+		{"guild_member_history", createGuildMemberHistoryTableSQL},
 		{"characters", createCharactersTableSQL},
 		{"character_changelog", createChangelogTableSQL},
 		{"v_character_changelog", createChangelogViewSQL},
@@ -420,7 +523,13 @@ func createTables(db *sql.DB) error {
 		{"page_views", createPageViewsTableSQL},
 		{"trading_posts", createTradingPostsTableSQL},
 		{"trading_post_items", createTradingPostItemsTableSQL},
+		{"trade_watches", createTradeWatchesTableSQL},
+		{"watchlist", createWatchlistTableSQL},
+		{"feature_flags", createFeatureFlagsTableSQL},
 		{"internal_item_db", createInternalItemDBTableSQL},
+		{"internal_item_db_fts", createInternalItemFTSTableSQL},
+		{"internal_item_db_triggers", createInternalItemTriggersSQL},
+		{"item_name_history", createItemNameHistoryTableSQL},
 		{"woe_seasons", createWoeSeasonsTableSQL},
 		{"woe_events", createWoeEventsTableSQL},
 		{"woe_event_rankings", createWoeEventRankingsTableSQL},
@@ -445,6 +554,7 @@ func createIndexes(db *sql.DB) error {
 		createPageIndexSQL, // This was missed in the original map
 		// 'items' table
 		`CREATE INDEX IF NOT EXISTS idx_items_name_available ON items (name_of_the_item, is_available);`,
+		`CREATE INDEX IF NOT EXISTS idx_items_name_available_time ON items (name_of_the_item, is_available, date_and_time_retrieved);`,
 		`CREATE INDEX IF NOT EXISTS idx_items_item_id ON items (item_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_items_available_seller ON items (is_available, seller_name);`,
 		`CREATE INDEX IF NOT EXISTS idx_items_timestamp_desc ON items (date_and_time_retrieved DESC);`,
@@ -468,11 +578,15 @@ func createIndexes(db *sql.DB) error {
 		`CREATE INDEX IF NOT EXISTS idx_trading_items_post_id ON trading_post_items (post_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_trading_items_item_id ON trading_post_items (item_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_trading_items_item_name ON trading_post_items (item_name);`,
+		// 'watchlist' table
+		`CREATE INDEX IF NOT EXISTS idx_watchlist_item_id ON watchlist (item_id);`,
 		// 'internal_item_db' table
 		`CREATE INDEX IF NOT EXISTS idx_internal_db_type ON internal_item_db (type);`,
 		`CREATE INDEX IF NOT EXISTS idx_internal_db_slots ON internal_item_db (slots);`,
 		`CREATE INDEX IF NOT EXISTS idx_internal_db_lower_name ON internal_item_db (LOWER(name));`,
 		`CREATE INDEX IF NOT EXISTS idx_internal_db_lower_name_pt ON internal_item_db (LOWER(name_pt));`,
+		// 'item_name_history' table
+		`CREATE INDEX IF NOT EXISTS idx_item_name_history_item_id ON item_name_history (item_id);`,
 		// 'woe_events' table
 		`CREATE INDEX IF NOT EXISTS idx_woe_events_season_id ON woe_events (season_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_woe_events_date_desc ON woe_events (event_date DESC);`,
@@ -1,9 +1,13 @@
 package storage
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
 )
 
 func TestStorageLifecycleAndDynamicMVPColumns(t *testing.T) {
@@ -79,3 +83,38 @@ func TestStorageLifecycleAndDynamicMVPColumns(t *testing.T) {
 		t.Errorf("Close failed: %v", err)
 	}
 }
+
+func TestRetryBusy(t *testing.T) {
+	saved := busyRetryDelays
+	busyRetryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+	defer func() { busyRetryDelays = saved }()
+
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	calls := 0
+	err := RetryBusy("test", func() error {
+		calls++
+		if calls < 2 {
+			return busy
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("transient busy: err=%v calls=%d, want nil after 2 calls", err, calls)
+	}
+
+	calls = 0
+	err = RetryBusy("test", func() error {
+		calls++
+		return busy
+	})
+	if !IsBusy(err) || calls != 3 {
+		t.Errorf("persistent busy: err=%v calls=%d, want busy after 3 calls", err, calls)
+	}
+
+	calls = 0
+	other := errors.New("constraint failed")
+	if err := RetryBusy("test", func() error { calls++; return other }); err != other || calls != 1 {
+		t.Errorf("non-busy error: err=%v calls=%d, want it returned without retry", err, calls)
+	}
+}
@@ -1,7 +1,8 @@
 // Package visitor batches page-view records and inserts them into the
-// visitors / page_views tables in 10-second intervals or whenever the
-// batch reaches BatchSize, whichever comes first. The Track middleware
-// enqueues views from the HTTP request path; Run consumes the channel.
+// visitors / page_views tables every Options.FlushInterval or whenever
+// the batch reaches Options.BatchSize, whichever comes first. The Track
+// middleware enqueues views from the HTTP request path; Run consumes the
+// channel.
 package visitor
 
 import (
@@ -11,62 +12,160 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
+
+	"github.com/denislee/yufa-mt/internal/metrics"
+	"github.com/denislee/yufa-mt/internal/middleware"
+	"github.com/denislee/yufa-mt/internal/storage"
 )
 
-// BatchSize is the in-memory threshold that triggers a flush.
-const BatchSize = 100
+// Defaults used for Options fields left at zero.
+const (
+	DefaultChannelSize   = 1000
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = 10 * time.Second
+)
 
-// FlushInterval is the periodic flush cadence.
-const FlushInterval = 10 * time.Second
+// Options sizes a Logger. Zero fields take the defaults above.
+type Options struct {
+	// ChannelSize is how many views may wait for the flusher before
+	// Track starts dropping them.
+	ChannelSize int
+	// BatchSize is the in-memory threshold that triggers a flush.
+	BatchSize int
+	// FlushInterval is the periodic flush cadence.
+	FlushInterval time.Duration
+}
 
-// PageView is one row queued for the page_views table.
+// PageView is one row queued for the page_views table. Referrer is the
+// external referring page (empty for direct visits and in-site clicks);
+// UAFamily is the browser family or "bot".
 type PageView struct {
 	VisitorHash string
 	PageURI     string
 	Timestamp   string
+	Referrer    string
+	UAFamily    string
+	IsBot       bool
+}
+
+// botUASubstrings mark a User-Agent as automated. Matched lowercase.
+var botUASubstrings = []string{
+	"bot", "crawl", "spider", "slurp", "curl", "wget", "python-requests",
+	"python-urllib", "go-http-client", "java/", "libwww", "httpclient",
+	"scrapy", "headless", "facebookexternalhit", "preview", "monitor",
+	"uptime", "okhttp", "axios", "node-fetch",
+}
+
+// ClassifyUserAgent returns the browser family of ua ("Chrome", "Firefox",
+// "Safari", "Edge", "Opera" or "Other") and whether it looks like a bot.
+// Bots, including an empty User-Agent, get the family "bot".
+func ClassifyUserAgent(ua string) (family string, bot bool) {
+	lower := strings.ToLower(ua)
+	if strings.TrimSpace(lower) == "" {
+		return "bot", true
+	}
+	for _, s := range botUASubstrings {
+		if strings.Contains(lower, s) {
+			return "bot", true
+		}
+	}
+	switch {
+	case strings.Contains(lower, "edg/"):
+		return "Edge", false
+	case strings.Contains(lower, "opr/") || strings.Contains(lower, "opera"):
+		return "Opera", false
+	case strings.Contains(lower, "firefox/"):
+		return "Firefox", false
+	case strings.Contains(lower, "chrome/") || strings.Contains(lower, "crios/"):
+		return "Chrome", false
+	case strings.Contains(lower, "safari/"):
+		return "Safari", false
+	}
+	return "Other", false
+}
+
+// externalReferrer returns the Referer of r without its query string, or
+// "" when there is none or it points back at this site.
+func externalReferrer(r *http.Request) string {
+	ref := r.Referer()
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" || strings.EqualFold(u.Host, r.Host) {
+		return ""
+	}
+	u.RawQuery, u.Fragment, u.User = "", "", nil
+	return u.String()
 }
 
 // Logger owns the bounded channel between request middleware and the
 // background batch flusher. Construct one with New, install Track on
 // public routes, and start Run in a background goroutine.
 type Logger struct {
-	db *sql.DB
-	ch chan PageView
+	db            *sql.DB
+	ch            chan PageView
+	batchSize     int
+	flushInterval time.Duration
 }
 
-// New returns a Logger backed by db with a 1000-slot channel. A full
-// channel drops events rather than blocking the request.
-func New(db *sql.DB) *Logger {
-	return &Logger{db: db, ch: make(chan PageView, 1000)}
+// New returns a Logger backed by db and sized by opts. A full channel
+// drops events rather than blocking the request.
+func New(db *sql.DB, opts Options) *Logger {
+	if opts.ChannelSize < 1 {
+		opts.ChannelSize = DefaultChannelSize
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	return &Logger{
+		db:            db,
+		ch:            make(chan PageView, opts.ChannelSize),
+		batchSize:     opts.BatchSize,
+		flushInterval: opts.FlushInterval,
+	}
 }
 
 // Track is the middleware that enqueues a PageView for each request and
 // then calls next.
 func (l *Logger) Track(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		family, bot := ClassifyUserAgent(r.UserAgent())
 		entry := PageView{
-			VisitorHash: hashVisitor(r),
+			VisitorHash: Hash(r),
 			PageURI:     r.URL.RequestURI(),
 			Timestamp:   time.Now().Format(time.RFC3339),
+			Referrer:    externalReferrer(r),
+			UAFamily:    family,
+			IsBot:       bot,
 		}
 		select {
 		case l.ch <- entry:
 		default:
+			metrics.PageViewsDropped.Inc()
 			log.Println("[W] [Logger] Page view log channel is full. Dropping a page view.")
 		}
 		next.ServeHTTP(w, r)
 	}
 }
 
+// QueueLen returns how many page views are waiting for the flusher.
+func (l *Logger) QueueLen() int {
+	return len(l.ch)
+}
+
 // Run is the main loop. On ctx cancellation it drains the channel and
 // flushes a final partial batch before returning.
 func (l *Logger) Run(ctx context.Context) {
 	var batch []PageView
-	ticker := time.NewTicker(FlushInterval)
+	ticker := time.NewTicker(l.flushInterval)
 	defer ticker.Stop()
 
 	for {
@@ -99,7 +198,7 @@ func (l *Logger) Run(ctx context.Context) {
 }
 
 func (l *Logger) flushIfFull(batch []PageView) []PageView {
-	if len(batch) >= BatchSize {
+	if len(batch) >= l.batchSize {
 		l.flush(batch)
 		return nil
 	}
@@ -111,67 +210,83 @@ func (l *Logger) flush(batch []PageView) {
 		return
 	}
 
-	tx, err := l.db.Begin()
+	var visitorErrors, viewErrors int
+	err := storage.RetryBusy("visitor flush", func() error {
+		var err error
+		visitorErrors, viewErrors, err = l.writeBatch(batch)
+		return err
+	})
 	if err != nil {
-		log.Printf("[E] [Logger] Failed to begin transaction: %v", err)
+		log.Printf("[E] [Logger] %v", err)
 		return
 	}
+	metrics.PageViewsProcessed.Add(float64(len(batch)))
+	log.Printf("[I] [Logger] Flushed %d views. (Visitor upsert errors: %d, View insert errors: %d)",
+		len(batch), visitorErrors, viewErrors)
+}
+
+// writeBatch inserts batch in a single transaction. Per-row failures are
+// counted rather than returned, except a locked database, which aborts
+// the transaction so flush can retry it.
+func (l *Logger) writeBatch(batch []PageView) (visitorErrors, viewErrors int, err error) {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
 	defer tx.Rollback()
 
 	visitorStmt, err := tx.Prepare(`
-		INSERT INTO visitors (visitor_hash, first_visit, last_visit)
-		VALUES (?, ?, ?)
+		INSERT INTO visitors (visitor_hash, first_visit, last_visit, is_bot)
+		VALUES (?, ?, ?, ?)
 		ON CONFLICT(visitor_hash) DO UPDATE SET
 			last_visit = excluded.last_visit;
 	`)
 	if err != nil {
-		log.Printf("[E] [Logger] Failed to prepare visitor statement: %v", err)
-		return
+		return 0, 0, fmt.Errorf("failed to prepare visitor statement: %w", err)
 	}
 	defer visitorStmt.Close()
 
 	viewStmt, err := tx.Prepare(`
-		INSERT INTO page_views (visitor_hash, page_path, view_timestamp)
-		VALUES (?, ?, ?);
+		INSERT INTO page_views (visitor_hash, page_path, view_timestamp, referrer, ua_family, is_bot)
+		VALUES (?, ?, ?, ?, ?, ?);
 	`)
 	if err != nil {
-		log.Printf("[E] [Logger] Failed to prepare page_view statement: %v", err)
-		return
+		return 0, 0, fmt.Errorf("failed to prepare page_view statement: %w", err)
 	}
 	defer viewStmt.Close()
 
 	visitorsProcessed := make(map[string]bool)
-	var visitorErrors, viewErrors int
 
 	for _, entry := range batch {
 		if !visitorsProcessed[entry.VisitorHash] {
-			if _, err := visitorStmt.Exec(entry.VisitorHash, entry.Timestamp, entry.Timestamp); err != nil {
+			if _, err := visitorStmt.Exec(entry.VisitorHash, entry.Timestamp, entry.Timestamp, entry.IsBot); err != nil {
+				if storage.IsBusy(err) {
+					return 0, 0, err
+				}
 				visitorErrors++
 			}
 			visitorsProcessed[entry.VisitorHash] = true
 		}
-		if _, err := viewStmt.Exec(entry.VisitorHash, entry.PageURI, entry.Timestamp); err != nil {
+		if _, err := viewStmt.Exec(entry.VisitorHash, entry.PageURI, entry.Timestamp, entry.Referrer, entry.UAFamily, entry.IsBot); err != nil {
+			if storage.IsBusy(err) {
+				return 0, 0, err
+			}
 			viewErrors++
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		log.Printf("[E] [Logger] Failed to commit batch: %v", err)
-	} else {
-		log.Printf("[I] [Logger] Flushed %d views. (Visitor upsert errors: %d, View insert errors: %d)",
-			len(batch), visitorErrors, viewErrors)
+		return 0, 0, fmt.Errorf("failed to commit batch: %w", err)
 	}
+	return visitorErrors, viewErrors, nil
 }
 
-// hashVisitor returns a stable hash for the requesting visitor based on
-// (X-Forwarded-For or RemoteAddr) + User-Agent.
-func hashVisitor(r *http.Request) string {
-	ip := r.Header.Get("X-Forwarded-For")
-	if ip == "" {
-		ip, _, _ = net.SplitHostPort(r.RemoteAddr)
-	} else {
-		ip = strings.Split(ip, ",")[0]
-	}
+// Hash returns a stable hash for the requesting visitor based on
+// the client IP (see middleware.ClientIP, which only believes forwarding
+// headers from trusted proxies) + User-Agent. Besides page views it keys
+// per-visitor data such as price watches.
+func Hash(r *http.Request) string {
+	ip := middleware.ClientIP(r)
 
 	ua := r.UserAgent()
 	data := fmt.Sprintf("%s-%s", ip, ua)
@@ -0,0 +1,79 @@
+package visitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/denislee/yufa-mt/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClassifyUserAgent(t *testing.T) {
+	cases := []struct {
+		ua     string
+		family string
+		bot    bool
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36", "Chrome", false},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36 Edg/120.0", "Edge", false},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox", false},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1", "Safari", false},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "bot", true},
+		{"curl/8.4.0", "bot", true},
+		{"Mozilla/5.0 HeadlessChrome/120.0", "bot", true},
+		{"", "bot", true},
+	}
+	for _, c := range cases {
+		family, bot := ClassifyUserAgent(c.ua)
+		if family != c.family || bot != c.bot {
+			t.Errorf("%q: got (%s, %t), want (%s, %t)", c.ua, family, bot, c.family, c.bot)
+		}
+	}
+}
+
+func TestExternalReferrer(t *testing.T) {
+	cases := map[string]string{
+		"":                                     "",
+		"https://yufa.example/item?name=Apple": "",
+		"https://www.google.com/search?q=yufa": "https://www.google.com/search",
+		"https://discord.com/channels/1/2#x":   "https://discord.com/channels/1/2",
+	}
+	for ref, want := range cases {
+		r := httptest.NewRequest("GET", "http://yufa.example/", nil)
+		if ref != "" {
+			r.Header.Set("Referer", ref)
+		}
+		if got := externalReferrer(r); got != want {
+			t.Errorf("Referer %q: got %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	l := New(nil, Options{})
+	if cap(l.ch) != DefaultChannelSize || l.batchSize != DefaultBatchSize || l.flushInterval != DefaultFlushInterval {
+		t.Errorf("zero Options = %d / %d / %s, want the defaults", cap(l.ch), l.batchSize, l.flushInterval)
+	}
+	l = New(nil, Options{ChannelSize: 5, BatchSize: 2, FlushInterval: time.Second})
+	if cap(l.ch) != 5 || l.batchSize != 2 || l.flushInterval != time.Second {
+		t.Errorf("custom Options = %d / %d / %s", cap(l.ch), l.batchSize, l.flushInterval)
+	}
+}
+
+func TestTrackCountsDrops(t *testing.T) {
+	l := New(nil, Options{ChannelSize: 1})
+	h := l.Track(func(http.ResponseWriter, *http.Request) {})
+	before := testutil.ToFloat64(metrics.PageViewsDropped)
+
+	for range 3 {
+		h(httptest.NewRecorder(), httptest.NewRequest("GET", "/summary", nil))
+	}
+	if l.QueueLen() != 1 {
+		t.Errorf("QueueLen() = %d, want 1", l.QueueLen())
+	}
+	if got := testutil.ToFloat64(metrics.PageViewsDropped) - before; got != 2 {
+		t.Errorf("dropped = %v, want 2", got)
+	}
+}
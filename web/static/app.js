@@ -151,12 +151,13 @@ function annotateSortableHeaders(root) {
 // Recompute from window.location.pathname after each swap. The route table
 // must stay in sync with the PageTitle branches in templates/navbar.html.
 const NAV_ROUTES = [
-    [p => p === '/',                                                                                 'summary'],
+    [p => p === '/' || p === '/summary',                                                             'summary'],
     [p => p === '/full-list',                                                                        'full-list'],
+    [p => p === '/stores',                                                                           'stores'],
     [p => p === '/activity',                                                                         'activity'],
     [p => p === '/discord',                                                                          'discord'],
     [p => p === '/chat',                                                                             'chat'],
-    [p => p === '/stats/drops' || p === '/stats/market' || p === '/stats/characters' || p === '/players', 'statistics'],
+    [p => p === '/stats/drops' || p === '/stats/market' || p === '/stats/characters' || p === '/stats/wealth' || p === '/players', 'statistics'],
     [p => p === '/characters' || p === '/guilds' || p === '/mvp-kills' || p === '/woe',              'rankings'],
 ];
 